@@ -43,5 +43,5 @@ func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
 	}
 
 	pluginmd.BeanstalkMetadata = config
-	pluginmd.Origin = Origin
+	pluginmd.SetOrigin(Origin)
 }
@@ -66,7 +66,7 @@ func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
 	}
 
 	pluginmd.EC2Metadata = &plugins.EC2Metadata{InstanceID: instanceData.InstanceID, AvailabilityZone: instanceData.AvailabilityZone}
-	pluginmd.Origin = Origin
+	pluginmd.SetOrigin(Origin)
 }
 
 // getToken fetches token to fetch EC2 metadata
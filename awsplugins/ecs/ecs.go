@@ -34,5 +34,5 @@ func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
 	}
 
 	pluginmd.ECSMetadata = &plugins.ECSMetadata{ContainerName: hostname}
-	pluginmd.Origin = Origin
+	pluginmd.SetOrigin(Origin)
 }
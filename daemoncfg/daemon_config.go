@@ -27,6 +27,7 @@ var tcpKey = "tcp"
 // A notation of '127.0.0.1:2000' or 'tcp:127.0.0.1:2000 udp:127.0.0.2:2001' or 'udp:127.0.0.1:2000 tcp:127.0.0.2:2001'
 // are both acceptable. The first one means UDP and TCP are running at the same address.
 // Notation 'hostname:2000' or 'tcp:hostname:2000 udp:hostname:2001' or 'udp:hostname:2000 tcp:hostname:2001' are also acceptable.
+// IPv6 addresses must be enclosed in square brackets, e.g. '[::1]:2000' or 'tcp:[::1]:2000 udp:[::1]:2001'.
 // By default it assumes a X-Ray daemon running at 127.0.0.1:2000 listening to both UDP and TCP traffic.
 type DaemonEndpoints struct {
 	// UDPAddr represents UDP endpoint for segments to be sent by emitter.
@@ -92,6 +93,16 @@ func GetDaemonEndpointsFromString(dAddr string) (*DaemonEndpoints, error) {
 	return nil, nil
 }
 
+// GetDaemonEndpointsFromConfig resolves the configured daemon address dAddr, or the environment variable if dAddr is empty.
+// Unlike GetDaemonEndpointsFromString, an explicitly configured address takes precedence over the environment variable.
+// DaemonEndpoints is nil if neither is set.
+func GetDaemonEndpointsFromConfig(dAddr string) (*DaemonEndpoints, error) {
+	if dAddr != "" {
+		return resolveAddress(dAddr)
+	}
+	return GetDaemonEndpointsFromEnv()
+}
+
 func resolveAddress(dAddr string) (*DaemonEndpoints, error) {
 	addr := strings.Split(dAddr, addressDelimiter)
 	switch len(addr) {
@@ -104,25 +115,25 @@ func resolveAddress(dAddr string) (*DaemonEndpoints, error) {
 }
 
 func parseDoubleForm(addr []string) (*DaemonEndpoints, error) {
-	addr1 := strings.Split(addr[0], ":") // tcp:127.0.0.1:2000  or udp:127.0.0.1:2000
-	addr2 := strings.Split(addr[1], ":") // tcp:127.0.0.1:2000  or udp:127.0.0.1:2000
+	addr1 := strings.SplitN(addr[0], ":", 2) // tcp:127.0.0.1:2000  or udp:[::1]:2000
+	addr2 := strings.SplitN(addr[1], ":", 2) // tcp:127.0.0.1:2000  or udp:[::1]:2000
 
-	if len(addr1) != 3 || len(addr2) != 3 {
+	if len(addr1) != 2 || len(addr2) != 2 {
 		return nil, errors.New("invalid daemon address: " + addr[0] + " " + addr[1])
 	}
 
-	// validate ports
-	_, pErr1 := strconv.Atoi(addr1[2])
-	_, pErr2 := strconv.Atoi(addr1[2])
-
-	if pErr1 != nil || pErr2 != nil {
-		return nil, errors.New("invalid daemon address port")
+	// validate host and port
+	if err := validateHostPort(addr1[1]); err != nil {
+		return nil, err
+	}
+	if err := validateHostPort(addr2[1]); err != nil {
+		return nil, err
 	}
 
 	addrMap := make(map[string]string)
 
-	addrMap[addr1[0]] = addr1[1] + ":" + addr1[2]
-	addrMap[addr2[0]] = addr2[1] + ":" + addr2[2]
+	addrMap[addr1[0]] = addr1[1]
+	addrMap[addr2[0]] = addr2[1]
 
 	if addrMap[udpKey] == "" || addrMap[tcpKey] == "" { // for double form, tcp and udp keywords should be present
 		return nil, errors.New("invalid daemon address")
@@ -144,18 +155,9 @@ func parseDoubleForm(addr []string) (*DaemonEndpoints, error) {
 	}, nil
 }
 
-func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:port"
-	a := strings.Split(addr, ":") // 127.0.0.1:2000
-
-	if len(a) != 2 {
-		return nil, errors.New("invalid daemon address: " + addr)
-	}
-
-	// validate port
-	_, pErr1 := strconv.Atoi(a[1])
-
-	if pErr1 != nil {
-		return nil, errors.New("invalid daemon address port")
+func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:port" or "[ipv6]:port"
+	if err := validateHostPort(addr); err != nil {
+		return nil, err
 	}
 
 	udpAddr, uErr := resolveUDPAddr(addr)
@@ -173,6 +175,20 @@ func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:po
 	}, nil
 }
 
+// validateHostPort checks that addr is of the form "host:port", where an
+// IPv6 host is enclosed in square brackets, e.g. "[::1]:2000".
+func validateHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.New("invalid daemon address: " + addr)
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return errors.New("invalid daemon address port")
+	}
+	return nil
+}
+
 func resolveUDPAddr(s string) (*net.UDPAddr, error) {
 	return net.ResolveUDPAddr(udpKey, s)
 }
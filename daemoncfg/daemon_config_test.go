@@ -306,6 +306,45 @@ func TestGetDaemonEndpointsForHostname7(t *testing.T) { // Invalid port - double
 	assert.Nil(t, dEndpt)
 }
 
+func TestGetDaemonEndpointsForIPv6SingleForm(t *testing.T) {
+	dEndpt, err := GetDaemonEndpointsFromString("[::1]:2000")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "[::1]:2000", dEndpt.UDPAddr.String())
+	assert.Equal(t, "[::1]:2000", dEndpt.TCPAddr.String())
+}
+
+func TestGetDaemonEndpointsForIPv6DoubleForm(t *testing.T) {
+	dEndpt, err := GetDaemonEndpointsFromString("tcp:[fd00::1]:2000 udp:[::1]:2001")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "[::1]:2001", dEndpt.UDPAddr.String())
+	assert.Equal(t, "[fd00::1]:2000", dEndpt.TCPAddr.String())
+}
+
+func TestGetDaemonEndpointsForIPv6Unbracketed(t *testing.T) { // IPv6 literal without brackets is ambiguous
+	dEndpt, err := GetDaemonEndpointsFromString("::1:2000")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(fmt.Sprint(err), addrErr))
+	assert.Nil(t, dEndpt)
+
+	dEndpt, err = GetDaemonEndpointsFromString("tcp:::1:2000 udp:::1:2000")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(fmt.Sprint(err), addrErr))
+	assert.Nil(t, dEndpt)
+}
+
+func TestGetDaemonEndpointsForIPv6InvalidPort(t *testing.T) {
+	dEndpt, err := GetDaemonEndpointsFromString("udp:[::1]:2000 tcp:[::1]:r4")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(fmt.Sprint(err), portErr))
+	assert.Nil(t, dEndpt)
+}
+
 // Benchmarks
 func BenchmarkGetDaemonEndpoints(b *testing.B) {
 	for i := 0; i < b.N; i++ {
@@ -345,3 +384,22 @@ func BenchmarkGetDaemonEndpointsFromString(b *testing.B) {
 		}
 	}
 }
+
+func TestGetDaemonEndpointsFromConfig(t *testing.T) {
+	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", "127.0.0.1:4000")
+	defer os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+
+	dEndpt, err := GetDaemonEndpointsFromConfig("127.0.0.1:3000") // provided daemon addr gets precedence over env variable
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, dEndpt.UDPAddr.Port)
+	assert.Equal(t, 3000, dEndpt.TCPAddr.Port)
+
+	dEndpt, err = GetDaemonEndpointsFromConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, 4000, dEndpt.UDPAddr.Port)
+
+	os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+	dEndpt, err = GetDaemonEndpointsFromConfig("")
+	assert.Nil(t, err)
+	assert.Nil(t, dEndpt)
+}
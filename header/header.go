@@ -29,6 +29,10 @@ const (
 	// SelfPrefix is the prefix for
 	// Self attribute in X-Amzn-Trace-Id.
 	SelfPrefix = "Self="
+
+	// LineagePrefix is the prefix for
+	// Lineage attribute in X-Amzn-Trace-Id.
+	LineagePrefix = "Lineage="
 )
 
 // SamplingDecision is a string representation of
@@ -71,10 +75,25 @@ type Header struct {
 	ParentID         string
 	SamplingDecision SamplingDecision
 
+	// Lineage records the chain of upstream invocations, such as SQS
+	// retries, that led to this request. It is empty when the incoming
+	// header carries no Lineage attribute.
+	Lineage string
+
+	// Self is the ID an Application Load Balancer adds for its own hop, as
+	// in Self=1-67891234-12456789abcdef012345678. Load balancers do not
+	// send segments to X-Ray, so it identifies no segment and is neither
+	// used as the parent of segments nor propagated downstream by String.
+	// It has the format of a trace ID rather than of a 16 digit segment
+	// ID, so it cannot be recorded as the parent_id of a segment either.
+	Self string
+
 	AdditionalData map[string]string
 }
 
 // FromString gets individual value for each item in Header struct.
+// Keys are matched case-insensitively and whitespace around keys and
+// values is ignored.
 func FromString(s string) *Header {
 	ret := &Header{
 		SamplingDecision: Unknown,
@@ -83,26 +102,37 @@ func FromString(s string) *Header {
 	parts := strings.Split(s, ";")
 	for i := range parts {
 		p := strings.TrimSpace(parts[i])
-		value, valid := valueFromKeyValuePair(p)
-		if valid {
-			switch {
-			case strings.HasPrefix(p, RootPrefix):
-				ret.TraceID = value
-			case strings.HasPrefix(p, ParentPrefix):
-				ret.ParentID = value
-			case strings.HasPrefix(p, SampledPrefix):
-				ret.SamplingDecision = samplingDecision(p)
-			case !strings.HasPrefix(p, SelfPrefix):
-				key, valid := keyFromKeyValuePair(p)
-				if valid {
-					ret.AdditionalData[key] = value
-				}
-			}
+		key, valid := keyFromKeyValuePair(p)
+		if !valid {
+			continue
+		}
+		value, _ := valueFromKeyValuePair(p)
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case hasKey(RootPrefix, key):
+			ret.TraceID = value
+		case hasKey(ParentPrefix, key):
+			ret.ParentID = value
+		case hasKey(SampledPrefix, key):
+			ret.SamplingDecision = samplingDecision(SampledPrefix + value)
+		case hasKey(LineagePrefix, key):
+			ret.Lineage = value
+		case hasKey(SelfPrefix, key):
+			ret.Self = value
+		default:
+			ret.AdditionalData[key] = value
 		}
 	}
 	return ret
 }
 
+// hasKey reports whether key is the key of the given prefix, ignoring case.
+func hasKey(prefix, key string) bool {
+	return strings.EqualFold(prefix, key+"=")
+}
+
 // String returns a string representation for header.
 func (h Header) String() string {
 	var p [][]byte
@@ -113,6 +143,9 @@ func (h Header) String() string {
 		p = append(p, []byte(ParentPrefix+h.ParentID))
 	}
 	p = append(p, []byte(h.SamplingDecision))
+	if h.Lineage != "" {
+		p = append(p, []byte(LineagePrefix+h.Lineage))
+	}
 	for key := range h.AdditionalData {
 		p = append(p, []byte(key+"="+h.AdditionalData[key]))
 	}
@@ -45,6 +45,23 @@ func TestLonghFromStringWithSpaces(t *testing.T) {
 	assert.Equal(t, "bar", h.AdditionalData["Foo"])
 }
 
+func TestLenientFromString(t *testing.T) {
+	h := FromString("root=" + ExampleTraceID + "; Parent = foo ;  sampled=1 ; SELF=2; Foo = bar")
+
+	assert.Equal(t, Sampled, h.SamplingDecision)
+	assert.Equal(t, ExampleTraceID, h.TraceID)
+	assert.Equal(t, "foo", h.ParentID)
+	assert.Equal(t, 1, len(h.AdditionalData))
+	assert.Equal(t, "bar", h.AdditionalData["Foo"])
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;Foo=bar", h.String())
+}
+
+func TestLenientSampledFromString(t *testing.T) {
+	assert.Equal(t, NotSampled, FromString(" SAMPLED = 0 ").SamplingDecision)
+	assert.Equal(t, Requested, FromString("sampled=?").SamplingDecision)
+	assert.Equal(t, Unknown, FromString("sampled=yes").SamplingDecision)
+}
+
 func TestSampledUnknownToString(t *testing.T) {
 	h := &Header{}
 	h.SamplingDecision = Unknown
@@ -74,6 +91,40 @@ func TestLonghToString(t *testing.T) {
 	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;Foo=bar", h.String())
 }
 
+func TestLineageFromString(t *testing.T) {
+	h := FromString("Root=" + ExampleTraceID + ";Parent=foo;Sampled=1;Lineage=a87bd80c:1|68fd508a:5;Foo=bar")
+
+	assert.Equal(t, "a87bd80c:1|68fd508a:5", h.Lineage)
+	assert.Equal(t, 1, len(h.AdditionalData))
+	assert.Equal(t, "bar", h.AdditionalData["Foo"])
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;Lineage=a87bd80c:1|68fd508a:5;Foo=bar", h.String())
+}
+
+func TestLineageMissingFromString(t *testing.T) {
+	h := FromString("Root=" + ExampleTraceID + ";Parent=foo;Sampled=1")
+
+	assert.Empty(t, h.Lineage)
+	assert.Empty(t, h.AdditionalData)
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1", h.String())
+}
+
+func TestLenientLineageFromString(t *testing.T) {
+	h := FromString("root=" + ExampleTraceID + "; lineage = a87bd80c:1 ")
+
+	assert.Equal(t, "a87bd80c:1", h.Lineage)
+	assert.Empty(t, h.AdditionalData)
+}
+
+func TestSelfFromString(t *testing.T) {
+	h := FromString("Self=1-67891234-12456789abcdef012345678;Root=" + ExampleTraceID + ";Sampled=1")
+
+	assert.Equal(t, "1-67891234-12456789abcdef012345678", h.Self)
+	assert.Equal(t, ExampleTraceID, h.TraceID)
+	assert.Empty(t, h.ParentID)
+	assert.Empty(t, h.AdditionalData)
+	assert.Equal(t, "Root="+ExampleTraceID+";Sampled=1", h.String())
+}
+
 // Benchmark
 func BenchmarkFromString(b *testing.B) {
 	str := "Sampled=?; Root=" + ExampleTraceID + "; Parent=foo; Self=2; Foo=bar"
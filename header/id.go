@@ -0,0 +1,34 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package header
+
+// IsValidTraceID reports whether s is an X-Ray trace ID: the version 1, the
+// start time of the trace as 8 hexadecimal digits and a random part of 24
+// hexadecimal digits, separated by dashes, as in
+// 1-5759e988-bd862e3fe1be46a994272793. Hexadecimal digits must be lowercase.
+func IsValidTraceID(s string) bool {
+	return len(s) == 35 && s[0] == '1' && s[1] == '-' && s[10] == '-' && isHex(s[2:10]) && isHex(s[11:])
+}
+
+// IsValidSegmentID reports whether s is an X-Ray segment ID, made of 16
+// lowercase hexadecimal digits, as in 53995c3f42cd8ad8.
+func IsValidSegmentID(s string) bool {
+	return len(s) == 16 && isHex(s)
+}
+
+// isHex reports whether s consists of lowercase hexadecimal digits.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
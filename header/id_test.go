@@ -0,0 +1,57 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package header
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidTraceID(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"1-5759e988-bd862e3fe1be46a994272793":  true,
+		"1-00000000-000000000000000000000000":  true,
+		"1-ffffffff-ffffffffffffffffffffffff":  true,
+		"":                                     false,
+		"1-5759e988-bd862e3fe1be46a99427279":   false, // random part too short
+		"1-5759e988-bd862e3fe1be46a9942727933": false, // random part too long
+		"1-5759e98-bd862e3fe1be46a9942727933":  false, // time too short
+		"1-5759e9888-bd862e3fe1be46a99427279":  false, // time too long
+		"2-5759e988-bd862e3fe1be46a994272793":  false, // unknown version
+		"1-5759E988-bd862e3fe1be46a994272793":  false, // uppercase time
+		"1-5759e988-BD862E3FE1BE46A994272793":  false, // uppercase random part
+		"1-5759e98g-bd862e3fe1be46a994272793":  false, // not hexadecimal
+		"1-5759e988-bd862e3fe1be46a99427279g":  false,
+		"1_5759e988-bd862e3fe1be46a994272793":  false, // wrong separators
+		"1-5759e988_bd862e3fe1be46a994272793":  false,
+		"1-5759e988bd862e3fe1be46a994272793-":  false,
+		"Root=1-5759e988-bd862e3fe1be46a99427": false,
+		"1-5759e988-bd862e3fe1be46a99427279é":  false, // multi-byte character
+	} {
+		assert.Equal(t, valid, IsValidTraceID(id), id)
+	}
+}
+
+func TestIsValidSegmentID(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"53995c3f42cd8ad8":  true,
+		"0000000000000000":  true,
+		"ffffffffffffffff":  true,
+		"":                  false,
+		"53995c3f42cd8ad":   false, // too short
+		"53995c3f42cd8ad80": false, // too long
+		"53995C3F42CD8AD8":  false, // uppercase
+		"53995c3f42cd8adg":  false, // not hexadecimal
+		"53995c3f-2cd8ad8":  false,
+		"53995c3f42cd8aé":   false, // multi-byte character
+	} {
+		assert.Equal(t, valid, IsValidSegmentID(id), id)
+	}
+}
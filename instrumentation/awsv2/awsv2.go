@@ -10,6 +10,7 @@ package awsv2
 
 import (
 	"context"
+	"time"
 
 	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-xray-sdk-go/xray"
@@ -19,6 +20,28 @@ import (
 
 type awsV2SubsegmentKey struct{}
 
+type awsV2AttemptTimerKey struct{}
+
+// attemptTimer times the attempts made by the retry middleware for an operation.
+type attemptTimer struct {
+	count      int
+	lastEnd    time.Time
+	retryDelay time.Duration
+}
+
+// start records the start of an attempt, counting the time since the end of
+// the previous one, mostly spent backing off, as retry delay.
+func (a *attemptTimer) start() {
+	if a.count > 0 {
+		a.retryDelay += time.Since(a.lastEnd)
+	}
+	a.count++
+}
+
+func (a *attemptTimer) end() {
+	a.lastEnd = time.Now()
+}
+
 func initializeMiddlewareAfter(stack *middleware.Stack) error {
 	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("XRayInitializeMiddlewareAfter", func(
 		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
@@ -36,9 +59,19 @@ func initializeMiddlewareAfter(stack *middleware.Stack) error {
 
 		// set the subsegment in the context
 		ctx = context.WithValue(ctx, awsV2SubsegmentKey{}, subseg)
+		timer := &attemptTimer{}
+		ctx = context.WithValue(ctx, awsV2AttemptTimerKey{}, timer)
 
 		out, metadata, err = next.HandleInitialize(ctx, in)
 
+		if accountID := xray.ResourceAccountID(in.Parameters, out.Result); accountID != "" {
+			subseg.GetAWS()[xray.AccountIDKey] = accountID
+		}
+		if timer.count > 0 {
+			subseg.GetAWS()["retries"] = timer.count - 1
+			subseg.GetAWS()["retry_delay_ms"] = timer.retryDelay.Milliseconds()
+		}
+
 		// End the subsegment when the response returns from this middleware
 		defer subseg.Close(err)
 
@@ -47,6 +80,25 @@ func initializeMiddlewareAfter(stack *middleware.Stack) error {
 		middleware.After)
 }
 
+// attemptMiddleware runs after the retry middleware, once for every attempt,
+// to time the delay between attempts.
+func attemptMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("XRayAttemptMiddleware", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		out middleware.FinalizeOutput, metadata middleware.Metadata, err error) {
+
+		timer, ok := ctx.Value(awsV2AttemptTimerKey{}).(*attemptTimer)
+		if !ok {
+			return next.HandleFinalize(ctx, in)
+		}
+
+		timer.start()
+		defer timer.end()
+		return next.HandleFinalize(ctx, in)
+	}),
+		middleware.After)
+}
+
 func deserializeMiddleware(stack *middleware.Stack) error {
 	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("XRayDeserializeMiddleware", func(
 		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
@@ -84,5 +136,5 @@ func deserializeMiddleware(stack *middleware.Stack) error {
 }
 
 func AWSV2Instrumentor(apiOptions *[]func(*middleware.Stack) error) {
-	*apiOptions = append(*apiOptions, initializeMiddlewareAfter, deserializeMiddleware)
+	*apiOptions = append(*apiOptions, initializeMiddlewareAfter, attemptMiddleware, deserializeMiddleware)
 }
@@ -19,9 +19,11 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/aws/aws-sdk-go-v2/service/route53/types"
 	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
 	"github.com/aws/aws-xray-sdk-go/xray"
 )
 
@@ -153,6 +155,129 @@ func TestAWSV2(t *testing.T) {
 	}
 }
 
+func TestAWSV2RetryDelay(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests <= 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, err := w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+		<ChangeResourceRecordSetsResponse>
+			<ChangeInfo>
+			<Comment>mockComment</Comment>
+			<Id>mockID</Id>
+		</ChangeInfo>
+		</ChangeResourceRecordSetsResponse>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}))
+	defer server.Close()
+
+	const backoff = 50 * time.Millisecond
+	ctx, root := xray.BeginSegment(context.Background(), "AWSSDKV2_Route53")
+	svc := route53.NewFromConfig(aws.Config{
+		Region: "us-west-2",
+		EndpointResolver: aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+			return aws.Endpoint{
+				URL:         server.URL,
+				SigningName: "route53",
+			}, nil
+		}),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = 3
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+					return backoff, nil
+				})
+			})
+		},
+	})
+
+	_, err := svc.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{},
+			Comment: aws.String("mock"),
+		},
+		HostedZoneId: aws.String("zone"),
+	}, func(options *route53.Options) {
+		AWSV2Instrumentor(&options.APIOptions)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root.Close(nil)
+	var subseg *xray.Segment
+	_ = json.Unmarshal(xray.GetSegment(ctx).Subsegments[0], &subseg)
+
+	if e, a := float64(2), subseg.GetAWS()["retries"]; e != a {
+		t.Errorf("expected retries to be %v, got %v", e, a)
+	}
+	delay, _ := subseg.GetAWS()["retry_delay_ms"].(float64)
+	if min := float64((2 * backoff).Milliseconds()); delay < min {
+		t.Errorf("expected retry delay of at least %vms, got %vms", min, delay)
+	}
+}
+
+func TestAWSV2ResourceAccountID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			_, err := w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+		<CreateQueryLoggingConfigResponse>
+			<QueryLoggingConfig>
+			<CloudWatchLogsLogGroupArn>arn:aws:logs:us-east-1:123456789012:log-group:/aws/route53/example.com</CloudWatchLogsLogGroupArn>
+			<HostedZoneId>zone</HostedZoneId>
+			<Id>mockID</Id>
+		</QueryLoggingConfig>
+		</CreateQueryLoggingConfigResponse>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}))
+	defer server.Close()
+
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{SamplingStrategy: sampling.NewDeterministicStrategy(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, root := xray.BeginSegment(ctx, "AWSSDKV2_Route53")
+	svc := route53.NewFromConfig(aws.Config{
+		Region: "us-west-2",
+		EndpointResolver: aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+			return aws.Endpoint{
+				URL:         server.URL,
+				SigningName: "route53",
+			}, nil
+		}),
+		Retryer: func() aws.Retryer {
+			return aws.NopRetryer{}
+		},
+	})
+
+	_, err = svc.CreateQueryLoggingConfig(ctx, &route53.CreateQueryLoggingConfigInput{
+		CloudWatchLogsLogGroupArn: aws.String("arn:aws:logs:us-east-1:123456789012:log-group:/aws/route53/example.com"),
+		HostedZoneId:              aws.String("zone"),
+	}, func(options *route53.Options) {
+		AWSV2Instrumentor(&options.APIOptions)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root.Close(nil)
+	var subseg *xray.Segment
+	_ = json.Unmarshal(xray.GetSegment(ctx).Subsegments[0], &subseg)
+
+	if e, a := "123456789012", subseg.GetAWS()[xray.AccountIDKey]; e != a {
+		t.Errorf("expected account id to be %v, got %v", e, a)
+	}
+}
+
 func TestAWSV2WithoutSegment(t *testing.T) {
 	cases := map[string]struct {
 		responseStatus int
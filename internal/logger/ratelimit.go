@@ -0,0 +1,120 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+)
+
+// DefaultSuppressInterval is the default minimum time between
+// two logs of an identical rate limited message.
+const DefaultSuppressInterval = 10 * time.Second
+
+// maxLimitedMessages bounds the number of distinct messages tracked.
+const maxLimitedMessages = 1024
+
+var limiter = &rateLimiter{
+	interval: DefaultSuppressInterval,
+	now:      time.Now,
+	entries:  make(map[string]*limitedEntry),
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	now      func() time.Time
+	entries  map[string]*limitedEntry
+}
+
+type limitedEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+// SetSuppressInterval sets the minimum time between two logs of an identical
+// message through the rate limited logging functions. A non-positive interval
+// disables rate limiting.
+func SetSuppressInterval(d time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.interval = d
+	limiter.entries = make(map[string]*limitedEntry)
+}
+
+// RateLimitedErrorf logs like Errorf, but an identical message is logged at
+// most once per suppress interval. The next log after the interval notes how
+// many times the message was suppressed.
+func RateLimitedErrorf(format string, args ...interface{}) {
+	limiter.log(nil, xraylog.LogLevelError, fmt.Sprintf(format, args...))
+}
+
+// RateLimitedLogf logs to l like RateLimitedErrorf, at the given level. If l
+// is nil the SDK logger is used. Identical messages are rate limited
+// together regardless of the logger they are logged to.
+func RateLimitedLogf(l xraylog.Logger, level xraylog.LogLevel, format string, args ...interface{}) {
+	limiter.log(l, level, fmt.Sprintf(format, args...))
+}
+
+func (rl *rateLimiter) log(l xraylog.Logger, level xraylog.LogLevel, msg string) {
+	if l == nil {
+		l = Logger
+	}
+
+	rl.mu.Lock()
+	if rl.interval <= 0 {
+		rl.mu.Unlock()
+		l.Log(level, printArgs{msg})
+		return
+	}
+
+	now := rl.now()
+	e, ok := rl.entries[msg]
+	if ok && now.Sub(e.last) < rl.interval {
+		e.suppressed++
+		rl.mu.Unlock()
+		return
+	}
+
+	suppressed := 0
+	if ok {
+		suppressed = e.suppressed
+		e.last = now
+		e.suppressed = 0
+	} else {
+		rl.evict(now)
+		rl.entries[msg] = &limitedEntry{last: now}
+	}
+	rl.mu.Unlock()
+
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (suppressed %d times)", msg, suppressed)
+	}
+	l.Log(level, printArgs{msg})
+}
+
+// evict makes room for a new message once maxLimitedMessages are tracked,
+// dropping the messages whose interval has passed, or all of them if none has.
+// The caller must hold rl.mu.
+func (rl *rateLimiter) evict(now time.Time) {
+	if len(rl.entries) < maxLimitedMessages {
+		return
+	}
+	for msg, e := range rl.entries {
+		if now.Sub(e.last) >= rl.interval {
+			delete(rl.entries, msg)
+		}
+	}
+	if len(rl.entries) >= maxLimitedMessages {
+		rl.entries = make(map[string]*limitedEntry)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+)
+
+func withTestLimiter(t *testing.T, interval time.Duration) (*bytes.Buffer, *time.Time) {
+	oldLogger, oldLimiter := Logger, limiter
+	t.Cleanup(func() { Logger, limiter = oldLogger, oldLimiter })
+
+	var buf bytes.Buffer
+	Logger = xraylog.NewDefaultLogger(&buf, xraylog.LogLevelDebug)
+
+	now := time.Unix(1500000000, 0)
+	limiter = &rateLimiter{
+		interval: interval,
+		now:      func() time.Time { return now },
+		entries:  make(map[string]*limitedEntry),
+	}
+	return &buf, &now
+}
+
+func TestRateLimitedErrorf(t *testing.T) {
+	buf, now := withTestLimiter(t, 10*time.Second)
+
+	for i := 0; i < 5; i++ {
+		RateLimitedErrorf("daemon unreachable: %s", "127.0.0.1:2000")
+	}
+	RateLimitedErrorf("other message")
+
+	gotLines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(gotLines) != 2 {
+		t.Fatalf("got %d lines", len(gotLines))
+	}
+	if !strings.HasSuffix(gotLines[0], "[ERROR] daemon unreachable: 127.0.0.1:2000") {
+		t.Errorf("unexpected first line %q", gotLines[0])
+	}
+
+	buf.Reset()
+	*now = now.Add(10 * time.Second)
+	RateLimitedErrorf("daemon unreachable: %s", "127.0.0.1:2000")
+
+	if !strings.Contains(buf.String(), "[ERROR] daemon unreachable: 127.0.0.1:2000 (suppressed 4 times)") {
+		t.Errorf("expected suppressed count, got %q", buf.String())
+	}
+
+	buf.Reset()
+	*now = now.Add(10 * time.Second)
+	RateLimitedErrorf("daemon unreachable: %s", "127.0.0.1:2000")
+
+	if strings.Contains(buf.String(), "suppressed") {
+		t.Errorf("expected no suppressed count, got %q", buf.String())
+	}
+}
+
+func TestRateLimitedErrorfDisabled(t *testing.T) {
+	buf, _ := withTestLimiter(t, 0)
+
+	for i := 0; i < 3; i++ {
+		RateLimitedErrorf("error")
+	}
+
+	if n := strings.Count(buf.String(), "[ERROR] error"); n != 3 {
+		t.Errorf("got %d lines", n)
+	}
+}
+
+func TestRateLimitedErrorfEviction(t *testing.T) {
+	buf, _ := withTestLimiter(t, time.Minute)
+
+	for i := 0; i <= maxLimitedMessages; i++ {
+		RateLimitedErrorf("error %d", i)
+	}
+	if len(limiter.entries) > maxLimitedMessages {
+		t.Errorf("tracking %d messages", len(limiter.entries))
+	}
+
+	buf.Reset()
+	RateLimitedErrorf("error %d", maxLimitedMessages)
+	if buf.Len() != 0 {
+		t.Errorf("expected most recent message to be suppressed, got %q", buf.String())
+	}
+}
+
+func TestSetSuppressInterval(t *testing.T) {
+	buf, _ := withTestLimiter(t, time.Minute)
+
+	RateLimitedErrorf("error")
+	SetSuppressInterval(0)
+	RateLimitedErrorf("error")
+
+	if n := strings.Count(buf.String(), "[ERROR] error"); n != 2 {
+		t.Errorf("got %d lines", n)
+	}
+}
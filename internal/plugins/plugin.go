@@ -36,7 +36,8 @@ type PluginMetadata struct {
 	// ECSMetadata records the ECS container ID.
 	ECSMetadata *ECSMetadata
 
-	// Origin records original service of the segment.
+	// Origin records original service of the segment. Plugins set it with
+	// SetOrigin, so that the most specific origin is kept.
 	Origin string
 }
 
@@ -59,3 +60,37 @@ type BeanstalkMetadata struct {
 	VersionLabel string `json:"version_label"`
 	DeploymentID int    `json:"deployment_id"`
 }
+
+// originPrecedence lists the origins detected by plugins from the most to
+// the least specific. Several plugins detect their environment on the same
+// host, such as the EC2 and ECS plugins in an ECS task on EC2, and the
+// origin of the hosting service closest to the application is kept
+// regardless of the order in which the plugins are initialized.
+var originPrecedence = []string{
+	"AWS::ElasticBeanstalk::Environment",
+	"AWS::AppRunner::Service",
+	"AWS::EKS::Container",
+	"AWS::ECS::Container",
+	"AWS::EC2::Instance",
+}
+
+// originRank returns the position of origin in originPrecedence, or
+// len(originPrecedence) for an origin which is not listed.
+func originRank(origin string) int {
+	for i, o := range originPrecedence {
+		if o == origin {
+			return i
+		}
+	}
+	return len(originPrecedence)
+}
+
+// SetOrigin sets the origin of md to origin unless md already has an origin
+// which is at least as specific, following originPrecedence. Origins which
+// are not listed there rank below those which are, and the first one set
+// is kept among them.
+func (md *PluginMetadata) SetOrigin(origin string) {
+	if md.Origin == "" || originRank(origin) < originRank(md.Origin) {
+		md.Origin = origin
+	}
+}
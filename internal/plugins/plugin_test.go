@@ -0,0 +1,67 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package plugins
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	beanstalkOrigin = "AWS::ElasticBeanstalk::Environment"
+	eksOrigin       = "AWS::EKS::Container"
+	ecsOrigin       = "AWS::ECS::Container"
+	ec2Origin       = "AWS::EC2::Instance"
+)
+
+// permutations returns every ordering of origins.
+func permutations(origins []string) [][]string {
+	if len(origins) <= 1 {
+		return [][]string{origins}
+	}
+	var out [][]string
+	for i, o := range origins {
+		rest := append(append([]string(nil), origins[:i]...), origins[i+1:]...)
+		for _, p := range permutations(rest) {
+			out = append(out, append([]string{o}, p...))
+		}
+	}
+	return out
+}
+
+func TestSetOriginPrecedence(t *testing.T) {
+	tc := []struct {
+		detected []string
+		expected string
+	}{
+		{[]string{ec2Origin}, ec2Origin},
+		{[]string{ec2Origin, ecsOrigin}, ecsOrigin},
+		{[]string{ec2Origin, ecsOrigin, eksOrigin}, eksOrigin},
+		{[]string{ec2Origin, ecsOrigin, beanstalkOrigin}, beanstalkOrigin},
+		{[]string{ec2Origin, "AWS::Custom::Origin"}, ec2Origin},
+	}
+
+	for _, c := range tc {
+		for _, order := range permutations(c.detected) {
+			md := &PluginMetadata{}
+			for _, origin := range order {
+				md.SetOrigin(origin)
+			}
+			assert.Equal(t, c.expected, md.Origin, "plugins initialized in order %v", order)
+		}
+	}
+}
+
+func TestSetOriginKeepsFirstUnlisted(t *testing.T) {
+	md := &PluginMetadata{}
+	md.SetOrigin("AWS::Custom::First")
+	md.SetOrigin("AWS::Custom::Second")
+	assert.Equal(t, "AWS::Custom::First", md.Origin)
+}
@@ -1,6 +1,6 @@
 // Code generated by go-bindata. DO NOT EDIT.
 // sources:
-// resources/AWSWhitelist.json (11.191kB)
+// resources/AWSWhitelist.json (11.550kB)
 // resources/DefaultSamplingRules.json (97B)
 // resources/ExampleSamplingRules.json (609B)
 
@@ -71,7 +71,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _resourcesAwswhitelistJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xec\x59\x4b\x73\xe2\x38\x10\xbe\xf3\x2b\x5c\x3e\xa7\xf6\xb2\xb7\xdc\x18\xf2\xa8\xd4\x92\x09\x09\xcc\xe6\xb0\xb5\x45\xc9\x52\xe3\x68\x62\x4b\x8e\x1e\x4c\xa8\xad\xfc\xf7\x2d\x49\x86\x80\x31\xb6\x90\x43\x86\x64\xe6\x30\x35\xc1\x6a\xab\xbf\xfe\xfa\xa1\x6e\xf9\xbf\x5e\x14\xc5\x12\xc4\x9c\x62\x90\xf1\x69\x64\x7e\x47\x51\x4c\x16\x0c\xe5\x9c\x24\xab\x27\x51\x14\xf3\x02\x04\x52\x94\x33\xb9\xf6\x34\x8a\xe2\x2f\x48\xe1\x87\x4b\x50\x57\x0a\xf2\x8d\x95\x28\x8a\x05\x3c\x69\x90\x6a\x4a\x40\x62\x41\x0b\xc5\x85\xac\x88\x44\x51\x7c\xe7\x84\xcc\xfb\xdb\xab\x51\x14\xe7\xa8\x88\x4f\x23\x25\x34\x9c\x54\x97\x52\x50\xd3\x47\x58\xc8\x5d\xeb\x02\x18\xca\x61\xaa\x78\x7c\x1a\xc5\x0a\x25\x19\x4c\xcd\x03\x19\x6f\x08\xbe\xac\xfd\x7a\x39\xd9\xc4\x2f\x0b\xce\x24\x4c\x0b\x24\x50\x0e\x0a\x2c\xfe\x7f\x36\xf1\x0f\x38\x93\x3a\x07\x32\x40\x05\xc2\x54\x2d\xd6\x37\xff\xb7\x57\xb3\xb1\xa3\xec\x5e\x50\x05\xbf\x49\x5b\x92\xb6\x09\x23\x36\x86\x0d\x78\x96\x01\x36\x21\x77\x0d\x4a\x50\x2c\xdb\x99\x1d\x08\x40\x0a\x26\x06\xf4\x2e\x5a\x9b\x50\x5d\x66\x3c\x41\xd9\x18\x30\x67\x04\x89\xc5\x15\x23\xf0\x0c\xb2\x8a\x6d\xc8\x71\xbb\xd0\x48\xf0\x39\x95\x94\x33\x20\x93\x07\xc1\x75\xfa\x50\x68\x55\x15\xb2\x40\xbf\xa2\x1c\xda\x2d\x3b\x83\x0c\x9a\xe3\xa5\xc9\xb0\x7a\x45\x47\xe3\x36\x67\x5c\xb0\xdb\xf6\xa2\xd1\x24\x55\xf2\x2e\xba\x5a\x8a\x62\x1b\xcf\x54\x2a\x60\xea\x0e\x10\xa9\x89\xad\xef\x8e\xe1\xf3\xe7\x42\x80\x34\x61\xe6\x17\x5a\xef\x51\xdd\x86\x54\x2a\xab\xbd\x5a\x98\xbc\x2c\x3f\x7f\xc6\x99\x96\x74\x0e\x63\x85\x84\x7a\xb5\xa2\x9a\x83\x34\xa7\xca\xc3\xb4\xc6\x22\xba\xda\xbd\xb6\x84\x66\x54\xaa\xa6\x1a\x8a\xb9\x66\x3b\x05\x6a\x8a\xa8\x93\xdf\x5d\x44\x6b\xc9\x1c\xe9\xe0\x18\x3a\xf2\x9c\xbf\xd5\x20\x16\x21\x76\xf5\x95\x12\x34\xd1\x0a\xe4\x84\x5f\xc2\x56\x4d\x6d\x4e\x1d\x5b\xad\x77\x07\x54\x40\xa2\x8d\x31\x62\x76\xd7\x0b\x2e\x7e\x20\xb1\xa5\x71\x0c\x86\x99\xe3\x49\x4f\x83\xf7\x33\xd0\x0e\x69\x0e\x6c\xeb\xd5\x36\xb6\xab\x0b\x5c\x99\xa3\xdc\x6e\x25\x0f\x9c\x27\x03\x9b\xff\x35\xd1\xc3\x80\x0c\xaa\xb5\xa1\xde\x77\xdf\x0a\x82\x3e\x6d\x13\xe0\x8c\x0b\x3e\x98\x57\xf1\x79\x06\x33\xca\xa8\x9b\x54\x4e\xda\xfb\x3b\xa7\xf6\x30\xfd\x5b\x6f\xfd\xff\xd2\xd6\x58\x3e\x49\x9f\xb9\xaa\x4f\xc8\x08\x44\x4e\x5d\xf4\x07\x10\x32\x44\x09\x64\x55\xc4\xb7\x1a\x34\x7c\x13\x99\x47\x2b\xfd\x80\x58\x0a\xd7\x20\x25\x4a\xe1\x6f\x2a\x69\x42\x33\xe3\xec\x00\x24\x2b\xa5\x15\x30\xaf\xbb\x4e\x68\x0e\x5c\x7b\x64\xc0\x0e\x54\x76\xa2\xea\x04\xad\x5b\x4a\x5c\x20\x9a\x01\xf1\x9d\x4f\xac\xd2\x6e\x35\xb8\xd6\xaf\xfb\x4c\x12\x25\x85\x6f\xc6\x59\xbb\xaa\x8f\xe2\x23\x07\x3a\xd8\x47\xfe\xec\x5c\x82\xb2\xc2\x6b\x5e\xfd\x79\xec\xac\x81\xf0\x06\x6e\xb4\x86\x22\xae\x3b\x8d\xed\xc2\xcd\x0f\x06\xa2\x7f\x3f\xee\x63\xdb\x30\x5f\x91\x8e\x86\xf9\xfb\xc3\x0c\x2e\x67\x80\xc8\x10\x94\x02\x31\xe6\x5a\x60\x17\x06\x3f\xd3\x2f\xcb\x6d\x3c\xdc\x62\xf0\x77\xc4\x6b\xbc\x32\x12\x30\xa3\xcf\x5d\x67\xab\x57\xdc\x07\x1d\xad\x9e\x8c\x9a\xd0\xd1\x4a\xa4\xef\x91\xe6\x77\x80\x81\xce\xbb\x14\xdc\x55\x6e\xba\x59\xb5\x92\x35\xd7\xe8\xf9\xab\xce\x13\x10\x37\xb3\x52\xc7\xb6\x88\x7b\xde\xbc\x8f\xff\x29\x5d\x11\xb8\x47\x54\x99\x25\xd7\x57\xf9\xb4\xd1\x8d\x91\xb3\x32\xe2\xa0\x81\x93\x3b\x2d\x61\xa1\x73\x07\x39\x9f\x43\xb7\xf6\xcc\x3f\x80\xc6\xc0\x48\x87\xe8\x39\x83\x0c\x2d\x96\xbe\xf1\x69\x07\x4f\xea\x34\xf8\xb8\x6c\xe7\x41\x66\x85\xde\xea\x4a\x7a\xe9\x39\xb4\xd4\x76\x80\xeb\xe9\xd2\xa0\x2b\x8f\x76\x61\xcd\x3b\x07\xed\x70\x3c\xdc\x70\xce\x94\xa0\xc7\x92\x38\x01\x99\x5f\x76\x68\x07\x85\x3f\xb3\x3a\x6a\xd1\x57\x47\x73\x8d\x31\x48\x39\xd3\xd5\x26\xe7\x8d\x11\xc9\x95\x9e\xb0\x62\x34\x7e\xb7\x36\xd2\x23\x04\xdf\xa7\x04\xf8\xa7\x7e\xaf\xfa\xd7\xe6\x2c\x9e\xa1\x3c\x21\xc8\x67\x1c\xbf\x62\x73\xfe\x18\x54\x7f\x2f\x34\xb3\x17\x21\x75\x1d\xaf\xd9\x15\x5b\x75\x93\x45\xb1\x7d\x39\xc6\xd3\xba\xc7\xb7\x1a\x65\x74\x46\x41\x74\x1d\x88\x4a\x60\xe7\x42\x70\xb1\x75\x31\xa5\x90\xd2\x72\xc0\x89\xc7\x44\xe9\xb8\xe9\xcb\x05\xc3\x9d\x09\xea\x66\x92\x43\xbd\xd7\x6d\xcc\x9f\x3e\xde\x77\x73\xfb\x17\x8d\x1f\x41\x05\x7d\xaf\xad\x7d\xd5\xae\xcc\x51\x66\x3b\xd0\xf6\xa8\x4f\xec\x1e\x36\xe4\xf7\xad\x11\x6e\xa6\xfd\xb8\xf8\x2f\x41\xdd\x24\xdf\x01\xef\x04\xdf\x14\x14\x7f\xc1\xa2\x63\x55\x3b\x0a\xef\xfd\x1a\x04\xec\x9f\xf3\xe5\x25\x13\x12\x8f\x95\x8a\xd8\xca\xe5\xce\x83\xf2\xa8\xb9\x68\x04\xe7\xcc\x3b\x70\x0f\x45\x9c\x92\x29\xb7\x2c\x86\x75\x2d\x43\x2a\x95\x63\xb2\xc6\x0b\x3e\x96\xd6\xbf\xfc\xc6\x96\x96\x7e\x0a\xb6\xf0\xd3\xc6\xd9\x80\x33\x65\xbf\x99\x1d\x94\xfe\x2e\x01\x36\xd2\xbf\xf2\x91\x31\xd2\x65\x76\x0d\x79\x9a\x52\x96\x1e\x79\x04\x36\xdb\x30\xe2\x19\xc5\x41\x5f\x9e\xca\x37\x3f\x87\x2b\x27\xe8\x63\xb9\xb2\xbe\xdf\x66\x5e\x5f\x3f\x47\x3a\xc9\xa8\x0c\xba\x4c\x99\xf0\x82\xe2\xbe\x60\xfb\x4c\x01\x42\x33\x45\x73\xf8\x43\xa2\x14\x72\x64\x1a\x09\xef\x91\xf0\x9c\x91\x82\x53\x16\x54\x66\x96\xef\x7a\x7f\x42\xee\x99\x7f\x2f\xbd\xff\x03\x00\x00\xff\xff\x0e\x2e\x89\x4c\xb7\x2b\x00\x00")
+var _resourcesAwswhitelistJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xed\x59\x4b\x73\x1a\x39\x10\xbe\xfb\x57\xb8\x38\xbb\xf6\x92\xdb\xde\x08\xc6\x29\x57\xec\x98\x18\x12\x1f\xb6\xb6\x28\x8d\xa6\x8d\xb5\x9e\x91\xc6\x7a\x10\xa8\x94\xff\xfb\xb6\xa4\x01\xc3\x30\x80\xd0\xec\x10\xdb\xd9\x83\xcb\x85\xd4\xa3\xfe\xfa\xa9\xee\xd6\xcf\x93\xd3\xd3\x8e\x02\x39\x65\x14\x54\xe7\xcf\xd3\x9f\xf8\x1b\x57\xd2\x39\x27\xb9\x48\x93\xe5\x0a\xae\x89\x02\x24\xd1\x4c\x70\xb5\xb2\x8a\xeb\x1f\x89\xa6\x0f\x9f\x40\x5f\x6a\xc8\xd7\x76\x70\x4f\xc2\x93\x01\xa5\xc7\x29\x28\x2a\x59\xa1\x85\x54\x15\x12\x24\xba\xf5\x44\xf6\xfb\xcd\x5d\xdc\xcf\x49\x81\xcb\x5a\x1a\x38\xab\x6e\x4d\x40\x8f\x1f\x61\xae\xb6\xed\x4b\x40\x39\x60\xac\x05\x12\x74\x34\x49\x32\x18\xdb\x05\xd5\x59\x23\x7c\x5e\xf9\xf5\x7c\xb6\x8e\x5f\x15\x28\x30\x8c\x0b\x22\xf1\x3b\x0d\x0e\xff\x5f\xeb\xf8\x7b\x48\x61\x72\x48\x7b\xa4\x20\x94\xe9\xf9\xea\xe1\x7f\x9f\xd4\x1c\xec\x55\x76\x27\x99\x86\xff\x95\xb6\x50\xda\x3a\x8c\x8e\x15\xac\x27\xb2\x0c\xa8\x75\xb9\x6b\xd0\x92\x51\xb5\x5f\xb3\x3d\x09\x44\xc3\xc8\x82\xde\xa6\xd6\x5d\xa8\x3e\x65\x22\x21\xd9\x10\xa8\xe0\x29\x91\xf3\x4b\x9e\xc2\x0c\x05\xaf\x60\xbb\x12\x74\x3f\xd1\x40\x8a\x29\x53\x88\x1d\xd2\xd1\x83\x14\x66\xf2\x50\x18\x5d\x25\x72\x40\xbf\x20\x9c\xfd\x92\x9d\x43\x06\xbb\xfd\x65\x97\x60\xf5\x8c\x5e\x8d\xd9\xbc\x70\xd1\x66\x3b\x48\x8d\x36\xa8\x92\xa3\xf0\xea\xcf\x80\x1a\x0d\x43\x8d\x2e\x99\x03\xd7\x9b\xec\x5a\xc9\x2e\x7b\x72\xf1\x3e\x66\x4c\x69\xc4\x7a\x0b\x24\xad\x71\xe9\x7f\xbc\x61\xfb\xb3\x02\xc1\x5b\xef\x0e\xf3\xe8\x63\x24\xd5\x2b\x04\xee\xb8\xab\x18\xc9\xfb\x33\x9a\x19\xc5\xa6\xd6\x5a\x52\xbf\x48\x51\x0d\x7d\x96\x33\x1d\x20\xda\xce\xdc\xbd\x3c\xbd\x36\x73\x67\x28\xc7\xae\xd4\x4d\x85\xe1\xfa\x80\xdc\xed\xe9\xb7\xe7\xee\x5a\x65\x0e\x8c\x7e\xa7\xa9\xe6\xab\x01\x39\x8f\x91\xab\xab\x91\x43\x82\xf1\xac\x46\x02\x43\xac\x0a\x67\x77\xe8\xb8\x4b\x62\xbb\x43\x45\x04\xda\x90\x12\xee\x4e\xbd\x10\xf2\x07\x91\x1b\x1c\x87\x60\x35\xf3\x7a\xc2\xd3\xe2\x7d\x0f\x6a\x87\x89\x4b\xe5\x07\x6a\xbb\xba\x21\xb4\xad\x20\xdc\x51\xaa\xe5\x38\xe9\xb9\xf8\xaf\xf1\x1e\xac\x4c\x7a\xd5\xdc\x50\x6f\xbb\x91\x24\x5c\x11\xaa\xcb\x9b\x45\x1d\xe9\x22\x5b\xb0\x5d\x56\xca\xc7\x62\xfc\xad\x48\xc9\xbb\xad\xb5\xbc\x70\xd1\xf5\xcf\x32\x1e\xcf\xe1\x9e\x71\xe6\x1b\xc2\xb3\xfd\x65\xb4\x67\xdb\x4e\x99\x7c\xb2\xfa\xbf\x94\xb5\xa3\x9e\x54\x48\xfb\xda\x4d\xd3\x01\xc8\x9c\xf9\x68\x8f\x50\xc8\x15\x49\x20\xab\x22\xc6\x5b\xc6\xc0\x37\x99\x05\x74\x2c\x0f\x84\x4f\xe0\x1a\xb3\x0d\x99\xc0\x77\x54\x45\xc2\x32\x6b\xec\x08\x24\x4b\xa6\x15\x30\x2f\xa7\x8e\x58\x0e\xc2\xe8\x68\x54\xae\x71\x6d\x04\xad\x59\x48\x5c\x10\x96\x41\x1a\xda\x06\x3a\xa6\xcd\xee\x9c\x5a\xbb\x1e\xd2\xb0\x95\x2a\xfc\xcf\x74\xb6\x9f\xd5\x5b\xb1\x91\x07\x1d\x6d\xa3\x70\xed\xe0\xad\xe5\x88\x57\xac\xfa\xeb\xb4\xb3\x02\x22\x18\xb8\xe5\x1a\x8b\xb8\xae\xfa\x70\x1b\x37\x3f\x38\xc8\xee\xdd\xb0\x4b\x5d\x83\x70\x99\x36\x14\x2c\xdc\x1e\xb6\x51\x3b\xc7\x62\xed\x0a\x34\x1e\x35\x14\x46\x52\xef\x06\xbf\xd2\x2e\x8b\x63\x54\x18\xfe\x86\x78\xad\x55\x06\x12\x2f\xd0\x59\xd3\x5e\xf2\x05\x77\xab\xad\xe4\x93\x65\x13\xdb\x4a\xca\xc9\x31\xc2\xfc\x16\x28\x60\xff\xde\x20\xe1\x2e\x63\xd3\xf7\xe6\x95\xa8\xb9\x26\xb3\x2f\x26\x4f\x40\xde\xdc\x97\x3c\x36\x49\xfc\xfa\xee\x73\xc2\x6f\xe9\x0a\xc1\x1d\x61\xda\x6e\xf9\xba\x4a\x35\xf5\x9c\xa5\x10\xad\x3a\x4e\xee\xb9\xc4\xb9\xce\x2d\xe4\x62\x0a\xcd\xca\xb3\x70\x07\x1a\x02\x4f\x1b\x78\x0f\xde\x67\x64\xbe\xb0\xcd\xd9\xe1\x49\x2a\x60\xe8\x5f\xf5\xaf\x56\x27\xff\x0b\xcb\x91\x05\xb7\x16\x5e\x01\x4a\x81\x2e\xd3\x83\xac\xd3\x6a\x85\x13\x60\x86\x3e\x47\x8d\xbc\x96\xc0\x89\x88\xfc\xb2\x42\x6b\x15\xfe\xbd\xe3\x51\x8b\xbe\x3a\x8a\x30\x94\xa2\xac\xf7\x26\x6b\x17\x91\x5a\xf2\x89\x4b\x46\xc3\xa3\x95\x91\x01\x2e\x78\x9c\x14\x10\x1e\xfa\x7b\x7a\xf1\x8c\xe4\x49\x4a\x42\xda\xf1\x4b\x3e\x15\x8f\x51\xf9\xf7\xc2\x70\x37\x08\xa9\xab\x78\xed\xa9\xd4\xb1\x1b\xcd\x8b\xcd\x61\xa0\x98\xd4\x2d\x7f\x35\x24\x63\xf7\x0c\x64\xd3\x86\xa8\x04\xd6\x97\x52\xc8\x8d\x41\x9c\x26\xda\xa8\x9e\x48\x03\x3a\x4a\xaf\x9b\xae\x9a\x73\xda\x58\x41\xcd\x44\xf2\xa8\x0f\x9a\xc6\x7c\x08\xb1\xbe\xef\xdb\x3f\x1a\xfa\x08\x3a\xea\x59\xbc\xf6\x53\xb7\x33\x25\x99\xab\x40\xf7\x7b\x7d\xe2\xce\x70\x2e\x7f\x68\x8e\xf0\x3d\xed\xdb\xc5\x8f\x1d\xe7\x4d\x62\x67\xe0\x31\xfe\xf5\x19\xe6\x0d\xb3\xda\xab\xb0\xde\xef\xa1\x80\xc3\x63\xbe\x1c\x32\x11\xf9\x58\xc9\x88\x7b\x75\xa9\x5e\x79\x28\x44\x94\x50\x5e\xbc\x96\x6b\xa8\xd4\x33\x19\x0b\xa7\xc5\xb8\xaa\xc5\x0e\x2b\xbc\x26\xb7\x3f\x9e\x04\x98\xa1\xe5\x62\xb7\xb4\x53\xb4\x84\xef\xd6\xcf\x7a\x82\x6b\xf7\x46\xd8\xaa\xfa\x9b\x38\xd8\xc0\xfc\xce\x57\x06\x4a\xef\x11\x60\x0d\x39\x61\x7c\xf2\x16\x2f\xfd\xa5\x0c\x03\x91\x31\x1a\xf5\xf2\x54\x7e\xf9\x3e\x4c\x39\x22\x6f\xcb\x94\xf5\xf5\x36\x0f\x7a\xfd\x1c\x98\x04\xd3\x48\xd4\x30\x65\x24\x0a\x46\xbb\x92\x1f\xd2\x05\x48\x4c\x30\x2c\x87\x3f\xec\x64\x23\x27\xb6\x90\x08\x6e\x09\xfb\x3c\x2d\x04\xe3\x51\x69\x66\xf1\x6d\xf0\x13\xf2\x89\xfd\x7b\x3e\xf9\x17\x75\xd7\xb9\x7f\x1e\x2d\x00\x00")
 
 func resourcesAwswhitelistJsonBytes() ([]byte, error) {
 	return bindataRead(
@@ -86,8 +86,8 @@ func resourcesAwswhitelistJson() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "resources/AWSWhitelist.json", size: 11191, mode: os.FileMode(0644), modTime: time.Unix(1573699810, 0)}
-	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc0, 0xee, 0x6f, 0x3d, 0xd1, 0x64, 0x48, 0xfb, 0xd4, 0xf7, 0x9, 0xda, 0x78, 0xfe, 0x46, 0xd, 0x67, 0xa6, 0xb3, 0xa7, 0x22, 0x68, 0xee, 0x2, 0x3c, 0x30, 0xe4, 0x51, 0xa6, 0xe1, 0x9b, 0x5a}}
+	info := bindataFileInfo{name: "resources/AWSWhitelist.json", size: 11550, mode: os.FileMode(0644), modTime: time.Unix(1791963957, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x29, 0xec, 0x39, 0x9e, 0x75, 0x29, 0x12, 0x38, 0x89, 0xae, 0xfc, 0x3e, 0x47, 0xe8, 0xe1, 0x63, 0x5b, 0x78, 0x25, 0xe1, 0x9a, 0x4e, 0xd1, 0x63, 0x76, 0x63, 0xf7, 0xb7, 0x8, 0xf3, 0xc5, 0x98}}
 	return a, nil
 }
 
@@ -40,6 +40,55 @@ func TestDefaultLogErrorStrategy(t *testing.T) {
 	assert.True(t, strings.Contains(buf.String(), "Suppressing AWS X-Ray context missing panic: TestLogError"))
 }
 
+func TestLogErrorWithStackStrategy(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogErrorWithStackStrategy(xraylog.NewDefaultLogger(&buf, xraylog.LogLevelDebug))
+	l.ContextMissing("TestLogErrorWithStack")
+
+	out := buf.String()
+	assert.Contains(t, out, "Suppressing AWS X-Ray context missing panic: TestLogErrorWithStack")
+	assert.Contains(t, out, "ctxmissing.TestLogErrorWithStackStrategy")
+	assert.Contains(t, out, "ctxmissing_test.go:")
+	assert.NotContains(t, out, "LogErrorWithStackStrategy).ContextMissing")
+}
+
+func TestLogErrorWithStackStrategyDefaultsToSDKLogger(t *testing.T) {
+	oldLogger := logger.Logger
+	defer func() { logger.Logger = oldLogger }()
+
+	var buf bytes.Buffer
+	logger.Logger = xraylog.NewDefaultLogger(&buf, xraylog.LogLevelDebug)
+
+	NewLogErrorWithStackStrategy(nil).ContextMissing("TestLogErrorWithStackDefault")
+	assert.Contains(t, buf.String(), "TestLogErrorWithStackDefault")
+}
+
+func TestLogErrorWithStackStrategyIsRateLimited(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogErrorWithStackStrategy(xraylog.NewDefaultLogger(&buf, xraylog.LogLevelDebug))
+	for i := 0; i < 3; i++ {
+		l.ContextMissing("TestLogErrorWithStackRateLimited")
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "TestLogErrorWithStackRateLimited"))
+}
+
+func TestLogErrorWithStackStrategyBoundsDepth(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogErrorWithStackStrategy(xraylog.NewDefaultLogger(&buf, xraylog.LogLevelDebug))
+
+	var recurse func(n int)
+	recurse = func(n int) {
+		if n == 0 {
+			l.ContextMissing("TestLogErrorWithStackDeep")
+			return
+		}
+		recurse(n - 1)
+	}
+	recurse(2 * maxStackDepth)
+
+	assert.Equal(t, maxStackDepth, strings.Count(buf.String(), "\n\t\t"))
+}
+
 func TestDefaultIgnoreErrorStrategy(t *testing.T) {
 	defer func() {
 		p := recover()
@@ -8,7 +8,18 @@
 
 package ctxmissing
 
-import "github.com/aws/aws-xray-sdk-go/internal/logger"
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+)
+
+// maxStackDepth is the maximum number of frames logged by
+// LogErrorWithStackStrategy.
+const maxStackDepth = 32
 
 // RuntimeErrorStrategy provides the AWS_XRAY_CONTEXT_MISSING
 // environment variable value for enabling the runtime error
@@ -37,6 +48,13 @@ type DefaultLogErrorStrategy struct{}
 // ignore error context missing strategy.
 type DefaultIgnoreErrorStrategy struct{}
 
+// LogErrorWithStackStrategy implements the log error context
+// missing strategy, logging the stack of the call which found
+// the context missing along with the error message.
+type LogErrorWithStackStrategy struct {
+	logger xraylog.Logger
+}
+
 // NewDefaultRuntimeErrorStrategy initializes
 // an instance of DefaultRuntimeErrorStrategy.
 func NewDefaultRuntimeErrorStrategy() *DefaultRuntimeErrorStrategy {
@@ -55,15 +73,23 @@ func NewDefaultIgnoreErrorStrategy() *DefaultIgnoreErrorStrategy {
 	return &DefaultIgnoreErrorStrategy{}
 }
 
+// NewLogErrorWithStackStrategy initializes an instance of
+// LogErrorWithStackStrategy which logs to l, or to the SDK
+// logger if l is nil.
+func NewLogErrorWithStackStrategy(l xraylog.Logger) *LogErrorWithStackStrategy {
+	return &LogErrorWithStackStrategy{logger: l}
+}
+
 // ContextMissing panics when the segment context is missing.
 func (dr *DefaultRuntimeErrorStrategy) ContextMissing(v interface{}) {
 	panic(v)
 }
 
 // ContextMissing logs an error message when the
-// segment context is missing.
+// segment context is missing. Identical messages are
+// logged at most once per suppress interval.
 func (dl *DefaultLogErrorStrategy) ContextMissing(v interface{}) {
-	logger.Errorf("Suppressing AWS X-Ray context missing panic: %v", v)
+	logger.RateLimitedErrorf("Suppressing AWS X-Ray context missing panic: %v", v)
 }
 
 // ContextMissing ignores an error message when the
@@ -71,3 +97,23 @@ func (dl *DefaultLogErrorStrategy) ContextMissing(v interface{}) {
 func (di *DefaultIgnoreErrorStrategy) ContextMissing(v interface{}) {
 	// do nothing
 }
+
+// ContextMissing logs an error message and the stack of its
+// caller, at most maxStackDepth frames deep, when the segment
+// context is missing. Identical messages from the same stack are
+// logged at most once per suppress interval.
+func (ls *LogErrorWithStackStrategy) ContextMissing(v interface{}) {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "\n\t%s\n\t\t%s:%d", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	logger.RateLimitedLogf(ls.logger, xraylog.LogLevelError, "Suppressing AWS X-Ray context missing panic: %v%s", v, b.String())
+}
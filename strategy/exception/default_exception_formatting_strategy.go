@@ -25,6 +25,15 @@ type StackTracer interface {
 	StackTrace() []uintptr
 }
 
+// FieldsProvider is an interface for errors exposing structured fields,
+// such as an error code or whether the failure is retryable. The X-Ray
+// exception schema has no place for them, so the SDK records them as
+// metadata of the segment in the "error_fields" namespace, keyed by the ID
+// of the exception.
+type FieldsProvider interface {
+	XRayFields() map[string]interface{}
+}
+
 // Exception provides the shape for unmarshalling an exception.
 type Exception struct {
 	ID      string  `json:"id,omitempty"`
@@ -115,6 +124,40 @@ func (dEFS *DefaultFormattingStrategy) Panicf(formatString string, args ...inter
 
 // ExceptionFromError takes an error and returns value of Exception
 func (dEFS *DefaultFormattingStrategy) ExceptionFromError(err error) Exception {
+	e := ExceptionWithoutStack(err)
+
+	var s []uintptr
+
+	// This is our publicly supported interface for passing along stack traces
+	var st StackTracer
+	if goerrors.As(err, &st) {
+		s = st.StackTrace()
+	}
+
+	// We also accept github.com/pkg/errors style stack traces for ease of use
+	var est interface {
+		StackTrace() errors.StackTrace
+	}
+	if goerrors.As(err, &est) {
+		for _, frame := range est.StackTrace() {
+			s = append(s, uintptr(frame))
+		}
+	}
+
+	if s == nil {
+		s = make([]uintptr, dEFS.FrameCount)
+		n := runtime.Callers(5, s)
+		s = s[:n]
+	}
+
+	e.Stack = convertStack(s)
+	return e
+}
+
+// ExceptionWithoutStack takes an error and returns value of Exception
+// carrying only its type and message. No stack trace is captured or
+// resolved, which avoids the cost of walking the stack.
+func ExceptionWithoutStack(err error) Exception {
 	var isRemote bool
 	var reqErr awserr.RequestFailure
 	if goerrors.As(err, &reqErr) {
@@ -139,32 +182,41 @@ func (dEFS *DefaultFormattingStrategy) ExceptionFromError(err error) Exception {
 	if goerrors.As(err, &xRayErr) {
 		e.Type = xRayErr.Type
 	}
+	return e
+}
 
-	var s []uintptr
-
-	// This is our publicly supported interface for passing along stack traces
-	var st StackTracer
-	if goerrors.As(err, &st) {
-		s = st.StackTrace()
+// ErrorFields returns a copy of the fields of err, or of an error it wraps,
+// implementing FieldsProvider, or nil if it has none. The fields are copied,
+// as the error may be reused or changed after it has been recorded.
+func ErrorFields(err error) map[string]interface{} {
+	var fp FieldsProvider
+	if !goerrors.As(err, &fp) {
+		return nil
 	}
-
-	// We also accept github.com/pkg/errors style stack traces for ease of use
-	var est interface {
-		StackTrace() errors.StackTrace
+	fields := fp.XRayFields()
+	if len(fields) == 0 {
+		return nil
 	}
-	if goerrors.As(err, &est) {
-		for _, frame := range est.StackTrace() {
-			s = append(s, uintptr(frame))
-		}
+	c := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		c[k] = v
 	}
+	return c
+}
 
-	if s == nil {
-		s = make([]uintptr, dEFS.FrameCount)
-		n := runtime.Callers(5, s)
-		s = s[:n]
+// NewException returns value of Exception with the given type, message and
+// stack frames. Unlike ExceptionFromError the current stack is not captured,
+// which allows recording exceptions that originated elsewhere.
+func NewException(errType, message string, stack []uintptr, remote bool) Exception {
+	e := Exception{
+		ID:      newExceptionID(),
+		Type:    errType,
+		Message: message,
+		Remote:  remote,
+	}
+	if len(stack) > 0 {
+		e.Stack = convertStack(stack)
 	}
-
-	e.Stack = convertStack(s)
 	return e
 }
 
@@ -10,6 +10,7 @@ package exception
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -130,6 +131,65 @@ func TestExceptionFromErrorXRayError(t *testing.T) {
 	assert.Equal(t, "error", err.Type)
 }
 
+func TestNewException(t *testing.T) {
+	defs, _ := NewDefaultFormattingStrategy()
+	xRayErr := defs.Error("Test")
+
+	e := NewException("UpstreamError", "upstream failed", xRayErr.StackTrace(), true)
+
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "UpstreamError", e.Type)
+	assert.Equal(t, "upstream failed", e.Message)
+	assert.True(t, e.Remote)
+	assert.Equal(t, "TestNewException", e.Stack[0].Label)
+}
+
+func TestNewExceptionWithoutStack(t *testing.T) {
+	e := NewException("UpstreamError", "upstream failed", nil, false)
+
+	assert.NotEmpty(t, e.ID)
+	assert.False(t, e.Remote)
+	assert.Nil(t, e.Stack)
+}
+
+func TestExceptionWithoutStack(t *testing.T) {
+	defaultStrategy := &DefaultFormattingStrategy{}
+	xRayErr := defaultStrategy.Panic("new XRayError")
+
+	e := ExceptionWithoutStack(xRayErr)
+
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "new XRayError", e.Message)
+	assert.Equal(t, "panic", e.Type)
+	assert.Nil(t, e.Stack)
+}
+
+type fieldsError struct {
+	fields map[string]interface{}
+}
+
+func (e *fieldsError) Error() string {
+	return "fields error"
+}
+
+func (e *fieldsError) XRayFields() map[string]interface{} {
+	return e.fields
+}
+
+func TestErrorFields(t *testing.T) {
+	fieldsErr := &fieldsError{fields: map[string]interface{}{"code": "E42", "retryable": true}}
+
+	fields := ErrorFields(fmt.Errorf("wrapped: %w", fieldsErr))
+	fieldsErr.fields["code"] = "changed"
+
+	assert.Equal(t, map[string]interface{}{"code": "E42", "retryable": true}, fields)
+}
+
+func TestErrorFieldsWithoutFields(t *testing.T) {
+	assert.Nil(t, ErrorFields(errors.New("plain")))
+	assert.Nil(t, ErrorFields(&fieldsError{}))
+}
+
 // Benchmarks
 func BenchmarkDefaultFormattingStrategy_Error(b *testing.B) {
 	defs, _ := NewDefaultFormattingStrategy()
@@ -95,6 +95,26 @@ func NewCentralizedStrategyWithFilePath(fp string) (*CentralizedStrategy, error)
 	return newCentralizedStrategy(fb)
 }
 
+// NewCentralizedStrategyWithFilePathOrDefault creates a centralized sampling
+// strategy with a fallback on local rules located at the given file path,
+// or on the local default rule if the file cannot be loaded. See
+// NewLocalizedStrategyFromFilePathOrDefault.
+//
+// Like any centralized sampling strategy, it uses its fallback until the
+// rules of X-Ray have been retrieved, and again whenever the rules have not
+// been refreshed for an hour because the X-Ray service cannot be reached.
+func NewCentralizedStrategyWithFilePathOrDefault(fp string) (*CentralizedStrategy, error) {
+	return newCentralizedStrategy(NewLocalizedStrategyFromFilePathOrDefault(fp))
+}
+
+// NewCentralizedStrategyWithJSONBytesOrDefault creates a centralized
+// sampling strategy with a fallback on local rules specified in the given
+// byte slice, or on the local default rule if they are invalid. See
+// NewCentralizedStrategyWithFilePathOrDefault.
+func NewCentralizedStrategyWithJSONBytesOrDefault(b []byte) (*CentralizedStrategy, error) {
+	return newCentralizedStrategy(NewLocalizedStrategyFromJSONBytesOrDefault(b))
+}
+
 func newCentralizedStrategy(fb *LocalizedStrategy) (*CentralizedStrategy, error) {
 	// Generate clientID
 	var r [12]byte
@@ -185,6 +205,26 @@ func (ss *CentralizedStrategy) ShouldTrace(request *Request) *Decision {
 	return ss.fallback.ShouldTrace(request)
 }
 
+// Healthy reports whether the strategy has sampling rules from the X-Ray
+// service which have not expired. Like ShouldTrace, it starts the rule and
+// target pollers if they are not running yet.
+func (ss *CentralizedStrategy) Healthy() bool {
+	ss.mu.Lock()
+	if !ss.pollerStart {
+		ss.start()
+	}
+	ss.mu.Unlock()
+
+	if ss.manifest.expired() {
+		return false
+	}
+
+	ss.manifest.mu.RLock()
+	defer ss.manifest.mu.RUnlock()
+
+	return ss.manifest.Default != nil
+}
+
 // start initiates rule and target pollers.
 func (ss *CentralizedStrategy) start() {
 	if !ss.pollerStart {
@@ -299,22 +339,11 @@ func (ss *CentralizedStrategy) refreshManifest() (err error) {
 			continue
 		}
 
-		if len(svcRule.Attributes) != 0 {
-			logger.Debug("Sampling rule with non nil Attributes is not applicable: ", *svcRule.RuleName)
-			continue
-		}
-
 		if svcRule.ResourceARN == nil {
 			logger.Debug("Sampling rule without ResourceARN is not applicable: ", *svcRule.RuleName)
 			continue
 		}
 
-		resourceARN := *svcRule.ResourceARN
-		if resourceARN != "*" {
-			logger.Debug("Sampling rule with ResourceARN not equal to * is not applicable: ", *svcRule.RuleName)
-			continue
-		}
-
 		// Create/update rule
 		r, putErr := ss.manifest.putRule(svcRule)
 		if putErr != nil {
@@ -515,6 +544,26 @@ func (ss *CentralizedStrategy) updateTarget(t *xraySvc.SamplingTargetDocument) (
 	return nil
 }
 
+// Reset empties the reservoirs of the rules and drops the quotas of the
+// sampling targets received from X-Ray along with the statistics gathered
+// for the next target request, as if the rules had just been fetched. The
+// fallback strategy is reset as well. The rules themselves are kept, so a
+// fixed rate set by a target applies until the rules are next refreshed.
+// Reset is meant for tests and maintenance rather than for regular use.
+func (ss *CentralizedStrategy) Reset() {
+	ss.manifest.mu.RLock()
+	rules := append([]*CentralizedRule(nil), ss.manifest.Rules...)
+	if ss.manifest.Default != nil {
+		rules = append(rules, ss.manifest.Default)
+	}
+	ss.manifest.mu.RUnlock()
+
+	for _, r := range rules {
+		r.reset()
+	}
+	ss.fallback.Reset()
+}
+
 // LoadDaemonEndpoints configures proxy with the provided endpoint.
 func (ss *CentralizedStrategy) LoadDaemonEndpoints(endpoints *daemoncfg.DaemonEndpoints) {
 	ss.daemonEndpoints = endpoints
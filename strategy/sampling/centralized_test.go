@@ -159,6 +159,75 @@ func TestShouldTracePositive1(t *testing.T) {
 	assert.Equal(t, int64(9), csr1.reservoir.used)
 }
 
+// Assert request matches against a sampling rule with a wildcard service name
+func TestShouldTraceWildcardServiceName(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+
+	rand := &utils.MockRand{
+		F64: 0.06,
+	}
+
+	csr1 := &CentralizedRule{
+		ruleName: "orders",
+		reservoir: &CentralizedReservoir{
+			quota:     10,
+			expiresAt: 1500000050,
+			reservoir: &reservoir{
+				capacity:     50,
+				currentEpoch: 1500000000,
+			},
+		},
+		Properties: getProperties("*", "*", "*", "orders-*", 0, 0),
+		clock:      clock,
+		rand:       rand,
+	}
+
+	csr2 := &CentralizedRule{
+		ruleName: "prod",
+		reservoir: &CentralizedReservoir{
+			quota:     10,
+			expiresAt: 1500000050,
+			reservoir: &reservoir{
+				capacity:     50,
+				currentEpoch: 1500000000,
+			},
+		},
+		priority:   1,
+		Properties: getProperties("*", "*", "*", "*-prod", 0, 0),
+		clock:      clock,
+		rand:       rand,
+	}
+
+	m := &CentralizedManifest{
+		Rules: []*CentralizedRule{csr1, csr2},
+		Index: map[string]*CentralizedRule{
+			"orders": csr1,
+			"prod":   csr2,
+		},
+		refreshedAt: 1500000000,
+		clock:       clock,
+	}
+
+	s := &CentralizedStrategy{
+		manifest: m,
+		clock:    clock,
+		rand:     rand,
+	}
+
+	for name, rule := range map[string]string{
+		"orders-prod":    "orders",
+		"orders-staging": "orders",
+		"payments-prod":  "prod",
+	} {
+		sd := s.ShouldTrace(&Request{ServiceName: name})
+
+		assert.True(t, sd.Sample)
+		assert.Equal(t, rule, *sd.Rule, "service name %q", name)
+	}
+}
+
 // Assert request matches against the correct sampling rule and gets sampled
 // ServiceType set to nil since not configured or passed in the request.
 // r1 is matched because we do best effort matching
@@ -684,6 +753,69 @@ func TestUpdateTarget(t *testing.T) {
 	assert.Equal(t, exp, act)
 }
 
+func TestCentralizedStrategyReset(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+
+	csr := &CentralizedRule{
+		ruleName: "r1",
+		Properties: &Properties{
+			Rate: 0.05,
+		},
+		requests: 10,
+		sampled:  7,
+		borrows:  1,
+		usedAt:   1500000000,
+		reservoir: &CentralizedReservoir{
+			quota:       10,
+			refreshedAt: 1500000000,
+			expiresAt:   1500000060,
+			interval:    20,
+			borrowed:    true,
+			reservoir: &reservoir{
+				capacity:     50,
+				used:         7,
+				currentEpoch: 1500000000,
+			},
+		},
+	}
+
+	fallback, err := NewLocalizedStrategy()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fallback.manifest.Default.reservoir.used = 1
+	fallback.manifest.Default.reservoir.currentEpoch = 1500000000
+
+	s := &CentralizedStrategy{
+		manifest: &CentralizedManifest{
+			Rules: []*CentralizedRule{csr},
+			Index: map[string]*CentralizedRule{"r1": csr},
+		},
+		fallback: fallback,
+		clock:    clock,
+	}
+
+	s.Reset()
+
+	exp := &CentralizedRule{
+		ruleName: "r1",
+		Properties: &Properties{
+			Rate: 0.05,
+		},
+		reservoir: &CentralizedReservoir{
+			interval: defaultInterval,
+			reservoir: &reservoir{
+				capacity: 50,
+			},
+		},
+	}
+	assert.Equal(t, exp, s.manifest.Rules[0])
+	assert.Equal(t, int64(0), fallback.manifest.Default.reservoir.used)
+	assert.Equal(t, int64(0), fallback.manifest.Default.reservoir.currentEpoch)
+}
+
 // Assert that a missing sampling rule returns an error
 func TestUpdateTargetMissingRule(t *testing.T) {
 	// Sampling target received from centralized sampling backend
@@ -974,9 +1106,9 @@ func TestRefreshManifestRuleAddition(t *testing.T) {
 	assert.Equal(t, int64(1500000060), ss.manifest.refreshedAt)
 }
 
-func TestRefreshManifestRuleAdditionInvalidRule1(t *testing.T) { // ResourceARN has invalid value
+func TestRefreshManifestRuleAdditionResourceARN(t *testing.T) { // ResourceARN other than *
 	serviceTye := ""
-	resourceARN := "XYZ" // invalid
+	resourceARN := "arn:aws:lambda:us-east-1:123456789012:function:*"
 	// Rule 'r1'
 	r1 := &CentralizedRule{
 		ruleName: "r1",
@@ -1048,10 +1180,11 @@ func TestRefreshManifestRuleAdditionInvalidRule1(t *testing.T) { // ResourceARN
 	err := ss.refreshManifest()
 	assert.Nil(t, err)
 	// Refresh manifest with updates from mock proxy
-	assert.Equal(t, 0, len(ss.manifest.Rules)) // Rule not added
+	assert.Equal(t, 1, len(ss.manifest.Rules)) // Rule added
+	assert.Equal(t, resourceARN, ss.manifest.Rules[0].resourceARN)
 }
 
-func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attributes
+func TestRefreshManifestRuleAdditionWithAttributes(t *testing.T) {
 	serviceTye := ""
 	resourceARN := "*"
 	attributes := make(map[string]*string)
@@ -1106,7 +1239,7 @@ func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attr
 			Host:          &serviceName1,
 			ServiceType:   &serviceTye,
 			ResourceARN:   &resourceARN,
-			Attributes:    attributes, // invalid
+			Attributes:    attributes,
 		},
 	}
 
@@ -1129,10 +1262,11 @@ func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attr
 
 	err := ss.refreshManifest()
 	assert.Nil(t, err)
-	assert.Equal(t, 0, len(ss.manifest.Rules)) // rule not added
+	assert.Equal(t, 1, len(ss.manifest.Rules)) // rule added
+	assert.Equal(t, attributes, ss.manifest.Rules[0].attributes)
 }
 
-func TestRefreshManifestRuleAdditionInvalidRule3(t *testing.T) { // 1 valid and 1 invalid rule
+func TestRefreshManifestRuleAdditionRulesWithAndWithoutAttributes(t *testing.T) {
 	serviceTye := ""
 	resourceARN := "*"
 	attributes := make(map[string]*string)
@@ -1200,12 +1334,12 @@ func TestRefreshManifestRuleAdditionInvalidRule3(t *testing.T) { // 1 valid and
 			Host:          &serviceName1,
 			ServiceType:   &serviceTye,
 			ResourceARN:   &resourceARN,
-			Attributes:    attributes, // invalid
+			Attributes:    attributes,
 		},
 	}
 
 	name2 := "r2"
-	u2 := &xraySvc.SamplingRuleRecord{ // valid rule
+	u2 := &xraySvc.SamplingRuleRecord{
 		SamplingRule: &xraySvc.SamplingRule{
 			RuleName:      &name2,
 			ServiceName:   &serviceName1,
@@ -1240,8 +1374,11 @@ func TestRefreshManifestRuleAdditionInvalidRule3(t *testing.T) { // 1 valid and
 
 	err := ss.refreshManifest()
 	assert.Nil(t, err)
-	assert.Equal(t, 1, len(ss.manifest.Rules)) // u1 not added
-	assert.Equal(t, r2.ruleName, ss.manifest.Rules[0].ruleName)
+	assert.Equal(t, 2, len(ss.manifest.Rules))
+	assert.Equal(t, r1.ruleName, ss.manifest.Rules[0].ruleName)
+	assert.Equal(t, attributes, ss.manifest.Rules[0].attributes)
+	assert.Equal(t, r2.ruleName, ss.manifest.Rules[1].ruleName)
+	assert.Empty(t, ss.manifest.Rules[1].attributes)
 	// Assert on refreshedAt timestamp
 	assert.Equal(t, int64(1500000060), ss.manifest.refreshedAt)
 }
@@ -1812,6 +1949,30 @@ func TestRefreshManifestProxyError(t *testing.T) {
 	assert.Equal(t, int64(1500000000), ss.manifest.refreshedAt)
 }
 
+// Assert that the local default rule makes decisions while X-Ray cannot be reached
+func TestCentralizedStrategyOrDefaultUnreachable(t *testing.T) {
+	ss, err := NewCentralizedStrategyWithJSONBytesOrDefault([]byte(`not json`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock := &utils.MockClock{NowTime: 1500000000}
+	ss.proxy = &mockProxy{}
+	ss.clock = clock
+	ss.manifest.clock = clock
+	ss.pollerStart = true
+	ss.fallback.SetClock(clock)
+	ss.fallback.SetRand(&utils.MockRand{F64: 0.5})
+
+	assert.Error(t, ss.refreshManifest())
+
+	assert.True(t, ss.ShouldTrace(&Request{}).Sample)
+	sd := ss.ShouldTrace(&Request{})
+	assert.False(t, sd.Sample)
+	if assert.NotNil(t, sd.Rate) {
+		assert.Equal(t, 0.05, *sd.Rate)
+	}
+}
+
 // Assert that valid targets from proxy result in updated quotas for sampling rules
 func TestRefreshTargets(t *testing.T) {
 	clock := &utils.MockClock{
@@ -2763,3 +2924,85 @@ func BenchmarkCentralizedStrategy_refreshTargets(b *testing.B) {
 		}
 	})
 }
+
+func TestCentralizedStrategyRuleSummaries(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+
+	premium := "premium"
+	csr := &CentralizedRule{
+		ruleName:    "r1",
+		priority:    10,
+		Properties:  getProperties("www.foo.com", "POST", "/resource/bar", "localhost", 0.1, 5),
+		serviceType: "AWS::EC2::Instance",
+		resourceARN: "*",
+		attributes:  map[string]*string{"tenant_tier": &premium},
+		reservoir: &CentralizedReservoir{
+			quota:     2,
+			reservoir: &reservoir{capacity: 5},
+		},
+	}
+	def := &CentralizedRule{
+		ruleName:   "Default",
+		priority:   10000,
+		Properties: getProperties("*", "*", "*", "*", 0.05, 1),
+		reservoir: &CentralizedReservoir{
+			reservoir: &reservoir{capacity: 1},
+		},
+	}
+
+	fallback, err := NewLocalizedStrategy()
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := &CentralizedStrategy{
+		manifest: &CentralizedManifest{
+			Default:     def,
+			Rules:       []*CentralizedRule{csr},
+			Index:       map[string]*CentralizedRule{"r1": csr, "Default": def},
+			refreshedAt: 1500000000,
+			clock:       clock,
+		},
+		fallback: fallback,
+		clock:    clock,
+	}
+
+	exp := []RuleSummary{
+		{
+			Name:          "r1",
+			Priority:      10,
+			ServiceName:   "localhost",
+			ServiceType:   "AWS::EC2::Instance",
+			Host:          "www.foo.com",
+			HTTPMethod:    "POST",
+			URLPath:       "/resource/bar",
+			ResourceARN:   "*",
+			Attributes:    map[string]string{"tenant_tier": "premium"},
+			ReservoirSize: 5,
+			Rate:          0.1,
+			Quota:         2,
+		},
+		{
+			Name:          "Default",
+			Priority:      10000,
+			ServiceName:   "*",
+			Host:          "*",
+			HTTPMethod:    "*",
+			URLPath:       "*",
+			ReservoirSize: 1,
+			Rate:          0.05,
+			Default:       true,
+		},
+	}
+	summaries := s.RuleSummaries()
+	assert.Equal(t, exp, summaries)
+
+	// Summaries are copies of the rules.
+	summaries[0].ReservoirSize = 100
+	assert.Equal(t, int64(5), csr.FixedTarget)
+
+	// Expired rules are replaced by the fallback rules.
+	clock.NowTime = 1500010000
+	assert.Equal(t, fallback.RuleSummaries(), s.RuleSummaries())
+}
@@ -0,0 +1,117 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-xray-sdk-go/daemoncfg"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// HealthChecker is implemented by strategies which can report whether they
+// are currently able to make sampling decisions. ChainedStrategy considers
+// strategies which do not implement it to be always healthy.
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// ChainedStrategy makes sampling decisions with the first of its strategies
+// which is healthy, such as a CentralizedStrategy followed by a
+// LocalizedStrategy for when the X-Ray service cannot be reached.
+type ChainedStrategy struct {
+	strategies []Strategy
+
+	mu      sync.Mutex
+	healthy []bool
+}
+
+// NewChainedStrategy initializes an instance of ChainedStrategy which tries
+// the given strategies in order. If none of them is healthy, the last one
+// makes the decision.
+func NewChainedStrategy(strategies ...Strategy) (*ChainedStrategy, error) {
+	if len(strategies) == 0 {
+		return nil, errors.New("at least one sampling strategy is required")
+	}
+	for _, s := range strategies {
+		if s == nil {
+			return nil, errors.New("sampling strategy must not be nil")
+		}
+	}
+
+	healthy := make([]bool, len(strategies))
+	for i := range healthy {
+		healthy[i] = true
+	}
+
+	return &ChainedStrategy{
+		strategies: append([]Strategy(nil), strategies...),
+		healthy:    healthy,
+	}, nil
+}
+
+// ShouldTrace determines whether a request should be sampled using the first
+// healthy strategy of the chain.
+func (cs *ChainedStrategy) ShouldTrace(request *Request) *Decision {
+	for i, s := range cs.strategies {
+		if cs.check(i) {
+			return s.ShouldTrace(request)
+		}
+	}
+
+	logger.Debug("No sampling strategy in the chain is healthy. Using the last sampling strategy")
+
+	return cs.strategies[len(cs.strategies)-1].ShouldTrace(request)
+}
+
+// Healthy reports whether any strategy of the chain is healthy, so that
+// chains can be nested.
+func (cs *ChainedStrategy) Healthy() bool {
+	for i := range cs.strategies {
+		if cs.check(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadDaemonEndpoints passes the endpoints on to the strategies of the chain
+// which talk to the daemon.
+func (cs *ChainedStrategy) LoadDaemonEndpoints(endpoints *daemoncfg.DaemonEndpoints) {
+	for _, s := range cs.strategies {
+		if l, ok := s.(interface {
+			LoadDaemonEndpoints(*daemoncfg.DaemonEndpoints)
+		}); ok {
+			l.LoadDaemonEndpoints(endpoints)
+		}
+	}
+}
+
+// check returns the current health of the strategy at index i and logs when
+// it has changed since the last check.
+func (cs *ChainedStrategy) check(i int) bool {
+	healthy := true
+	if hc, ok := cs.strategies[i].(HealthChecker); ok {
+		healthy = hc.Healthy()
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.healthy[i] != healthy {
+		if healthy {
+			logger.Infof("Sampling strategy %d of %d in the chain is healthy again", i+1, len(cs.strategies))
+		} else {
+			logger.Infof("Sampling strategy %d of %d in the chain is unhealthy", i+1, len(cs.strategies))
+		}
+		cs.healthy[i] = healthy
+	}
+	return healthy
+}
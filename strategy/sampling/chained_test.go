@@ -0,0 +1,127 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/daemoncfg"
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHealthStrategy struct {
+	healthy   bool
+	decision  bool
+	endpoints *daemoncfg.DaemonEndpoints
+}
+
+func (ms *mockHealthStrategy) ShouldTrace(request *Request) *Decision {
+	return &Decision{Sample: ms.decision}
+}
+
+func (ms *mockHealthStrategy) Healthy() bool {
+	return ms.healthy
+}
+
+func (ms *mockHealthStrategy) LoadDaemonEndpoints(endpoints *daemoncfg.DaemonEndpoints) {
+	ms.endpoints = endpoints
+}
+
+func TestNewChainedStrategyInvalid(t *testing.T) {
+	_, err := NewChainedStrategy()
+	assert.Error(t, err)
+	_, err = NewChainedStrategy(NewDeterministicStrategy(true), nil)
+	assert.Error(t, err)
+}
+
+func TestChainedStrategyUsesFirstHealthy(t *testing.T) {
+	first := &mockHealthStrategy{healthy: false, decision: true}
+	second := &mockHealthStrategy{healthy: true, decision: false}
+	cs, err := NewChainedStrategy(first, second, NewDeterministicStrategy(true))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, cs.ShouldTrace(&Request{}).Sample)
+	assert.Equal(t, []bool{false, true, true}, cs.healthy)
+
+	first.healthy = true
+	assert.True(t, cs.ShouldTrace(&Request{}).Sample)
+	assert.Equal(t, []bool{true, true, true}, cs.healthy)
+}
+
+func TestChainedStrategyNoneHealthy(t *testing.T) {
+	first := &mockHealthStrategy{healthy: false, decision: true}
+	last := &mockHealthStrategy{healthy: false, decision: false}
+	cs, err := NewChainedStrategy(first, last)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, cs.Healthy())
+	assert.False(t, cs.ShouldTrace(&Request{}).Sample)
+}
+
+func TestChainedStrategyLoadDaemonEndpoints(t *testing.T) {
+	ms := &mockHealthStrategy{}
+	cs, err := NewChainedStrategy(ms, NewDeterministicStrategy(true))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	endpoints := &daemoncfg.DaemonEndpoints{}
+	cs.LoadDaemonEndpoints(endpoints)
+	assert.Equal(t, endpoints, ms.endpoints)
+}
+
+func TestChainedStrategyFallsBackFromCentralized(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500003601,
+	}
+
+	// Centralized manifest which has not been refreshed yet.
+	m := &CentralizedManifest{
+		Rules: []*CentralizedRule{},
+		Index: map[string]*CentralizedRule{},
+		clock: clock,
+	}
+	centralized := &CentralizedStrategy{
+		manifest:    m,
+		clock:       clock,
+		pollerStart: true,
+	}
+
+	cs, err := NewChainedStrategy(centralized, NewDeterministicStrategy(false))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, centralized.Healthy())
+	assert.False(t, cs.ShouldTrace(&Request{ServiceType: "test"}).Sample)
+
+	// Once rules have been fetched the centralized strategy is used.
+	m.refreshedAt = clock.Now().Unix()
+	m.Default = &CentralizedRule{
+		ruleName: "Default",
+		reservoir: &CentralizedReservoir{
+			quota:     10,
+			expiresAt: 1500003700,
+			reservoir: &reservoir{
+				capacity: 50,
+			},
+		},
+		Properties: &Properties{},
+		clock:      clock,
+		rand:       &utils.MockRand{F64: 0.5},
+	}
+
+	assert.True(t, centralized.Healthy())
+	assert.True(t, cs.ShouldTrace(&Request{ServiceType: "test"}).Sample)
+}
@@ -0,0 +1,26 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+// DeterministicStrategy makes the same trace sampling decision for every
+// request. It is meant for tests which must not depend on randomness.
+type DeterministicStrategy struct {
+	decision bool
+}
+
+// NewDeterministicStrategy initializes an instance of DeterministicStrategy
+// which samples every request if decision is true, and none otherwise.
+func NewDeterministicStrategy(decision bool) *DeterministicStrategy {
+	return &DeterministicStrategy{decision: decision}
+}
+
+// ShouldTrace returns the fixed sampling decision of the DeterministicStrategy.
+func (ds *DeterministicStrategy) ShouldTrace(rq *Request) *Decision {
+	return &Decision{Sample: ds.decision}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
+
+// HashStrategy samples a fixed share of traces, deciding by a hash of the
+// trace ID. Every service using a HashStrategy with the same rate makes the
+// same decision for the same trace, so a trace sampled by one of them is
+// sampled by all of them even when the decision is not passed on in the
+// trace header.
+//
+// Unlike LocalizedStrategy and CentralizedStrategy, HashStrategy has no
+// reservoir: it does not guarantee that a minimum number of requests per
+// second are sampled, so services with little traffic may go without traces
+// for long stretches, and it does not limit the number of traces sampled
+// during traffic spikes either. Its rate also applies to every request, as
+// it does not match requests against rules.
+type HashStrategy struct {
+	rate float64
+
+	// Provides random numbers for requests without a trace ID
+	rand utils.Rand
+}
+
+// NewHashStrategy initializes an instance of HashStrategy which samples the
+// share rate of traces, between 0 and 1.
+func NewHashStrategy(rate float64) (*HashStrategy, error) {
+	if math.IsNaN(rate) || rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("sampling rate %v must be between 0 and 1", rate)
+	}
+	return &HashStrategy{rate: rate, rand: &utils.DefaultRand{}}, nil
+}
+
+// ShouldTrace samples the request if the hash of its trace ID falls within
+// the rate of the strategy. Requests without a trace ID are sampled at
+// random with the same rate.
+func (hs *HashStrategy) ShouldTrace(rq *Request) *Decision {
+	rate := hs.rate
+	if rq.TraceID == "" {
+		return &Decision{Sample: hs.rand.Float64() < hs.rate, Rate: &rate}
+	}
+	return &Decision{Sample: TraceIDFraction(rq.TraceID) < hs.rate, Rate: &rate}
+}
+
+// TraceIDFraction maps the trace ID to a number in [0, 1) with the FNV-1a
+// hash of the ID. The bits of the hash are mixed with the finalizer of
+// MurmurHash3, as the high bits of FNV-1a barely change between IDs which
+// only differ in their last characters. Other decisions made by trace ID
+// can prefix the ID, so that they do not follow which traces HashStrategy
+// samples.
+func TraceIDFraction(traceID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	// Keep the 53 bits a float64 represents exactly.
+	return float64(x>>11) / (1 << 53)
+}
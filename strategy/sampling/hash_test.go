@@ -0,0 +1,71 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHashStrategyInvalidRate(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.1, math.NaN()} {
+		hs, err := NewHashStrategy(rate)
+		assert.Nil(t, hs)
+		assert.Error(t, err)
+	}
+}
+
+func TestHashStrategySameTraceSameDecision(t *testing.T) {
+	first, err := NewHashStrategy(0.5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	second, err := NewHashStrategy(0.5)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		rq := &Request{TraceID: fmt.Sprintf("1-5759e988-bd862e3fe1be46a9942727%02x", i)}
+		assert.Equal(t, first.ShouldTrace(rq).Sample, first.ShouldTrace(rq).Sample)
+		assert.Equal(t, first.ShouldTrace(rq).Sample, second.ShouldTrace(rq).Sample)
+	}
+}
+
+func TestHashStrategyRate(t *testing.T) {
+	none, _ := NewHashStrategy(0)
+	all, _ := NewHashStrategy(1)
+	tenth, _ := NewHashStrategy(0.1)
+
+	sampled := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		rq := &Request{TraceID: fmt.Sprintf("1-5759e988-%024x", i)}
+		assert.False(t, none.ShouldTrace(rq).Sample)
+		assert.True(t, all.ShouldTrace(rq).Sample)
+		if tenth.ShouldTrace(rq).Sample {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 0.1, float64(sampled)/n, 0.02)
+}
+
+func TestHashStrategyWithoutTraceID(t *testing.T) {
+	hs, _ := NewHashStrategy(0.5)
+
+	hs.rand = &utils.MockRand{F64: 0.4}
+	assert.True(t, hs.ShouldTrace(&Request{}).Sample)
+
+	hs.rand = &utils.MockRand{F64: 0.6}
+	assert.False(t, hs.ShouldTrace(&Request{}).Sample)
+}
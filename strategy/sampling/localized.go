@@ -11,6 +11,7 @@ package sampling
 import (
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/resources"
+	"github.com/aws/aws-xray-sdk-go/utils"
 )
 
 // LocalizedStrategy makes trace sampling decisions based on
@@ -57,8 +58,87 @@ func NewLocalizedStrategyFromJSONBytes(b []byte) (*LocalizedStrategy, error) {
 	return &LocalizedStrategy{manifest: manifest}, nil
 }
 
+// NewLocalizedStrategyFromFilePathOrDefault initializes an instance of
+// LocalizedStrategy using the ruleset found at the filepath fp, like
+// NewLocalizedStrategyFromFilePath. If the file cannot be read or holds
+// invalid rules, the error is logged and the default rules of
+// NewLocalizedStrategy are used instead, so that a typo in the rules
+// neither fails startup nor samples every request.
+func NewLocalizedStrategyFromFilePathOrDefault(fp string) *LocalizedStrategy {
+	ss, err := NewLocalizedStrategyFromFilePath(fp)
+	if err != nil {
+		logger.Errorf("Unable to load sampling rules from %s, using the default sampling rules instead: %v", fp, err)
+		return defaultLocalizedStrategy()
+	}
+	return ss
+}
+
+// NewLocalizedStrategyFromJSONBytesOrDefault initializes an instance of
+// LocalizedStrategy using the ruleset provided in the json bytes b, or the
+// default rules if b holds invalid rules. See
+// NewLocalizedStrategyFromFilePathOrDefault.
+func NewLocalizedStrategyFromJSONBytesOrDefault(b []byte) *LocalizedStrategy {
+	ss, err := NewLocalizedStrategyFromJSONBytes(b)
+	if err != nil {
+		logger.Errorf("Unable to load sampling rules, using the default sampling rules instead: %v", err)
+		return defaultLocalizedStrategy()
+	}
+	return ss
+}
+
+// defaultLocalizedStrategy returns a LocalizedStrategy with the default
+// rules, which are embedded in the SDK and always valid.
+func defaultLocalizedStrategy() *LocalizedStrategy {
+	ss, err := NewLocalizedStrategy()
+	if err != nil {
+		panic(err)
+	}
+	return ss
+}
+
+// SetRand replaces the random number generator the rules use to sample
+// requests beyond their reservoir, for instance with a utils.MockRand so
+// that tests of a rule set do not depend on randomness. SetRand must be
+// called before the strategy is used.
+func (lss *LocalizedStrategy) SetRand(r utils.Rand) {
+	for _, rule := range lss.rules() {
+		rule.mu.Lock()
+		rule.rand = r
+		rule.mu.Unlock()
+	}
+}
+
+// SetClock replaces the clock the reservoirs of the rules use to count
+// requests per second, for instance with a utils.MockClock. SetClock must
+// be called before the strategy is used.
+func (lss *LocalizedStrategy) SetClock(c utils.Clock) {
+	for _, rule := range lss.rules() {
+		rule.mu.Lock()
+		rule.reservoir.clock = c
+		rule.mu.Unlock()
+	}
+}
+
+// Reset empties the reservoirs of the rules, as if no request had been
+// sampled yet, for instance so that tests can start from a fresh second.
+func (lss *LocalizedStrategy) Reset() {
+	for _, rule := range lss.rules() {
+		rule.mu.Lock()
+		rule.reservoir.used = 0
+		rule.reservoir.currentEpoch = 0
+		rule.mu.Unlock()
+	}
+}
+
+// rules returns the rules of the manifest followed by the default rule.
+func (lss *LocalizedStrategy) rules() []*Rule {
+	return append(append([]*Rule(nil), lss.manifest.Rules...), lss.manifest.Default)
+}
+
 // ShouldTrace consults the LocalizedStrategy's rule set to determine
-// if the given request should be traced or not.
+// if the given request should be traced or not. Rules are evaluated in
+// priority order and the first matching rule decides. The default rule
+// applies only when no other rule matches.
 func (lss *LocalizedStrategy) ShouldTrace(rq *Request) *Decision {
 	logger.Debugf("Determining ShouldTrace decision for:\n\thost: %s\n\tpath: %s\n\tmethod: %s", rq.Host, rq.URL, rq.Method)
 	if nil != lss.manifest.Rules {
@@ -0,0 +1,137 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+// RuleSummary is a copy of the settings of a sampling rule, meant to be
+// logged to check which rules a strategy uses. Changing it has no effect
+// on the rule.
+type RuleSummary struct {
+	// Name of the rule. Local rules have no name, except the default rule.
+	Name string `json:"name,omitempty"`
+
+	// Priority orders the rule against the other rules of its strategy.
+	Priority int64 `json:"priority"`
+
+	ServiceName string `json:"service_name,omitempty"`
+	ServiceType string `json:"service_type,omitempty"`
+	Host        string `json:"host,omitempty"`
+	HTTPMethod  string `json:"http_method,omitempty"`
+	URLPath     string `json:"url_path,omitempty"`
+	ResourceARN string `json:"resource_arn,omitempty"`
+
+	// Attributes must all be present in a request, with a matching value,
+	// for the rule to apply. Only centralized rules have attributes.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// ReservoirSize is the number of requests sampled per second before
+	// Rate applies. For centralized rules, it is the reservoir size of the
+	// rule across all instances of the service.
+	ReservoirSize int64   `json:"reservoir_size"`
+	Rate          float64 `json:"rate"`
+
+	// Quota is the share of the reservoir the X-Ray service assigned to this
+	// instance. It is only set for centralized rules.
+	Quota int64 `json:"quota,omitempty"`
+
+	// Default is true for the rule applying to requests no other rule
+	// matches.
+	Default bool `json:"default,omitempty"`
+}
+
+// RuleSummarizer is implemented by strategies which can list the sampling
+// rules they use.
+type RuleSummarizer interface {
+	RuleSummaries() []RuleSummary
+}
+
+// RuleSummaries returns the rules of the strategy in the order they are
+// matched, followed by the default rule.
+func (lss *LocalizedStrategy) RuleSummaries() []RuleSummary {
+	var summaries []RuleSummary
+	for _, rule := range lss.manifest.Rules {
+		summaries = append(summaries, rule.summary())
+	}
+	if lss.manifest.Default != nil {
+		s := lss.manifest.Default.summary()
+		s.Name = "Default"
+		s.Default = true
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// RuleSummaries returns the rules fetched from the X-Ray service in the
+// order they are matched, followed by the default rule. While the strategy
+// has no rules from the service which have not expired, it returns the rules
+// of its fallback strategy instead.
+func (ss *CentralizedStrategy) RuleSummaries() []RuleSummary {
+	if ss.manifest.expired() {
+		return ss.fallback.RuleSummaries()
+	}
+
+	ss.manifest.mu.RLock()
+	defer ss.manifest.mu.RUnlock()
+
+	var summaries []RuleSummary
+	for _, rule := range ss.manifest.Rules {
+		summaries = append(summaries, rule.summary())
+	}
+	if ss.manifest.Default != nil {
+		s := ss.manifest.Default.summary()
+		s.Default = true
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+func (r *Rule) summary() RuleSummary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return RuleSummary{
+		Priority:      r.Priority,
+		ServiceName:   r.ServiceName,
+		Host:          r.Host,
+		HTTPMethod:    r.HTTPMethod,
+		URLPath:       r.URLPath,
+		ReservoirSize: r.FixedTarget,
+		Rate:          r.Rate,
+	}
+}
+
+func (r *CentralizedRule) summary() RuleSummary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := RuleSummary{
+		Name:        r.ruleName,
+		Priority:    r.priority,
+		ServiceType: r.serviceType,
+		ResourceARN: r.resourceARN,
+		Quota:       r.reservoir.quota,
+	}
+	for k, v := range r.attributes {
+		if v == nil {
+			continue
+		}
+		if s.Attributes == nil {
+			s.Attributes = make(map[string]string, len(r.attributes))
+		}
+		s.Attributes[k] = *v
+	}
+	if r.Properties != nil {
+		s.ServiceName = r.ServiceName
+		s.Host = r.Host
+		s.HTTPMethod = r.HTTPMethod
+		s.URLPath = r.URLPath
+		s.ReservoirSize = r.FixedTarget
+		s.Rate = r.Rate
+	}
+	return s
+}
@@ -12,6 +12,11 @@ package sampling
 type Decision struct {
 	Sample bool
 	Rule   *string
+
+	// Rate is the fixed rate, between 0 and 1, with which the request was
+	// sampled, or nil if the decision was not made at a rate, such as when
+	// the request was taken from a reservoir.
+	Rate *float64
 }
 
 // Request represents parameters used to make a sampling decision.
@@ -21,4 +26,14 @@ type Request struct {
 	URL         string
 	ServiceName string
 	ServiceType string
+	ResourceARN string
+
+	// TraceID is the ID of the trace the request belongs to, from the
+	// incoming trace header or generated for a new trace.
+	TraceID string
+
+	// Attributes are matched against the attributes of centralized sampling
+	// rules. A rule with attributes applies only to requests having every
+	// one of them, with a value matching the rule's wildcard pattern.
+	Attributes map[string]string
 }
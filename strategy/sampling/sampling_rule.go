@@ -43,7 +43,22 @@ func (r *CentralizedRule) AppliesTo(request *Request) bool {
 		(request.URL == "" || pattern.WildcardMatchCaseInsensitive(r.URLPath, request.URL)) &&
 		(request.Method == "" || pattern.WildcardMatchCaseInsensitive(r.HTTPMethod, request.Method)) &&
 		(request.ServiceName == "" || pattern.WildcardMatchCaseInsensitive(r.ServiceName, request.ServiceName)) &&
-		(request.ServiceType == "" || pattern.WildcardMatchCaseInsensitive(r.serviceType, request.ServiceType))
+		(request.ServiceType == "" || pattern.WildcardMatchCaseInsensitive(r.serviceType, request.ServiceType)) &&
+		pattern.WildcardMatchCaseInsensitive(r.resourceARN, request.ResourceARN) &&
+		r.attributesMatch(request.Attributes)
+}
+
+// attributesMatch returns true if attrs has every attribute of the rule,
+// with a value matching the attribute's pattern.
+// Assumes lock is already held, if required.
+func (r *CentralizedRule) attributesMatch(attrs map[string]string) bool {
+	for k, p := range r.attributes {
+		v, ok := attrs[k]
+		if !ok || p == nil || !pattern.WildcardMatchCaseInsensitive(*p, v) {
+			return false
+		}
+	}
+	return true
 }
 
 // CentralizedRule represents a centralized sampling rule
@@ -90,6 +105,17 @@ type CentralizedRule struct {
 	mu sync.RWMutex
 }
 
+// reset drops the quota of the rule's target and its statistics.
+func (r *CentralizedRule) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests, r.sampled, r.borrows, r.usedAt = 0, 0, 0, 0
+	r.reservoir.quota, r.reservoir.refreshedAt, r.reservoir.expiresAt = 0, 0, 0
+	r.reservoir.interval = defaultInterval
+	r.reservoir.reset(0)
+}
+
 // stale returns true if the quota is due for a refresh. False otherwise.
 func (r *CentralizedRule) stale(now int64) bool {
 	r.mu.RLock()
@@ -128,6 +154,8 @@ func (r *CentralizedRule) Sample() *Decision {
 			r.ruleName,
 		)
 		sd.Sample = r.bernoulliSample()
+		rate := r.Rate
+		sd.Rate = &rate
 
 		return sd
 	}
@@ -147,6 +175,8 @@ func (r *CentralizedRule) Sample() *Decision {
 
 	// Use bernoulli sampling if quota expended
 	sd.Sample = r.bernoulliSample()
+	rate := r.Rate
+	sd.Rate = &rate
 
 	return sd
 }
@@ -200,6 +230,11 @@ type Rule struct {
 	// Common sampling rule properties
 	*Properties
 
+	// Priority orders the rule against the other rules of its manifest.
+	// Rules with a lower value are matched first; rules without a priority
+	// are matched after all prioritized rules, in the order they are listed.
+	Priority int64 `json:"priority"`
+
 	mu sync.RWMutex
 }
 
@@ -211,6 +246,8 @@ func (r *Rule) Sample() *Decision {
 		sd.Sample = true
 	} else {
 		sd.Sample = r.rand.Float64() < r.Rate
+		rate := r.Rate
+		sd.Rate = &rate
 	}
 	r.mu.Unlock()
 	return &sd
@@ -13,6 +13,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/utils"
@@ -100,6 +102,9 @@ func processManifest(srm *RuleManifest) error {
 	if srm.Default.FixedTarget < 0 || srm.Default.Rate < 0 {
 		return errors.New("the default rule must specify non-negative values for fixed_target and rate")
 	}
+	if srm.Default.Priority != 0 {
+		return errors.New("the default rule must not specify a priority as it is always matched last")
+	}
 
 	c := &utils.DefaultClock{}
 
@@ -134,14 +139,33 @@ func processManifest(srm *RuleManifest) error {
 				},
 			}
 		}
+		sortRules(srm.Rules)
 	}
 	return nil
 }
 
+// sortRules orders rules by ascending priority. Rules without a priority are
+// placed after all prioritized rules, and rules of equal priority keep the
+// order in which they are listed, so the first matching rule is deterministic.
+func sortRules(rules []*Rule) {
+	key := func(r *Rule) int64 {
+		if r.Priority == 0 {
+			return math.MaxInt64
+		}
+		return r.Priority
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return key(rules[i]) < key(rules[j])
+	})
+}
+
 func validateVersion2(rule *Rule) error {
 	if rule.FixedTarget < 0 || rule.Rate < 0 {
 		return errors.New("all rules must have non-negative values for fixed_target and rate")
 	}
+	if rule.Priority < 0 {
+		return errors.New("all rules must have a non-negative priority")
+	}
 	if rule.ServiceName != "" || rule.Host == "" || rule.HTTPMethod == "" || rule.URLPath == "" {
 		return errors.New("all non-default rules must have values for url_path, host, and http_method")
 	}
@@ -152,6 +176,9 @@ func validateVersion1(rule *Rule) error {
 	if rule.FixedTarget < 0 || rule.Rate < 0 {
 		return errors.New("all rules must have non-negative values for fixed_target and rate")
 	}
+	if rule.Priority < 0 {
+		return errors.New("all rules must have a non-negative priority")
+	}
 	if rule.Host != "" || rule.ServiceName == "" || rule.HTTPMethod == "" || rule.URLPath == "" {
 		return errors.New("all non-default rules must have values for url_path, service_name, and http_method")
 	}
@@ -55,6 +55,105 @@ func TestInactiveRule(t *testing.T) {
 	assert.False(t, s)
 }
 
+func TestAppliesToServiceNameWildcard(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		serviceName string
+		applies     bool
+	}{
+		{"*", "orders-prod", true},
+		{"*", "", true},
+		{"orders-*", "orders-prod", true},
+		{"orders-*", "orders-staging", true},
+		{"orders-*", "Orders-Prod", true},
+		{"orders-*", "payments-prod", false},
+		{"*-prod", "orders-prod", true},
+		{"*-prod", "orders-staging", false},
+		{"orders-?rod", "orders-prod", true},
+		{"orders-prod", "orders-prod", true},
+		{"orders-prod", "orders-prod-2", false},
+	}
+
+	for _, test := range tests {
+		r := &CentralizedRule{
+			Properties: getProperties("*", "*", "*", test.pattern, 0, 0),
+		}
+		sr := &Request{
+			Host:        "www.foo.com",
+			Method:      "GET",
+			URL:         "/resource",
+			ServiceName: test.serviceName,
+		}
+
+		assert.Equal(t, test.applies, r.AppliesTo(sr), "pattern %q service name %q", test.pattern, test.serviceName)
+	}
+}
+
+func TestAppliesToResourceARN(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		resourceARN string
+		applies     bool
+	}{
+		{"*", "arn:aws:lambda:us-east-1:123456789012:function:orders", true},
+		{"*", "", true},
+		{"arn:aws:lambda:us-east-1:123456789012:function:*", "arn:aws:lambda:us-east-1:123456789012:function:orders", true},
+		{"arn:aws:lambda:us-east-1:123456789012:function:*", "arn:aws:apigateway:us-east-1::/restapis/abc", false},
+		{"arn:aws:lambda:us-east-1:123456789012:function:orders", "", false},
+	}
+
+	for _, test := range tests {
+		r := &CentralizedRule{
+			Properties:  getProperties("*", "*", "*", "*", 0, 0),
+			resourceARN: test.pattern,
+		}
+		sr := &Request{
+			Host:        "www.foo.com",
+			Method:      "GET",
+			URL:         "/resource",
+			ServiceName: "orders",
+			ResourceARN: test.resourceARN,
+		}
+
+		assert.Equal(t, test.applies, r.AppliesTo(sr), "pattern %q resource ARN %q", test.pattern, test.resourceARN)
+	}
+}
+
+func TestAppliesToAttributes(t *testing.T) {
+	premium, wildcard := "prem*", "*"
+	tests := []struct {
+		attributes map[string]string
+		applies    bool
+	}{
+		{map[string]string{"tenant_tier": "premium", "region": "eu"}, true},
+		{map[string]string{"tenant_tier": "Premium", "region": ""}, true},
+		{map[string]string{"tenant_tier": "basic", "region": "eu"}, false},
+		{map[string]string{"tenant_tier": "premium"}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		r := &CentralizedRule{
+			Properties:  getProperties("*", "*", "*", "*", 0, 0),
+			resourceARN: "*",
+			attributes:  map[string]*string{"tenant_tier": &premium, "region": &wildcard},
+		}
+		sr := &Request{
+			ServiceName: "orders",
+			Attributes:  test.attributes,
+		}
+
+		assert.Equal(t, test.applies, r.AppliesTo(sr), "attributes %v", test.attributes)
+	}
+
+	// Rules without attributes apply regardless of the request's attributes.
+	r := &CentralizedRule{
+		Properties:  getProperties("*", "*", "*", "*", 0, 0),
+		resourceARN: "*",
+	}
+	assert.True(t, r.AppliesTo(&Request{Attributes: map[string]string{"tenant_tier": "premium"}}))
+}
+
 func TestExpiredReservoirBernoulliSample(t *testing.T) {
 	// One second past expiration
 	clock := &utils.MockClock{
@@ -95,6 +194,9 @@ func TestExpiredReservoirBernoulliSample(t *testing.T) {
 	assert.Equal(t, "r1", *sd.Rule)
 	assert.Equal(t, int64(1), csr.sampled)
 	assert.Equal(t, int64(1), csr.requests)
+	if assert.NotNil(t, sd.Rate) {
+		assert.Equal(t, 0.06, *sd.Rate)
+	}
 }
 
 func TestTakeFromQuotaSample(t *testing.T) {
@@ -127,6 +229,7 @@ func TestTakeFromQuotaSample(t *testing.T) {
 	assert.Equal(t, int64(1), csr.sampled)
 	assert.Equal(t, int64(1), csr.requests)
 	assert.Equal(t, int64(1), csr.reservoir.used)
+	assert.Nil(t, sd.Rate)
 }
 
 func TestBernoulliSamplePositve(t *testing.T) {
@@ -170,6 +273,9 @@ func TestBernoulliSamplePositve(t *testing.T) {
 	assert.Equal(t, int64(1), csr.sampled)
 	assert.Equal(t, int64(1), csr.requests)
 	assert.Equal(t, int64(10), csr.reservoir.used)
+	if assert.NotNil(t, sd.Rate) {
+		assert.Equal(t, 0.06, *sd.Rate)
+	}
 }
 
 func TestBernoulliSampleNegative(t *testing.T) {
@@ -241,6 +347,7 @@ func TestReservoirSample(t *testing.T) {
 	assert.True(t, sd.Sample)
 	assert.Nil(t, sd.Rule)
 	assert.Equal(t, int64(6), lsr.reservoir.used)
+	assert.Nil(t, sd.Rate)
 }
 
 // Test bernoulli sampling for local sampling rule
@@ -281,6 +388,9 @@ func TestLocalBernoulliSample(t *testing.T) {
 	assert.False(t, sd.Sample)
 	assert.Nil(t, sd.Rule)
 	assert.Equal(t, int64(10), lsr.reservoir.used)
+	if assert.NotNil(t, sd.Rate) {
+		assert.Equal(t, 0.06, *sd.Rate)
+	}
 }
 
 func TestSnapshot(t *testing.T) {
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/aws/aws-xray-sdk-go/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -119,6 +120,50 @@ func TestNewLocalizedStrategyFromInvalidJSONBytes(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestNewLocalizedStrategyFromFilePathOrDefault(t *testing.T) {
+	testFile, err := filepath.Abs(filepath.Join("testdata", "rule-v1-invalid.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, ss := range map[string]*LocalizedStrategy{
+		"invalid rules": NewLocalizedStrategyFromFilePathOrDefault(testFile),
+		"missing file":  NewLocalizedStrategyFromFilePathOrDefault(filepath.Join("testdata", "missing.json")),
+		"invalid json":  NewLocalizedStrategyFromJSONBytesOrDefault([]byte(`{"version": 2, "default": {`)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if !assert.NotNil(t, ss) {
+				return
+			}
+			// The default rule samples the first request of a second and
+			// 5% of the others.
+			ss.SetClock(&utils.MockClock{NowTime: 1500000000})
+			ss.SetRand(&utils.MockRand{F64: 0.5})
+			assert.True(t, ss.ShouldTrace(&Request{}).Sample)
+			sd := ss.ShouldTrace(&Request{})
+			assert.False(t, sd.Sample)
+			if assert.NotNil(t, sd.Rate) {
+				assert.Equal(t, 0.05, *sd.Rate)
+			}
+		})
+	}
+}
+
+func TestNewLocalizedStrategyFromJSONBytesOrDefaultValidRules(t *testing.T) {
+	ss := NewLocalizedStrategyFromJSONBytesOrDefault([]byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 0,
+	    "rate": 1
+	  },
+	  "rules": [
+	  ]
+	}`))
+	ss.SetRand(&utils.MockRand{F64: 0.5})
+	assert.True(t, ss.ShouldTrace(&Request{}).Sample)
+	assert.True(t, ss.ShouldTrace(&Request{}).Sample)
+}
+
 // Benchmarks
 func BenchmarkNewLocalizedStrategyFromJSONBytes(b *testing.B) {
 	ruleBytes := []byte(`{
@@ -137,3 +182,259 @@ func BenchmarkNewLocalizedStrategyFromJSONBytes(b *testing.B) {
 		}
 	}
 }
+
+func TestLocalizedStrategyRulePriority(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 0,
+	    "rate": 0
+	  },
+	  "rules": [
+	    {
+	      "description": "unprioritized catch-all",
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "*",
+	      "fixed_target": 0,
+	      "rate": 0
+	    },
+	    {
+	      "description": "broad",
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "/api/*",
+	      "fixed_target": 0,
+	      "rate": 0,
+	      "priority": 20
+	    },
+	    {
+	      "description": "specific",
+	      "host": "*",
+	      "http_method": "GET",
+	      "url_path": "/api/users",
+	      "fixed_target": 0,
+	      "rate": 1,
+	      "priority": 10
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"/api/users", "/api/*", "*"}, []string{
+		ss.manifest.Rules[0].URLPath,
+		ss.manifest.Rules[1].URLPath,
+		ss.manifest.Rules[2].URLPath,
+	})
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/api/users", Method: "GET"}).Sample)
+		assert.False(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/api/users", Method: "POST"}).Sample)
+		assert.False(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/other", Method: "GET"}).Sample)
+	}
+}
+
+func TestLocalizedStrategyEqualPriorityKeepsOrder(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 0,
+	    "rate": 0
+	  },
+	  "rules": [
+	    {
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "/api/*",
+	      "fixed_target": 0,
+	      "rate": 1,
+	      "priority": 5
+	    },
+	    {
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "*",
+	      "fixed_target": 0,
+	      "rate": 0,
+	      "priority": 5
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "/api/*", ss.manifest.Rules[0].URLPath)
+	for i := 0; i < 100; i++ {
+		assert.True(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/api/orders", Method: "GET"}).Sample)
+	}
+}
+
+func TestLocalizedStrategyInvalidPriority(t *testing.T) {
+	negative := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 1,
+	    "rate": 0.05
+	  },
+	  "rules": [
+	    {
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "*",
+	      "fixed_target": 1,
+	      "rate": 0.05,
+	      "priority": -1
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(negative)
+	assert.Nil(t, ss)
+	assert.NotNil(t, err)
+
+	prioritizedDefault := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 1,
+	    "rate": 0.05,
+	    "priority": 1
+	  },
+	  "rules": []
+	}`)
+	ss, err = NewLocalizedStrategyFromJSONBytes(prioritizedDefault)
+	assert.Nil(t, ss)
+	assert.NotNil(t, err)
+}
+
+func TestDeterministicStrategy(t *testing.T) {
+	rq := &Request{Host: "example.com", URL: "/api", Method: "GET"}
+	assert.True(t, NewDeterministicStrategy(true).ShouldTrace(rq).Sample)
+	assert.False(t, NewDeterministicStrategy(false).ShouldTrace(rq).Sample)
+}
+
+func TestLocalizedStrategySetRand(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 0,
+	    "rate": 0.6
+	  },
+	  "rules": [
+	    {
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "/api/*",
+	      "fixed_target": 0,
+	      "rate": 0.4
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ss.SetRand(&utils.MockRand{F64: 0.5})
+
+	for i := 0; i < 100; i++ {
+		assert.False(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/api/users", Method: "GET"}).Sample)
+		assert.True(t, ss.ShouldTrace(&Request{Host: "example.com", URL: "/other", Method: "GET"}).Sample)
+	}
+}
+
+func TestLocalizedStrategySetClock(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 2,
+	    "rate": 0
+	  },
+	  "rules": []
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock := &utils.MockClock{NowTime: 1500000000}
+	ss.SetClock(clock)
+	ss.SetRand(&utils.MockRand{F64: 0.99})
+
+	rq := &Request{Host: "example.com", URL: "/", Method: "GET"}
+	for i := 0; i < 2; i++ {
+		assert.True(t, ss.ShouldTrace(rq).Sample)
+		assert.True(t, ss.ShouldTrace(rq).Sample)
+		assert.False(t, ss.ShouldTrace(rq).Sample)
+		clock.Increment(1, 0)
+	}
+}
+
+func TestLocalizedStrategyReset(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 1,
+	    "rate": 0
+	  },
+	  "rules": []
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ss.SetClock(&utils.MockClock{NowTime: 1500000000})
+	ss.SetRand(&utils.MockRand{F64: 0.99})
+
+	rq := &Request{Host: "example.com", URL: "/", Method: "GET"}
+	assert.True(t, ss.ShouldTrace(rq).Sample)
+	assert.False(t, ss.ShouldTrace(rq).Sample)
+
+	// Within the same second, the reservoir is full again after a reset.
+	ss.Reset()
+	assert.True(t, ss.ShouldTrace(rq).Sample)
+	assert.False(t, ss.ShouldTrace(rq).Sample)
+}
+
+func TestLocalizedStrategyRuleSummaries(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 1,
+	    "rate": 0.05
+	  },
+	  "rules": [
+	    {
+	      "host": "example.com",
+	      "http_method": "GET",
+	      "url_path": "/api/*",
+	      "fixed_target": 10,
+	      "rate": 0.5,
+	      "priority": 1
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	exp := []RuleSummary{
+		{
+			Priority:      1,
+			Host:          "example.com",
+			HTTPMethod:    "GET",
+			URLPath:       "/api/*",
+			ReservoirSize: 10,
+			Rate:          0.5,
+		},
+		{
+			Name:          "Default",
+			ReservoirSize: 1,
+			Rate:          0.05,
+			Default:       true,
+		},
+	}
+	assert.Equal(t, exp, ss.RuleSummaries())
+}
@@ -0,0 +1,187 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// aggregatedNamespace is the metadata namespace holding the summaries of
+// subsegments aggregated by Config.AggregationPolicy.
+const aggregatedNamespace = "aggregated"
+
+// DefaultAggregationBuckets are the upper bounds, in seconds, of the duration
+// histogram of aggregated subsegments if AggregationPolicy.Buckets is empty.
+var DefaultAggregationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// AggregationPolicy summarizes subsegments of the same name throughout a
+// segment tree into metadata of the root of the tree when it is emitted,
+// instead of emitting every one of them. Only closed subsegments without
+// subsegments of their own are aggregated, and never those whose ID
+// services downstream may refer to: those whose ID was sent in a trace
+// header and those in the "remote" or "aws" namespace are always emitted,
+// so that the traces of the called services stay attached to them.
+//
+// For every name with at least MinCount such subsegments, Keep of
+// them are emitted as they are and the others are dropped. The root records
+// all of them, kept or not, under the name in the "aggregated" metadata
+// namespace:
+//
+//	"count":          the number of subsegments
+//	"error_count":    the number of subsegments marked as an error or fault
+//	"emitted":        the number of subsegments kept
+//	"total_duration": the sum of the durations of the subsegments, in seconds
+//	"min_duration":   the shortest duration
+//	"max_duration":   the longest duration
+//	"buckets":        the upper bounds of the histogram buckets, in seconds
+//	"counts":         the number of subsegments in each bucket, followed by
+//	                  the number of those longer than the last bound
+//
+// Unlike Config.CoalesceSubsegments, which merges consecutive siblings,
+// aggregation groups subsegments by name across the whole tree. Segment
+// trees are aggregated before their subsegments are coalesced.
+type AggregationPolicy struct {
+	// MinCount is the least number of subsegments of a name which are
+	// aggregated. Policies with a MinCount below 2 aggregate nothing.
+	MinCount int
+
+	// Keep is the number of subsegments of an aggregated name which are
+	// emitted as representatives, taken in the order of the tree with the
+	// subsegments of every parent in the order they were begun.
+	Keep int
+
+	// Buckets are the ascending upper bounds, in seconds, of the duration
+	// histogram. DefaultAggregationBuckets is used if Buckets is empty.
+	Buckets []float64
+}
+
+// subsegmentAggregate accumulates the subsegments of one name.
+type subsegmentAggregate struct {
+	count, errors, kept int
+	total, min, max     float64
+	counts              []int
+}
+
+// aggregateSubsegments aggregates the subsegments of the tree below seg as
+// described for AggregationPolicy.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) aggregateSubsegments(p *AggregationPolicy) {
+	if p.MinCount < 2 {
+		return
+	}
+
+	counts := map[string]int{}
+	seg.countAggregatable(counts)
+	aggregates := map[string]*subsegmentAggregate{}
+	buckets := p.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultAggregationBuckets
+	}
+	for name, n := range counts {
+		if n >= p.MinCount {
+			aggregates[name] = &subsegmentAggregate{min: math.Inf(1), counts: make([]int, len(buckets)+1)}
+		}
+	}
+	if len(aggregates) == 0 {
+		return
+	}
+
+	removed := seg.dropAggregated(aggregates, p.Keep, buckets)
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata[aggregatedNamespace] == nil {
+		seg.Metadata[aggregatedNamespace] = map[string]interface{}{}
+	}
+	for name, a := range aggregates {
+		seg.Metadata[aggregatedNamespace][name] = map[string]interface{}{
+			"count":          a.count,
+			"error_count":    a.errors,
+			"emitted":        a.kept,
+			"total_duration": a.total,
+			"min_duration":   a.min,
+			"max_duration":   a.max,
+			"buckets":        buckets,
+			"counts":         a.counts,
+		}
+	}
+
+	if removed > 0 && seg.ParentSegment != nil {
+		atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(removed-1))
+	}
+}
+
+// countAggregatable counts the subsegments below seg which may be
+// aggregated by name.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) countAggregatable(counts map[string]int) {
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		if s.aggregatable() {
+			counts[s.Name]++
+		} else {
+			s.countAggregatable(counts)
+		}
+		s.Unlock()
+	}
+}
+
+// aggregatable reports whether seg may be aggregated by name.
+// seg has a lock acquired by the caller.
+func (seg *Segment) aggregatable() bool {
+	return seg.coalescable() && !seg.referencedDownstream()
+}
+
+// dropAggregated records the subsegments below seg into their aggregates
+// and drops all but the first keep of each name. It returns the number of
+// subsegments dropped.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) dropAggregated(aggregates map[string]*subsegmentAggregate, keep int, buckets []float64) int {
+	kept := seg.rawSubsegments[:0]
+	removed := 0
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		a := aggregates[s.Name]
+		if !s.aggregatable() || a == nil {
+			removed += s.dropAggregated(aggregates, keep, buckets)
+			s.Unlock()
+			kept = append(kept, s)
+			continue
+		}
+
+		d := s.EndTime - s.StartTime
+		a.count++
+		if s.Error || s.Fault {
+			a.errors++
+		}
+		a.total += d
+		a.min = math.Min(a.min, d)
+		a.max = math.Max(a.max, d)
+		i := 0
+		for i < len(buckets) && d > buckets[i] {
+			i++
+		}
+		a.counts[i]++
+		s.Unlock()
+
+		if a.kept < keep {
+			a.kept++
+			kept = append(kept, s)
+		} else {
+			removed++
+		}
+	}
+	for i := len(kept); i < len(seg.rawSubsegments); i++ {
+		seg.rawSubsegments[i] = nil
+	}
+	seg.rawSubsegments = kept
+	return removed
+}
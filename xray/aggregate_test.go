@@ -0,0 +1,140 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateSubsegments(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).AggregationPolicy = &AggregationPolicy{MinCount: 3, Keep: 2, Buckets: []float64{1, 2}}
+	clock := useMockClock(t, 1000)
+
+	ctx, root := BeginSegment(ctx, "root")
+	// Calls of the same name are aggregated across the tree.
+	durations := []int64{1, 3, 2, 1}
+	for i, d := range durations {
+		parent := ctx
+		if i%2 == 1 {
+			parent, _ = BeginSubsegment(ctx, "batch")
+		}
+		_, seg := BeginSubsegment(parent, "query")
+		clock.Increment(d, 0)
+		if i == 1 {
+			seg.Close(errors.New("boom"))
+		} else {
+			seg.Close(nil)
+		}
+		if i%2 == 1 {
+			GetSegment(parent).Close(nil)
+		}
+	}
+	// Names with fewer subsegments than MinCount are kept as they are.
+	for i := 0; i < 2; i++ {
+		_, seg := BeginSubsegment(ctx, "rare")
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	names := map[string]int{}
+	queries := 0
+	for _, raw := range emitted.Subsegments {
+		var s Segment
+		if assert.NoError(t, json.Unmarshal(raw, &s)) {
+			names[s.Name]++
+			for _, child := range s.Subsegments {
+				var c Segment
+				if assert.NoError(t, json.Unmarshal(child, &c)) && c.Name == "query" {
+					queries++
+				}
+			}
+		}
+	}
+	queries += names["query"]
+	assert.Equal(t, 2, queries)
+	assert.Equal(t, 2, names["batch"])
+	assert.Equal(t, 2, names["rare"])
+
+	assert.Equal(t, map[string]interface{}{
+		"query": map[string]interface{}{
+			"count":          float64(4),
+			"error_count":    float64(1),
+			"emitted":        float64(2),
+			"total_duration": float64(7),
+			"min_duration":   float64(1),
+			"max_duration":   float64(3),
+			"buckets":        []interface{}{float64(1), float64(2)},
+			"counts":         []interface{}{float64(2), float64(1), float64(1)},
+		},
+	}, emitted.Metadata[aggregatedNamespace])
+}
+
+func TestAggregateSubsegmentsDisabledByDefault(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	for i := 0; i < 5; i++ {
+		_, seg := BeginSubsegment(ctx, "query")
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, 5)
+	assert.NotContains(t, emitted.Metadata, aggregatedNamespace)
+}
+
+func TestAggregateSubsegmentsKeepsReferencedDownstream(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).AggregationPolicy = &AggregationPolicy{MinCount: 2, Keep: 1}
+
+	ctx, root := BeginSegment(ctx, "root")
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSubsegment(ctx, "propagated")
+		ids[seg.DownstreamHeader().ParentID] = true
+		seg.Close(nil)
+	}
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSubsegment(ctx, "remote")
+		seg.Namespace = "remote"
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, 6)
+	assert.NotContains(t, emitted.Metadata, aggregatedNamespace)
+	for _, b := range emitted.Subsegments {
+		var s Segment
+		assert.NoError(t, json.Unmarshal(b, &s))
+		if s.Name == "propagated" {
+			assert.True(t, ids[s.ID])
+		}
+	}
+}
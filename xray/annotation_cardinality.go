@@ -0,0 +1,88 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+)
+
+// AnnotationCardinalityGuard counts the distinct values added to every
+// annotation key within the process, and warns, at most once per key every
+// few seconds, about values past a budget of distinct values per key, such
+// as for a key recording raw user IDs. Annotations are indexed by X-Ray to
+// filter traces, which high-cardinality values make costly and of little
+// use. Set it as Config.AnnotationCardinalityGuard.
+//
+// Memory is bounded: values are remembered as 64-bit hashes, at most the
+// budget per key, and only for a limited number of keys. Further keys are
+// not guarded.
+type AnnotationCardinalityGuard struct {
+	// MoveToMetadata makes values past the budget of their key added as
+	// metadata of the default namespace rather than annotations. It must be
+	// set before the guard is used.
+	MoveToMetadata bool
+
+	maxValues int
+	maxKeys   int
+
+	mu   sync.Mutex
+	keys map[string]map[uint64]struct{}
+}
+
+// NewAnnotationCardinalityGuard initializes and returns a pointer to an
+// instance of AnnotationCardinalityGuard allowing maxValues distinct values
+// for each of up to maxKeys annotation keys.
+func NewAnnotationCardinalityGuard(maxValues, maxKeys int) (*AnnotationCardinalityGuard, error) {
+	if maxValues <= 0 {
+		return nil, fmt.Errorf("maximum number of distinct values %d must be positive", maxValues)
+	}
+	if maxKeys <= 0 {
+		return nil, fmt.Errorf("maximum number of annotation keys %d must be positive", maxKeys)
+	}
+	return &AnnotationCardinalityGuard{
+		maxValues: maxValues,
+		maxKeys:   maxKeys,
+		keys:      make(map[string]map[uint64]struct{}),
+	}, nil
+}
+
+// exceeds reports whether value is past the budget of distinct values of
+// key, counting it towards the budget otherwise.
+func (g *AnnotationCardinalityGuard) exceeds(key string, value interface{}) bool {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%T:%v", value, value)
+	sum := h.Sum64()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	values, ok := g.keys[key]
+	if !ok {
+		if len(g.keys) >= g.maxKeys {
+			return false
+		}
+		values = make(map[uint64]struct{})
+		g.keys[key] = values
+	}
+	if _, ok := values[sum]; ok {
+		return false
+	}
+	if len(values) < g.maxValues {
+		values[sum] = struct{}{}
+		return false
+	}
+
+	logger.RateLimitedLogf(nil, xraylog.LogLevelWarn, "Annotation key %q has more than %d distinct values", key, g.maxValues)
+	return true
+}
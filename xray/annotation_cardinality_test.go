@@ -0,0 +1,74 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAnnotationCardinalityGuardInvalid(t *testing.T) {
+	_, err := NewAnnotationCardinalityGuard(0, 10)
+	assert.Error(t, err)
+	_, err = NewAnnotationCardinalityGuard(10, 0)
+	assert.Error(t, err)
+}
+
+func TestAnnotationCardinalityGuardExceeds(t *testing.T) {
+	guard, err := NewAnnotationCardinalityGuard(2, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, guard.exceeds("user", "alice"))
+	assert.False(t, guard.exceeds("user", "bob"))
+	assert.True(t, guard.exceeds("user", "carol"))
+	// Values already counted stay within the budget.
+	assert.False(t, guard.exceeds("user", "alice"))
+	// Values of different types are distinct.
+	assert.False(t, guard.exceeds("status", 200))
+	assert.False(t, guard.exceeds("status", "200"))
+	assert.True(t, guard.exceeds("status", 404))
+	// Keys past the maximum are not guarded.
+	assert.False(t, guard.exceeds("route", "a"))
+	assert.False(t, guard.exceeds("route", "b"))
+	assert.False(t, guard.exceeds("route", "c"))
+}
+
+func TestAnnotationCardinalityGuardMoveToMetadata(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	guard, err := NewAnnotationCardinalityGuard(1, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+	guard.MoveToMetadata = true
+	GetRecorder(ctx).AnnotationCardinalityGuard = guard
+
+	for _, user := range []string{"alice", "bob"} {
+		_, seg := BeginSegment(ctx, "test")
+		assert.NoError(t, seg.AddAnnotation("user", user))
+		seg.Close(nil)
+	}
+
+	first, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "alice", first.Annotations["user"])
+
+	second, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, second.Annotations, "user")
+	assert.Equal(t, "bob", second.Metadata["default"]["user"])
+}
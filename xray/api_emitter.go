@@ -0,0 +1,419 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// Limits of a single PutTraceSegments call. The daemon sends batches of the
+// same number of documents.
+const (
+	apiMaxBatchDocuments = 50
+	apiMaxDocumentBytes  = 64 * 1024
+)
+
+// Default retry parameters of an APIEmitter, see WithAPIRetries.
+const (
+	defaultAPIMaxRetries  = 3
+	defaultAPIBaseBackoff = 100 * time.Millisecond
+	defaultAPIMaxBackoff  = 5 * time.Second
+)
+
+// defaultAPIFlushInterval is the longest time segments wait in an
+// APIEmitter before they are sent.
+const defaultAPIFlushInterval = time.Second
+
+// XRayClient is the part of the X-Ray API used by APIEmitter. It is
+// implemented by *xray.XRay of the AWS SDK.
+type XRayClient interface {
+	PutTraceSegmentsWithContext(ctx aws.Context, input *xraySvc.PutTraceSegmentsInput, opts ...request.Option) (*xraySvc.PutTraceSegmentsOutput, error)
+}
+
+// apiDocument is a segment document waiting to be sent, along with the ID
+// X-Ray refers to it by when it is not processed.
+type apiDocument struct {
+	id  string
+	doc string
+
+	// retries is the number of times the document has been sent again.
+	retries int
+}
+
+// APIEmitter sends segments to X-Ray with the PutTraceSegments API instead
+// of the daemon, for environments where running the daemon is impractical.
+// Every segment document, and every subsegment streamed from its tree, is
+// one trace segment document. Documents are sent in calls holding at most
+// 50 of them, once a batch is full or at least once a second; documents
+// larger than the 64KB limit of X-Ray are dropped.
+//
+// Calls failing with a throttling or other error the AWS SDK deems
+// retryable are sent again after an exponential backoff with full jitter:
+// before the nth retry the emitter waits a random time up to the base
+// backoff times 2^(n-1), capped at the maximum backoff. Documents X-Ray
+// reports as unprocessed are put back at the front of the next batch after
+// the same backoff. Calls and documents are retried at most 3 times,
+// waiting up to 100ms before the first retry and at most 5s, unless
+// configured otherwise with WithAPIRetries. Documents are dropped, and
+// counted in DroppedCount, once their retries are exhausted or when a call
+// fails with an error which is not retryable.
+//
+// Unlike the UDP packets sent to the daemon, every batch is a signed HTTPS
+// request, which adds its latency to the batch and counts against the
+// throttling limits of the account. Segments are charged at the same rate
+// as those sent by the daemon. A full batch is sent from Emit, which is
+// called when a segment closes, so wrap the emitter in a PooledEmitter to
+// keep closing segments from waiting on X-Ray, and call Close or Drain
+// before a short lived process such as a Lambda function invocation ends.
+// The X-Ray client must not be instrumented with AWS, as its calls would be
+// traced in turn.
+type APIEmitter struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment.
+	dropped uint64
+
+	client XRayClient
+
+	// maxRetries is the number of times a call or document is sent again.
+	// The nth retry waits a random time up to baseBackoff * 2^(n-1),
+	// capped at maxBackoff.
+	maxRetries  int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+
+	// mu guards pending and closed.
+	mu      sync.Mutex
+	pending []apiDocument
+	closed  bool
+
+	// sendMu serializes sending batches, so that Drain returns only once
+	// batches sent concurrently have been sent as well.
+	sendMu sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// APIEmitterOption configures an APIEmitter created by NewAPIEmitter.
+type APIEmitterOption interface {
+	apply(ae *APIEmitter)
+}
+
+type funcAPIEmitterOption struct {
+	f func(ae *APIEmitter)
+}
+
+func (f funcAPIEmitterOption) apply(ae *APIEmitter) {
+	f.f(ae)
+}
+
+// WithAPIRetries makes the emitter send failed calls and unprocessed
+// documents again up to maxRetries times, waiting a random time up to
+// baseBackoff before the first retry, doubled on every further retry up to
+// maxBackoff. A maxRetries of zero disables retries, and a maxBackoff below
+// baseBackoff is raised to baseBackoff.
+func WithAPIRetries(maxRetries int, baseBackoff, maxBackoff time.Duration) APIEmitterOption {
+	return funcAPIEmitterOption{f: func(ae *APIEmitter) {
+		if maxRetries < 0 {
+			maxRetries = 0
+		}
+		if maxBackoff < baseBackoff {
+			maxBackoff = baseBackoff
+		}
+		ae.maxRetries = maxRetries
+		ae.baseBackoff = baseBackoff
+		ae.maxBackoff = maxBackoff
+	}}
+}
+
+// NewAPIEmitter initializes and returns a pointer to an instance of
+// APIEmitter which puts segments into X-Ray through xrayClient. Call Close
+// to send the remaining segments and stop the emitter.
+func NewAPIEmitter(xrayClient XRayClient, opts ...APIEmitterOption) (*APIEmitter, error) {
+	if xrayClient == nil {
+		return nil, errors.New("x-ray client must not be nil")
+	}
+
+	ae := &APIEmitter{
+		client:      xrayClient,
+		maxRetries:  defaultAPIMaxRetries,
+		baseBackoff: defaultAPIBaseBackoff,
+		maxBackoff:  defaultAPIMaxBackoff,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(ae)
+	}
+	ae.wg.Add(1)
+	go ae.flushPeriodically(defaultAPIFlushInterval)
+	return ae, nil
+}
+
+// RefreshEmitterWithAddress is a no-op as APIEmitter
+// does not send segments to the daemon.
+func (ae *APIEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// Emit adds segment or subsegment to the batch if root segment is sampled,
+// and sends the batch once it is full.
+// seg has a write lock acquired by the caller.
+func (ae *APIEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	full := false
+	for _, p := range packSegments(seg, nil) {
+		if len(p) > apiMaxDocumentBytes {
+			logger.Errorf("Dropping segment of %d bytes, larger than the X-Ray segment document limit.", len(p))
+			atomic.AddUint64(&ae.dropped, 1)
+			continue
+		}
+		var id struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(p, &id)
+
+		ae.mu.Lock()
+		if ae.closed {
+			ae.mu.Unlock()
+			atomic.AddUint64(&ae.dropped, 1)
+			continue
+		}
+		ae.pending = append(ae.pending, apiDocument{id: id.ID, doc: string(p)})
+		full = full || len(ae.pending) >= apiMaxBatchDocuments
+		ae.mu.Unlock()
+	}
+
+	if full {
+		if err := ae.flush(context.Background()); err != nil {
+			logger.Errorf("Error sending segments to X-Ray: %v", err)
+		}
+	}
+}
+
+// Drain sends every segment emitted so far to X-Ray. It returns an error if
+// some of them could not be put, or when ctx is done.
+func (ae *APIEmitter) Drain(ctx context.Context) error {
+	return ae.flush(ctx)
+}
+
+// Close stops the emitter and sends the segments emitted so far. Segments
+// emitted after Close are dropped.
+func (ae *APIEmitter) Close() error {
+	ae.mu.Lock()
+	if ae.closed {
+		ae.mu.Unlock()
+		return nil
+	}
+	ae.closed = true
+	ae.mu.Unlock()
+
+	close(ae.done)
+	ae.wg.Wait()
+	return ae.flush(context.Background())
+}
+
+// DroppedCount returns the number of segment documents which were not put
+// into X-Ray.
+func (ae *APIEmitter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&ae.dropped)
+}
+
+func (ae *APIEmitter) flushPeriodically(interval time.Duration) {
+	defer ae.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ae.flush(context.Background()); err != nil {
+				logger.Errorf("Error sending segments to X-Ray: %v", err)
+			}
+		case <-ae.done:
+			return
+		}
+	}
+}
+
+// flush sends the pending documents in batches within the limits of
+// PutTraceSegments, including the unprocessed documents put back while
+// flushing. It returns the last error of a batch which could not be put.
+func (ae *APIEmitter) flush(ctx context.Context) error {
+	ae.sendMu.Lock()
+	defer ae.sendMu.Unlock()
+
+	var err error
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		batch := ae.nextBatch()
+		if len(batch) == 0 {
+			return err
+		}
+		if e := ae.send(ctx, batch); e != nil {
+			err = e
+		}
+	}
+}
+
+// nextBatch takes the pending documents which fit one PutTraceSegments call.
+func (ae *APIEmitter) nextBatch() []apiDocument {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	n := len(ae.pending)
+	if n > apiMaxBatchDocuments {
+		n = apiMaxBatchDocuments
+	}
+	batch := make([]apiDocument, n)
+	copy(batch, ae.pending)
+	for i := 0; i < n; i++ {
+		ae.pending[i] = apiDocument{}
+	}
+	ae.pending = ae.pending[n:]
+	return batch
+}
+
+// requeue puts the unprocessed documents back at the front of the pending
+// documents, so that they are sent with the next batch, and drops those
+// whose retries are exhausted. It returns the highest number of retries of
+// the documents put back, or -1 if all of them were dropped.
+func (ae *APIEmitter) requeue(unprocessed []apiDocument) int {
+	var retry []apiDocument
+	retries := -1
+	for _, d := range unprocessed {
+		if d.retries >= ae.maxRetries {
+			atomic.AddUint64(&ae.dropped, 1)
+			continue
+		}
+		d.retries++
+		if d.retries > retries {
+			retries = d.retries
+		}
+		retry = append(retry, d)
+	}
+	if len(retry) == 0 {
+		return -1
+	}
+
+	ae.mu.Lock()
+	ae.pending = append(retry, ae.pending...)
+	ae.mu.Unlock()
+	return retries
+}
+
+// send puts batch into X-Ray, sending it again up to maxRetries times after
+// a throttling or other retryable error, and puts the documents X-Ray did
+// not process back to be sent with the next batch. Documents which could
+// not be put in the end are dropped.
+func (ae *APIEmitter) send(ctx context.Context, batch []apiDocument) error {
+	docs := make([]*string, len(batch))
+	for i := range batch {
+		docs[i] = aws.String(batch[i].doc)
+	}
+	input := &xraySvc.PutTraceSegmentsInput{TraceSegmentDocuments: docs}
+
+	for attempt := 0; ; attempt++ {
+		out, err := ae.client.PutTraceSegmentsWithContext(ctx, input)
+		if err == nil {
+			unprocessed := unprocessedDocuments(batch, out)
+			if len(unprocessed) == 0 {
+				return nil
+			}
+			logger.Debugf("X-Ray did not process %d segment documents", len(unprocessed))
+			retries := ae.requeue(unprocessed)
+			if retries < 0 {
+				return fmt.Errorf("x-ray did not process %d segment documents", len(unprocessed))
+			}
+			// Wait before the next batch, which is likely to be
+			// throttled as well.
+			return ae.wait(ctx, retries)
+		}
+
+		if !request.IsErrorThrottle(err) && !request.IsErrorRetryable(err) || attempt == ae.maxRetries || ctx.Err() != nil {
+			atomic.AddUint64(&ae.dropped, uint64(len(batch)))
+			return err
+		}
+
+		logger.Debugf("Retrying %d segment documents after error from X-Ray: %v", len(batch), err)
+		if err := ae.wait(ctx, attempt+1); err != nil {
+			atomic.AddUint64(&ae.dropped, uint64(len(batch)))
+			return err
+		}
+	}
+}
+
+// wait sleeps before the given retry for a random time up to the backoff of
+// the retry, and returns an error if ctx is done first.
+func (ae *APIEmitter) wait(ctx context.Context, retry int) error {
+	backoff := ae.baseBackoff
+	for i := 1; i < retry && backoff < ae.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > ae.maxBackoff {
+		backoff = ae.maxBackoff
+	}
+	if backoff <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unprocessedDocuments returns the documents of batch whose IDs X-Ray
+// reports among the unprocessed trace segments.
+func unprocessedDocuments(batch []apiDocument, out *xraySvc.PutTraceSegmentsOutput) []apiDocument {
+	if out == nil || len(out.UnprocessedTraceSegments) == 0 {
+		return nil
+	}
+
+	ids := make(map[string]bool, len(out.UnprocessedTraceSegments))
+	for _, u := range out.UnprocessedTraceSegments {
+		if u != nil {
+			logger.Debugf("X-Ray did not process segment %s: %s %s", aws.StringValue(u.Id), aws.StringValue(u.ErrorCode), aws.StringValue(u.Message))
+			ids[aws.StringValue(u.Id)] = true
+		}
+	}
+
+	var unprocessed []apiDocument
+	for _, d := range batch {
+		if ids[d.id] {
+			unprocessed = append(unprocessed, d)
+		}
+	}
+	return unprocessed
+}
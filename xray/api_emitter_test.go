@@ -0,0 +1,238 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockXRay records the batches of documents it is sent. unprocessed returns
+// the IDs of the documents of a call which should not be processed.
+type mockXRay struct {
+	mu          sync.Mutex
+	batches     [][]string
+	unprocessed func(call int) []string
+	err         error
+}
+
+func (m *mockXRay) PutTraceSegmentsWithContext(ctx aws.Context, input *xraySvc.PutTraceSegmentsInput, opts ...request.Option) (*xraySvc.PutTraceSegmentsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	call := len(m.batches)
+	m.batches = append(m.batches, aws.StringValueSlice(input.TraceSegmentDocuments))
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	out := &xraySvc.PutTraceSegmentsOutput{}
+	if m.unprocessed != nil {
+		for _, id := range m.unprocessed(call) {
+			out.UnprocessedTraceSegments = append(out.UnprocessedTraceSegments, &xraySvc.UnprocessedTraceSegment{
+				Id:        aws.String(id),
+				ErrorCode: aws.String("ThrottledException"),
+			})
+		}
+	}
+	return out, nil
+}
+
+func newTestAPIEmitter(t *testing.T, client XRayClient) *APIEmitter {
+	ae, err := NewAPIEmitter(client, WithAPIRetries(defaultAPIMaxRetries, 0, 0))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return ae
+}
+
+func sampledSegmentWithID(name, id string) *Segment {
+	seg := sampledSegment(name)
+	seg.ID = id
+	return seg
+}
+
+func TestNewAPIEmitterValidation(t *testing.T) {
+	_, err := NewAPIEmitter(nil)
+	assert.Error(t, err)
+}
+
+func TestAPIEmitterSendsOnClose(t *testing.T) {
+	client := &mockXRay{}
+	ae := newTestAPIEmitter(t, client)
+
+	ae.Emit(sampledSegment("first"))
+	ae.Emit(sampledSegment("second"))
+	notSampled := sampledSegment("not sampled")
+	notSampled.Sampled = false
+	ae.Emit(notSampled)
+	assert.NoError(t, ae.Close())
+
+	ae.Emit(sampledSegment("after close"))
+	assert.Equal(t, uint64(1), ae.DroppedCount())
+
+	if !assert.Len(t, client.batches, 1) || !assert.Len(t, client.batches[0], 2) {
+		return
+	}
+	for i, name := range []string{"first", "second"} {
+		var seg Segment
+		if assert.NoError(t, json.Unmarshal([]byte(client.batches[0][i]), &seg)) {
+			assert.Equal(t, name, seg.Name)
+		}
+	}
+}
+
+func TestAPIEmitterBatchLimits(t *testing.T) {
+	client := &mockXRay{}
+	ae := newTestAPIEmitter(t, client)
+	defer ae.Close()
+
+	for i := 0; i < apiMaxBatchDocuments*2+1; i++ {
+		ae.Emit(sampledSegment("test"))
+	}
+	assert.NoError(t, ae.Drain(context.Background()))
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	total := 0
+	for _, b := range client.batches {
+		assert.True(t, len(b) <= apiMaxBatchDocuments)
+		total += len(b)
+	}
+	assert.Equal(t, apiMaxBatchDocuments*2+1, total)
+}
+
+func TestAPIEmitterDropsOversizedDocuments(t *testing.T) {
+	client := &mockXRay{}
+	ae := newTestAPIEmitter(t, client)
+
+	seg := sampledSegment("test")
+	seg.Metadata = map[string]map[string]interface{}{"default": {"pad": strings.Repeat("y", apiMaxDocumentBytes)}}
+	ae.Emit(seg)
+	assert.NoError(t, ae.Close())
+
+	assert.Equal(t, uint64(1), ae.DroppedCount())
+	assert.Empty(t, client.batches)
+}
+
+func TestAPIEmitterRetriesUnprocessedDocuments(t *testing.T) {
+	client := &mockXRay{
+		unprocessed: func(call int) []string {
+			if call == 0 {
+				return []string{"2222222222222222"}
+			}
+			return nil
+		},
+	}
+	ae := newTestAPIEmitter(t, client)
+
+	for i := 1; i <= 3; i++ {
+		ae.Emit(sampledSegmentWithID(fmt.Sprintf("segment-%d", i), strings.Repeat(fmt.Sprint(i), 16)))
+	}
+	assert.NoError(t, ae.Close())
+
+	if !assert.Len(t, client.batches, 2) {
+		return
+	}
+	assert.Len(t, client.batches[0], 3)
+	if assert.Len(t, client.batches[1], 1) {
+		assert.Equal(t, client.batches[0][1], client.batches[1][0])
+	}
+	assert.Zero(t, ae.DroppedCount())
+}
+
+func TestAPIEmitterRequeuesUnprocessedDocumentsIntoNextBatch(t *testing.T) {
+	client := &mockXRay{
+		unprocessed: func(call int) []string {
+			if call == 0 {
+				return []string{"1111111111111111"}
+			}
+			return nil
+		},
+	}
+	ae := newTestAPIEmitter(t, client)
+
+	ae.Emit(sampledSegmentWithID("first", "1111111111111111"))
+	for i := 0; i < apiMaxBatchDocuments; i++ {
+		ae.Emit(sampledSegment("test"))
+	}
+	assert.NoError(t, ae.Close())
+
+	// The full batch is sent from Emit, which goes on to send the
+	// unprocessed document before the last segment is emitted.
+	if !assert.Len(t, client.batches, 3) {
+		return
+	}
+	assert.Len(t, client.batches[0], apiMaxBatchDocuments)
+	assert.Equal(t, []string{client.batches[0][0]}, client.batches[1])
+	assert.Len(t, client.batches[2], 1)
+	assert.Zero(t, ae.DroppedCount())
+}
+
+func TestAPIEmitterDropsUnprocessedDocumentsAfterRetries(t *testing.T) {
+	client := &mockXRay{
+		unprocessed: func(call int) []string {
+			return []string{"1111111111111111"}
+		},
+	}
+	ae := newTestAPIEmitter(t, client)
+
+	ae.Emit(sampledSegmentWithID("first", "1111111111111111"))
+	ae.Emit(sampledSegmentWithID("second", "2222222222222222"))
+	assert.Error(t, ae.Close())
+
+	assert.Len(t, client.batches, defaultAPIMaxRetries+1)
+	assert.Equal(t, uint64(1), ae.DroppedCount())
+}
+
+func TestAPIEmitterRetriesThrottledCalls(t *testing.T) {
+	client := &mockXRay{err: awserr.New("ThrottlingException", "rate exceeded", nil)}
+	ae := newTestAPIEmitter(t, client)
+
+	ae.Emit(sampledSegment("test"))
+	assert.Error(t, ae.Close())
+
+	assert.Len(t, client.batches, defaultAPIMaxRetries+1)
+	assert.Equal(t, uint64(1), ae.DroppedCount())
+}
+
+func TestAPIEmitterDropsOnNonRetryableError(t *testing.T) {
+	client := &mockXRay{err: awserr.New("InvalidRequestException", "invalid request", nil)}
+	ae := newTestAPIEmitter(t, client)
+
+	ae.Emit(sampledSegment("test"))
+	ae.Emit(sampledSegment("test"))
+	assert.Error(t, ae.Close())
+
+	assert.Len(t, client.batches, 1)
+	assert.Equal(t, uint64(2), ae.DroppedCount())
+}
+
+func TestWithAPIRetries(t *testing.T) {
+	ae, err := NewAPIEmitter(&mockXRay{}, WithAPIRetries(5, time.Second, time.Millisecond))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ae.Close()
+
+	assert.Equal(t, 5, ae.maxRetries)
+	assert.Equal(t, time.Second, ae.baseBackoff)
+	assert.Equal(t, time.Second, ae.maxBackoff)
+}
@@ -13,12 +13,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptrace"
 	"reflect"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -35,6 +41,24 @@ const ExtendedRequestIDKey string = "id_2"
 // S3ExtendedRequestIDHeaderKey is the key name of the s3 extend request id.
 const S3ExtendedRequestIDHeaderKey string = "x-amz-id-2"
 
+// AWSPageNumberKey is the annotation key holding the page number of an AWS
+// call made through one of the SDK's paginators.
+const AWSPageNumberKey = "page_number"
+
+// AWSTimeoutKey is the annotation key marking AWS calls which failed because
+// a timeout of the SDK's HTTP client fired, rather than with an error from the
+// service or because the call's context was done. AWSTimeoutSecondsKey holds
+// the timeout of the HTTP client, if it has one.
+const (
+	AWSTimeoutKey        = "timeout"
+	AWSTimeoutSecondsKey = "timeout_seconds"
+)
+
+// AWSSigningTimeKey is the key in the aws map of AWS subsegments holding the
+// time in seconds spent signing the request, which includes retrieving or
+// refreshing credentials. The time spent signing every attempt is added up.
+const AWSSigningTimeKey = "signing_time"
+
 // TraceIDHeaderKey is the HTTP header name used for tracing.
 const TraceIDHeaderKey = "x-amzn-trace-id"
 
@@ -48,7 +72,7 @@ const (
 )
 
 func beginSubsegment(r *request.Request, name string) {
-	ctx, _ := BeginSubsegment(r.HTTPRequest.Context(), name)
+	ctx, _ := beginPooledSubsegment(r.HTTPRequest.Context(), name)
 	r.SetContext(ctx)
 }
 
@@ -64,12 +88,33 @@ func endSubsegment(r *request.Request) {
 var xRayBeforeValidateHandler = request.NamedHandler{
 	Name: "XRayBeforeValidateHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
+		if GetSegment(r.HTTPRequest.Context()) == nil && getLambdaTraceHeader(r.HTTPRequest.Context()) == nil {
+			// Report the missing segment once and leave the call untraced
+			// instead of failing to begin a subsegment in every handler.
+			cfg := GetRecorder(r.HTTPRequest.Context())
+			failedMessage := fmt.Sprintf("failed to trace AWS call %s.%s: segment cannot be found. Make sure to pass a context carrying a segment, e.g. by using the WithContext variant of the operation.", r.ClientInfo.ServiceName, r.Operation.Name)
+			if cfg != nil && cfg.ContextMissingStrategy != nil {
+				cfg.ContextMissingStrategy.ContextMissing(failedMessage)
+			} else {
+				globalCfg.ContextMissingStrategy().ContextMissing(failedMessage)
+			}
+			r.SetContext(context.WithValue(r.HTTPRequest.Context(), awsSkippedContextKey{}, true))
+			return
+		}
+
 		ctx, opseg := BeginSubsegment(r.HTTPRequest.Context(), r.ClientInfo.ServiceName)
 		if opseg == nil {
 			return
 		}
+		// opseg is already visible to its parent, which calls running
+		// concurrently on the same context may be streaming or emitting.
+		opseg.Lock()
 		opseg.Namespace = "aws"
-		marshalctx, _ := BeginSubsegment(ctx, "marshal")
+		opseg.Unlock()
+		marshalctx, _ := beginPooledSubsegment(ctx, "marshal")
 
 		r.SetContext(marshalctx)
 		r.HTTPRequest.Header.Set(TraceIDHeaderKey, opseg.DownstreamHeader().String())
@@ -79,6 +124,9 @@ var xRayBeforeValidateHandler = request.NamedHandler{
 var xRayAfterBuildHandler = request.NamedHandler{
 	Name: "XRayAfterBuildHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		endSubsegment(r)
 	},
 }
@@ -86,21 +134,70 @@ var xRayAfterBuildHandler = request.NamedHandler{
 var xRayBeforeSignHandler = request.NamedHandler{
 	Name: "XRayBeforeSignHandler",
 	Fn: func(r *request.Request) {
-		ctx, seg := BeginSubsegment(r.HTTPRequest.Context(), "attempt")
+		if awsCallSkipped(r) {
+			return
+		}
+		ctx, seg := beginInternalSubsegment(r.HTTPRequest.Context(), "attempt")
 		if seg == nil {
 			return
 		}
-		ct, _ := NewClientTrace(ctx)
-		r.SetContext(httptrace.WithClientTrace(ctx, ct.httpTrace))
+		// The HTTP subsegment of an X-Ray RoundTripper records the
+		// connection of the attempt itself.
+		if !tracedHTTPClient(r.Config.HTTPClient) {
+			ct, _ := NewClientTrace(ctx)
+			ctx = httptrace.WithClientTrace(ctx, ct.httpTrace)
+		}
+		r.SetContext(context.WithValue(ctx, awsSignStartContextKey{}, clock.Now()))
+	},
+}
+
+// awsSignStartContextKey holds the time the current attempt began to be
+// signed, until XRayBeforeSendHandler has recorded it.
+type awsSignStartContextKey struct{}
+
+// xRayBeforeSendHandler records the time spent signing an attempt. It runs
+// first in the Send handlers rather than last in the Sign handlers, since
+// clients push their signer to the back of Sign after the handlers of the
+// session have been copied.
+var xRayBeforeSendHandler = request.NamedHandler{
+	Name: "XRayBeforeSendHandler",
+	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
+		ctx := r.HTTPRequest.Context()
+		start, _ := ctx.Value(awsSignStartContextKey{}).(time.Time)
+		if start.IsZero() {
+			return
+		}
+		// Every attempt is signed again, so clear the start time to keep a
+		// later attempt which is not timed from counting this one twice.
+		r.SetContext(context.WithValue(ctx, awsSignStartContextKey{}, time.Time{}))
+
+		opseg := GetSegment(ctx)
+		for opseg != nil && opseg.getNamespace() != "aws" {
+			opseg = opseg.parent
+		}
+		if opseg == nil {
+			return
+		}
+		elapsed := clock.Now().Sub(start).Seconds()
+		opseg.Lock()
+		total, _ := opseg.GetAWS()[AWSSigningTimeKey].(float64)
+		opseg.GetAWS()[AWSSigningTimeKey] = total + elapsed
+		opseg.Unlock()
 	},
 }
 
 var xRayAfterSendHandler = request.NamedHandler{
 	Name: "XRayAfterSendHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		curseg := GetSegment(r.HTTPRequest.Context())
 
-		if curseg != nil && curseg.Name == "attempt" {
+		if curseg != nil && curseg.getName() == "attempt" {
 			// An error could have prevented the connect subsegment from closing,
 			// so clean it up here.
 			curseg.RLock()
@@ -121,6 +218,9 @@ var xRayAfterSendHandler = request.NamedHandler{
 var xRayBeforeUnmarshalHandler = request.NamedHandler{
 	Name: "XRayBeforeUnmarshalHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		endSubsegment(r) // end attempt subsegment
 		beginSubsegment(r, "unmarshal")
 	},
@@ -129,6 +229,9 @@ var xRayBeforeUnmarshalHandler = request.NamedHandler{
 var xRayAfterUnmarshalHandler = request.NamedHandler{
 	Name: "XRayAfterUnmarshalHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		endSubsegment(r)
 	},
 }
@@ -136,8 +239,11 @@ var xRayAfterUnmarshalHandler = request.NamedHandler{
 var xRayBeforeRetryHandler = request.NamedHandler{
 	Name: "XRayBeforeRetryHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		endSubsegment(r) // end attempt subsegment
-		ctx, _ := BeginSubsegment(r.HTTPRequest.Context(), "wait")
+		ctx, _ := beginPooledSubsegment(r.HTTPRequest.Context(), "wait")
 
 		r.SetContext(ctx)
 	},
@@ -146,6 +252,9 @@ var xRayBeforeRetryHandler = request.NamedHandler{
 var xRayAfterRetryHandler = request.NamedHandler{
 	Name: "XRayAfterRetryHandler",
 	Fn: func(r *request.Request) {
+		if awsCallSkipped(r) {
+			return
+		}
 		endSubsegment(r)
 	},
 }
@@ -154,6 +263,7 @@ func pushHandlers(handlers *request.Handlers, completionWhitelistFilename string
 	handlers.Validate.PushFrontNamed(xRayBeforeValidateHandler)
 	handlers.Build.PushBackNamed(xRayAfterBuildHandler)
 	handlers.Sign.PushFrontNamed(xRayBeforeSignHandler)
+	handlers.Send.PushFrontNamed(xRayBeforeSendHandler)
 	handlers.Send.PushBackNamed(xRayAfterSendHandler)
 	handlers.Unmarshal.PushFrontNamed(xRayBeforeUnmarshalHandler)
 	handlers.Unmarshal.PushBackNamed(xRayAfterUnmarshalHandler)
@@ -178,6 +288,40 @@ func AWSWithWhitelist(c *client.Client, filename string) {
 	pushHandlers(&c.Handlers, filename)
 }
 
+// BeginAWSSubsegment creates a subsegment for a call to an AWS compatible
+// service made without the AWS SDK, such as S3 compatible storage, which
+// shows as an AWS node in the service map like calls traced by AWS. As for
+// those calls, the subsegment is named service and is in the "aws"
+// namespace, and its aws map holds service, operation and region:
+//
+//	"name":      "S3"
+//	"namespace": "aws"
+//	"aws":       {"service": "S3", "operation": "GetObject", "region": "us-east-1"}
+//
+// service and operation are required for X-Ray to group the calls, and
+// should be named as in the AWS SDK; region is omitted if empty. Record the
+// request ID of the call under RequestIDKey of the aws map, and the status
+// of the response with HttpCaptureResponse, before closing the subsegment.
+func BeginAWSSubsegment(ctx context.Context, service, operation, region string) (context.Context, *Segment) {
+	ctx, seg := BeginSubsegment(ctx, service)
+	if seg == nil {
+		return ctx, nil
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+	if seg.Dummy {
+		return ctx, seg
+	}
+	seg.Namespace = "aws"
+	seg.GetAWS()["service"] = service
+	seg.GetAWS()["operation"] = operation
+	if region != "" {
+		seg.GetAWS()["region"] = region
+	}
+	return ctx, seg
+}
+
 // AWSSession adds X-Ray tracing to an AWS session. Clients created under this
 // session will inherit X-Ray tracing.
 func AWSSession(s *session.Session) *session.Session {
@@ -192,6 +336,85 @@ func AWSSessionWithWhitelist(s *session.Session, filename string) *session.Sessi
 	return s
 }
 
+// AWSWithSampling adds X-Ray tracing to an AWS client and only records the
+// given fraction of its calls as subsegments. See AWSSamplingRule.
+func AWSWithSampling(c *client.Client, rules ...AWSSamplingRule) {
+	if c == nil {
+		panic("Please initialize the provided AWS client before passing to the AWSWithSampling() method.")
+	}
+	pushHandlers(&c.Handlers, "")
+	c.Handlers.Validate.PushFrontNamed(xRaySamplingHandler(newAWSSampler(rules)))
+}
+
+// AWSSessionWithSampling adds X-Ray tracing to an AWS session and only
+// records the given fraction of calls made by its clients as subsegments.
+// See AWSSamplingRule.
+func AWSSessionWithSampling(s *session.Session, rules ...AWSSamplingRule) *session.Session {
+	pushHandlers(&s.Handlers, "")
+	s.Handlers.Validate.PushFrontNamed(xRaySamplingHandler(newAWSSampler(rules)))
+	return s
+}
+
+// AWSWithSkippedOperations adds X-Ray tracing to an AWS client, except for
+// calls to the given operations, which create no subsegment at all, for
+// instance DescribeTable calls made by health checks. The trace header is
+// still passed on to the service.
+func AWSWithSkippedOperations(c *client.Client, ops ...AWSOperation) {
+	if c == nil {
+		panic("Please initialize the provided AWS client before passing to the AWSWithSkippedOperations() method.")
+	}
+	pushHandlers(&c.Handlers, "")
+	c.Handlers.Validate.PushFrontNamed(xRaySkipHandler(ops))
+}
+
+// AWSSessionWithSkippedOperations adds X-Ray tracing to an AWS session,
+// except for calls to the given operations made by its clients. See
+// AWSWithSkippedOperations.
+func AWSSessionWithSkippedOperations(s *session.Session, ops ...AWSOperation) *session.Session {
+	pushHandlers(&s.Handlers, "")
+	s.Handlers.Validate.PushFrontNamed(xRaySkipHandler(ops))
+	return s
+}
+
+// AWSWithHTTPSubsegments adds X-Ray tracing to an AWS client and traces
+// the HTTP requests of its calls as well, by wrapping the HTTP client of c,
+// or http.DefaultClient if it has none, with Client and the given options.
+// Every attempt of a call then holds a "remote" subsegment recording the
+// HTTP request, along with its DNS, connect and TLS timings, and the trace
+// header passed on to the service names the HTTP subsegment as parent:
+//
+//	"S3" (aws) > "attempt" > "bucket.s3.amazonaws.com" (remote) > "connect"
+//
+// The timings are only recorded under the HTTP subsegment, instead of under
+// the attempt as they otherwise are, so that no time is counted twice. The
+// HTTP client of c is replaced by a copy and left unchanged.
+func AWSWithHTTPSubsegments(c *client.Client, opts ...ClientOption) {
+	if c == nil {
+		panic("Please initialize the provided AWS client before passing to the AWSWithHTTPSubsegments() method.")
+	}
+	pushHandlers(&c.Handlers, "")
+	c.Config.HTTPClient = Client(c.Config.HTTPClient, opts...)
+}
+
+// AWSSessionWithHTTPSubsegments adds X-Ray tracing to an AWS session and
+// traces the HTTP requests of calls made by its clients as well. See
+// AWSWithHTTPSubsegments.
+func AWSSessionWithHTTPSubsegments(s *session.Session, opts ...ClientOption) *session.Session {
+	pushHandlers(&s.Handlers, "")
+	s.Config.HTTPClient = Client(s.Config.HTTPClient, opts...)
+	return s
+}
+
+// tracedHTTPClient reports whether the requests of hc are traced by an
+// X-Ray RoundTripper.
+func tracedHTTPClient(hc *http.Client) bool {
+	if hc == nil {
+		return false
+	}
+	_, ok := hc.Transport.(*roundtripper)
+	return ok
+}
+
 func xrayCompleteHandler(filename string) request.NamedHandler {
 	whitelistJSON := parseWhitelistJSON(filename)
 	whitelist := &jsonMap{}
@@ -203,9 +426,12 @@ func xrayCompleteHandler(filename string) request.NamedHandler {
 	return request.NamedHandler{
 		Name: "XRayCompleteHandler",
 		Fn: func(r *request.Request) {
+			if awsCallSkipped(r) {
+				return
+			}
 			curseg := GetSegment(r.HTTPRequest.Context())
 
-			for curseg != nil && curseg.Namespace != "aws" {
+			for curseg != nil && curseg.getNamespace() != "aws" {
 				curseg.Close(nil)
 				curseg = curseg.parent
 			}
@@ -223,6 +449,10 @@ func xrayCompleteHandler(filename string) request.NamedHandler {
 				opseg.GetAWS()[strings.ToLower(addUnderScoreBetweenWords(k))] = v
 			}
 
+			if accountID := ResourceAccountID(r.Params, r.Data); accountID != "" {
+				opseg.GetAWS()[AccountIDKey] = accountID
+			}
+
 			opseg.GetAWS()["region"] = r.ClientInfo.SigningRegion
 			opseg.GetAWS()["operation"] = r.Operation.Name
 			opseg.GetAWS()["retries"] = r.RetryCount
@@ -242,11 +472,102 @@ func xrayCompleteHandler(filename string) request.NamedHandler {
 			}
 
 			opseg.Unlock()
+
+			if page := awsPageNumber(opseg, r); page > 0 {
+				opseg.AddAnnotation(AWSPageNumberKey, page)
+			}
+			if isAWSTimeout(r.Error) {
+				opseg.AddAnnotation(AWSTimeoutKey, true)
+				if r.Config.HTTPClient != nil && r.Config.HTTPClient.Timeout > 0 {
+					opseg.AddAnnotation(AWSTimeoutSecondsKey, r.Config.HTTPClient.Timeout.Seconds())
+				}
+			}
 			opseg.Close(r.Error)
 		},
 	}
 }
 
+// awsPageNumber returns the page number of r if its operation is paginated,
+// or 0 if it is not or the number cannot be determined. Every page is sent as
+// its own request, so the pages already get their own subsegments; the page
+// number is found by matching the request's input token against the output
+// tokens of earlier pages recorded on the parent of opseg.
+func awsPageNumber(opseg *Segment, r *request.Request) int {
+	if r.Operation == nil || r.Operation.Paginator == nil || opseg.parent == nil {
+		return 0
+	}
+	parent := opseg.parent
+	in := paginationTokenKey(r.Params, r.Operation.InputTokens)
+	out := ""
+	if r.Error == nil {
+		out = paginationTokenKey(r.Data, r.Operation.OutputTokens)
+	}
+
+	parent.Lock()
+	defer parent.Unlock()
+
+	page := 1
+	if in != "" {
+		var ok bool
+		if page, ok = parent.awsPages[in]; !ok {
+			// the caller supplied the token, so we don't know the page
+			return 0
+		}
+		delete(parent.awsPages, in)
+	}
+	if out != "" {
+		if parent.awsPages == nil {
+			parent.awsPages = make(map[string]int)
+		}
+		parent.awsPages[out] = page + 1
+	}
+	return page
+}
+
+// paginationTokenKey returns a string identifying the values of the tokens
+// at the given paths of v, or "" if none of them are set.
+func paginationTokenKey(v interface{}, tokens []string) string {
+	var values []interface{}
+	set := false
+	for _, token := range tokens {
+		vals, _ := awsutil.ValuesAtPath(v, token)
+		if len(vals) > 0 && vals[0] != nil && !reflect.ValueOf(vals[0]).IsZero() {
+			set = true
+			values = append(values, vals[0])
+		} else {
+			values = append(values, nil)
+		}
+	}
+	if !set {
+		return ""
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprint(values)
+	}
+	return string(b)
+}
+
+// isAWSTimeout reports whether err, the error of an AWS call, is a timeout
+// of the HTTP client sending the request. Calls cancelled through their
+// context fail with request.CanceledErrorCode instead.
+func isAWSTimeout(err error) bool {
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	for err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return true
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			err = aerr.OrigErr()
+		} else {
+			err = errors.Unwrap(err)
+		}
+	}
+	return false
+}
+
 func parseWhitelistJSON(filename string) []byte {
 	if filename != "" {
 		readBytes, err := ioutil.ReadFile(filename)
@@ -281,6 +602,20 @@ func keyValue(r interface{}, tag string) interface{} {
 	return nil
 }
 
+// isNilValue reports whether v is nil or a nil pointer, map or slice, as
+// unset optional fields of the AWS SDK input and output shapes are.
+func isNilValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
 func addUnderScoreBetweenWords(name string) string {
 	var buffer bytes.Buffer
 	for i, char := range name {
@@ -363,7 +698,7 @@ func extractParameters(whitelistKey string, rType int, r *request.Request, white
 				} else if rType == responseKeyword {
 					value = keyValue(r.Data, child.(string))
 				}
-				if (value != reflect.Value{}) {
+				if (value != reflect.Value{}) && !isNilValue(value) {
 					valueMap[child.(string)] = value
 				}
 			}
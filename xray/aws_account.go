@@ -0,0 +1,97 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"reflect"
+	"strings"
+)
+
+// AccountIDKey is the key of the aws metadata holding the account ID of
+// the resource an AWS call is made on, as found in the ARNs of its request
+// or response.
+const AccountIDKey = "account_id"
+
+// arnFields are the fields of AWS request and response shapes which, on top
+// of those named after ARNs, accept either a name or an ARN.
+var arnFields = map[string]bool{
+	"FunctionName": true,
+	"KeyId":        true,
+	"SecretId":     true,
+	"TableName":    true,
+}
+
+// ResourceAccountID returns the account ID of the first ARN found in the
+// top-level fields of shapes, the request and response shapes of an AWS
+// call, or "" if there is none. Only fields named after ARNs, such as
+// TopicArn or StreamARN, and fields accepting ARNs in place of names, such
+// as FunctionName, are looked at. Values which are not ARNs or do not name
+// an account, as S3 bucket ARNs, are skipped.
+func ResourceAccountID(shapes ...interface{}) string {
+	for _, shape := range shapes {
+		v := reflect.ValueOf(shape)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				break
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+
+		typ := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" || !isARNField(field.Name) {
+				continue
+			}
+			f := v.Field(i)
+			if f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					continue
+				}
+				f = f.Elem()
+			}
+			if f.Kind() != reflect.String {
+				continue
+			}
+			if accountID := arnAccountID(f.String()); accountID != "" {
+				return accountID
+			}
+		}
+	}
+	return ""
+}
+
+// isARNField reports whether the field of an AWS shape named name may hold
+// an ARN.
+func isARNField(name string) bool {
+	return strings.HasSuffix(name, "Arn") || strings.HasSuffix(name, "ARN") || arnFields[name]
+}
+
+// arnAccountID returns the account ID of arn, of the form
+// arn:partition:service:region:account-id:resource, or "" if arn is not an
+// ARN naming an account.
+func arnAccountID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[5] == "" {
+		return ""
+	}
+	accountID := parts[4]
+	if len(accountID) != 12 {
+		return ""
+	}
+	for _, c := range accountID {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return accountID
+}
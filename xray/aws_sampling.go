@@ -0,0 +1,148 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-xray-sdk-go/pattern"
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
+
+// AWSSamplingRule sets the fraction of AWS SDK calls to Service and
+// Operation which are recorded as subsegments. Service and Operation are
+// matched case-insensitively and may contain the wildcards * and ?. Calls
+// which are not recorded are counted in an annotation on the segment that
+// made them, named after the service and operation, for example
+// aws_skipped_dynamodb_getitem.
+//
+// Rules are evaluated in order and the first matching rule applies. Calls
+// matching no rule are always recorded.
+type AWSSamplingRule struct {
+	Service   string
+	Operation string
+	Rate      float64
+}
+
+type awsSampler struct {
+	rules []AWSSamplingRule
+	rand  utils.Rand
+}
+
+func newAWSSampler(rules []AWSSamplingRule) *awsSampler {
+	return &awsSampler{
+		rules: rules,
+		rand:  &utils.DefaultRand{},
+	}
+}
+
+// sample returns true if a call to the given service and operation should
+// be recorded.
+func (s *awsSampler) sample(service, operation string) bool {
+	for _, rule := range s.rules {
+		if pattern.WildcardMatchCaseInsensitive(rule.Service, service) &&
+			pattern.WildcardMatchCaseInsensitive(rule.Operation, operation) {
+			return s.rand.Float64() < rule.Rate
+		}
+	}
+	return true
+}
+
+// AWSOperation names AWS SDK calls to Service and Operation, matched
+// case-insensitively. Both may contain the wildcards * and ?.
+type AWSOperation struct {
+	Service   string
+	Operation string
+}
+
+// matchesAWSOperation returns true if a call to the given service and
+// operation matches one of ops.
+func matchesAWSOperation(ops []AWSOperation, service, operation string) bool {
+	for _, op := range ops {
+		if pattern.WildcardMatchCaseInsensitive(op.Service, service) &&
+			pattern.WildcardMatchCaseInsensitive(op.Operation, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+type awsSkippedContextKey struct{}
+
+// awsCallSkipped returns true if r is not traced, either because
+// xRaySamplingHandler decided not to record it or because no segment was found.
+func awsCallSkipped(r *request.Request) bool {
+	skipped, _ := r.HTTPRequest.Context().Value(awsSkippedContextKey{}).(bool)
+	return skipped
+}
+
+func xRaySamplingHandler(s *awsSampler) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "XRaySamplingHandler",
+		Fn: func(r *request.Request) {
+			parent := GetSegment(r.HTTPRequest.Context())
+			if parent == nil || s.sample(r.ClientInfo.ServiceName, r.Operation.Name) {
+				return
+			}
+
+			parent.Lock()
+			if !parent.Dummy {
+				// The count is added like any other annotation, so that the
+				// key is sanitized and the cardinality guard applies. The
+				// guard may have moved earlier counts to the metadata.
+				key := parent.annotationKey(skippedAWSCallsKey(r.ClientInfo.ServiceName, r.Operation.Name))
+				n, _ := parent.Annotations[key].(int)
+				if m, _ := parent.Metadata["default"][key].(int); m > n {
+					n = m
+				}
+				parent.addAnnotation(key, n+1)
+			}
+			parent.Unlock()
+
+			// Keep propagating the trace to the downstream service.
+			r.SetContext(context.WithValue(r.HTTPRequest.Context(), awsSkippedContextKey{}, true))
+			r.HTTPRequest.Header.Set(TraceIDHeaderKey, parent.DownstreamHeader().String())
+		},
+	}
+}
+
+// xRaySkipHandler keeps calls matching ops from being traced. Unlike
+// xRaySamplingHandler, it does not count them on their parent segment.
+func xRaySkipHandler(ops []AWSOperation) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "XRaySkipHandler",
+		Fn: func(r *request.Request) {
+			if !matchesAWSOperation(ops, r.ClientInfo.ServiceName, r.Operation.Name) {
+				return
+			}
+
+			r.SetContext(context.WithValue(r.HTTPRequest.Context(), awsSkippedContextKey{}, true))
+			// Keep propagating the trace to the downstream service.
+			if parent := GetSegment(r.HTTPRequest.Context()); parent != nil {
+				r.HTTPRequest.Header.Set(TraceIDHeaderKey, parent.DownstreamHeader().String())
+			}
+		},
+	}
+}
+
+// skippedAWSCallsKey returns the annotation key counting skipped calls to
+// the given service and operation. Annotation keys may only contain
+// alphanumeric characters and underscores.
+func skippedAWSCallsKey(service, operation string) string {
+	sanitize := func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}
+	return strings.Map(sanitize, "aws_skipped_"+service+"_"+operation)
+}
@@ -0,0 +1,202 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSSamplerSample(t *testing.T) {
+	rand := &utils.MockRand{F64: 0.5}
+	s := &awsSampler{
+		rules: []AWSSamplingRule{
+			{Service: "dynamodb", Operation: "GetItem", Rate: 0.1},
+			{Service: "dynamodb", Operation: "*", Rate: 0.9},
+		},
+		rand: rand,
+	}
+
+	assert.False(t, s.sample("dynamodb", "GetItem"))
+	assert.False(t, s.sample("DynamoDB", "getitem"))
+	assert.True(t, s.sample("dynamodb", "PutItem"))
+	assert.True(t, s.sample("lambda", "Invoke"))
+
+	rand.F64 = 0.05
+	assert.True(t, s.sample("dynamodb", "GetItem"))
+}
+
+func TestSkippedAWSCallsKey(t *testing.T) {
+	assert.Equal(t, "aws_skipped_dynamodb_getitem", skippedAWSCallsKey("dynamodb", "GetItem"))
+	assert.Equal(t, "aws_skipped_runtime_sagemaker_invokeendpoint", skippedAWSCallsKey("runtime.sagemaker", "InvokeEndpoint"))
+}
+
+func TestAWSWithSamplingSkipsCalls(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var traceHeaders []*header.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeaders = append(traceHeaders, header.FromString(r.Header.Get(TraceIDHeaderKey)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := lambda.New(s)
+	AWSWithSampling(svc.Client, AWSSamplingRule{Service: "lambda", Operation: "ListFunctions", Rate: 0})
+
+	ctx, root := BeginSegment(ctx, "Test")
+	for i := 0; i < 3; i++ {
+		_, err := svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+		assert.NoError(t, err)
+	}
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, seg.Subsegments)
+	assert.Equal(t, 3.0, seg.Annotations["aws_skipped_lambda_listfunctions"]) // json encoder turns this into a float64
+
+	// The trace is still propagated, with the segment as the parent.
+	if assert.Len(t, traceHeaders, 3) {
+		assert.Equal(t, seg.TraceID, traceHeaders[0].TraceID)
+		assert.Equal(t, seg.ID, traceHeaders[0].ParentID)
+	}
+}
+
+func TestAWSWithSamplingCountsThroughAnnotationRules(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	guard, err := NewAnnotationCardinalityGuard(2, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+	guard.MoveToMetadata = true
+	cfg := GetRecorder(ctx)
+	cfg.AnnotationCardinalityGuard = guard
+	cfg.AnnotationKeySanitizer = strings.ToUpper
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := lambda.New(s)
+	AWSWithSampling(svc.Client, AWSSamplingRule{Service: "lambda", Operation: "ListFunctions", Rate: 0})
+
+	ctx, root := BeginSegment(ctx, "Test")
+	for i := 0; i < 4; i++ {
+		_, err := svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+		assert.NoError(t, err)
+	}
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The key is sanitized, and counts past the distinct values the guard
+	// allows are moved to the metadata.
+	assert.Equal(t, 2.0, seg.Annotations["AWS_SKIPPED_LAMBDA_LISTFUNCTIONS"])
+	assert.Equal(t, 4.0, seg.Metadata["default"]["AWS_SKIPPED_LAMBDA_LISTFUNCTIONS"])
+	assert.NotContains(t, seg.Annotations, "aws_skipped_lambda_listfunctions")
+}
+
+func TestMatchesAWSOperation(t *testing.T) {
+	ops := []AWSOperation{
+		{Service: "dynamodb", Operation: "DescribeTable"},
+		{Service: "s3", Operation: "Head*"},
+	}
+
+	assert.True(t, matchesAWSOperation(ops, "DynamoDB", "describetable"))
+	assert.True(t, matchesAWSOperation(ops, "s3", "HeadObject"))
+	assert.False(t, matchesAWSOperation(ops, "dynamodb", "GetItem"))
+	assert.False(t, matchesAWSOperation(nil, "dynamodb", "DescribeTable"))
+}
+
+func TestAWSWithSkippedOperations(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var traceHeaders []*header.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeaders = append(traceHeaders, header.FromString(r.Header.Get(TraceIDHeaderKey)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := lambda.New(s)
+	AWSWithSkippedOperations(svc.Client, AWSOperation{Service: "lambda", Operation: "ListFunctions"})
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, err = svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+	assert.NoError(t, err)
+	_, err = svc.GetFunctionWithContext(ctx, &lambda.GetFunctionInput{FunctionName: aws.String("f")})
+	assert.NoError(t, err)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, seg.Subsegments, 1) {
+		var subseg *Segment
+		if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+			assert.Equal(t, "lambda", subseg.Name)
+			assert.Equal(t, "GetFunction", subseg.AWS["operation"])
+		}
+	}
+	assert.NotContains(t, seg.Annotations, "aws_skipped_lambda_listfunctions")
+
+	// The trace is still propagated, with the segment as the parent.
+	if assert.Len(t, traceHeaders, 2) {
+		assert.Equal(t, seg.TraceID, traceHeaders[0].TraceID)
+		assert.Equal(t, seg.ID, traceHeaders[0].ParentID)
+	}
+}
@@ -11,16 +11,23 @@ package xray
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-xray-sdk-go/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,6 +69,19 @@ func TestAWS(t *testing.T) {
 		return lambda.New(AWSSessionWithWhitelist(s, whitelist))
 	}
 
+	// Rules not matching any lambda call must not change the recorded subsegments.
+	rules := []AWSSamplingRule{{Service: "dynamodb", Operation: "*", Rate: 0}}
+
+	onClientWithSampling := func(s *session.Session) *lambda.Lambda {
+		svc := lambda.New(s)
+		AWSWithSampling(svc.Client, rules...)
+		return svc
+	}
+
+	onSessionWithSampling := func(s *session.Session) *lambda.Lambda {
+		return lambda.New(AWSSessionWithSampling(s, rules...))
+	}
+
 	type constructor func(*session.Session) *lambda.Lambda
 	constructors := []struct {
 		name        string
@@ -71,6 +91,8 @@ func TestAWS(t *testing.T) {
 		{"AWSSession()", onSession},
 		{"AWSWithWhitelist()", onClientWithWhitelist},
 		{"AWSSessionWithWhitelist()", onSessionWithWhitelist},
+		{"AWSWithSampling()", onClientWithSampling},
+		{"AWSSessionWithSampling()", onSessionWithSampling},
 	}
 
 	// Run all combinations of constructors + tests.
@@ -239,3 +261,612 @@ func testAWSDataRace(ctx context.Context, td *TestDaemon, t *testing.T, svc *lam
 	wg.Wait()
 	seg.Close(nil)
 }
+
+type countingContextMissingStrategy struct {
+	messages []string
+}
+
+func (s *countingContextMissingStrategy) ContextMissing(v interface{}) {
+	s.messages = append(s.messages, fmt.Sprint(v))
+}
+
+func TestAWSWithoutSegmentReportsContextMissing(t *testing.T) {
+	session, cleanup := fakeSession(t, false)
+	defer cleanup()
+	svc := lambda.New(AWSSession(session))
+
+	cms := &countingContextMissingStrategy{}
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: cms})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+	assert.NoError(t, err)
+	if assert.Len(t, cms.messages, 1) {
+		assert.Contains(t, cms.messages[0], "lambda.ListFunctions")
+	}
+}
+
+func TestAWSDynamoDBTableAndConsumedCapacity(t *testing.T) {
+	tests := []struct {
+		name             string
+		response         string
+		consumedCapacity bool
+	}{
+		{"with consumed capacity", `{"ConsumedCapacity":{"TableName":"users","CapacityUnits":0.5},"Item":{}}`, true},
+		{"without consumed capacity", `{"Item":{}}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(test.response))
+			}))
+			defer ts.Close()
+
+			var maxRetries = 0
+			s, err := session.NewSession(&aws.Config{
+				Region:      aws.String("fake-moon-1"),
+				Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+				MaxRetries:  &maxRetries,
+				Endpoint:    aws.String(ts.URL),
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			svc := dynamodb.New(AWSSession(s))
+
+			ctx, root := BeginSegment(ctx, "Test")
+			_, err = svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+				TableName:              aws.String("users"),
+				Key:                    map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+				ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+			})
+			root.Close(nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var subseg *Segment
+			if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+				return
+			}
+			assert.Equal(t, "dynamodb", subseg.Name)
+			assert.Equal(t, "users", subseg.AWS["table_name"])
+			if test.consumedCapacity {
+				if cc, ok := subseg.AWS["consumed_capacity"].(map[string]interface{}); assert.True(t, ok) {
+					assert.Equal(t, "users", cc["TableName"])
+					assert.Equal(t, 0.5, cc["CapacityUnits"])
+				}
+			} else {
+				assert.NotContains(t, subseg.AWS, "consumed_capacity")
+			}
+		})
+	}
+}
+
+func TestAWSDynamoDBScanPages(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	responses := []string{
+		`{"Items":[{"id":{"S":"1"}}],"LastEvaluatedKey":{"id":{"S":"1"}}}`,
+		`{"Items":[{"id":{"S":"2"}}],"LastEvaluatedKey":{"id":{"S":"2"}}}`,
+		`{"Items":[{"id":{"S":"3"}}]}`,
+	}
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(responses[calls]))
+		calls++
+	}))
+	defer ts.Close()
+
+	var maxRetries = 0
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		MaxRetries:  &maxRetries,
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	ctx, root := BeginSegment(ctx, "Test")
+	var pages int
+	err = svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{TableName: aws.String("users")},
+		func(*dynamodb.ScanOutput, bool) bool {
+			pages++
+			return true
+		})
+	root.Close(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, pages)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, seg.Subsegments, 3) {
+		return
+	}
+	for i, raw := range seg.Subsegments {
+		var subseg *Segment
+		if !assert.NoError(t, json.Unmarshal(raw, &subseg)) {
+			return
+		}
+		assert.Equal(t, "dynamodb", subseg.Name)
+		assert.Equal(t, "Scan", subseg.AWS["operation"])
+		assert.Equal(t, float64(i+1), subseg.Annotations[AWSPageNumberKey])
+	}
+}
+
+func TestAWSResourceAccountID(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	s, cleanup := fakeSession(t, false)
+	defer cleanup()
+	svc := lambda.New(AWSSession(s))
+
+	for _, test := range []struct {
+		functionName string
+		accountID    interface{}
+	}{
+		{"arn:aws:lambda:us-west-2:123456789012:function:my-function", "123456789012"},
+		{"my-function", nil},
+		{"arn:aws:lambda:us-west-2:not-an-account:function:my-function", nil},
+	} {
+		ctx, root := BeginSegment(ctx, "Test")
+		_, err := svc.InvokeWithContext(ctx, &lambda.InvokeInput{FunctionName: aws.String(test.functionName)})
+		root.Close(nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		var subseg *Segment
+		if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+			return
+		}
+		assert.Equal(t, test.accountID, subseg.AWS[AccountIDKey], test.functionName)
+	}
+}
+
+func TestResourceAccountID(t *testing.T) {
+	type shape struct {
+		_           struct{}
+		Name        *string
+		TopicArn    *string
+		StreamARN   string
+		FunctionArn *string
+	}
+	assert.Equal(t, "", ResourceAccountID(nil, (*shape)(nil), "arn", &shape{}))
+	assert.Equal(t, "", ResourceAccountID(&shape{
+		Name:      aws.String("arn:aws:sns:us-east-1:123456789012:ignored"),
+		StreamARN: "arn:aws:s3:::bucket",
+	}))
+	assert.Equal(t, "210987654321", ResourceAccountID(&shape{
+		TopicArn:  aws.String("arn:aws:sns:us-east-1:12345:topic"),
+		StreamARN: "arn:aws:kinesis:us-east-1:210987654321:stream/events",
+	}, &shape{FunctionArn: aws.String("arn:aws:lambda:us-east-1:123456789012:function:f")}))
+	assert.Equal(t, "123456789012", ResourceAccountID(&shape{}, shape{FunctionArn: aws.String("arn:aws:lambda:us-east-1:123456789012:function:f")}))
+}
+
+func TestAWSNonPaginatedCallHasNoPageNumber(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"Item":{}}`))
+	}))
+	defer ts.Close()
+
+	var maxRetries = 0
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		MaxRetries:  &maxRetries,
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, err = svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+	})
+	root.Close(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.NotContains(t, subseg.Annotations, AWSPageNumberKey)
+}
+
+func TestAWSTimeoutAnnotated(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	var maxRetries = 0
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		MaxRetries:  &maxRetries,
+		Endpoint:    aws.String(ts.URL),
+		HTTPClient:  &http.Client{Timeout: 50 * time.Millisecond},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, err = svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+	})
+	root.Close(nil)
+	assert.Error(t, err)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, true, subseg.Annotations[AWSTimeoutKey])
+	assert.Equal(t, 0.05, subseg.Annotations[AWSTimeoutSecondsKey])
+	assert.True(t, subseg.Fault)
+}
+
+// slowProvider takes delay to retrieve credentials, which expire at once so
+// that every attempt retrieves them again.
+type slowProvider struct {
+	delay     time.Duration
+	retrieved int32
+}
+
+func (p *slowProvider) Retrieve() (credentials.Value, error) {
+	atomic.AddInt32(&p.retrieved, 1)
+	time.Sleep(p.delay)
+	return credentials.Value{AccessKeyID: "akid", SecretAccessKey: "secret"}, nil
+}
+
+func (p *slowProvider) IsExpired() bool { return true }
+
+func TestAWSSigningTimeAddedUpOverRetries(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	provider := &slowProvider{delay: 30 * time.Millisecond}
+	var maxRetries = 2
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewCredentials(provider),
+		MaxRetries:  &maxRetries,
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, err = svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+	})
+	root.Close(nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&provider.retrieved))
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, float64(2), subseg.AWS["retries"])
+	signing, ok := subseg.AWS[AWSSigningTimeKey].(float64)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.GreaterOrEqual(t, signing, 0.09)
+	// Waiting between attempts is not counted as signing.
+	var waited float64
+	for _, raw := range subseg.Subsegments {
+		var s *Segment
+		if assert.NoError(t, json.Unmarshal(raw, &s)) && s.Name == "wait" {
+			waited += s.EndTime - s.StartTime
+		}
+	}
+	assert.Less(t, signing, subseg.EndTime-subseg.StartTime-waited)
+}
+
+// clockProvider advances the mock clock by delay to retrieve credentials,
+// which expire at once so that every attempt retrieves them again.
+type clockProvider struct {
+	clock *utils.MockClock
+	delay int64
+}
+
+func (p *clockProvider) Retrieve() (credentials.Value, error) {
+	p.clock.Increment(p.delay, 0)
+	return credentials.Value{AccessKeyID: "akid", SecretAccessKey: "secret"}, nil
+}
+
+func (p *clockProvider) IsExpired() bool { return true }
+
+func TestAWSSigningTimeMockClock(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	mc := useMockClock(t, 1500000000)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewCredentials(&clockProvider{clock: mc, delay: 2}),
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, err = svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+	})
+	assert.NoError(t, err)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, float64(2), subseg.AWS[AWSSigningTimeKey])
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsAWSTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"timeout", awserr.New(request.ErrCodeRequestError, "send request failed", &url.Error{Op: "Post", URL: "http://localhost", Err: timeoutError{}}), true},
+		{"wrapped timeout", awserr.New(request.ErrCodeRequestError, "send request failed", fmt.Errorf("dial: %w", timeoutError{})), true},
+		{"canceled", awserr.New(request.CanceledErrorCode, "request context canceled", context.DeadlineExceeded), false},
+		{"service error", awserr.NewRequestFailure(awserr.New("InternalServerError", "boom", nil), http.StatusInternalServerError, "reqid"), false},
+		{"not an AWS error", timeoutError{}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, isAWSTimeout(c.err), c.name)
+	}
+}
+
+func TestAWSConcurrentCallsSharingContext(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	// Stream completed subsegments while other calls are still running, so
+	// the tree is serialized concurrently with the calls.
+	GetRecorder(ctx).StreamingStrategy, _ = NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"Item":{}}`))
+	}))
+	defer ts.Close()
+
+	var maxRetries = 0
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("fake-moon-1"),
+		Credentials: credentials.NewStaticCredentials("akid", "secret", "noop"),
+		MaxRetries:  &maxRetries,
+		Endpoint:    aws.String(ts.URL),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	svc := dynamodb.New(AWSSession(s))
+
+	const calls = 8
+	ctx, root := BeginSegment(ctx, "Test")
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+				TableName: aws.String("users"),
+				Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String("1")}},
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	root.Close(nil)
+
+	// Every call is recorded once, either streamed or within the root.
+	names := 0
+	for {
+		seg, err := td.Recv()
+		if err != nil {
+			break
+		}
+		if seg.Type == "subsegment" {
+			if seg.Name == "dynamodb" {
+				assert.Equal(t, root.ID, seg.ParentID)
+				assert.Equal(t, "aws", seg.Namespace)
+				names++
+			}
+			continue
+		}
+		for _, raw := range seg.Subsegments {
+			var subseg *Segment
+			if assert.NoError(t, json.Unmarshal(raw, &subseg)) {
+				assert.Equal(t, "dynamodb", subseg.Name)
+				assert.Equal(t, "aws", subseg.Namespace)
+				names++
+			}
+		}
+	}
+	assert.Equal(t, calls, names)
+}
+
+func TestAWSWithHTTPSubsegments(t *testing.T) {
+	for name, constructor := range map[string]func(*session.Session) *lambda.Lambda{
+		"AWSWithHTTPSubsegments()": func(s *session.Session) *lambda.Lambda {
+			svc := lambda.New(s)
+			AWSWithHTTPSubsegments(svc.Client)
+			return svc
+		},
+		"AWSSessionWithHTTPSubsegments()": func(s *session.Session) *lambda.Lambda {
+			return lambda.New(AWSSessionWithHTTPSubsegments(s))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			s, cleanup := fakeSession(t, false)
+			defer cleanup()
+			svc := constructor(s)
+
+			ctx, root := BeginSegment(ctx, "Test")
+			_, err := svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+			root.Close(nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+				return
+			}
+			var opseg Segment
+			if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &opseg)) {
+				return
+			}
+			assert.Equal(t, "aws", opseg.Namespace)
+			attempt := &Segment{}
+			for _, raw := range opseg.Subsegments {
+				sub := &Segment{}
+				if assert.NoError(t, json.Unmarshal(raw, sub)) && sub.Name == "attempt" {
+					attempt = sub
+				}
+			}
+			if !assert.Len(t, attempt.Subsegments, 1) {
+				return
+			}
+
+			var remote Segment
+			if !assert.NoError(t, json.Unmarshal(attempt.Subsegments[0], &remote)) {
+				return
+			}
+			assert.Equal(t, "remote", remote.Namespace)
+			assert.Equal(t, http.StatusOK, remote.HTTP.Response.Status)
+			assert.True(t, remote.StartTime >= attempt.StartTime)
+			assert.True(t, remote.EndTime <= attempt.EndTime)
+			if assert.NotEmpty(t, remote.Subsegments) {
+				var connect Segment
+				assert.NoError(t, json.Unmarshal(remote.Subsegments[0], &connect))
+				assert.Equal(t, "connect", connect.Name)
+			}
+		})
+	}
+}
+
+func TestBeginAWSSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, sub := BeginAWSSubsegment(ctx, "S3", "GetObject", "us-east-1")
+	sub.Lock()
+	sub.GetAWS()[RequestIDKey] = "request-1"
+	sub.Unlock()
+	HttpCaptureResponse(sub, http.StatusOK)
+	sub.Close(nil)
+	_, noRegion := BeginAWSSubsegment(ctx, "S3", "PutObject", "")
+	noRegion.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 2) {
+		return
+	}
+	var s3, put Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &s3))
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[1], &put))
+	assert.Equal(t, "S3", s3.Name)
+	assert.Equal(t, "aws", s3.Namespace)
+	assert.Equal(t, "S3", s3.AWS["service"])
+	assert.Equal(t, "GetObject", s3.AWS["operation"])
+	assert.Equal(t, "us-east-1", s3.AWS["region"])
+	assert.Equal(t, "request-1", s3.AWS[RequestIDKey])
+	assert.Equal(t, http.StatusOK, s3.HTTP.Response.Status)
+	assert.Equal(t, "PutObject", put.AWS["operation"])
+	assert.NotContains(t, put.AWS, "region")
+}
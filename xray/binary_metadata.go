@@ -0,0 +1,78 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// MaxBinaryMetadataSize is the largest number of bytes AddBinaryMetadata
+// accepts. Segment documents sent to the daemon must fit a single UDP
+// packet, and base64 grows the data by a third.
+const MaxBinaryMetadataSize = 8 * 1024
+
+// BinaryMetadataEncoding is the encoding recorded with binary metadata.
+const BinaryMetadataEncoding = "base64"
+
+// BinaryMetadata is the metadata value stored by AddBinaryMetadata. It marks
+// Data as binary data encoded with Encoding, and Size is the number of bytes
+// before encoding.
+type BinaryMetadata struct {
+	Encoding string `json:"encoding"`
+	Data     string `json:"data"`
+	Size     int    `json:"size"`
+}
+
+// AddBinaryMetadata adds data to the metadata of the segment under key in
+// namespace, encoded with base64 and marked as binary. It returns an error
+// without adding the metadata if data is larger than MaxBinaryMetadataSize.
+// DecodeBinaryMetadata returns the original bytes.
+func (seg *Segment) AddBinaryMetadata(namespace string, key string, data []byte) error {
+	if len(data) > MaxBinaryMetadataSize {
+		return fmt.Errorf("binary metadata %q of %d bytes is larger than %d bytes", key, len(data), MaxBinaryMetadataSize)
+	}
+	return seg.AddMetadataToNamespace(namespace, key, BinaryMetadata{
+		Encoding: BinaryMetadataEncoding,
+		Data:     base64.StdEncoding.EncodeToString(data),
+		Size:     len(data),
+	})
+}
+
+// DecodeBinaryMetadata returns the bytes of a metadata value added with
+// AddBinaryMetadata. It accepts the value as stored on the segment as well
+// as the map it becomes when a segment document is unmarshalled.
+func DecodeBinaryMetadata(value interface{}) ([]byte, error) {
+	var encoding, data string
+	switch v := value.(type) {
+	case BinaryMetadata:
+		encoding, data = v.Encoding, v.Data
+	case *BinaryMetadata:
+		if v == nil {
+			return nil, errors.New("metadata value is not binary")
+		}
+		encoding, data = v.Encoding, v.Data
+	case map[string]interface{}:
+		var ok bool
+		if encoding, ok = v["encoding"].(string); !ok {
+			return nil, errors.New("metadata value is not binary")
+		}
+		if data, ok = v["data"].(string); !ok {
+			return nil, errors.New("binary metadata has no data")
+		}
+	default:
+		return nil, errors.New("metadata value is not binary")
+	}
+
+	if encoding != BinaryMetadataEncoding {
+		return nil, fmt.Errorf("unsupported binary metadata encoding %q", encoding)
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddBinaryMetadata(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	data := []byte{0x08, 0x96, 0x01, 0x00, 0xff}
+	_, seg := BeginSegment(ctx, "test")
+	assert.NoError(t, seg.AddBinaryMetadata("request", "snapshot", data))
+
+	decoded, err := DecodeBinaryMetadata(seg.Metadata["request"]["snapshot"])
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	value := emitted.Metadata["request"]["snapshot"]
+	assert.Equal(t, map[string]interface{}{
+		"encoding": "base64",
+		"data":     "CJYBAP8=",
+		"size":     float64(5),
+	}, value)
+	decoded, err = DecodeBinaryMetadata(value)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
+
+func TestAddBinaryMetadataTooLarge(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+
+	assert.NoError(t, seg.AddBinaryMetadata("request", "max", bytes.Repeat([]byte{1}, MaxBinaryMetadataSize)))
+	assert.Error(t, seg.AddBinaryMetadata("request", "oversize", bytes.Repeat([]byte{1}, MaxBinaryMetadataSize+1)))
+	assert.Contains(t, seg.Metadata["request"], "max")
+	assert.NotContains(t, seg.Metadata["request"], "oversize")
+}
+
+func TestDecodeBinaryMetadataErrors(t *testing.T) {
+	for _, value := range []interface{}{
+		nil,
+		"CJYBAP8=",
+		(*BinaryMetadata)(nil),
+		map[string]interface{}{"data": "CJYBAP8="},
+		map[string]interface{}{"encoding": "base64"},
+		BinaryMetadata{Encoding: "hex", Data: "ff"},
+		BinaryMetadata{Encoding: "base64", Data: "not base64!"},
+	} {
+		_, err := DecodeBinaryMetadata(value)
+		assert.Error(t, err, "%#v", value)
+	}
+}
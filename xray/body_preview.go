@@ -0,0 +1,122 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces the values of redacted JSON fields.
+const redactedValue = "***"
+
+// previewBuffer keeps the first max bytes written to it and discards the
+// rest. The transport may still be writing the body while the response is
+// read, so it is safe for concurrent use.
+type previewBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (pb *previewBuffer) Write(p []byte) (int, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if room := pb.max - len(pb.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		pb.buf = append(pb.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+func (pb *previewBuffer) bytes() []byte {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	return append([]byte(nil), pb.buf...)
+}
+
+// previewBody reads a request body through a previewBuffer and closes the
+// original body.
+type previewBody struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyRedactor masks the values of JSON fields in body previews.
+type bodyRedactor struct {
+	fields []string
+
+	// pattern matches the fields and their values in previews which are not
+	// valid JSON, such as those cut off in the middle of the body.
+	pattern *regexp.Regexp
+}
+
+func newBodyRedactor(fields []string) *bodyRedactor {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	return &bodyRedactor{
+		fields:  fields,
+		pattern: regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`),
+	}
+}
+
+// redact returns the preview b with the values of the redacted fields
+// replaced. A nil bodyRedactor returns b unchanged.
+func (br *bodyRedactor) redact(b []byte) string {
+	if br == nil {
+		return string(b)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err == nil {
+		if out, err := json.Marshal(br.redactValue(v)); err == nil {
+			return string(out)
+		}
+	}
+	return br.pattern.ReplaceAllString(string(b), `${1}"`+redactedValue+`"`)
+}
+
+func (br *bodyRedactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range v {
+			if br.redacts(k) {
+				v[k] = redactedValue
+			} else {
+				v[k] = br.redactValue(fv)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = br.redactValue(e)
+		}
+	}
+	return v
+}
+
+func (br *bodyRedactor) redacts(key string) bool {
+	for _, f := range br.fields {
+		if strings.EqualFold(key, f) {
+			return true
+		}
+	}
+	return false
+}
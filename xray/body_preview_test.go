@@ -0,0 +1,53 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewBuffer(t *testing.T) {
+	pb := &previewBuffer{max: 5}
+	n, err := pb.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = pb.Write([]byte("defgh"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "abcde", string(pb.bytes()))
+}
+
+func TestBodyRedactor(t *testing.T) {
+	br := newBodyRedactor([]string{"token", "secret"})
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"nested", `{"auth":{"Token":"abc"},"list":[{"secret":1}]}`, `{"auth":{"Token":"***"},"list":[{"secret":"***"}]}`},
+		{"cut off in value", `{"id":7,"token":"abcd`, `{"id":7,"token":"***"`},
+		{"cut off after number", `{"secret":12345,"id":`, `{"secret":"***","id":`},
+		{"not json", `token=abc`, `token=abc`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, br.redact([]byte(test.in)))
+		})
+	}
+}
+
+func TestBodyRedactorWithoutFields(t *testing.T) {
+	br := newBodyRedactor(nil)
+	assert.Nil(t, br)
+	assert.Equal(t, `{"token":"abc"}`, br.redact([]byte(`{"token":"abc"}`)))
+}
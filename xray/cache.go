@@ -0,0 +1,58 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+)
+
+// CacheMetadataNamespace is the metadata namespace in which cache
+// subsegments record their operation and result. The namespace holds:
+//
+//	"operation": the operation passed to BeginCacheSubsegment, such as "get"
+//	"hit":       true if the operation found what it looked up, set by RecordCacheResult
+//	"key_count": the number of keys the operation involved, set by RecordCacheResult
+//
+// For example:
+//
+//	"metadata": {"cache": {"operation": "mget", "hit": false, "key_count": 3}}
+const CacheMetadataNamespace = "cache"
+
+// BeginCacheSubsegment creates a subsegment for a call to a cache, in the
+// "remote" namespace, and records operation into its cache metadata. Call
+// RecordCacheResult on the subsegment with the result before closing it.
+func BeginCacheSubsegment(ctx context.Context, name string, operation string) (context.Context, *Segment) {
+	ctx, seg := BeginSubsegment(ctx, name)
+	if seg == nil {
+		return ctx, nil
+	}
+
+	seg.Lock()
+	if !seg.Dummy {
+		seg.Namespace = "remote"
+	}
+	seg.Unlock()
+
+	_ = seg.AddMetadataToNamespace(CacheMetadataNamespace, "operation", operation)
+	return ctx, seg
+}
+
+// RecordCacheResult records whether the cache operation of a subsegment
+// begun with BeginCacheSubsegment was a hit, and the number of keys it
+// involved, into the cache metadata of the subsegment.
+func (seg *Segment) RecordCacheResult(hit bool, keyCount int) error {
+	if seg == nil {
+		return errors.New("unable to record cache result on nil segment")
+	}
+	if err := seg.AddMetadataToNamespace(CacheMetadataNamespace, "hit", hit); err != nil {
+		return err
+	}
+	return seg.AddMetadataToNamespace(CacheMetadataNamespace, "key_count", keyCount)
+}
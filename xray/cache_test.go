@@ -0,0 +1,54 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeginCacheSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, sub := BeginCacheSubsegment(ctx, "redis", "mget")
+	assert.NoError(t, sub.RecordCacheResult(false, 3))
+	sub.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.Len(t, seg.Subsegments, 1) && assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, "redis", subseg.Name)
+		assert.Equal(t, "remote", subseg.Namespace)
+		assert.Equal(t, map[string]interface{}{
+			"operation": "mget",
+			"hit":       false,
+			"key_count": float64(3),
+		}, subseg.Metadata[CacheMetadataNamespace])
+	}
+}
+
+func TestBeginCacheSubsegmentWithoutSegment(t *testing.T) {
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: &TestContextMissingStrategy{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, sub := BeginCacheSubsegment(ctx, "redis", "get")
+	assert.Nil(t, sub)
+	assert.Error(t, sub.RecordCacheResult(true, 1))
+}
@@ -11,12 +11,78 @@ package xray
 import (
 	"context"
 	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
 
+// CaptureOption configures the subsegment created by Capture and
+// CaptureAsync before the traced function runs.
+type CaptureOption interface {
+	apply(seg *Segment) error
+}
+
+type funcCaptureOption struct {
+	f func(seg *Segment) error
+}
+
+func (f funcCaptureOption) apply(seg *Segment) error {
+	return f.f(seg)
+}
+
+// WithNamespace sets the namespace of the subsegment, such as "remote"
+// for calls to other services or "aws" for calls to AWS services.
+func WithNamespace(namespace string) CaptureOption {
+	return funcCaptureOption{f: func(seg *Segment) error {
+		seg.Lock()
+		seg.Namespace = namespace
+		seg.Unlock()
+		return nil
+	}}
+}
+
+// WithAnnotation adds an annotation to the subsegment.
+func WithAnnotation(key string, value interface{}) CaptureOption {
+	return funcCaptureOption{f: func(seg *Segment) error {
+		return seg.AddAnnotation(key, value)
+	}}
+}
+
+// WithMetadata adds metadata to the default namespace of the subsegment.
+func WithMetadata(key string, value interface{}) CaptureOption {
+	return funcCaptureOption{f: func(seg *Segment) error {
+		return seg.AddMetadata(key, value)
+	}}
+}
+
+// WithName renames the subsegment, for instance to override the name
+// CaptureFunc derives from its caller. Names are cut to 200 characters.
+func WithName(name string) CaptureOption {
+	return funcCaptureOption{f: func(seg *Segment) error {
+		if len(name) > 200 {
+			name = name[:200]
+		}
+		seg.Lock()
+		seg.Name = name
+		seg.Unlock()
+		return nil
+	}}
+}
+
 // Capture traces the provided synchronous function by
 // beginning and closing a subsegment around its execution.
-func Capture(ctx context.Context, name string, fn func(context.Context) error) (err error) {
+// The options are applied to the subsegment before fn runs; an option that
+// fails is logged and does not prevent fn from running.
+func Capture(ctx context.Context, name string, fn func(context.Context) error, opts ...CaptureOption) (err error) {
 	c, seg := BeginSubsegment(ctx, name)
+	if seg != nil {
+		for _, opt := range opts {
+			if optErr := opt.apply(seg); optErr != nil {
+				logger.Errorf("Failed to apply capture option to %s: %v", name, optErr)
+			}
+		}
+	}
 
 	defer func() {
 		if seg != nil {
@@ -32,9 +98,15 @@ func Capture(ctx context.Context, name string, fn func(context.Context) error) (
 		}
 	}()
 
+	// A panic in fn is recorded as a fault with the stack of the panic and
+	// closes the subsegment, then fn's panic continues to unwind so that
+	// outer recovery still sees the original value.
 	defer func() {
 		if p := recover(); p != nil {
-			err = seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+			if seg != nil {
+				err = seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+				seg.closeOpenSubsegments(markFault)
+			}
 			panic(p)
 		}
 	}()
@@ -48,14 +120,54 @@ func Capture(ctx context.Context, name string, fn func(context.Context) error) (
 	return err
 }
 
+// CaptureFunc traces fn like Capture, naming the subsegment after the
+// function calling CaptureFunc, such as "orders.(*Service).Checkout" for a
+// method of the orders package, so that simple cases need no name. A
+// WithName option overrides the name.
+func CaptureFunc(ctx context.Context, fn func(context.Context) error, opts ...CaptureOption) error {
+	return Capture(ctx, callerName(2), fn, opts...)
+}
+
+// callerName returns the name of the function skip frames above
+// callerName on the stack, without the path of its package.
+func callerName(skip int) string {
+	pcs := make([]uintptr, 1)
+	if runtime.Callers(skip+1, pcs) == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames(pcs).Next()
+	name := frame.Function
+	if name == "" {
+		return "unknown"
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
 // CaptureAsync traces an arbitrary code segment within a goroutine.
 // Use CaptureAsync instead of manually calling Capture within a goroutine
 // to ensure the segment is flushed properly.
-func CaptureAsync(ctx context.Context, name string, fn func(context.Context) error) {
+// fn runs with a context detached from the cancellation of ctx, so the work
+// may outlive the request that started it. A panic in fn is recorded as a
+// fault on the subsegment and logged instead of crashing the program.
+// CaptureAsync returns as soon as the subsegment has begun and the options
+// have been applied.
+func CaptureAsync(ctx context.Context, name string, fn func(context.Context) error, opts ...CaptureOption) {
+	detached := DetachContext(ctx)
+
 	started := make(chan struct{})
-	go Capture(ctx, name, func(ctx context.Context) error {
-		close(started)
-		return fn(ctx)
-	})
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				logger.Errorf("Recovered panic in asynchronously captured %s: %v", name, p)
+			}
+		}()
+		Capture(detached, name, func(ctx context.Context) error {
+			close(started)
+			return fn(ctx)
+		}, opts...)
+	}()
 	<-started
 }
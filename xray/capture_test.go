@@ -112,12 +112,134 @@ func TestPanicCapture(t *testing.T) {
 	assert.Equal(t, "TestPanicCapture", subseg.Cause.Exceptions[0].Stack[3].Label)
 }
 
+func TestPanicCaptureClosesSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	var recovered interface{}
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		_ = Capture(ctx, "PanicService", func(context.Context) error {
+			panic("MyPanic")
+		})
+	}()
+	root.Close(nil)
+
+	assert.Equal(t, "MyPanic", recovered)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, seg.Fault)
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, "PanicService", subseg.Name)
+	assert.True(t, subseg.Fault)
+	assert.False(t, subseg.InProgress)
+	assert.NotZero(t, subseg.EndTime)
+	assert.Equal(t, "MyPanic", subseg.Cause.Exceptions[0].Message)
+	assert.NotEmpty(t, subseg.Cause.Exceptions[0].Stack)
+}
+
+func TestPanicCaptureWithoutSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.PanicsWithValue(t, "MyPanic", func() {
+		_ = Capture(ctx, "PanicService", func(context.Context) error {
+			panic("MyPanic")
+		})
+	})
+}
+
 func TestNoSegmentCapture(t *testing.T) {
 	ctx, _ := NewTestDaemon()
 	_, seg := BeginSubsegment(ctx, "Name")
 	seg.Close(nil)
 }
 
+func TestCaptureWithOptions(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	err := Capture(ctx, "TestService", func(ctx context.Context) error {
+		seg := GetSegment(ctx)
+		assert.Equal(t, "remote", seg.Namespace)
+		assert.Equal(t, "v", seg.Annotations["k"])
+		return nil
+	}, WithNamespace("remote"), WithAnnotation("k", "v"), WithMetadata("m", 1), WithAnnotation("bad", []int{1}))
+	assert.NoError(t, err)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, "remote", subseg.Namespace)
+		assert.Equal(t, map[string]interface{}{"k": "v"}, subseg.Annotations)
+		assert.Equal(t, 1.0, subseg.Metadata["default"]["m"])
+	}
+}
+
+func TestCaptureWithOptionsNoSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ran := false
+	err := Capture(ctx, "TestService", func(context.Context) error {
+		ran = true
+		return nil
+	}, WithNamespace("remote"))
+	assert.NoError(t, err)
+	assert.True(t, ran)
+}
+
+type captureFuncService struct{}
+
+func (captureFuncService) checkout(ctx context.Context) error {
+	return CaptureFunc(ctx, func(context.Context) error {
+		return errors.New("out of stock")
+	})
+}
+
+func TestCaptureFunc(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	assert.NoError(t, CaptureFunc(ctx, func(context.Context) error { return nil }))
+	assert.Error(t, captureFuncService{}.checkout(ctx))
+	assert.NoError(t, CaptureFunc(ctx, func(context.Context) error { return nil }, WithName("override")))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var names []string
+	for _, b := range seg.Subsegments {
+		var subseg *Segment
+		if !assert.NoError(t, json.Unmarshal(b, &subseg)) {
+			return
+		}
+		names = append(names, subseg.Name)
+		if subseg.Name == "xray.captureFuncService.checkout" {
+			assert.True(t, subseg.Fault)
+			assert.Equal(t, "out of stock", subseg.Cause.Exceptions[0].Message)
+		}
+	}
+	assert.Equal(t, []string{"xray.TestCaptureFunc", "xray.captureFuncService.checkout", "override"}, names)
+}
+
 func TestCaptureAsync(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -149,6 +271,54 @@ func TestCaptureAsync(t *testing.T) {
 	}
 }
 
+func TestCaptureAsyncDetachesCancellation(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	ctx, root := BeginSegment(ctx, "Test")
+
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	CaptureAsync(ctx, "Background", func(ctx context.Context) error {
+		<-release
+		done <- ctx.Err()
+		return nil
+	})
+	cancel()
+	close(release)
+
+	assert.NoError(t, <-done)
+	root.Close(nil)
+}
+
+func TestCaptureAsyncPanic(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	CaptureAsync(ctx, "Background", func(context.Context) error {
+		panic("async panic")
+	})
+
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.Len(t, seg.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, "Background", subseg.Name)
+	assert.True(t, subseg.Fault)
+	if assert.NotNil(t, subseg.Cause) && assert.Len(t, subseg.Cause.Exceptions, 1) {
+		assert.Equal(t, "async panic", subseg.Cause.Exceptions[0].Message)
+		assert.Equal(t, "panic", subseg.Cause.Exceptions[0].Type)
+	}
+}
+
 // Benchmarks
 func BenchmarkCapture(b *testing.B) {
 	ctx, seg := BeginSegment(context.Background(), "TestCaptureSeg")
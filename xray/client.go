@@ -10,11 +10,15 @@ package xray
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
@@ -24,7 +28,9 @@ const emptyHostRename = "empty_host_error"
 // Client creates a shallow copy of the provided http client,
 // defaulting to http.DefaultClient, with roundtripper wrapped
 // with xray.RoundTripper.
-func Client(c *http.Client) *http.Client {
+// The options configure what the subsegments of the client's requests
+// record.
+func Client(c *http.Client, opts ...ClientOption) *http.Client {
 	if c == nil {
 		c = http.DefaultClient
 	}
@@ -33,21 +39,192 @@ func Client(c *http.Client) *http.Client {
 		transport = http.DefaultTransport
 	}
 	return &http.Client{
-		Transport:     RoundTripper(transport),
+		Transport:     RoundTripper(transport, opts...),
 		CheckRedirect: c.CheckRedirect,
 		Jar:           c.Jar,
 		Timeout:       c.Timeout,
 	}
 }
 
+// TraceRedirects returns a function for http.Client.CheckRedirect which
+// records every redirect followed by the client as a "redirect" subsegment of
+// the segment in the request's context. The subsegment carries the method and
+// URL of the request that was redirected, the redirect status code, and the
+// Location it redirected to as the "location" metadata of the "http"
+// namespace. Only the first maxHops redirects of a request are recorded.
+//
+// The redirect is then checked with next. If next is nil, the default policy
+// of http.Client applies and the client stops after 10 redirects.
+func TraceRedirects(next func(req *http.Request, via []*http.Request) error, maxHops int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) <= maxHops && req.Response != nil {
+			recordRedirect(req, via[len(via)-1])
+		}
+
+		if next != nil {
+			return next(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+func recordRedirect(req, prev *http.Request) {
+	if GetSegment(req.Context()) == nil {
+		return
+	}
+
+	_, seg := BeginSubsegment(req.Context(), "redirect")
+	if seg == nil {
+		return
+	}
+
+	seg.Lock()
+	seg.GetHTTP().GetRequest().Method = prev.Method
+	seg.GetHTTP().GetRequest().URL = stripURL(*prev.URL)
+	seg.GetHTTP().GetResponse().Status = req.Response.StatusCode
+	seg.Unlock()
+
+	seg.AddMetadataToNamespace("http", "location", stripURL(*req.URL))
+	seg.Close(nil)
+}
+
+// ClientOption configures the subsegments recorded by Client and
+// RoundTripper.
+type ClientOption interface {
+	apply(rt *roundtripper)
+}
+
+type funcClientOption struct {
+	f func(rt *roundtripper)
+}
+
+func (f funcClientOption) apply(rt *roundtripper) {
+	f.f(rt)
+}
+
+// WithBodyPreview records the first maxBytes bytes of the body of every
+// request as the "http.request.body_preview" metadata of its subsegment. The
+// body is copied while the transport sends it, so the request is unchanged.
+// The values of the JSON fields named in redact, matched case-insensitively,
+// are replaced with "***", also in previews cut off in the middle of the
+// body. Only bodies held in memory, for which the request has GetBody, are
+// previewed; streamed bodies are not.
+func WithBodyPreview(maxBytes int, redact ...string) ClientOption {
+	return funcClientOption{f: func(rt *roundtripper) {
+		rt.previewSize = maxBytes
+		rt.redactor = newBodyRedactor(redact)
+	}}
+}
+
+// WithConnectionInfo records whether the connection of every request was
+// reused from the pool of the transport or freshly dialed, as the
+// "connection_reused" metadata of the "http" namespace of its subsegment.
+// For reused connections, "was_idle" records whether the connection was idle
+// in the pool, and "idle_time" for how many seconds. This helps sizing the
+// idle connection pool of the transport, such as http.Transport.MaxIdleConns.
+func WithConnectionInfo() ClientOption {
+	return funcClientOption{f: func(rt *roundtripper) {
+		rt.connectionInfo = true
+	}}
+}
+
+// WithTLSCertificateInfo records the subject, issuer and expiry of the
+// certificate the server presented during the TLS handshake of every
+// request, as the "subject", "issuer", "not_after" and "expires_in" (in
+// seconds) metadata of the "tls" namespace of its subsegment. This helps
+// catching certificates about to expire. Requests sent over a reused
+// connection make no handshake, so nothing is recorded for them.
+func WithTLSCertificateInfo() ClientOption {
+	return funcClientOption{f: func(rt *roundtripper) {
+		rt.tlsCertificateInfo = true
+	}}
+}
+
 // RoundTripper wraps the provided http roundtripper with xray.Capture,
 // sets HTTP-specific xray fields, and adds the trace header to the outbound request.
-func RoundTripper(rt http.RoundTripper) http.RoundTripper {
-	return &roundtripper{rt}
+// If rt is nil, requests are sent with http.DefaultTransport.
+//
+// Every call to RoundTrip records one subsegment, so where it is placed
+// in a chain of round trippers decides what the subsegment spans. Wrapped
+// around a round tripper which retries requests, it records a single
+// subsegment covering all attempts; wrapped by the retrying round tripper,
+// it records one subsegment per attempt, each carrying the outcome of that
+// attempt only:
+//
+//	// One subsegment per request, including its retries.
+//	client := &http.Client{Transport: xray.RoundTripper(retry(metrics(http.DefaultTransport)))}
+//	// One subsegment per attempt.
+//	client := &http.Client{Transport: retry(xray.RoundTripper(metrics(http.DefaultTransport)))}
+func RoundTripper(rt http.RoundTripper, opts ...ClientOption) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t := &roundtripper{Base: rt}
+	for _, opt := range opts {
+		opt.apply(t)
+	}
+	return t
+}
+
+// WrapTransport wraps base, such as an *http.Transport with a custom dialer
+// or TLS configuration, to trace the requests sent with it, like
+// RoundTripper. The requests are still sent by base, with all of its
+// settings: the wrapper only begins a subsegment for every request and adds
+// the trace header to it. If base is nil, requests are sent with
+// http.DefaultTransport.
+//
+//	transport := &http.Transport{DialContext: dialer.DialContext}
+//	client := &http.Client{Transport: xray.WrapTransport(transport)}
+func WrapTransport(base http.RoundTripper, opts ...ClientOption) http.RoundTripper {
+	return RoundTripper(base, opts...)
+}
+
+// recordTLSCertificateInfo records the leaf certificate of the server in
+// connState into the metadata of its subsegment seg.
+func recordTLSCertificateInfo(seg *Segment, connState tls.ConnectionState) {
+	if len(connState.PeerCertificates) == 0 {
+		return
+	}
+	cert := connState.PeerCertificates[0]
+	seg.AddMetadataToNamespace("tls", "subject", cert.Subject.String())
+	seg.AddMetadataToNamespace("tls", "issuer", cert.Issuer.String())
+	seg.AddMetadataToNamespace("tls", "not_after", cert.NotAfter.UTC().Format(time.RFC3339))
+	seg.AddMetadataToNamespace("tls", "expires_in", time.Until(cert.NotAfter).Seconds())
+}
+
+// recordConnectionInfo records how the connection of a request was
+// obtained into the metadata of its subsegment seg.
+func recordConnectionInfo(seg *Segment, info httptrace.GotConnInfo) {
+	seg.AddMetadataToNamespace("http", "connection_reused", info.Reused)
+	if info.Reused {
+		seg.AddMetadataToNamespace("http", "was_idle", info.WasIdle)
+		if info.WasIdle {
+			seg.AddMetadataToNamespace("http", "idle_time", info.IdleTime.Seconds())
+		}
+	}
 }
 
 type roundtripper struct {
 	Base http.RoundTripper
+
+	// previewSize is the number of request body bytes recorded, if positive.
+	previewSize int
+	redactor    *bodyRedactor
+
+	connectionInfo     bool
+	tlsCertificateInfo bool
+}
+
+// CloseIdleConnections closes the idle connections of Base if it is able
+// to, so that http.Client.CloseIdleConnections reaches the wrapped
+// transport.
+func (rt *roundtripper) CloseIdleConnections() {
+	if c, ok := rt.Base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
 }
 
 // RoundTrip wraps a single HTTP transaction and add corresponding information into a subsegment.
@@ -77,6 +254,22 @@ func (rt *roundtripper) RoundTrip(r *http.Request) (*http.Response, error) {
 		if e != nil {
 			return e
 		}
+		if rt.connectionInfo {
+			gotConn := ct.httpTrace.GotConn
+			ct.httpTrace.GotConn = func(info httptrace.GotConnInfo) {
+				gotConn(info)
+				recordConnectionInfo(seg, info)
+			}
+		}
+		if rt.tlsCertificateInfo {
+			tlsHandshakeDone := ct.httpTrace.TLSHandshakeDone
+			ct.httpTrace.TLSHandshakeDone = func(connState tls.ConnectionState, err error) {
+				tlsHandshakeDone(connState, err)
+				if err == nil {
+					recordTLSCertificateInfo(seg, connState)
+				}
+			}
+		}
 		r = r.WithContext(httptrace.WithClientTrace(ctx, ct.httpTrace))
 
 		seg.Lock()
@@ -90,11 +283,21 @@ func (rt *roundtripper) RoundTrip(r *http.Request) (*http.Response, error) {
 		seg.GetHTTP().GetRequest().Method = r.Method
 		seg.GetHTTP().GetRequest().URL = stripURL(*r.URL)
 
-		r.Header.Set(TraceIDHeaderKey, seg.DownstreamHeader().String())
+		r.Header.Set(traceHeaderName(seg.ParentSegment.Configuration), seg.DownstreamHeader().String())
 		seg.Unlock()
 
+		var preview *previewBuffer
+		if rt.previewSize > 0 && r.Body != nil && r.Body != http.NoBody && r.GetBody != nil {
+			preview = &previewBuffer{max: rt.previewSize}
+			r.Body = &previewBody{Reader: io.TeeReader(r.Body, preview), Closer: r.Body}
+		}
+
 		resp, err = rt.Base.RoundTrip(r)
 
+		if preview != nil {
+			seg.AddMetadata("http.request.body_preview", rt.redactor.redact(preview.bytes()))
+		}
+
 		if resp != nil {
 			seg.Lock()
 			seg.GetHTTP().GetResponse().Status = resp.StatusCode
@@ -14,11 +14,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/http2"
@@ -63,6 +67,115 @@ func TestRoundTripper(t *testing.T) {
 	assert.Equal(t, &roundtripper{Base: http.DefaultTransport}, rt)
 }
 
+func TestNilRoundTripper(t *testing.T) {
+	rt := RoundTripper(nil)
+	assert.Equal(t, &roundtripper{Base: http.DefaultTransport}, rt)
+}
+
+// retryingRoundTripper sends every request up to attempts times, until it
+// gets a response which is not a server error.
+type retryingRoundTripper struct {
+	next     http.RoundTripper
+	attempts int
+}
+
+func (rt retryingRoundTripper) RoundTrip(r *http.Request) (resp *http.Response, err error) {
+	for i := 0; i < rt.attempts; i++ {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		resp, err = rt.next.RoundTrip(r)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+func TestRoundTripperOrdering(t *testing.T) {
+	cases := []struct {
+		name      string
+		transport func(http.RoundTripper) http.RoundTripper
+		statuses  []int
+	}{
+		{
+			name: "outermost",
+			transport: func(base http.RoundTripper) http.RoundTripper {
+				return RoundTripper(retryingRoundTripper{next: base, attempts: 2})
+			},
+			statuses: []int{http.StatusOK},
+		},
+		{
+			name: "inside retries",
+			transport: func(base http.RoundTripper) http.RoundTripper {
+				return retryingRoundTripper{next: RoundTripper(base), attempts: 2}
+			},
+			statuses: []int{http.StatusServiceUnavailable, http.StatusOK},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			client := &http.Client{Transport: c.transport(http.DefaultTransport)}
+			if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+				return
+			}
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var statuses []int
+			for _, raw := range seg.Subsegments {
+				var subseg *Segment
+				if assert.NoError(t, json.Unmarshal(raw, &subseg)) {
+					statuses = append(statuses, subseg.HTTP.Response.Status)
+				}
+			}
+			assert.Equal(t, c.statuses, statuses)
+		})
+	}
+}
+
+func TestRoundTripCustomTraceHeaderName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).TraceHeaderName = "Amzn-Trace-Id"
+
+	ch := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch <- r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	err := httpDoTest(ctx, Client(nil), http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := <-ch
+	assert.Empty(t, h.Get(TraceIDHeaderKey))
+	assert.Contains(t, h.Get("Amzn-Trace-Id"), "Root="+seg.TraceID)
+}
+
 func TestRoundTrip(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -517,3 +630,310 @@ func BenchmarkClient(b *testing.B) {
 		Client(nil)
 	}
 }
+
+func redirectSubsegments(t *testing.T, seg *Segment) []*Segment {
+	var redirects []*Segment
+	for _, raw := range seg.Subsegments {
+		var s *Segment
+		if assert.NoError(t, json.Unmarshal(raw, &s)) && s.Name == "redirect" {
+			redirects = append(redirects, s)
+		}
+	}
+	return redirects
+}
+
+func TestRoundTripBodyPreview(t *testing.T) {
+	const body = `{"user":"alice","password":"hunter2","items":[1,2,3]}`
+
+	tests := []struct {
+		name        string
+		maxBytes    int
+		wantPreview string
+	}{
+		{"whole body", 1024, `{"items":[1,2,3],"password":"***","user":"alice"}`},
+		{"truncated body", 36, `{"user":"alice","password":"***"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			ch := make(chan string, 1)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				ch <- string(b)
+			}))
+			defer ts.Close()
+
+			client := Client(nil, WithBodyPreview(test.maxBytes, "Password"))
+			err := httpDoTest(ctx, client, http.MethodPost, ts.URL, strings.NewReader(body))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, body, <-ch)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var subseg *Segment
+			if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+				assert.Equal(t, test.wantPreview, subseg.Metadata["default"]["http.request.body_preview"])
+			}
+		})
+	}
+}
+
+func TestRoundTripBodyPreviewStreamingBody(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	client := Client(nil, WithBodyPreview(1024))
+	// A body of unknown length has no GetBody and is sent as a stream.
+	body := ioutil.NopCloser(strings.NewReader("streamed"))
+	err := httpDoTest(ctx, client, http.MethodPost, ts.URL, body)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.NotContains(t, subseg.Metadata["default"], "http.request.body_preview")
+	}
+}
+
+func TestTraceRedirects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b?token=secret", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		maxHops   int
+		redirects int
+	}{
+		{maxHops: 0, redirects: 0},
+		{maxHops: 1, redirects: 1},
+		{maxHops: 5, redirects: 2},
+	} {
+		ctx, td := NewTestDaemon()
+		client := Client(&http.Client{CheckRedirect: TraceRedirects(nil, tc.maxHops)})
+		if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL+"/a", nil)) {
+			td.Close()
+			return
+		}
+
+		seg, err := td.Recv()
+		td.Close()
+		if !assert.NoError(t, err) {
+			return
+		}
+		redirects := redirectSubsegments(t, seg)
+		if !assert.Equal(t, tc.redirects, len(redirects), "maxHops %d", tc.maxHops) {
+			continue
+		}
+		if tc.redirects > 0 {
+			assert.Equal(t, http.MethodGet, redirects[0].HTTP.Request.Method)
+			assert.Equal(t, ts.URL+"/a", redirects[0].HTTP.Request.URL)
+			assert.Equal(t, http.StatusFound, redirects[0].HTTP.Response.Status)
+			assert.Equal(t, ts.URL+"/b", redirects[0].Metadata["http"]["location"])
+		}
+		if tc.redirects > 1 {
+			assert.Equal(t, ts.URL+"/b", redirects[1].HTTP.Request.URL)
+			assert.Equal(t, http.StatusMovedPermanently, redirects[1].HTTP.Response.Status)
+			assert.Equal(t, ts.URL+"/c", redirects[1].Metadata["http"]["location"])
+		}
+	}
+}
+
+func TestTraceRedirectsCallsNext(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	var calls int
+	next := func(req *http.Request, via []*http.Request) error {
+		calls++
+		return http.ErrUseLastResponse
+	}
+	client := Client(&http.Client{CheckRedirect: TraceRedirects(next, 5)})
+
+	_, root, req, err := newRequest(ctx, http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	root.Close(nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1, len(redirectSubsegments(t, seg)))
+}
+
+func TestRoundTripConnectionInfo(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	client := Client(&http.Client{Transport: transport}, WithConnectionInfo())
+
+	var subsegs []*Segment
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+			return
+		}
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		var subseg *Segment
+		if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+			return
+		}
+		subsegs = append(subsegs, subseg)
+	}
+
+	assert.Equal(t, false, subsegs[0].Metadata["http"]["connection_reused"])
+	assert.NotContains(t, subsegs[0].Metadata["http"], "was_idle")
+	assert.Equal(t, true, subsegs[1].Metadata["http"]["connection_reused"])
+	assert.Equal(t, true, subsegs[1].Metadata["http"]["was_idle"])
+	assert.Contains(t, subsegs[1].Metadata["http"], "idle_time")
+}
+
+func TestRoundTripConnectionInfoDisabled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	if !assert.NoError(t, httpDoTest(ctx, Client(nil), http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.NotContains(t, subseg.Metadata["http"], "connection_reused")
+	}
+}
+
+func TestRoundTripTLSCertificateInfo(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := Client(ts.Client(), WithTLSCertificateInfo())
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+
+	cert := ts.Certificate()
+	tlsInfo := subseg.Metadata["tls"]
+	assert.Equal(t, cert.Subject.String(), tlsInfo["subject"])
+	assert.Equal(t, cert.Issuer.String(), tlsInfo["issuer"])
+	assert.Equal(t, cert.NotAfter.UTC().Format(time.RFC3339), tlsInfo["not_after"])
+	assert.InDelta(t, time.Until(cert.NotAfter).Seconds(), tlsInfo["expires_in"], 60)
+}
+
+func TestWrapTransport(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var traceHeader string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeader = r.Header.Get(TraceIDHeaderKey)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var dials int32
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	client := &http.Client{Transport: WrapTransport(transport)}
+	defer client.CloseIdleConnections()
+
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.Equal(t, "remote", subseg.Namespace)
+	assert.Equal(t, http.StatusOK, subseg.HTTP.Response.Status)
+	assert.Contains(t, traceHeader, "Root="+seg.TraceID)
+	assert.Contains(t, traceHeader, "Parent="+subseg.ID)
+
+	// CloseIdleConnections reaches the wrapped transport, which dials again.
+	client.CloseIdleConnections()
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+	_, err = td.Recv()
+	assert.NoError(t, err)
+}
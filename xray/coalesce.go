@@ -0,0 +1,153 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// coalescedNamespace is the metadata namespace holding the count and
+// durations of subsegments merged by Config.CoalesceSubsegments.
+const coalescedNamespace = "coalesced"
+
+// coalesceSubsegments merges runs of at least threshold coalescable
+// subsegments of the same name throughout the tree below seg, as described
+// for Config.CoalesceSubsegments.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) coalesceSubsegments(threshold int) {
+	seg.sortSubsegmentsByStart()
+
+	kept := seg.rawSubsegments[:0]
+	removed := 0
+	for i := 0; i < len(seg.rawSubsegments); {
+		first := seg.rawSubsegments[i]
+		first.Lock()
+		first.coalesceSubsegments(threshold)
+		name, ok := first.Name, first.coalescable() && !first.referencedDownstream()
+		first.Unlock()
+
+		j := i + 1
+		if ok {
+			for ; j < len(seg.rawSubsegments); j++ {
+				s := seg.rawSubsegments[j]
+				s.Lock()
+				same := s.Name == name && s.coalescable() && !s.referencedDownstream()
+				s.Unlock()
+				if !same {
+					break
+				}
+			}
+		}
+
+		if j-i >= threshold {
+			mergeSubsegments(seg.rawSubsegments[i:j])
+			removed += j - i - 1
+		} else {
+			j = i + 1
+		}
+		kept = append(kept, first)
+		i = j
+	}
+	for i := len(kept); i < len(seg.rawSubsegments); i++ {
+		seg.rawSubsegments[i] = nil
+	}
+	seg.rawSubsegments = kept
+
+	if removed > 0 && seg.ParentSegment != nil {
+		atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(removed-1))
+	}
+}
+
+// sortSubsegmentsByStart orders the subsegments of seg by start time, so
+// that runs do not depend on the order subsegments were added in, which
+// removing subsegments does not preserve.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) sortSubsegmentsByStart() {
+	starts := make([]float64, len(seg.rawSubsegments))
+	for i, s := range seg.rawSubsegments {
+		s.RLock()
+		starts[i] = s.StartTime
+		s.RUnlock()
+	}
+	sort.Stable(subsegmentsByStart{segs: seg.rawSubsegments, starts: starts})
+}
+
+type subsegmentsByStart struct {
+	segs   []*Segment
+	starts []float64
+}
+
+func (b subsegmentsByStart) Len() int           { return len(b.segs) }
+func (b subsegmentsByStart) Less(i, j int) bool { return b.starts[i] < b.starts[j] }
+func (b subsegmentsByStart) Swap(i, j int) {
+	b.segs[i], b.segs[j] = b.segs[j], b.segs[i]
+	b.starts[i], b.starts[j] = b.starts[j], b.starts[i]
+}
+
+// coalescable reports whether seg may be merged with its siblings.
+// seg has a lock acquired by the caller.
+func (seg *Segment) coalescable() bool {
+	return !seg.InProgress && !seg.Dummy && len(seg.rawSubsegments) == 0
+}
+
+// referencedDownstream reports whether services called under seg may refer
+// to its ID, so that it must not be merged into another subsegment: its ID
+// was sent in a trace header, or it records a call to a remote service,
+// which may have been given its ID by other means.
+// seg has a lock acquired by the caller.
+func (seg *Segment) referencedDownstream() bool {
+	return atomic.LoadInt32(&seg.propagated) == 1 || seg.Namespace == "remote" || seg.Namespace == "aws"
+}
+
+// mergeSubsegments records the run of subsegments in the first of them.
+func mergeSubsegments(run []*Segment) {
+	first := run[0]
+	first.Lock()
+	defer first.Unlock()
+
+	var total float64
+	min, max := math.Inf(1), 0.0
+	for i, s := range run {
+		if i > 0 {
+			s.Lock()
+		}
+		d := s.EndTime - s.StartTime
+		total += d
+		min = math.Min(min, d)
+		max = math.Max(max, d)
+
+		if s.StartTime < first.StartTime {
+			first.StartTime = s.StartTime
+		}
+		if s.EndTime > first.EndTime {
+			first.EndTime = s.EndTime
+		}
+		first.Error = first.Error || s.Error
+		first.Fault = first.Fault || s.Fault
+		first.Throttle = first.Throttle || s.Throttle
+		if first.Cause == nil {
+			first.Cause = s.Cause
+		}
+		if i > 0 {
+			s.Unlock()
+		}
+	}
+
+	if first.Metadata == nil {
+		first.Metadata = map[string]map[string]interface{}{}
+	}
+	first.Metadata[coalescedNamespace] = map[string]interface{}{
+		"count":          len(run),
+		"total_duration": total,
+		"min_duration":   min,
+		"max_duration":   max,
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceSubsegments(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CoalesceSubsegments = 3
+	clock := useMockClock(t, 1000)
+
+	ctx, root := BeginSegment(ctx, "root")
+	durations := []int64{1, 3, 2}
+	for i, d := range durations {
+		_, seg := BeginSubsegment(ctx, "loop")
+		clock.Increment(d, 0)
+		if i == 1 {
+			seg.Close(errors.New("boom"))
+		} else {
+			seg.Close(nil)
+		}
+	}
+	// Runs shorter than the threshold are kept as they are.
+	for i := 0; i < 2; i++ {
+		_, seg := BeginSubsegment(ctx, "pair")
+		clock.Increment(1, 0)
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, emitted.Subsegments, 3) {
+		return
+	}
+
+	var loop, pair Segment
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &loop))
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[1], &pair))
+	assert.Equal(t, "loop", loop.Name)
+	assert.Equal(t, float64(1000), loop.StartTime)
+	assert.Equal(t, float64(1006), loop.EndTime)
+	assert.True(t, loop.Fault)
+	if assert.NotNil(t, loop.Cause) && assert.Len(t, loop.Cause.Exceptions, 1) {
+		assert.Equal(t, "boom", loop.Cause.Exceptions[0].Message)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"count":          float64(3),
+		"total_duration": float64(6),
+		"min_duration":   float64(1),
+		"max_duration":   float64(3),
+	}, loop.Metadata[coalescedNamespace])
+	assert.Equal(t, "pair", pair.Name)
+	assert.NotContains(t, pair.Metadata, coalescedNamespace)
+}
+
+func TestCoalesceSubsegmentsInStartOrder(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CoalesceSubsegments = 3
+	clock := useMockClock(t, 1000)
+
+	ctx, root := BeginSegment(ctx, "root")
+	for _, name := range []string{"loop", "loop", "loop", "other"} {
+		_, seg := BeginSubsegment(ctx, name)
+		clock.Increment(1, 0)
+		seg.Close(nil)
+	}
+	// Removing subsegments may leave the others out of order.
+	root.Lock()
+	raw := root.rawSubsegments
+	raw[1], raw[3] = raw[3], raw[1]
+	root.Unlock()
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, emitted.Subsegments, 2) {
+		return
+	}
+	var loop, other Segment
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &loop))
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[1], &other))
+	assert.Equal(t, "loop", loop.Name)
+	assert.Equal(t, float64(3), loop.Metadata[coalescedNamespace]["count"])
+	assert.Equal(t, "other", other.Name)
+}
+
+func TestCoalesceSubsegmentsDisabledByDefault(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	for i := 0; i < 5; i++ {
+		_, seg := BeginSubsegment(ctx, "loop")
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, 5)
+}
+
+func TestCoalesceSubsegmentsKeepsParents(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CoalesceSubsegments = 2
+
+	ctx, root := BeginSegment(ctx, "root")
+	for i := 0; i < 2; i++ {
+		childCtx, seg := BeginSubsegment(ctx, "batch")
+		for j := 0; j < 3; j++ {
+			_, item := BeginSubsegment(childCtx, "item")
+			item.Close(nil)
+		}
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The batches have subsegments of their own, so only their items merge.
+	if !assert.Len(t, emitted.Subsegments, 2) {
+		return
+	}
+	for _, raw := range emitted.Subsegments {
+		var batch Segment
+		if assert.NoError(t, json.Unmarshal(raw, &batch)) {
+			assert.Equal(t, "batch", batch.Name)
+			assert.Len(t, batch.Subsegments, 1)
+		}
+	}
+}
+
+func TestCoalesceSubsegmentsKeepsReferencedDownstream(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CoalesceSubsegments = 2
+
+	ctx, root := BeginSegment(ctx, "root")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSubsegment(ctx, "propagated")
+		ids = append(ids, seg.DownstreamHeader().ParentID)
+		seg.Close(nil)
+	}
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSubsegment(ctx, "remote")
+		seg.Namespace = "remote"
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, emitted.Subsegments, 6) {
+		return
+	}
+	for i, id := range ids {
+		var s Segment
+		assert.NoError(t, json.Unmarshal(emitted.Subsegments[i], &s))
+		assert.Equal(t, id, s.ID)
+		assert.NotContains(t, s.Metadata, coalescedNamespace)
+	}
+}
@@ -10,9 +10,12 @@ package xray
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/daemoncfg"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
@@ -34,6 +37,13 @@ type SDK struct {
 	Version  string `json:"sdk_version,omitempty"`
 	Type     string `json:"sdk,omitempty"`
 	RuleName string `json:"sampling_rule_name,omitempty"`
+
+	// SamplingRate is the fixed rate with which the segment was sampled, so
+	// that the volume of the traffic can be estimated as the number of
+	// sampled segments divided by their rate. It is omitted for segments
+	// sampled from a reservoir, or whose decision was not made by the
+	// sampling strategy or Config.MinimumSampleRate.
+	SamplingRate float64 `json:"sampling_rate,omitempty"`
 }
 
 // SetLogger sets the logger instance used by xray.
@@ -42,6 +52,15 @@ func SetLogger(l xraylog.Logger) {
 	logger.Logger = l
 }
 
+// SetLogSuppressInterval sets the minimum time between two logs of an
+// identical context missing or emitter error message. Repeated messages
+// within the interval are dropped and counted, and the next log of the
+// message notes how many times it was suppressed. The default is 10 seconds;
+// a non-positive interval logs every occurrence.
+func SetLogSuppressInterval(d time.Duration) {
+	logger.SetSuppressInterval(d)
+}
+
 var globalCfg = newGlobalConfig()
 
 func newGlobalConfig() *globalConfig {
@@ -106,14 +125,54 @@ type globalConfig struct {
 	daemonAddr                  *net.UDPAddr
 	emitter                     Emitter
 	serviceVersion              string
+	resourceARN                 string
+	traceHeaderName             string
 	samplingStrategy            sampling.Strategy
 	streamingStrategy           StreamingStrategy
 	exceptionFormattingStrategy exception.FormattingStrategy
 	contextMissingStrategy      ctxmissing.Strategy
+	captureRequestHeaders       []string
+	captureResponseHeaders      []string
+	faultOnRequestDeadline      bool
+	disableStackTraces          bool
+	captureSQLPrepareTimings    bool
+	countSQLRows                bool
+	captureSQLPoolStats         bool
+	validateSegments            bool
+	disableSDKMetadata          bool
+	sampleAll                   bool
+	overrideUpstreamSampling    bool
+	captureCallerLocation       bool
+	coalesceSubsegments         int
+	aggregationPolicy           *AggregationPolicy
+	sampleDebugTraces           bool
+	captureRequestBodyReadTime  bool
+	maxSubsegmentsPerSegment    int
+	truncateOversizeSegments    bool
+	independentSubsegments      bool
+	segmentIDGenerator          func() string
+	annotationKeySanitizer      func(string) string
+	samplingOverride            func(*http.Request) (bool, bool)
+	sampleLargeRequestsOver     int64
+	minimumSampleRate           float64
+	onEmit                      func(time.Duration, int)
+	nestedSegmentBehavior       NestedSegmentBehavior
+	correlationIDHeader         string
+	correlationIDGenerator      func() string
+	closeSegmentsOnCancel       bool
+	inferredCallerHeader        string
+	annotationCardinalityGuard  *AnnotationCardinalityGuard
+	openSubsegmentPolicy        OpenSubsegmentPolicy
+	captureGoroutineID          bool
+	promoteOrphanSubsegments    bool
 }
 
 // Config is a set of X-Ray configurations.
 type Config struct {
+	// DaemonAddr is the address of the X-Ray daemon, see the daemoncfg
+	// package for its notations. It takes precedence over the
+	// AWS_XRAY_DAEMON_ADDRESS environment variable, which is used when it is
+	// empty, and the daemon is expected at 127.0.0.1:2000 if neither is set.
 	DaemonAddr                  string
 	ServiceVersion              string
 	Emitter                     Emitter
@@ -122,6 +181,267 @@ type Config struct {
 	ExceptionFormattingStrategy exception.FormattingStrategy
 	ContextMissingStrategy      ctxmissing.Strategy
 
+	// CaptureRequestHeaders and CaptureResponseHeaders list the HTTP headers
+	// that Handler records into the metadata of the segment it creates.
+	// Header names are matched case-insensitively and multiple values are
+	// joined with a comma. Headers carrying credentials, such as
+	// Authorization and Cookie, are only captured when listed explicitly.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+
+	// ResourceARN is the ARN of the AWS resource running the service, such
+	// as a Lambda function or an API Gateway stage. It is matched against
+	// the resource ARN of centralized sampling rules and recorded on every
+	// segment.
+	ResourceARN string
+
+	// TraceHeaderName is the name of the header Handler, HandlerWithContext,
+	// the fasthttp handler, the gRPC interceptors and the RoundTripper
+	// returned by Client read the trace header from and send it in, for
+	// ingress which renames X-Amzn-Trace-Id. It defaults to
+	// TraceIDHeaderKey. Calls to AWS services always send X-Amzn-Trace-Id.
+	TraceHeaderName string
+
+	// FaultOnRequestDeadline makes Handler and HandlerWithContext mark the
+	// segment as a fault when the deadline of the request's context is
+	// exceeded before the wrapped handler returns.
+	FaultOnRequestDeadline bool
+
+	// CaptureRequestBodyReadTime makes Handler and HandlerWithContext record
+	// the time the wrapped handler spent reading the request body, in
+	// seconds, as the "http.request.body_read_time" metadata of the segment,
+	// which tells slow clients apart from slow handlers. The time is counted
+	// until the body has been read to its end or the handler returns.
+	CaptureRequestBodyReadTime bool
+
+	// DisableStackTraces makes AddError record only the type and message of
+	// an error. The stack is neither captured nor resolved, which saves time
+	// and bytes on hot error paths. The segment is still marked as a fault.
+	DisableStackTraces bool
+
+	// CaptureSQLPrepareTimings makes statements prepared through SQLContext
+	// or SQLConnector record how long preparing and executing them took,
+	// in seconds, as the "prepare" and "execute" metadata of the "sql"
+	// namespace of every subsegment executing the statement.
+	CaptureSQLPrepareTimings bool
+
+	// CountSQLRows makes queries run through SQLContext or SQLConnector
+	// count the rows the caller reads from their result, recorded as the
+	// "rows_returned" metadata of the "sql" namespace of the query's
+	// subsegment once the rows are closed or read to the end. Rows are only
+	// counted as they are scanned, so the count misses rows left unread. The
+	// subsegment is closed when the query returns, so the count is lost if
+	// its segment has been sent before the rows are read.
+	CountSQLRows bool
+
+	// CaptureSQLPoolStats makes databases opened with SQLContext record
+	// their connection pool, as returned by (*sql.DB).Stats, into every
+	// subsegment: the open, in use and idle connections and the number of
+	// waits for a connection, as the "pool" metadata of the "sql"
+	// namespace. Connectors wrapped by SQLConnector don't know their DB and
+	// record nothing.
+	CaptureSQLPoolStats bool
+
+	// ValidateSegments makes segments missing a trace ID, ID, name or start
+	// time be logged and counted by RejectedSegmentCount instead of being
+	// emitted to the daemon, which would drop them silently.
+	ValidateSegments bool
+
+	// DisableSDKMetadata stops segments from recording the SDK version as
+	// aws.xray.sdk_version and the Go runtime and its version in the service
+	// data.
+	DisableSDKMetadata bool
+
+	// SampleAll samples every segment without evaluating the sampling
+	// strategy, such as for a staging environment which should trace every
+	// request. Sampling decisions take precedence as follows:
+	//
+	//   - An upstream Sampled=0 in the incoming trace header is honored,
+	//     unless OverrideUpstreamSampling is set as well.
+	//   - Otherwise SampleAll samples the segment.
+	//   - Without SampleAll, an upstream decision is honored and the
+	//     sampling strategy decides for requests which carry none.
+	SampleAll bool
+
+	// OverrideUpstreamSampling makes SampleAll also sample requests which
+	// upstream services decided not to sample. It has no effect without
+	// SampleAll.
+	OverrideUpstreamSampling bool
+
+	// CaptureCallerLocation makes BeginSubsegment record the file and line
+	// of the code outside the SDK which called it as the "source" metadata
+	// of the subsegment. Walking the stack is expensive, so it is disabled
+	// by default and meant for finding where subsegments originate.
+	CaptureCallerLocation bool
+
+	// CoalesceSubsegments, if at least 2, merges every run of at least that
+	// many completed subsegments of the same parent, consecutive in order
+	// of start time, which share a name and have no subsegments of their
+	// own into the first of them when the segment is emitted, for instance
+	// for subsegments begun in a tight loop. The merged subsegment spans the
+	// whole run and records the count and the total, minimum and maximum
+	// duration of the run in the "coalesced" metadata namespace. It is
+	// marked as an error, fault or throttle if any subsegment of the run
+	// was, and keeps the first cause recorded; any other data of the later
+	// subsegments is dropped. Subsegments whose ID services downstream may
+	// refer to, those whose ID was sent in a trace header and those in the
+	// "remote" or "aws" namespace, are never merged, so that the traces of
+	// the called services stay attached to them.
+	CoalesceSubsegments int
+
+	// AggregationPolicy, if set, summarizes subsegments of the same name
+	// throughout a segment tree into metadata of its root when the tree is
+	// emitted, emitting only a few of them. See AggregationPolicy.
+	AggregationPolicy *AggregationPolicy
+
+	// SampleDebugTraces samples every segment begun from an incoming trace
+	// header marked with Debug=1 by Segment.SetDebug in an upstream service,
+	// regardless of the upstream sampling decision and SampleAll.
+	SampleDebugTraces bool
+
+	// MaxSubsegmentsPerSegment, if positive, caps the number of subsegments
+	// begun in a segment tree. Beyond it, BeginSubsegment returns a
+	// subsegment which is never emitted, and calls made through it are
+	// attributed to its parent. The number of dropped subsegments is
+	// recorded as the "dropped_subsegments" metadata of the root segment.
+	// The subsegments the SDK begins for itself, for the phases of HTTP
+	// and AWS requests, neither count against it nor are dropped. It
+	// defaults to no limit.
+	MaxSubsegmentsPerSegment int
+
+	// TruncateOversizeSegments makes DefaultEmitter send a stub of segments
+	// too large for a packet to the daemon, instead of dropping them. The
+	// stub keeps the IDs, name, times and error flags of the segment, and
+	// the annotation "truncated": true, so that the trace keeps its
+	// skeleton. Its subsegments, metadata, annotations and other fields are
+	// dropped.
+	TruncateOversizeSegments bool
+
+	// EmitSubsegmentsIndependently makes the subsegments directly below a
+	// segment be emitted on their own as soon as they and their subsegments
+	// have closed, instead of within the document of the segment, as is
+	// done for the subsegments of a Lambda function. Every such document has
+	// the type "subsegment" and the trace ID and parent ID X-Ray reassembles
+	// the trace with, so that traces too large for a single document arrive
+	// in pieces regardless of their subsegment count. Subsegments emitted on
+	// their own are left out of the aggregation and coalescing of the
+	// segment.
+	EmitSubsegmentsIndependently bool
+
+	// SegmentIDGenerator, if set, generates the IDs of sampled segments and
+	// subsegments instead of NewSegmentID, for instance to make IDs
+	// reproducible in tests. IDs must be 16 lowercase hexadecimal digits, as
+	// the daemon rejects any other ID; invalid IDs are logged and replaced
+	// with one generated by NewSegmentID.
+	SegmentIDGenerator func() string
+
+	// AnnotationKeySanitizer, if set, replaces SanitizeAnnotationKey to turn
+	// the keys given to AddAnnotation into keys the daemon accepts, which
+	// may only contain alphanumeric characters and underscores.
+	AnnotationKeySanitizer func(key string) string
+
+	// AnnotationCardinalityGuard, if set, warns about annotation keys
+	// given more distinct values than it allows, and may add such values as
+	// metadata instead.
+	AnnotationCardinalityGuard *AnnotationCardinalityGuard
+
+	// SamplingOverride, if set, is called with the request of every segment
+	// begun by Handler, HandlerWithContext and BeginSegmentWithSampling. If
+	// it returns forced, decision is the sampling decision of the segment,
+	// even over the decision of an incoming trace header, a Debug=1 trace
+	// header with SampleDebugTraces and SampleAll, and the sampling strategy
+	// is not consulted. Otherwise the segment is sampled as without an
+	// override.
+	SamplingOverride func(r *http.Request) (decision bool, forced bool)
+
+	// SampleLargeRequestsOver, if positive, samples every request whose
+	// Content-Length is larger than that many bytes, such as big uploads,
+	// without evaluating the sampling strategy. Decisions of an incoming
+	// trace header are honored, and requests of unknown length are sampled
+	// as usual.
+	SampleLargeRequestsOver int64
+
+	// MinimumSampleRate, if positive, additionally samples that fraction,
+	// between 0 and 1, of the requests the sampling strategy decides not to
+	// sample, so that some are traced even when no sampling rule matches or
+	// the reservoir and rate of a rule are exhausted. Requests sampled this
+	// way still count against the rules of the strategy. It only applies
+	// where the strategy is consulted, so decisions of an incoming trace
+	// header and a forced SamplingOverride are honored.
+	MinimumSampleRate float64
+
+	// OnEmit, if set, is called by DefaultEmitter for every packet written
+	// to the daemon, with the time from the call to Emit to the end of the
+	// write and the size of the packet in bytes. A segment streamed in
+	// several packets reports each of them. It is invoked synchronously in
+	// the emit path, while the segment is locked, so it must return quickly
+	// and must not use the segment, for instance by recording its duration
+	// into a histogram.
+	OnEmit func(duration time.Duration, size int)
+
+	// NestedSegmentBehavior decides what BeginSegment does when given a
+	// context which already holds an open segment or subsegment. By default
+	// it begins a subsegment of it, rather than the root of another trace.
+	NestedSegmentBehavior NestedSegmentBehavior
+
+	// OpenSubsegmentPolicy decides what closing a segment does while some
+	// of its subsegments are still open. By default the segment is sent
+	// right away, with those subsegments in progress.
+	OpenSubsegmentPolicy OpenSubsegmentPolicy
+
+	// CaptureGoroutineID records the ID of the goroutine beginning every
+	// subsegment as its "goroutine_id" metadata, to see which work ran in
+	// parallel. It is meant for debugging only: Go exposes no goroutine
+	// IDs, so they are parsed from a stack trace, which costs time, and
+	// are left out if the runtime formats stack traces differently.
+	CaptureGoroutineID bool
+
+	// PromoteOrphanSubsegments makes BeginSubsegment, and functions using
+	// it such as Capture, begin a segment when their context holds no
+	// segment, instead of reporting the missing segment to the
+	// ContextMissingStrategy. It lets libraries trace their operations
+	// when used by applications which do not trace requests. Each such
+	// operation is then the root of a trace of its own, disconnected from
+	// whatever called it, and is sampled on its own by the sampling
+	// strategy.
+	PromoteOrphanSubsegments bool
+
+	// CorrelationIDHeader, if set, names a request header carrying an ID
+	// of the request in other systems, such as the request ID written to
+	// the logs of the service. Handler records its value as the
+	// "correlation_id" annotation of the segment, so that traces can be
+	// searched by it and joined with those logs.
+	CorrelationIDHeader string
+
+	// CorrelationIDGenerator, if set, generates the correlation ID of
+	// requests without the CorrelationIDHeader. The generated ID is
+	// annotated like a received one and set on the header of the request
+	// passed to the handler, so that the application can log it.
+	CorrelationIDGenerator func() string
+
+	// CloseSegmentsOnCancel closes a segment as soon as the context it was
+	// begun with is canceled, for instance by Handler once the client
+	// disconnected from a streaming response, instead of when it is closed.
+	// The subsegments still in progress are closed first, and they and the
+	// segment are annotated with "canceled", so that the segment is sent
+	// with its whole tree rather than with subsegments left in progress.
+	// Closing them afterwards does not send them again.
+	CloseSegmentsOnCancel bool
+
+	// InferredCallerHeader, if set, names a request header carrying the
+	// name of the caller, such as a header set by an uninstrumented
+	// gateway. For sampled requests with this header and no parent in
+	// their trace header, Handler sends a placeholder segment named after
+	// the caller, lasting as long as the segment of the request, as the
+	// parent of that segment, so that the service map shows the caller.
+	//
+	// The placeholder only stands for the caller: its duration is that of
+	// the service rather than as measured by the caller, and it records no
+	// errors of its own. Every traced request sends an additional segment.
+	// The header must only be set by trusted callers, as every name in it
+	// becomes a node of the service map.
+	InferredCallerHeader string
+
 	// LogLevel and LogFormat are deprecated and no longer have any effect.
 	// See SetLogger() and the associated xraylog.Logger interface to control
 	// logging.
@@ -133,7 +453,7 @@ type Config struct {
 func ContextWithConfig(ctx context.Context, c Config) (context.Context, error) {
 	var errors exception.MultiError
 
-	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromString(c.DaemonAddr)
+	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromConfig(c.DaemonAddr)
 
 	if daemonEndpoints != nil {
 		if c.Emitter != nil {
@@ -160,6 +480,9 @@ func ContextWithConfig(ctx context.Context, c Config) (context.Context, error) {
 		}
 	}
 
+	warnSensitiveHeaders(c.CaptureRequestHeaders)
+	warnSensitiveHeaders(c.CaptureResponseHeaders)
+
 	var err error
 	switch len(errors) {
 	case 0:
@@ -177,7 +500,9 @@ func configureStrategy(s sampling.Strategy, daemonEndpoints *daemoncfg.DaemonEnd
 	if s == nil {
 		return
 	}
-	strategy, ok := s.(*sampling.CentralizedStrategy)
+	strategy, ok := s.(interface {
+		LoadDaemonEndpoints(*daemoncfg.DaemonEndpoints)
+	})
 	if ok {
 		strategy.LoadDaemonEndpoints(daemonEndpoints)
 	}
@@ -198,7 +523,7 @@ func Configure(c Config) error {
 		globalCfg.emitter = c.Emitter
 	}
 
-	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromString(c.DaemonAddr)
+	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromConfig(c.DaemonAddr)
 	if daemonEndpoints != nil {
 		globalCfg.daemonAddr = daemonEndpoints.UDPAddr
 		globalCfg.emitter.RefreshEmitterWithAddress(globalCfg.daemonAddr)
@@ -235,6 +560,152 @@ func Configure(c Config) error {
 		globalCfg.serviceVersion = c.ServiceVersion
 	}
 
+	if c.ResourceARN != "" {
+		globalCfg.resourceARN = c.ResourceARN
+	}
+
+	if c.TraceHeaderName != "" {
+		globalCfg.traceHeaderName = c.TraceHeaderName
+	}
+
+	if c.FaultOnRequestDeadline {
+		globalCfg.faultOnRequestDeadline = true
+	}
+
+	if c.DisableStackTraces {
+		globalCfg.disableStackTraces = true
+	}
+
+	if c.CaptureSQLPrepareTimings {
+		globalCfg.captureSQLPrepareTimings = true
+	}
+
+	if c.CountSQLRows {
+		globalCfg.countSQLRows = true
+	}
+
+	if c.CaptureSQLPoolStats {
+		globalCfg.captureSQLPoolStats = true
+	}
+
+	if c.ValidateSegments {
+		globalCfg.validateSegments = true
+	}
+
+	if c.DisableSDKMetadata {
+		globalCfg.disableSDKMetadata = true
+	}
+
+	if c.SampleAll {
+		globalCfg.sampleAll = true
+	}
+
+	if c.OverrideUpstreamSampling {
+		globalCfg.overrideUpstreamSampling = true
+	}
+
+	if c.CaptureCallerLocation {
+		globalCfg.captureCallerLocation = true
+	}
+
+	if c.CoalesceSubsegments > 0 {
+		globalCfg.coalesceSubsegments = c.CoalesceSubsegments
+	}
+
+	if c.AggregationPolicy != nil {
+		globalCfg.aggregationPolicy = c.AggregationPolicy
+	}
+
+	if c.SampleDebugTraces {
+		globalCfg.sampleDebugTraces = true
+	}
+
+	if c.CaptureRequestBodyReadTime {
+		globalCfg.captureRequestBodyReadTime = true
+	}
+
+	if c.MaxSubsegmentsPerSegment > 0 {
+		globalCfg.maxSubsegmentsPerSegment = c.MaxSubsegmentsPerSegment
+	}
+
+	if c.TruncateOversizeSegments {
+		globalCfg.truncateOversizeSegments = true
+	}
+
+	if c.EmitSubsegmentsIndependently {
+		globalCfg.independentSubsegments = true
+	}
+
+	if c.SegmentIDGenerator != nil {
+		globalCfg.segmentIDGenerator = c.SegmentIDGenerator
+	}
+
+	if c.AnnotationKeySanitizer != nil {
+		globalCfg.annotationKeySanitizer = c.AnnotationKeySanitizer
+	}
+
+	if c.AnnotationCardinalityGuard != nil {
+		globalCfg.annotationCardinalityGuard = c.AnnotationCardinalityGuard
+	}
+
+	if c.SamplingOverride != nil {
+		globalCfg.samplingOverride = c.SamplingOverride
+	}
+
+	if c.SampleLargeRequestsOver > 0 {
+		globalCfg.sampleLargeRequestsOver = c.SampleLargeRequestsOver
+	}
+
+	if c.MinimumSampleRate > 0 {
+		globalCfg.minimumSampleRate = c.MinimumSampleRate
+	}
+
+	if c.OnEmit != nil {
+		globalCfg.onEmit = c.OnEmit
+	}
+
+	if c.NestedSegmentBehavior != NestedSegmentDefault {
+		globalCfg.nestedSegmentBehavior = c.NestedSegmentBehavior
+	}
+
+	if c.OpenSubsegmentPolicy != OpenSubsegmentsInProgress {
+		globalCfg.openSubsegmentPolicy = c.OpenSubsegmentPolicy
+	}
+
+	if c.CaptureGoroutineID {
+		globalCfg.captureGoroutineID = c.CaptureGoroutineID
+	}
+
+	if c.PromoteOrphanSubsegments {
+		globalCfg.promoteOrphanSubsegments = c.PromoteOrphanSubsegments
+	}
+
+	if c.CorrelationIDHeader != "" {
+		globalCfg.correlationIDHeader = c.CorrelationIDHeader
+	}
+
+	if c.CorrelationIDGenerator != nil {
+		globalCfg.correlationIDGenerator = c.CorrelationIDGenerator
+	}
+
+	if c.CloseSegmentsOnCancel {
+		globalCfg.closeSegmentsOnCancel = c.CloseSegmentsOnCancel
+	}
+
+	if c.InferredCallerHeader != "" {
+		globalCfg.inferredCallerHeader = c.InferredCallerHeader
+	}
+
+	if c.CaptureRequestHeaders != nil {
+		warnSensitiveHeaders(c.CaptureRequestHeaders)
+		globalCfg.captureRequestHeaders = c.CaptureRequestHeaders
+	}
+
+	if c.CaptureResponseHeaders != nil {
+		warnSensitiveHeaders(c.CaptureResponseHeaders)
+		globalCfg.captureResponseHeaders = c.CaptureResponseHeaders
+	}
+
 	switch len(errors) {
 	case 0:
 		return nil
@@ -245,6 +716,50 @@ func Configure(c Config) error {
 	}
 }
 
+// RefreshEmitter points the globally configured emitter at the given daemon
+// address without reconfiguring anything else. The address accepts the same
+// notations as Config.DaemonAddr and, like it, takes precedence over the
+// AWS_XRAY_DAEMON_ADDRESS environment variable. It is safe to call while
+// segments are being emitted. Emitters that do not support changing their
+// address implement RefreshEmitterWithAddress as a no-op, so for those this
+// only updates the address used by the sampling strategy.
+func RefreshEmitter(addr string) error {
+	if addr == "" {
+		return errors.New("daemon address must not be empty")
+	}
+
+	daemonEndpoints, err := daemoncfg.GetDaemonEndpointsFromConfig(addr)
+	if err != nil {
+		return err
+	}
+
+	globalCfg.Lock()
+	defer globalCfg.Unlock()
+
+	globalCfg.daemonAddr = daemonEndpoints.UDPAddr
+	globalCfg.emitter.RefreshEmitterWithAddress(globalCfg.daemonAddr)
+	configureStrategy(globalCfg.samplingStrategy, daemonEndpoints)
+
+	return nil
+}
+
+// CurrentSamplingStrategy returns the sampling strategy of the global
+// configuration, which segments use unless their context carries a
+// configuration of its own.
+func CurrentSamplingStrategy() sampling.Strategy {
+	return globalCfg.SamplingStrategy()
+}
+
+// SamplingRules returns copies of the rules of the current sampling
+// strategy, for instance to log them at startup. It returns nil if the
+// strategy does not implement sampling.RuleSummarizer.
+func SamplingRules() []sampling.RuleSummary {
+	if rs, ok := CurrentSamplingStrategy().(sampling.RuleSummarizer); ok {
+		return rs.RuleSummaries()
+	}
+	return nil
+}
+
 func (c *globalConfig) DaemonAddr() *net.UDPAddr {
 	c.RLock()
 	defer c.RUnlock()
@@ -280,3 +795,27 @@ func (c *globalConfig) ServiceVersion() string {
 	defer c.RUnlock()
 	return c.serviceVersion
 }
+
+func (c *globalConfig) Emitter() Emitter {
+	c.RLock()
+	defer c.RUnlock()
+	return c.emitter
+}
+
+func (c *globalConfig) TraceHeaderName() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.traceHeaderName
+}
+
+// traceHeaderName returns the name of the trace header configured by cfg,
+// falling back to the global configuration and then to TraceIDHeaderKey.
+func traceHeaderName(cfg *Config) string {
+	if cfg != nil && cfg.TraceHeaderName != "" {
+		return cfg.TraceHeaderName
+	}
+	if name := globalCfg.TraceHeaderName(); name != "" {
+		return name
+	}
+	return TraceIDHeaderKey
+}
@@ -175,18 +175,88 @@ func TestSetConfigureParameters(t *testing.T) {
 	ResetConfig()
 }
 
+func TestCurrentSamplingStrategy(t *testing.T) {
+	defer ResetConfig()
+
+	ss, err := sampling.NewLocalizedStrategy()
+	if !assert.NoError(t, err) {
+		return
+	}
+	Configure(Config{SamplingStrategy: ss})
+	assert.Equal(t, ss, CurrentSamplingStrategy())
+	assert.Equal(t, ss.RuleSummaries(), SamplingRules())
+	assert.Len(t, SamplingRules(), 1)
+
+	Configure(Config{SamplingStrategy: &TestSamplingStrategy{}})
+	assert.Nil(t, SamplingRules())
+}
+
 func TestSetDaemonAddressEnvironmentVariable(t *testing.T) {
 	env := stashEnv()
 	defer popEnv(env)
 	daemonAddr := "127.0.0.1:3000"
 	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", "127.0.0.1:4000")
 	Configure(Config{DaemonAddr: daemonAddr})
-	assert.Equal(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}, globalCfg.daemonAddr)
+	assert.Equal(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000}, globalCfg.daemonAddr, "DaemonAddr takes precedence over the variable")
 	os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
 
 	ResetConfig()
 }
 
+func TestConfigureDaemonAddressFromEnvironmentVariable(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	defer ResetConfig()
+
+	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", "127.0.0.1:4000")
+	e := &TestRefreshingEmitter{}
+	Configure(Config{Emitter: e})
+	daemonAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}
+	assert.Equal(t, daemonAddr, globalCfg.daemonAddr)
+	assert.Equal(t, daemonAddr, e.addr)
+
+	os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+	Configure(Config{Emitter: e})
+	assert.Equal(t, daemonAddr, globalCfg.daemonAddr, "the address is kept without DaemonAddr or the variable")
+}
+
+type TestRefreshingEmitter struct {
+	addr *net.UDPAddr
+}
+
+func (te *TestRefreshingEmitter) Emit(seg *Segment) {}
+
+func (te *TestRefreshingEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	te.addr = raddr
+}
+
+func TestRefreshEmitter(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	e := &TestRefreshingEmitter{}
+	Configure(Config{Emitter: e})
+
+	assert.NoError(t, RefreshEmitter("127.0.0.1:3000"))
+	daemonAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+	assert.Equal(t, daemonAddr, e.addr)
+	assert.Equal(t, daemonAddr, globalCfg.DaemonAddr())
+
+	ResetConfig()
+}
+
+func TestRefreshEmitterInvalidAddress(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	e := &TestRefreshingEmitter{}
+	Configure(Config{Emitter: e})
+
+	assert.Error(t, RefreshEmitter(""))
+	assert.Error(t, RefreshEmitter("This is not a valid address"))
+	assert.Nil(t, e.addr)
+
+	ResetConfig()
+}
+
 func TestSetContextMissingEnvironmentVariable(t *testing.T) {
 	env := stashEnv()
 	defer popEnv(env)
@@ -0,0 +1,88 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// ConsoleEmitter prints emitted segments as an indented tree of
+// (sub)segment names, durations and error markers to stderr instead of
+// sending them to the daemon. It is meant for local development only.
+type ConsoleEmitter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewConsoleEmitter initializes and returns a
+// pointer to an instance of ConsoleEmitter.
+func NewConsoleEmitter() *ConsoleEmitter {
+	return &ConsoleEmitter{w: os.Stderr}
+}
+
+// RefreshEmitterWithAddress is a no-op as ConsoleEmitter
+// does not send segments to the daemon.
+func (ce *ConsoleEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// Emit prints the tree below seg if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (ce *ConsoleEmitter) Emit(seg *Segment) {
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	var b bytes.Buffer
+	writeSegmentTree(&b, seg, 0)
+
+	ce.Lock()
+	defer ce.Unlock()
+	if _, err := ce.w.Write(b.Bytes()); err != nil {
+		logger.Errorf("Error printing segment %s: %s", seg.Name, err)
+	}
+}
+
+// writeSegmentTree writes one line for seg and its subsegments to b,
+// indented by depth.
+// seg has a write lock acquired by the caller.
+func writeSegmentTree(b *bytes.Buffer, seg *Segment, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(seg.Name)
+
+	if seg.EndTime > 0 {
+		d := time.Duration((seg.EndTime - seg.StartTime) * float64(time.Second))
+		fmt.Fprintf(b, " %v", d.Round(time.Microsecond))
+	} else {
+		b.WriteString(" (in progress)")
+	}
+	if seg.Fault {
+		b.WriteString(" [fault]")
+	}
+	if seg.Error {
+		b.WriteString(" [error]")
+	}
+	if seg.Throttle {
+		b.WriteString(" [throttle]")
+	}
+	b.WriteString("\n")
+
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		writeSegmentTree(b, s, depth+1)
+		s.Unlock()
+	}
+}
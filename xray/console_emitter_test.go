@@ -0,0 +1,60 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleEmitter(t *testing.T) {
+	var b bytes.Buffer
+	e := NewConsoleEmitter()
+	e.w = &b
+
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          e,
+		SamplingStrategy: &TestSamplingStrategy{},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	ctx1, child := BeginSubsegment(ctx, "Child")
+	_, grandchild := BeginSubsegment(ctx1, "Grandchild")
+	grandchild.Close(errors.New("test"))
+	child.Close(nil)
+	_, sibling := BeginSubsegment(ctx, "Sibling")
+	sibling.Close(nil)
+	root.Close(nil)
+
+	assert.Regexp(t, regexp.MustCompile(`^Segment \S+
+  Child \S+
+    Grandchild \S+ \[fault\]
+  Sibling \S+
+$`), b.String())
+}
+
+func TestConsoleEmitterNotSampled(t *testing.T) {
+	var b bytes.Buffer
+	e := NewConsoleEmitter()
+	e.w = &b
+
+	seg := &Segment{Name: "Segment"}
+	seg.ParentSegment = seg
+	e.Emit(seg)
+
+	assert.Empty(t, b.String())
+}
@@ -11,6 +11,12 @@ package xray
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
 )
 
 // ContextKeytype defines integer to be type of ContextKey.
@@ -74,6 +80,53 @@ func TraceID(ctx context.Context) string {
 	return ""
 }
 
+// DownstreamHeader returns the value of the X-Amzn-Trace-Id header for
+// passing the segment or subsegment in ctx to a downstream call. It is built
+// the way Client builds the header for the subsegment of each request, so it
+// carries the sampling decision, with the segment in ctx as the parent. It
+// returns "" if ctx holds no segment.
+// See BuildOutboundHeader.
+func DownstreamHeader(ctx context.Context) string {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ""
+	}
+	return seg.DownstreamHeader().String()
+}
+
+// LogFields returns the trace ID and the ID of the segment or subsegment in
+// ctx as the key/value pairs "trace_id" and "segment_id", in the form taken
+// by structured loggers such as slog and zap, to correlate log lines with
+// the trace they were written during. It returns nil and allocates nothing
+// if ctx holds no segment or the segment has no trace ID. Segments which
+// are not sampled may have no ID, in which case only the trace ID is
+// returned.
+func LogFields(ctx context.Context) []interface{} {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return nil
+	}
+
+	seg.RLock()
+	id := seg.ID
+	root := seg.ParentSegment
+	seg.RUnlock()
+
+	traceID := ""
+	if root != nil {
+		root.RLock()
+		traceID = root.TraceID
+		root.RUnlock()
+	}
+	if traceID == "" {
+		return nil
+	}
+	if id == "" {
+		return []interface{}{"trace_id", traceID}
+	}
+	return []interface{}{"trace_id", traceID, "segment_id", id}
+}
+
 // RequestWasTraced returns true if the context contains an X-Ray segment
 // that was created from an HTTP request that contained a trace header.
 // This is useful to ensure that a service is only called from X-Ray traced
@@ -87,11 +140,137 @@ func RequestWasTraced(ctx context.Context) bool {
 	return false
 }
 
+// IsSampled returns true if the segment or subsegment in ctx is sampled,
+// that is if what is recorded into it is sent to X-Ray. It returns false if
+// ctx has no segment. This allows skipping expensive instrumentation for
+// traces which are not sampled.
+func IsSampled(ctx context.Context) bool {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return false
+	}
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.Sampled
+}
+
 // DetachContext returns a new context with the existing segment.
 // This is useful for creating background tasks which won't be cancelled
-// when a request completes.
+// when a request completes. The values of ctx, such as its recorder and
+// default annotations, are kept, while its deadline and cancellation are
+// dropped.
 func DetachContext(ctx context.Context) context.Context {
-	return context.WithValue(context.Background(), ContextKey, GetSegment(ctx))
+	return context.WithValue(detachedContext{ctx}, ContextKey, GetSegment(ctx))
+}
+
+// detachedContext is a context with the values of its parent but never
+// done.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// ContextWithTraceHeader returns a new context which continues the trace
+// described by h, such as the X-Amzn-Trace-Id of a message consumed from a
+// queue. Subsegments begun from the returned context are children of the
+// segment identified by h.ParentID and are emitted as soon as they close.
+// The sampling decision of h is honored; if it carries none, the configured
+// sampling strategy decides. Config.SampleAll and OverrideUpstreamSampling
+// apply as they do to segments begun by Handler. A nil header or one
+// without a trace or parent ID cannot be continued, in which case ctx is
+// returned unchanged.
+func ContextWithTraceHeader(ctx context.Context, h *header.Header) context.Context {
+	if h == nil || h.TraceID == "" || h.ParentID == "" {
+		logger.Debug("Unable to continue trace from header: trace and parent ID are required")
+		return ctx
+	}
+
+	seg := basicSegment("facade", h)
+	seg.assignConfiguration(GetRecorder(ctx))
+
+	if sampleAll(seg.GetConfiguration(), h) {
+		seg.Sampled = true
+		logger.Debug("SampleAll decided: Sampled=true")
+	} else if h.SamplingDecision != header.Sampled && h.SamplingDecision != header.NotSampled {
+		sd := seg.GetConfiguration().SamplingStrategy.ShouldTrace(&sampling.Request{TraceID: h.TraceID, Attributes: samplingAttributes(ctx)})
+		seg.Sampled = sd.Sample
+		logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
+	}
+
+	return context.WithValue(ctx, ContextKey, seg)
+}
+
+// samplingAttributesContextKey holds the attributes set by
+// SetSamplingAttribute.
+type samplingAttributesContextKey struct{}
+
+// SetSamplingAttribute returns a copy of ctx in which the sampling
+// attribute key has value. The attributes of ctx are passed to the sampling
+// strategy when a segment is begun from it, and centralized sampling rules
+// with attributes apply only to segments having all of them with a matching
+// value. Attributes must therefore be set before the sampling decision is
+// made: on the context passed to BeginSegment or ContextWithTraceHeader, or
+// on the request context in a middleware running before Handler. Setting
+// them once the segment has begun has no effect.
+func SetSamplingAttribute(ctx context.Context, key, value string) context.Context {
+	old := samplingAttributes(ctx)
+	attrs := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		attrs[k] = v
+	}
+	attrs[key] = value
+	return context.WithValue(ctx, samplingAttributesContextKey{}, attrs)
+}
+
+// samplingAttributes returns the sampling attributes of ctx, which must not
+// be modified.
+func samplingAttributes(ctx context.Context) map[string]string {
+	attrs, _ := ctx.Value(samplingAttributesContextKey{}).(map[string]string)
+	return attrs
+}
+
+// defaultAnnotationsContextKey holds the annotations set by
+// WithDefaultAnnotations.
+type defaultAnnotationsContextKey struct{}
+
+// WithDefaultAnnotations returns a copy of ctx carrying annotations, which
+// are added to every subsegment begun from it or from contexts derived from
+// it, such as the tenant or region of a request. They are merged with the
+// default annotations ctx already carries, annotations having the same key
+// replacing those of ctx. Annotations added later to a subsegment replace
+// the default ones. Only subsegments begun after the call get them: the
+// segment and subsegments begun before are unchanged, and so are
+// subsegments begun with (*Segment).BeginSubsegment, which takes no context.
+// Values must be of type string, number or boolean, like those of
+// AddAnnotation; other values are logged and ignored.
+func WithDefaultAnnotations(ctx context.Context, annotations map[string]interface{}) context.Context {
+	old := defaultAnnotations(ctx)
+	merged := make(map[string]interface{}, len(old)+len(annotations))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		if !isAnnotationValue(v) {
+			logger.Errorf("Ignoring default annotation key: %q value: %q. value must be of type string, number or boolean", k, v)
+			continue
+		}
+		merged[k] = v
+	}
+	return context.WithValue(ctx, defaultAnnotationsContextKey{}, merged)
+}
+
+// defaultAnnotations returns the default annotations of ctx, which must not
+// be modified.
+func defaultAnnotations(ctx context.Context) map[string]interface{} {
+	annotations, _ := ctx.Value(defaultAnnotationsContextKey{}).(map[string]interface{})
+	return annotations
 }
 
 // AddAnnotation adds an annotation to the provided segment or subsegment in ctx.
@@ -118,6 +297,15 @@ func AddMetadataToNamespace(ctx context.Context, namespace string, key string, v
 	return ErrRetrieveSegment
 }
 
+// AppendMetadata appends value to the metadata stored under key in namespace
+// of the segment or subsegment in ctx.
+func AppendMetadata(ctx context.Context, namespace string, key string, value interface{}) error {
+	if seg := GetSegment(ctx); seg != nil {
+		return seg.AppendMetadata(namespace, key, value)
+	}
+	return ErrRetrieveSegment
+}
+
 // AddError adds an error to the provided segment or subsegment in ctx.
 func AddError(ctx context.Context, err error) error {
 	if seg := GetSegment(ctx); seg != nil {
@@ -125,3 +313,110 @@ func AddError(ctx context.Context, err error) error {
 	}
 	return ErrRetrieveSegment
 }
+
+// SetSegmentName renames the root segment of the segment or subsegment
+// provided in ctx with Segment.SetName. This allows middleware that runs
+// after the segment was started to replace the fallback name with a more
+// descriptive one. The name can only be changed until the segment has been
+// emitted.
+func SetSegmentName(ctx context.Context, name string) error {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ErrRetrieveSegment
+	}
+	if seg.ParentSegment != nil {
+		seg = seg.ParentSegment
+	}
+	return seg.SetName(name)
+}
+
+// SetHTTPResponseStatus records code as the HTTP response status of the root
+// segment of the segment or subsegment provided in ctx, and marks the segment
+// as an error, throttle or fault as Handler would. Use it when the status is
+// only known outside the response writer seen by Handler, such as behind a
+// wrapped writer. Once set, Handler keeps this status instead of the one it
+// captured. The status can only be set until the segment has been emitted.
+func SetHTTPResponseStatus(ctx context.Context, code int) error {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ErrRetrieveSegment
+	}
+
+	root := seg.ParentSegment
+	root.Lock()
+	if root.Emitted {
+		root.Unlock()
+		return fmt.Errorf("unable to set response status of segment %q: segment has already been emitted", root.Name)
+	}
+	root.responseStatusSet = true
+	root.Unlock()
+
+	HttpCaptureResponse(root, code)
+	return nil
+}
+
+// SetHTTPRequestInfo records the method, URL, user agent and client IP of
+// the request traced by the root segment of the segment or subsegment
+// provided in ctx, replacing what Handler captured. Use it behind a reverse
+// proxy to record the request of the original client, for instance from
+// forwarded headers, rather than the call made by the proxy. Empty values
+// leave the captured ones in place. A client IP set this way is marked as
+// forwarded. The request can only be set until the segment has been emitted.
+func SetHTTPRequestInfo(ctx context.Context, method, url, userAgent, clientIP string) error {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ErrRetrieveSegment
+	}
+
+	root := seg.ParentSegment
+	root.Lock()
+	defer root.Unlock()
+
+	if root.Emitted {
+		return fmt.Errorf("unable to set request of segment %q: segment has already been emitted", root.Name)
+	}
+
+	req := root.GetHTTP().GetRequest()
+	if method != "" {
+		req.Method = method
+	}
+	if url != "" {
+		req.URL = url
+	}
+	if userAgent != "" {
+		req.UserAgent = userAgent
+	}
+	if clientIP != "" {
+		req.ClientIP = clientIP
+		req.XForwardedFor = true
+	}
+	return nil
+}
+
+// RouteTemplateAnnotationKey is the annotation in which SetRouteTemplate
+// records the route template of a request.
+const RouteTemplateAnnotationKey = "http_route"
+
+// SetRouteTemplate records template, the route matching the request traced
+// by the root segment of the segment or subsegment provided in ctx such as
+// "/users/{id}", as the annotation RouteTemplateAnnotationKey of the root.
+// The concrete URL, such as "/users/42", stays in the http request data of
+// the segment, so that traces can be grouped by route and still be debugged
+// by URL. Annotations are indexed, so templates must have a low cardinality:
+// any parameter of the path must be replaced by a placeholder. The template
+// can only be set until the segment has been emitted.
+func SetRouteTemplate(ctx context.Context, template string) error {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ErrRetrieveSegment
+	}
+
+	root := seg.ParentSegment
+	root.RLock()
+	emitted := root.Emitted
+	root.RUnlock()
+	if emitted {
+		return fmt.Errorf("unable to set route template of segment %q: segment has already been emitted", root.Name)
+	}
+	return root.AddAnnotation(RouteTemplateAnnotationKey, template)
+}
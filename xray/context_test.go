@@ -10,10 +10,18 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,6 +40,42 @@ func TestEmptyTraceID(t *testing.T) {
 	assert.Empty(t, traceID)
 }
 
+func TestDownstreamHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.Empty(t, DownstreamHeader(context.Background()))
+
+	ctx, seg := BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+	assert.Equal(t, "Root="+seg.TraceID+";Parent="+seg.ID+";Sampled=1", DownstreamHeader(ctx))
+
+	subCtx, subseg := BeginSubsegmentWithoutSampling(ctx, "sub")
+	defer subseg.Close(nil)
+	assert.Equal(t, "Root="+seg.TraceID+";Parent="+subseg.ID+";Sampled=0", DownstreamHeader(subCtx))
+}
+
+func TestLogFields(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+	assert.Equal(t, []interface{}{"trace_id", seg.TraceID, "segment_id", seg.ID}, LogFields(ctx))
+
+	subCtx, subseg := BeginSubsegment(ctx, "sub")
+	defer subseg.Close(nil)
+	assert.Equal(t, []interface{}{"trace_id", seg.TraceID, "segment_id", subseg.ID}, LogFields(subCtx))
+}
+
+func TestLogFieldsWithoutSegment(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, LogFields(ctx))
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		LogFields(ctx)
+	}))
+}
+
 func TestRequestWasNotTraced(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -62,6 +106,29 @@ func TestDetachContext(t *testing.T) {
 	}
 }
 
+type detachedValueKey struct{}
+
+func TestDetachContextKeepsValues(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx = WithDefaultAnnotations(ctx, map[string]interface{}{"tenant": "acme"})
+	ctx = context.WithValue(ctx, detachedValueKey{}, "value")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	ctx1, seg := BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+	ctx2 := DetachContext(ctx1)
+
+	_, hasDeadline := ctx2.Deadline()
+	assert.False(t, hasDeadline)
+	assert.Nil(t, ctx2.Done())
+	assert.Equal(t, "value", ctx2.Value(detachedValueKey{}))
+	assert.Equal(t, GetRecorder(ctx1), GetRecorder(ctx2))
+	assert.Equal(t, map[string]interface{}{"tenant": "acme"}, defaultAnnotations(ctx2))
+}
+
 func TestValidAnnotations(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -142,6 +209,33 @@ func TestSimpleMetadata(t *testing.T) {
 	assert.Equal(t, true, seg.Metadata["default"]["bool"])
 }
 
+func TestAppendMetadata(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	for _, item := range []string{"a", "b", "c"} {
+		assert.NoError(t, AppendMetadata(ctx, "items", "processed", item))
+	}
+	assert.NoError(t, AddMetadataToNamespace(ctx, "items", "scalar", 1))
+	assert.NoError(t, AppendMetadata(ctx, "items", "scalar", 2))
+	assert.NoError(t, AddMetadataToNamespace(ctx, "items", "slice", []string{"x"}))
+	assert.NoError(t, AppendMetadata(ctx, "items", "slice", "y"))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []interface{}{"a", "b", "c"}, seg.Metadata["items"]["processed"])
+	assert.Equal(t, []interface{}{1.0, 2.0}, seg.Metadata["items"]["scalar"])
+	assert.Equal(t, []interface{}{"x", "y"}, seg.Metadata["items"]["slice"])
+}
+
+func TestAppendMetadataWithoutSegment(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, AppendMetadata(context.Background(), "items", "processed", "a"))
+}
+
 func TestAddError(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -159,6 +253,182 @@ func TestAddError(t *testing.T) {
 	assert.Equal(t, "errors.errorString", seg.Cause.Exceptions[0].Type)
 }
 
+func TestAddErrorDisableStackTraces(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).DisableStackTraces = true
+
+	ctx, root := BeginSegment(ctx, "Test")
+	err := AddError(ctx, errors.New("New Error"))
+	assert.NoError(t, err)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, seg.Fault)
+	assert.Equal(t, "New Error", seg.Cause.Exceptions[0].Message)
+	assert.Equal(t, "errors.errorString", seg.Cause.Exceptions[0].Type)
+	assert.Empty(t, seg.Cause.Exceptions[0].Stack)
+}
+
+// fieldsError is an error exposing structured fields.
+type fieldsError struct{}
+
+func (e *fieldsError) Error() string {
+	return "fields error"
+}
+
+func (e *fieldsError) XRayFields() map[string]interface{} {
+	return map[string]interface{}{"code": "E42", "retryable": true}
+}
+
+func TestAddErrorFields(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	assert.NoError(t, AddError(ctx, fmt.Errorf("wrapped: %w", &fieldsError{})))
+	assert.NoError(t, AddError(ctx, errors.New("plain")))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, seg.Cause.Exceptions, 2) {
+		return
+	}
+	id := seg.Cause.Exceptions[0].ID
+	assert.Equal(t, map[string]interface{}{
+		id: map[string]interface{}{"code": "E42", "retryable": true},
+	}, seg.Metadata["error_fields"])
+
+	// The exception itself keeps to the X-Ray schema.
+	b, err := json.Marshal(seg.Cause.Exceptions[0])
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "E42")
+}
+
+func TestSetSegmentName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Fallback")
+	subCtx, subseg := BeginSubsegment(ctx, "Subsegment")
+	assert.NoError(t, SetSegmentName(subCtx, "GET /users/{id}"))
+	subseg.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "GET /users/{id}", seg.Name)
+	assert.Error(t, SetSegmentName(ctx, "Too late"))
+}
+
+func TestSetSegmentNameValidatesLikeSetName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Fallback")
+	assert.Error(t, SetSegmentName(ctx, ""))
+	assert.Equal(t, "Fallback", root.Name)
+	assert.NoError(t, SetSegmentName(ctx, strings.Repeat("a", 250)))
+	assert.Len(t, root.Name, 200)
+	root.Close(nil)
+}
+
+func TestSetSegmentNameMissingSegment(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, SetSegmentName(context.Background(), "Name"))
+}
+
+func TestSetHTTPResponseStatus(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	subCtx, subseg := BeginSubsegment(ctx, "Subsegment")
+	assert.NoError(t, SetHTTPResponseStatus(subCtx, http.StatusTooManyRequests))
+	subseg.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusTooManyRequests, seg.HTTP.Response.Status)
+	assert.True(t, seg.Error)
+	assert.True(t, seg.Throttle)
+	assert.False(t, seg.Fault)
+	assert.Error(t, SetHTTPResponseStatus(ctx, http.StatusOK))
+}
+
+func TestSetHTTPResponseStatusMissingSegment(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, SetHTTPResponseStatus(context.Background(), http.StatusOK))
+}
+
+func TestContextWithTraceHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	h := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	ctx = ContextWithTraceHeader(ctx, h)
+	_, subseg := BeginSubsegment(ctx, "Consume")
+	subseg.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Consume", seg.Name)
+	assert.Equal(t, h.TraceID, seg.TraceID)
+	assert.Equal(t, h.ParentID, seg.ParentID)
+	assert.Equal(t, "subsegment", seg.Type)
+}
+
+func TestContextWithTraceHeaderNotSampled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	h := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0")
+	ctx = ContextWithTraceHeader(ctx, h)
+	_, subseg := BeginSubsegment(ctx, "Consume")
+	subseg.Close(nil)
+
+	_, err := td.Recv()
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestContextWithTraceHeaderSamplingStrategy(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).SamplingStrategy = &TestSamplingStrategy{}
+
+	h := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8")
+	ctx = ContextWithTraceHeader(ctx, h)
+	assert.True(t, GetSegment(ctx).Sampled)
+}
+
+func TestContextWithTraceHeaderMissingIDs(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, ctx, ContextWithTraceHeader(ctx, header.FromString("Sampled=1")))
+}
+
+func TestContextWithTraceHeaderPropagatesLineage(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	h := header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;Sampled=1;Lineage=a87bd80c:1")
+	ctx = ContextWithTraceHeader(ctx, h)
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+
+	assert.Equal(t, "a87bd80c:1", subseg.DownstreamHeader().Lineage)
+	subseg.Close(nil)
+}
+
 // Benchmarks
 func BenchmarkGetRecorder(b *testing.B) {
 	ctx, td := NewTestDaemon()
@@ -215,3 +485,193 @@ func BenchmarkAddMetadata(b *testing.B) {
 	}
 	seg.Close(nil)
 }
+
+func TestSetHTTPRequestInfo(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, SetHTTPRequestInfo(r.Context(), http.MethodPut, "https://example.com/users/1", "OriginalClient", "203.0.113.7"))
+		w.WriteHeader(http.StatusOK)
+	})
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/internal", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set("User-Agent", "Proxy")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.MethodPut, seg.HTTP.Request.Method)
+	assert.Equal(t, "https://example.com/users/1", seg.HTTP.Request.URL)
+	assert.Equal(t, "OriginalClient", seg.HTTP.Request.UserAgent)
+	assert.Equal(t, "203.0.113.7", seg.HTTP.Request.ClientIP)
+	assert.True(t, seg.HTTP.Request.XForwardedFor)
+}
+
+func TestSetHTTPRequestInfoKeepsEmptyValues(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	root.GetHTTP().GetRequest().Method = http.MethodGet
+	root.GetHTTP().GetRequest().ClientIP = "10.0.0.1"
+	assert.NoError(t, SetHTTPRequestInfo(ctx, "", "https://example.com/", "", ""))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.MethodGet, seg.HTTP.Request.Method)
+	assert.Equal(t, "https://example.com/", seg.HTTP.Request.URL)
+	assert.Equal(t, "10.0.0.1", seg.HTTP.Request.ClientIP)
+	assert.False(t, seg.HTTP.Request.XForwardedFor)
+	assert.Error(t, SetHTTPRequestInfo(ctx, http.MethodPost, "", "", ""))
+}
+
+func TestSetHTTPRequestInfoMissingSegment(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, SetHTTPRequestInfo(context.Background(), http.MethodGet, "", "", ""))
+}
+
+// attributesStrategy samples every request and records their attributes.
+type attributesStrategy struct {
+	attributes []map[string]string
+}
+
+func (s *attributesStrategy) ShouldTrace(rq *sampling.Request) *sampling.Decision {
+	s.attributes = append(s.attributes, rq.Attributes)
+	return &sampling.Decision{Sample: true}
+}
+
+func TestSetSamplingAttribute(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ss := &attributesStrategy{}
+	GetRecorder(ctx).SamplingStrategy = ss
+
+	actx := SetSamplingAttribute(ctx, "tenant_tier", "premium")
+	actx2 := SetSamplingAttribute(actx, "region", "eu")
+	assert.Nil(t, samplingAttributes(ctx))
+	assert.Equal(t, map[string]string{"tenant_tier": "premium"}, samplingAttributes(actx))
+
+	_, seg := BeginSegment(actx2, "test")
+	seg.Close(nil)
+
+	handler := HandlerWithContext(ctx, NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	middleware := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(SetSamplingAttribute(r.Context(), "tenant_tier", "basic")))
+	})
+	middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, []map[string]string{
+		{"tenant_tier": "premium", "region": "eu"},
+		{"tenant_tier": "basic"},
+	}, ss.attributes)
+}
+
+func TestSetRouteTemplate(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, sub := BeginSubsegment(r.Context(), "lookup")
+		assert.NoError(t, SetRouteTemplate(r.Context(), "/users/{id}"))
+		sub.Close(nil)
+		w.WriteHeader(http.StatusOK)
+	})
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users/42")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "/users/{id}", seg.Annotations[RouteTemplateAnnotationKey])
+	assert.Equal(t, ts.URL+"/users/42", seg.HTTP.Request.URL)
+}
+
+func TestSetRouteTemplateMissingSegment(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, SetRouteTemplate(context.Background(), "/users/{id}"))
+}
+
+func TestWithDefaultAnnotations(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, before := BeginSubsegment(ctx, "before")
+	before.Close(nil)
+
+	ctx = WithDefaultAnnotations(ctx, map[string]interface{}{"tenant": "acme", "region": "eu"})
+	ctx = WithDefaultAnnotations(ctx, map[string]interface{}{"region": "us", "shard": 3, "tags": []string{"a"}})
+	subCtx, sub := BeginSubsegment(ctx, "sub")
+	assert.NoError(t, sub.AddAnnotation("tenant", "other"))
+	_, nested := BeginSubsegment(subCtx, "nested")
+	nested.Close(nil)
+	sub.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, seg.Annotations)
+	var subsegs []*Segment
+	for _, b := range seg.Subsegments {
+		var s *Segment
+		if !assert.NoError(t, json.Unmarshal(b, &s)) {
+			return
+		}
+		subsegs = append(subsegs, s)
+	}
+	if !assert.Len(t, subsegs, 2) {
+		return
+	}
+	assert.Empty(t, subsegs[0].Annotations)
+	assert.Equal(t, map[string]interface{}{"tenant": "other", "region": "us", "shard": float64(3)}, subsegs[1].Annotations)
+
+	var nestedSeg *Segment
+	if !assert.NoError(t, json.Unmarshal(subsegs[1].Subsegments[0], &nestedSeg)) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"tenant": "acme", "region": "us", "shard": float64(3)}, nestedSeg.Annotations)
+}
+
+func TestIsSampled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	sampledCtx, sampled := BeginSegment(ctx, "sampled")
+	assert.True(t, IsSampled(sampledCtx))
+	subCtx, sub := BeginSubsegment(sampledCtx, "sub")
+	assert.True(t, IsSampled(subCtx))
+	unsampledSubCtx, unsampledSub := BeginSubsegmentWithoutSampling(sampledCtx, "unsampled")
+	assert.False(t, IsSampled(unsampledSubCtx))
+	unsampledSub.Close(nil)
+	sub.Close(nil)
+	sampled.Close(nil)
+
+	GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+	unsampledCtx, unsampled := BeginSegment(ctx, "unsampled")
+	assert.False(t, IsSampled(unsampledCtx))
+	unsampled.Close(nil)
+
+	assert.False(t, IsSampled(context.Background()))
+}
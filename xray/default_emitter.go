@@ -9,10 +9,14 @@
 package xray
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
@@ -20,18 +24,176 @@ import (
 // Header is added before sending segments to daemon.
 const Header = `{"format": "json", "version": 1}` + "\n"
 
+// maxPacketSize is the largest UDP packet the daemon accepts.
+const maxPacketSize = 64 * 1024
+
+// writeErrorWindow is the number of recent writes RecentWriteErrorRate
+// covers.
+const writeErrorWindow = 20
+
+// packetBuffers holds the buffers packets are built in, so that emitting
+// large segments does not allocate a new buffer every time.
+var packetBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// putPacketBuffer returns buf to packetBuffers unless it grew well past the
+// maximum packet size for an oversize segment.
+func putPacketBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > 4*maxPacketSize {
+		return
+	}
+	packetBuffers.Put(buf)
+}
+
 // DefaultEmitter provides the naive implementation of emitting trace entities.
 type DefaultEmitter struct {
+	// Counters are accessed atomically and kept first for 64-bit alignment.
+	emitted     uint64
+	dropped     uint64
+	writeErrors uint64
+
+	// breakerOpenUntil is the time, in Unix nanoseconds, until which the
+	// circuit breaker is open, or zero while it is closed. It is accessed
+	// atomically.
+	breakerOpenUntil int64
+
 	sync.Mutex
 	conn *net.UDPConn
 	addr *net.UDPAddr
+
+	// timeFormatter, if set, replaces the epoch seconds start and end times
+	// of emitted segments.
+	timeFormatter TimeFormatter
+
+	// daemonHost, if set, is resolved again every resolveInterval, and the
+	// emitter switches to the new address when it changes.
+	daemonHost      string
+	resolveInterval time.Duration
+	nextResolve     time.Time
+	resolveUDPAddr  func(network, address string) (*net.UDPAddr, error)
+
+	// sendBufferBytes, if positive, is the send buffer size requested for
+	// the UDP socket.
+	sendBufferBytes int
+
+	// breakerFailures, if positive, is the number of failed writes among
+	// the last 2*breakerFailures opening the circuit breaker for
+	// breakerCooldown.
+	breakerFailures int
+	breakerCooldown time.Duration
+
+	// recentWrites records whether each of the last writes failed, at
+	// least the last writeErrorWindow, with nextWrite the index of the
+	// oldest one.
+	recentWrites []bool
+	nextWrite    int
+}
+
+// BreakerState is the state of the circuit breaker of a DefaultEmitter.
+type BreakerState int
+
+const (
+	// BreakerClosed is the state of a breaker while segments are sent.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen is the state of a breaker during the cooldown following
+	// failed writes: segments are not sampled.
+	BreakerOpen
+
+	// BreakerHalfOpen is the state of a breaker once the cooldown is over:
+	// segments are sampled again, and the breaker closes with the next
+	// successful write or opens again with the next failure.
+	BreakerHalfOpen
+)
+
+// defaultResolveInterval is how often WithDaemonHost resolves the daemon
+// host if no interval is given.
+const defaultResolveInterval = time.Minute
+
+// EmitterOption configures a DefaultEmitter created by NewDefaultEmitter.
+type EmitterOption interface {
+	apply(de *DefaultEmitter)
+}
+
+type funcEmitterOption struct {
+	f func(de *DefaultEmitter)
+}
+
+func (f funcEmitterOption) apply(de *DefaultEmitter) {
+	f.f(de)
+}
+
+// WithTimeFormatter makes the emitter serialize start and end times with tf,
+// for forwarders which do not accept the epoch seconds of the X-Ray daemon.
+func WithTimeFormatter(tf TimeFormatter) EmitterOption {
+	return funcEmitterOption{f: func(de *DefaultEmitter) {
+		de.timeFormatter = tf
+	}}
+}
+
+// WithDaemonHost makes the emitter resolve hostPort, the daemon address as a
+// host name and port, at most every interval and send segments to the new
+// address once the host resolves to a different one, such as after the
+// failover of a service VIP. An interval of zero or less defaults to one
+// minute. If resolving fails the emitter keeps sending to the last address.
+//
+// Only the emitter follows hostPort: the sampling strategy keeps fetching
+// sampling rules from the daemon address it was configured with, from
+// Config.DaemonAddr or AWS_XRAY_DAEMON_ADDRESS, and does not follow a
+// failover of the host.
+func WithDaemonHost(hostPort string, interval time.Duration) EmitterOption {
+	return funcEmitterOption{f: func(de *DefaultEmitter) {
+		if interval <= 0 {
+			interval = defaultResolveInterval
+		}
+		de.daemonHost = hostPort
+		de.resolveInterval = interval
+	}}
+}
+
+// WithSendBufferBytes sets the send buffer of the UDP socket of the emitter
+// (SO_SNDBUF) to size bytes, so that bursts of segments are buffered rather
+// than dropped by the operating system. The operating system may clamp the
+// size, for instance to net.core.wmem_max on Linux, which also doubles it
+// for its own bookkeeping; the effective size is logged where it can be
+// read back.
+func WithSendBufferBytes(size int) EmitterOption {
+	return funcEmitterOption{f: func(de *DefaultEmitter) {
+		de.sendBufferBytes = size
+	}}
+}
+
+// WithCircuitBreaker stops tracing once failures of the last 2*failures
+// writes to the daemon have failed, such as when no daemon runs during local
+// development, to save the work of recording segments which cannot be sent.
+// The failures need not be consecutive: without a daemon, writes to the UDP
+// socket alternate between succeeding and failing, as a write reports that
+// the host refused an earlier packet. Segments
+// begun with the emitter during the cooldown that follows are not sampled,
+// and thus neither recorded nor propagated as sampled downstream. Once the
+// cooldown is over, segments are sampled again to test the daemon: the
+// next successful write resumes tracing and the next failure starts another
+// cooldown. A non-positive number of failures disables the breaker.
+func WithCircuitBreaker(failures int, cooldown time.Duration) EmitterOption {
+	return funcEmitterOption{f: func(de *DefaultEmitter) {
+		de.breakerFailures = failures
+		de.breakerCooldown = cooldown
+	}}
 }
 
 // NewDefaultEmitter initializes and returns a
 // pointer to an instance of DefaultEmitter.
-func NewDefaultEmitter(raddr *net.UDPAddr) (*DefaultEmitter, error) {
+func NewDefaultEmitter(raddr *net.UDPAddr, opts ...EmitterOption) (*DefaultEmitter, error) {
 	initLambda()
-	d := &DefaultEmitter{addr: raddr}
+	d := &DefaultEmitter{addr: raddr, resolveUDPAddr: net.ResolveUDPAddr}
+	for _, opt := range opts {
+		opt.apply(d)
+	}
+	d.recentWrites = make([]bool, writeErrorWindow)
+	if 2*d.breakerFailures > writeErrorWindow {
+		d.recentWrites = make([]bool, 2*d.breakerFailures)
+	}
 	return d, nil
 }
 
@@ -47,11 +209,129 @@ func (de *DefaultEmitter) refresh(raddr *net.UDPAddr) (err error) {
 	de.addr = raddr
 
 	if err != nil {
-		logger.Errorf("Error dialing emitter address %v: %s", raddr, err)
+		logger.RateLimitedErrorf("Error dialing emitter address %v: %s", raddr, err)
 		return err
 	}
 
 	logger.Infof("Emitter using address: %v", raddr)
+	if de.sendBufferBytes > 0 {
+		de.setSendBuffer()
+	}
+	return nil
+}
+
+// setSendBuffer requests sendBufferBytes as the send buffer of conn and logs
+// the size the operating system applied.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) setSendBuffer() {
+	if err := de.conn.SetWriteBuffer(de.sendBufferBytes); err != nil {
+		logger.Errorf("Error setting emitter send buffer to %d bytes: %s", de.sendBufferBytes, err)
+		return
+	}
+
+	size, err := sendBufferSize(de.conn)
+	switch {
+	case err != nil:
+		logger.Debugf("Emitter send buffer requested to %d bytes, unable to read the effective size: %s", de.sendBufferBytes, err)
+	case size < de.sendBufferBytes:
+		logger.Warnf("Emitter send buffer requested to %d bytes was clamped to %d bytes by the operating system", de.sendBufferBytes, size)
+	default:
+		logger.Infof("Emitter send buffer set to %d bytes", size)
+	}
+}
+
+// resolveDaemonHost resolves daemonHost if resolveInterval has passed since
+// it was last resolved and redials if the address has changed.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) resolveDaemonHost() {
+	now := clock.Now()
+	if now.Before(de.nextResolve) {
+		return
+	}
+	de.nextResolve = now.Add(de.resolveInterval)
+
+	raddr, err := de.resolveUDPAddr("udp", de.daemonHost)
+	if err != nil {
+		logger.RateLimitedErrorf("Error resolving daemon address %s: %s", de.daemonHost, err)
+		return
+	}
+	if de.addr != nil && de.addr.String() == raddr.String() {
+		return
+	}
+
+	logger.Infof("Daemon address %s resolved to %v", de.daemonHost, raddr)
+	if de.conn != nil {
+		de.conn.Close()
+		de.conn = nil
+	}
+	de.addr = raddr
+}
+
+// BreakerState returns the state of the circuit breaker set up with
+// WithCircuitBreaker, always BreakerClosed if there is none.
+func (de *DefaultEmitter) BreakerState() BreakerState {
+	until := atomic.LoadInt64(&de.breakerOpenUntil)
+	switch {
+	case until == 0:
+		return BreakerClosed
+	case clock.Now().UnixNano() < until:
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+func (de *DefaultEmitter) suspendsTracing() bool {
+	return de.BreakerState() == BreakerOpen
+}
+
+// recordWrite records the outcome of a write and updates the circuit
+// breaker with it.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) recordWrite(failed bool) {
+	de.recentWrites[de.nextWrite] = failed
+	de.nextWrite = (de.nextWrite + 1) % len(de.recentWrites)
+
+	if de.breakerFailures <= 0 {
+		return
+	}
+	wasOpen := atomic.LoadInt64(&de.breakerOpenUntil) != 0
+	if !failed {
+		if wasOpen {
+			atomic.StoreInt64(&de.breakerOpenUntil, 0)
+			logger.Infof("Emitter circuit breaker closed, tracing resumed")
+		}
+		return
+	}
+
+	if !wasOpen && de.recentFailures(2*de.breakerFailures) < de.breakerFailures {
+		return
+	}
+	atomic.StoreInt64(&de.breakerOpenUntil, clock.Now().Add(de.breakerCooldown).UnixNano())
+	logger.Warnf("Emitter circuit breaker opened, tracing suspended for %v", de.breakerCooldown)
+}
+
+// recentFailures returns the number of failed writes among the last n.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) recentFailures(n int) int {
+	failures := 0
+	for i := 1; i <= n; i++ {
+		if de.recentWrites[(de.nextWrite-i+len(de.recentWrites))%len(de.recentWrites)] {
+			failures++
+		}
+	}
+	return failures
+}
+
+// Drain waits for packets which are being written to the daemon. Segments
+// are written to the UDP socket as they are emitted, so there is nothing
+// else left to send.
+func (de *DefaultEmitter) Drain(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	de.Lock()
+	de.Unlock()
 	return nil
 }
 
@@ -63,65 +343,249 @@ func (de *DefaultEmitter) Emit(seg *Segment) {
 			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
 		}
 	}()
-	HeaderBytes := []byte(Header)
+	start := time.Now()
 
 	if seg == nil || !seg.ParentSegment.Sampled {
 		return
 	}
+	onEmit := seg.ParentSegment.GetConfiguration().OnEmit
+
+	buf := packetBuffers.Get().(*bytes.Buffer)
+	defer putPacketBuffer(buf)
+
+	// The document of an orphan segment, usually the largest, is encoded
+	// into the packet buffer, after the streamed subsegments, without
+	// being copied into a slice of its own.
+	packets := packSubsegments(seg, nil, de.timeFormatter)
+	total := len(packets)
+	if seg.isOrphan() {
+		total++
+	}
+	for i := 0; i < total; i++ {
+		buf.Reset()
+		buf.WriteString(Header)
+		if i < len(packets) {
+			buf.Write(packets[i])
+		} else if err := encodeSegment(buf, seg, de.timeFormatter); err != nil {
+			logger.Errorf("JSON error while marshalling (Sub)Segment: %v", err)
+			atomic.AddUint64(&de.dropped, 1)
+			continue
+		}
+		logger.DebugDeferred(func() string { return string(buf.Bytes()[len(Header):]) })
 
-	for _, p := range packSegments(seg, nil) {
-		logger.Debug(string(p))
+		packet := buf.Bytes()
+		if len(packet) > maxPacketSize && seg.ParentSegment.GetConfiguration().TruncateOversizeSegments {
+			if stub, err := truncatedSegment(packet[len(Header):]); err != nil {
+				logger.Errorf("Error truncating segment: %v", err)
+			} else {
+				logger.RateLimitedErrorf("Truncating segment of %d bytes which exceeds the maximum packet size of %d bytes", len(packet), maxPacketSize)
+				buf.Truncate(len(Header))
+				buf.Write(stub)
+				packet = buf.Bytes()
+			}
+		}
+		if len(packet) > maxPacketSize {
+			logger.RateLimitedErrorf("Dropping segment of %d bytes which exceeds the maximum packet size of %d bytes", len(packet), maxPacketSize)
+			atomic.AddUint64(&de.dropped, 1)
+			continue
+		}
 
 		de.Lock()
 
+		if de.daemonHost != "" {
+			de.resolveDaemonHost()
+		}
+
 		if de.conn == nil {
 			if err := de.refresh(de.addr); err != nil {
+				de.recordWrite(true)
 				de.Unlock()
+				atomic.AddUint64(&de.dropped, uint64(total-i))
 				return
 			}
 		}
 
-		_, err := de.conn.Write(append(HeaderBytes, p...))
+		_, err := de.conn.Write(packet)
 		if err != nil {
-			logger.Error(err)
+			logger.RateLimitedErrorf("Error writing segment to daemon: %v", err)
+			atomic.AddUint64(&de.writeErrors, 1)
+		} else {
+			atomic.AddUint64(&de.emitted, 1)
 		}
+		de.recordWrite(err != nil)
 		de.Unlock()
+
+		if err == nil && onEmit != nil {
+			onEmit(time.Since(start), len(packet))
+		}
 	}
 }
 
+// EmittedCount returns the number of packets written to the daemon. Like the
+// other counters it only starts over for a new emitter.
+func (de *DefaultEmitter) EmittedCount() uint64 {
+	return atomic.LoadUint64(&de.emitted)
+}
+
+// DroppedCount returns the number of packets which were not sent, either
+// because they exceed the maximum packet size or because no connection to
+// the daemon could be made.
+func (de *DefaultEmitter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&de.dropped)
+}
+
+// WriteErrorCount returns the number of packets for which writing to the
+// daemon failed.
+func (de *DefaultEmitter) WriteErrorCount() uint64 {
+	return atomic.LoadUint64(&de.writeErrors)
+}
+
+// RecentWriteErrorRate returns the fraction of the last 20 writes to the
+// daemon which failed, including those for which no connection to the
+// daemon could be made.
+func (de *DefaultEmitter) RecentWriteErrorRate() float64 {
+	de.Lock()
+	defer de.Unlock()
+	return float64(de.recentFailures(writeErrorWindow)) / writeErrorWindow
+}
+
+// truncatedFields are the fields of a segment document kept in the stub
+// sent for segments too large for a packet.
+var truncatedFields = []string{
+	"trace_id", "id", "parent_id", "name", "type", "namespace", "origin",
+	"start_time", "end_time", "in_progress", "error", "fault", "throttle",
+}
+
+// truncatedSegment returns a stub of the segment document doc holding only
+// the fields in truncatedFields, marked with the annotation "truncated".
+func truncatedSegment(doc []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+
+	stub := make(map[string]interface{}, len(truncatedFields)+1)
+	for _, name := range truncatedFields {
+		if v, ok := fields[name]; ok {
+			stub[name] = v
+		}
+	}
+	stub["annotations"] = map[string]interface{}{"truncated": true}
+	return json.Marshal(stub)
+}
+
 // seg has a write lock acquired by the caller.
 func packSegments(seg *Segment, outSegments [][]byte) [][]byte {
-	trimSubsegment := func(s *Segment) []byte {
-		ss := globalCfg.StreamingStrategy()
-		if seg.ParentSegment.Configuration != nil && seg.ParentSegment.Configuration.StreamingStrategy != nil {
-			ss = seg.ParentSegment.Configuration.StreamingStrategy
-		}
-		for ss.RequiresStreaming(s) {
-			if len(s.rawSubsegments) == 0 {
-				break
-			}
-			cb := ss.StreamCompletedSubsegments(s)
-			outSegments = append(outSegments, cb...)
-		}
-		b, err := json.Marshal(s)
-		if err != nil {
-			logger.Errorf("JSON error while marshalling (Sub)Segment: %v", err)
+	return packFormattedSegments(seg, outSegments, nil)
+}
+
+// packFormattedSegments does what packSegments does, with the start and end
+// times formatted by tf if it is not nil.
+// seg has a write lock acquired by the caller.
+func packFormattedSegments(seg *Segment, outSegments [][]byte, tf TimeFormatter) [][]byte {
+	outSegments = packSubsegments(seg, outSegments, tf)
+	if seg.isOrphan() {
+		if b := marshalSegment(seg, tf); b != nil {
+			outSegments = append(outSegments, b)
 		}
-		return b
 	}
+	return outSegments
+}
 
+// packSubsegments does what packFormattedSegments does but leaves out the
+// document of seg itself, for emitters encoding it themselves.
+// seg has a write lock acquired by the caller.
+func packSubsegments(seg *Segment, outSegments [][]byte, tf TimeFormatter) [][]byte {
 	for _, s := range seg.rawSubsegments {
 		s.Lock()
-		outSegments = packSegments(s, outSegments)
-		if b := trimSubsegment(s); b != nil {
+		outSegments = packFormattedSegments(s, outSegments, tf)
+		outSegments = streamSubsegments(seg, s, outSegments, tf)
+		if b := marshalSegment(s, tf); b != nil {
 			seg.Subsegments = append(seg.Subsegments, b)
 		}
 		s.Unlock()
 	}
 	if seg.isOrphan() {
-		if b := trimSubsegment(seg); b != nil {
-			outSegments = append(outSegments, b)
+		outSegments = streamSubsegments(seg, seg, outSegments, tf)
+	}
+	return outSegments
+}
+
+// streamSubsegments streams the completed subsegments of s, part of the
+// segment seg, for as long as the streaming strategy requires it. The
+// subsegments streamed by a custom strategy are already serialized, so
+// their times are formatted by tf with FormatSegmentTimes.
+// s has a write lock acquired by the caller.
+func streamSubsegments(seg, s *Segment, outSegments [][]byte, tf TimeFormatter) [][]byte {
+	ss := globalCfg.StreamingStrategy()
+	if seg.ParentSegment.Configuration != nil && seg.ParentSegment.Configuration.StreamingStrategy != nil {
+		ss = seg.ParentSegment.Configuration.StreamingStrategy
+	}
+	for ss.RequiresStreaming(s) {
+		if len(s.rawSubsegments) == 0 {
+			break
+		}
+		var cb [][]byte
+		if dss, ok := ss.(*DefaultStreamingStrategy); ok {
+			cb = dss.streamCompletedSubsegments(s, tf)
+		} else {
+			cb = ss.StreamCompletedSubsegments(s)
+			if tf != nil {
+				cb = formatStreamedSubsegments(cb, tf)
+			}
+		}
+		if len(cb) == 0 {
+			// Only subsegments in progress are left to stream.
+			break
 		}
+		outSegments = append(outSegments, cb...)
 	}
 	return outSegments
 }
+
+// formatStreamedSubsegments formats the times of the serialized subsegments
+// cb with tf, dropping those which cannot be formatted.
+func formatStreamedSubsegments(cb [][]byte, tf TimeFormatter) [][]byte {
+	formatted := cb[:0]
+	for _, b := range cb {
+		f, err := FormatSegmentTimes(b, tf)
+		if err != nil {
+			logger.Errorf("Error formatting segment times: %v", err)
+			continue
+		}
+		formatted = append(formatted, f)
+	}
+	return formatted
+}
+
+func marshalSegment(s *Segment, tf TimeFormatter) []byte {
+	doc, err := segmentDocument(s, tf)
+	if err != nil {
+		logger.Errorf("Error formatting segment times: %v", err)
+		return nil
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		logger.Errorf("JSON error while marshalling (Sub)Segment: %v", err)
+	}
+	return b
+}
+
+// encodeSegment writes the document of s to buf, as json.Marshal would
+// return it. The Encoder still builds the whole document in an internal
+// buffer before writing it, but encoding/json pools that buffer, whereas
+// json.Marshal copies the document into a new slice for every segment.
+func encodeSegment(buf *bytes.Buffer, s *Segment, tf TimeFormatter) error {
+	doc, err := segmentDocument(s, tf)
+	if err != nil {
+		return err
+	}
+	n := buf.Len()
+	if err := json.NewEncoder(buf).Encode(doc); err != nil {
+		buf.Truncate(n)
+		return err
+	}
+	// Drop the newline ending every value written by an Encoder.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
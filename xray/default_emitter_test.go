@@ -9,10 +9,13 @@
 package xray
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -194,3 +197,508 @@ func TestDefaultEmitterWithPanic(t *testing.T) {
 	}
 	emitter.Emit(seg)
 }
+
+func TestDefaultEmitterDrain(t *testing.T) {
+	emitter, err := NewDefaultEmitter(&net.UDPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 3000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, emitter.Drain(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, emitter.Drain(ctx))
+}
+
+func TestDefaultEmitterCounters(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &Segment{Name: "Segment", Sampled: true}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+
+	seg.Metadata = map[string]map[string]interface{}{
+		"default": {"large": strings.Repeat("x", maxPacketSize)},
+	}
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+	assert.Equal(t, uint64(1), emitter.DroppedCount())
+
+	seg.Metadata = nil
+	emitter.conn.Close()
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+	assert.Equal(t, uint64(1), emitter.DroppedCount())
+	assert.Equal(t, uint64(1), emitter.WriteErrorCount())
+}
+
+func TestDefaultEmitterOnEmit(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	seg := &Segment{
+		Name:    "Segment",
+		Sampled: true,
+		Configuration: &Config{OnEmit: func(d time.Duration, size int) {
+			assert.True(t, d >= 0)
+			sizes = append(sizes, size)
+		}},
+	}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{n}, sizes)
+	}
+
+	emitter.conn.Close()
+	emitter.Emit(seg)
+	assert.Len(t, sizes, 1)
+}
+
+func TestDefaultEmitterCircuitBreaker(t *testing.T) {
+	mc := useMockClock(t, 1500000000)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr), WithCircuitBreaker(3, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := ContextWithConfig(context.Background(), Config{Emitter: emitter, SamplingStrategy: fixedSamplingStrategy(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emit := func() bool {
+		_, seg := BeginSegment(ctx, "test")
+		sampled := seg.Sampled
+		seg.Close(nil)
+		return sampled
+	}
+	// emitUntil emits until the breaker is in state, for at most 20 segments.
+	emitUntil := func(state BreakerState) {
+		for i := 0; i < 20 && emitter.BreakerState() != state; i++ {
+			emit()
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	assert.True(t, emit())
+	assert.Equal(t, BreakerClosed, emitter.BreakerState())
+
+	// Nothing listens on the port any more, so the host refuses the packets
+	// and some of the writes fail.
+	conn.Close()
+	emitUntil(BreakerOpen)
+	assert.Equal(t, BreakerOpen, emitter.BreakerState())
+	assert.False(t, emit())
+	assert.GreaterOrEqual(t, emitter.WriteErrorCount(), uint64(3))
+
+	// Once the cooldown is over the failures still counted open the breaker
+	// again.
+	mc.Increment(60, 0)
+	assert.Equal(t, BreakerHalfOpen, emitter.BreakerState())
+	emitUntil(BreakerOpen)
+	assert.Equal(t, BreakerOpen, emitter.BreakerState())
+
+	conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mc.Increment(60, 0)
+	emitter.RefreshEmitterWithAddress(conn.LocalAddr().(*net.UDPAddr))
+	for i := 0; i < 6; i++ {
+		assert.True(t, emit())
+		assert.Equal(t, BreakerClosed, emitter.BreakerState())
+	}
+}
+
+func TestWrappedDefaultEmitterCircuitBreaker(t *testing.T) {
+	useMockClock(t, 1500000000)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr), WithCircuitBreaker(3, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled, err := NewPooledEmitter(emitter, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pooled.Close()
+	shedding, err := NewLoadSheddingEmitter(emitter, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(t, emitterSuspendsTracing(pooled))
+	assert.False(t, emitterSuspendsTracing(shedding))
+	emitTrace(emitter, NewTraceID())
+	conn.Close()
+	for i := 0; i < 20 && emitter.BreakerState() != BreakerOpen; i++ {
+		emitTrace(emitter, NewTraceID())
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, emitterSuspendsTracing(pooled))
+	assert.True(t, emitterSuspendsTracing(shedding))
+}
+
+func TestDefaultEmitterWithoutCircuitBreaker(t *testing.T) {
+	emitter, err := NewDefaultEmitter(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.RefreshEmitterWithAddress(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2000})
+	emitter.conn.Close()
+
+	seg := &Segment{Name: "Segment", Sampled: true}
+	seg.ParentSegment = seg
+	for i := 0; i < 10; i++ {
+		emitter.Emit(seg)
+	}
+	assert.Equal(t, BreakerClosed, emitter.BreakerState())
+}
+
+func TestDefaultEmitterTruncatesOversizeSegments(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &Segment{
+		Name:      "Segment",
+		ID:        "53995c3f42cd8ad8",
+		TraceID:   "1-581cf771-a006649127e371903a2de979",
+		StartTime: 1500000000,
+		EndTime:   1500000001,
+		Fault:     true,
+		Sampled:   true,
+		Annotations: map[string]interface{}{
+			"user": "alice",
+		},
+		Metadata: map[string]map[string]interface{}{
+			"default": {"large": strings.Repeat("x", maxPacketSize)},
+		},
+		Configuration: &Config{TruncateOversizeSegments: true},
+	}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+	assert.Equal(t, uint64(0), emitter.DroppedCount())
+
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var stub *Segment
+	if !assert.NoError(t, json.Unmarshal(buffer[len(Header):n], &stub)) {
+		return
+	}
+	assert.Equal(t, seg.Name, stub.Name)
+	assert.Equal(t, seg.ID, stub.ID)
+	assert.Equal(t, seg.TraceID, stub.TraceID)
+	assert.Equal(t, seg.StartTime, stub.StartTime)
+	assert.Equal(t, seg.EndTime, stub.EndTime)
+	assert.True(t, stub.Fault)
+	assert.Equal(t, map[string]interface{}{"truncated": true}, stub.Annotations)
+	assert.Nil(t, stub.Metadata)
+}
+
+func TestDefaultEmitterIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &Segment{Name: "Segment", Sampled: true}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, string(buffer[:n]), `"name":"Segment"`)
+}
+
+func TestDefaultEmitterWithTimeFormatter(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr), WithTimeFormatter(ISO8601TimeFormatter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &Segment{Name: "Segment", Sampled: true, StartTime: 1500000000, EndTime: 1500000001}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, string(buffer[:n]), `"start_time":"2017-07-14T02:40:00Z"`)
+	assert.Contains(t, string(buffer[:n]), `"end_time":"2017-07-14T02:40:01Z"`)
+}
+
+func TestDefaultEmitterWithTimeFormatterSubsegments(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr), WithTimeFormatter(ISO8601TimeFormatter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+
+	seg := &Segment{Name: "Segment", Sampled: true, StartTime: 1500000000, EndTime: 1500000001, Configuration: &Config{StreamingStrategy: ss}}
+	seg.ParentSegment = seg
+	for _, name := range []string{"embedded", "streamed"} {
+		seg.AddSubsegment(&Segment{Name: name, ID: "53995c3f42cd8ad8", ParentSegment: seg, StartTime: 1500000000, EndTime: 1500000001})
+	}
+	seg.totalSubSegments = 2
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(2), emitter.EmittedCount())
+
+	// The streamed subsegment is sent on its own, and the other one embedded
+	// in the segment.
+	for _, want := range []int{1, 2} {
+		buffer := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if !assert.NoError(t, err) {
+			return
+		}
+		doc := string(buffer[:n])
+		assert.Equal(t, want, strings.Count(doc, `"start_time":"2017-07-14T02:40:00Z"`), doc)
+		assert.Equal(t, want, strings.Count(doc, `"end_time":"2017-07-14T02:40:01Z"`), doc)
+	}
+}
+
+func TestDefaultEmitterWithDaemonHost(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	first := listen()
+	defer first.Close()
+	second := listen()
+	defer second.Close()
+
+	received := func(conn *net.UDPConn) bool {
+		buffer := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _, err := conn.ReadFrom(buffer)
+		return err == nil
+	}
+
+	mc := useMockClock(t, 1500000000)
+	emitter, err := NewDefaultEmitter(nil, WithDaemonHost("xray-daemon.internal:2000", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mock resolver stands in for a DNS name whose address changes.
+	var resolved *net.UDPAddr
+	var resolveErr error
+	var resolves int
+	emitter.resolveUDPAddr = func(network, address string) (*net.UDPAddr, error) {
+		assert.Equal(t, "udp", network)
+		assert.Equal(t, "xray-daemon.internal:2000", address)
+		resolves++
+		return resolved, resolveErr
+	}
+
+	seg := &Segment{Name: "Segment", Sampled: true}
+	seg.ParentSegment = seg
+
+	resolved = first.LocalAddr().(*net.UDPAddr)
+	emitter.Emit(seg)
+	assert.True(t, received(first))
+
+	// The address is cached until the interval has passed.
+	resolved = second.LocalAddr().(*net.UDPAddr)
+	emitter.Emit(seg)
+	assert.True(t, received(first))
+	assert.Equal(t, 1, resolves)
+
+	mc.Increment(61, 0)
+	emitter.Emit(seg)
+	assert.True(t, received(second))
+	assert.Equal(t, 2, resolves)
+
+	// A failed lookup keeps the last address.
+	resolveErr = errors.New("no such host")
+	mc.Increment(61, 0)
+	emitter.Emit(seg)
+	assert.True(t, received(second))
+	assert.Equal(t, 3, resolves)
+	assert.Equal(t, uint64(4), emitter.EmittedCount())
+}
+
+func TestDefaultEmitterWithSendBufferBytes(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr), WithSendBufferBytes(64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.RefreshEmitterWithAddress(conn.LocalAddr().(*net.UDPAddr))
+
+	size, err := sendBufferSize(emitter.conn)
+	if err != nil {
+		t.Skipf("send buffer size not readable: %v", err)
+	}
+	assert.True(t, size >= 64*1024, "send buffer of %d bytes", size)
+
+	seg := &Segment{Name: "Segment", Sampled: true}
+	seg.ParentSegment = seg
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+}
+
+func TestDefaultEmitterEncodesAsMarshal(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &Segment{
+		Name:      "Segment",
+		Sampled:   true,
+		StartTime: 1500000000,
+		EndTime:   1500000001,
+		Metadata:  map[string]map[string]interface{}{"default": {"html": "<a href=\"x\">&</a>"}},
+	}
+	seg.ParentSegment = seg
+	sub := &Segment{Name: "Subsegment", StartTime: 1500000000, EndTime: 1500000001, parent: seg, ParentSegment: seg}
+	seg.rawSubsegments = append(seg.rawSubsegments, sub)
+	emitter.Emit(seg)
+	assert.Equal(t, uint64(1), emitter.EmittedCount())
+
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	want, err := json.Marshal(seg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Header+string(want), string(buffer[:n]))
+}
+
+// BenchmarkDefaultEmitterLargeMetadata emits a segment carrying a large
+// metadata map, to measure the memory serializing it takes.
+func BenchmarkDefaultEmitterLargeMetadata(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buffer := make([]byte, maxPacketSize)
+		for {
+			if _, _, err := conn.ReadFrom(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	large := make(map[string]interface{}, 1000)
+	for i := 0; i < 1000; i++ {
+		large[fmt.Sprintf("key-%04d", i)] = strings.Repeat("v", 40)
+	}
+	seg := &Segment{
+		TraceID:   NewTraceID(),
+		ID:        NewSegmentID(),
+		Name:      "Segment",
+		StartTime: 1461096053.37518,
+		EndTime:   1461096053.4042,
+		Sampled:   true,
+		Metadata:  map[string]map[string]interface{}{"default": {"large": large}},
+	}
+	seg.ParentSegment = seg
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(seg)
+	}
+}
@@ -9,7 +9,6 @@
 package xray
 
 import (
-	"encoding/json"
 	"errors"
 	"sync/atomic"
 
@@ -21,6 +20,13 @@ var defaultMaxSubsegmentCount uint32 = 20
 // DefaultStreamingStrategy provides a default value of 20
 // for the maximum number of subsegments that can be emitted
 // in a single UDP packet.
+//
+// Once a segment tree holds more than MaxSubsegmentCount subsegments, its
+// completed subsegments are sent to the daemon on their own as they close.
+// The daemon drops UDP packets larger than 64KB, so raising the count makes
+// large traces arrive in fewer pieces at the risk of exceeding that limit;
+// the daemon also limits segment documents to 64KB once it forwards them.
+// Segment.SetMaxSubsegmentCount overrides the count for a single tree.
 type DefaultStreamingStrategy struct {
 	MaxSubsegmentCount uint32
 }
@@ -43,21 +49,41 @@ func NewDefaultStreamingStrategyWithMaxSubsegmentCount(maxSubsegmentCount int) (
 }
 
 // RequiresStreaming returns true when the number of subsegment
-// children for a given segment is larger than MaxSubsegmentCount.
+// children for a given segment is larger than MaxSubsegmentCount, or the
+// count set by Segment.SetMaxSubsegmentCount.
 func (dSS *DefaultStreamingStrategy) RequiresStreaming(seg *Segment) bool {
 	if seg.ParentSegment.Sampled {
-		return atomic.LoadUint32(&seg.ParentSegment.totalSubSegments) > dSS.MaxSubsegmentCount
+		max := dSS.MaxSubsegmentCount
+		if count := atomic.LoadUint32(&seg.ParentSegment.maxSubsegmentCount); count > 0 {
+			max = count
+		}
+		return atomic.LoadUint32(&seg.ParentSegment.totalSubSegments) > max
 	}
 	return false
 }
 
-// StreamCompletedSubsegments separates subsegments from the provided
-// segment tree and sends them to daemon as streamed subsegment UDP packets.
+// StreamCompletedSubsegments separates a completed subsegment from the
+// provided segment tree and returns it as a streamed subsegment UDP packet.
+// The streamed subsegment records the trace ID and parent ID needed to
+// reattach it to the tree. Subsegments that are still in progress are kept
+// in the tree, as they would otherwise never be sent once they complete.
 func (dSS *DefaultStreamingStrategy) StreamCompletedSubsegments(seg *Segment) [][]byte {
+	return dSS.streamCompletedSubsegments(seg, nil)
+}
+
+// streamCompletedSubsegments does what StreamCompletedSubsegments does, with
+// the start and end times formatted by tf if it is not nil.
+func (dSS *DefaultStreamingStrategy) streamCompletedSubsegments(seg *Segment, tf TimeFormatter) [][]byte {
 	logger.Debug("Beginning to stream subsegments.")
 	var outSegments [][]byte
 	for i := 0; i < len(seg.rawSubsegments); i++ {
 		child := seg.rawSubsegments[i]
+		child.Lock()
+		if child.InProgress || child.openSegments != 0 {
+			child.Unlock()
+			continue
+		}
+
 		seg.rawSubsegments[i] = seg.rawSubsegments[len(seg.rawSubsegments)-1]
 		seg.rawSubsegments[len(seg.rawSubsegments)-1] = nil
 		seg.rawSubsegments = seg.rawSubsegments[:len(seg.rawSubsegments)-1]
@@ -69,13 +95,8 @@ func (dSS *DefaultStreamingStrategy) StreamCompletedSubsegments(seg *Segment) []
 		atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(0))
 
 		// Add extra information into child subsegment
-		child.Lock()
 		child.beforeEmitSubsegment(seg)
-		cb, err := json.Marshal(child)
-		if err != nil {
-			logger.Errorf("JSON error while marshalling subsegment: %v", err)
-		}
-		outSegments = append(outSegments, cb)
+		outSegments = append(outSegments, marshalSegment(child, tf))
 		logger.Debugf("Streaming subsegment named '%s' from segment tree.", child.Name)
 		child.Unlock()
 
@@ -9,6 +9,8 @@
 package xray
 
 import (
+	"encoding/json"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +27,169 @@ func TestDefaultStreamingStrategyMaxSegmentSizeParameterValidation(t *testing.T)
 	assert.Nil(t, dss)
 	assert.Error(t, e, "maxSubsegmentCount must be a non-negative integer")
 }
+
+func TestDefaultStreamingStrategyReconstructsTree(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).StreamingStrategy, _ = NewDefaultStreamingStrategyWithMaxSubsegmentCount(2)
+
+	ctx, root := BeginSegment(ctx, "root")
+	for _, child := range []struct {
+		name        string
+		grandchilds []string
+	}{
+		{"a", []string{"a1", "a2", "a3"}},
+		{"b", []string{"b1"}},
+		{"c", nil},
+	} {
+		childCtx, seg := BeginSubsegment(ctx, child.name)
+		for _, name := range child.grandchilds {
+			_, gc := BeginSubsegment(childCtx, name)
+			gc.Close(nil)
+		}
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	// Collect every document sent to the daemon, indexed by ID.
+	docs := map[string]*Segment{}
+	var emittedRoot *Segment
+	for {
+		seg, err := td.Recv()
+		if err != nil {
+			break
+		}
+		if seg.Type == "subsegment" {
+			assert.Equal(t, root.TraceID, seg.TraceID, seg.Name)
+			assert.NotEmpty(t, seg.ParentID, seg.Name)
+		} else {
+			emittedRoot = seg
+		}
+		docs[seg.ID] = seg
+	}
+	if !assert.NotNil(t, emittedRoot) {
+		return
+	}
+
+	// Reassemble the tree: embedded subsegments plus streamed ones by parent ID.
+	children := map[string][]string{}
+	var walk func(seg *Segment)
+	walk = func(seg *Segment) {
+		for _, raw := range seg.Subsegments {
+			s := &Segment{}
+			if assert.NoError(t, json.Unmarshal(raw, s)) {
+				children[seg.Name] = append(children[seg.Name], s.Name)
+				walk(s)
+			}
+		}
+	}
+	for _, seg := range docs {
+		walk(seg)
+		if seg.Type == "subsegment" {
+			parent, ok := docs[seg.ParentID]
+			if !ok {
+				// The parent is embedded in another document.
+				parent = findEmbedded(t, docs, seg.ParentID)
+			}
+			if assert.NotNil(t, parent, "detached subsegment %s", seg.Name) {
+				children[parent.Name] = append(children[parent.Name], seg.Name)
+			}
+		}
+	}
+	for _, c := range children {
+		sort.Strings(c)
+	}
+
+	assert.Equal(t, map[string][]string{
+		"root": {"a", "b", "c"},
+		"a":    {"a1", "a2", "a3"},
+		"b":    {"b1"},
+	}, children)
+}
+
+// findEmbedded returns the subsegment with the given ID embedded in any of docs.
+func findEmbedded(t *testing.T, docs map[string]*Segment, id string) *Segment {
+	var find func(raw []json.RawMessage) *Segment
+	find = func(raw []json.RawMessage) *Segment {
+		for _, r := range raw {
+			s := &Segment{}
+			if assert.NoError(t, json.Unmarshal(r, s)) {
+				if s.ID == id {
+					return s
+				}
+				if found := find(s.Subsegments); found != nil {
+					return found
+				}
+			}
+		}
+		return nil
+	}
+	for _, seg := range docs {
+		if found := find(seg.Subsegments); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestDefaultStreamingStrategyKeepsInProgressSubsegments(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+
+	root := &Segment{ID: "root", TraceID: "1-5759e988-bd862e3fe1be46a994272793", Sampled: true}
+	root.ParentSegment = root
+	open := &Segment{ID: "open", Name: "open", InProgress: true, parent: root, ParentSegment: root}
+	done := &Segment{ID: "done", Name: "done", EndTime: 1, parent: root, ParentSegment: root}
+	root.rawSubsegments = []*Segment{open, done}
+	root.Subsegments = []json.RawMessage{[]byte(`{"name":"open"}`), []byte(`{"name":"done"}`)}
+	root.totalSubSegments = 2
+
+	out := dss.StreamCompletedSubsegments(root)
+	if assert.Len(t, out, 1) {
+		streamed := &Segment{}
+		assert.NoError(t, json.Unmarshal(out[0], streamed))
+		assert.Equal(t, "done", streamed.Name)
+		assert.Equal(t, "subsegment", streamed.Type)
+		assert.Equal(t, root.ID, streamed.ParentID)
+		assert.Equal(t, root.TraceID, streamed.TraceID)
+	}
+	assert.Equal(t, []*Segment{open}, root.rawSubsegments)
+	assert.Equal(t, []json.RawMessage{[]byte(`{"name":"open"}`)}, root.Subsegments)
+
+	assert.Empty(t, dss.StreamCompletedSubsegments(root))
+	assert.Equal(t, []*Segment{open}, root.rawSubsegments)
+}
+
+func TestDefaultStreamingStrategyPerSegmentOverride(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).StreamingStrategy, _ = NewDefaultStreamingStrategyWithMaxSubsegmentCount(2)
+
+	ctx, root := BeginSegment(ctx, "root")
+	assert.Error(t, root.SetMaxSubsegmentCount(0))
+	assert.NoError(t, root.SetMaxSubsegmentCount(10))
+	for i := 0; i < 5; i++ {
+		_, seg := BeginSubsegment(ctx, "child")
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	// Nothing was streamed, so the whole tree arrives in one document.
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	assert.Len(t, seg.Subsegments, 5)
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestDefaultStreamingStrategyRequiresStreamingOverride(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(2)
+	root := &Segment{Sampled: true, totalSubSegments: 3}
+	root.ParentSegment = root
+
+	assert.True(t, dss.RequiresStreaming(root))
+	assert.NoError(t, root.SetMaxSubsegmentCount(3))
+	assert.False(t, dss.RequiresStreaming(root))
+}
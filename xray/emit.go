@@ -0,0 +1,114 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// AddSubsegment adds child, a subsegment built by hand, to seg, a segment or
+// subsegment built by hand, for sending it with Emit. Subsegments begun
+// with BeginSubsegment are already part of their segment and must not be
+// added.
+func (seg *Segment) AddSubsegment(child *Segment) {
+	seg.Lock()
+	defer seg.Unlock()
+
+	child.Lock()
+	child.parent = seg
+	child.Unlock()
+	seg.rawSubsegments = append(seg.rawSubsegments, child)
+}
+
+// Emit sends seg, a segment built by hand rather than with BeginSegment,
+// along with the subsegments added to it with AddSubsegment. It allows
+// forwarding traces recorded by other systems, which are complete by the
+// time they are received, without beginning and closing each
+// (sub)segment.
+//
+// Every (sub)segment must have a name, an ID, a start time and either an
+// end time or InProgress set. seg must also have a trace ID, and its
+// subsegments inherit it. Emit returns an error naming the first
+// (sub)segment missing one of them, and sends nothing then. The segment is
+// sent as sampled, with the emitter of seg.Configuration, or of the global
+// configuration if seg has none. seg must not be modified once emitted.
+func Emit(seg *Segment) error {
+	if SdkDisabled() {
+		return nil
+	}
+	if seg == nil {
+		return errors.New("cannot emit a nil segment")
+	}
+
+	// Fill in a copy of the configuration, which may be shared.
+	cfg := seg.Configuration
+	seg.Configuration = nil
+	seg.assignConfiguration(cfg)
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.parent != nil {
+		return fmt.Errorf("segment %q is a subsegment", seg.Name)
+	}
+	if seg.Emitted {
+		return fmt.Errorf("segment %q has already been emitted", seg.Name)
+	}
+	if err := ValidateSegment(seg); err != nil {
+		return err
+	}
+	if err := seg.checkEnded(); err != nil {
+		return err
+	}
+
+	seg.ParentSegment = seg
+	seg.Sampled = true
+	atomic.StoreUint32(&seg.totalSubSegments, 0)
+	seg.linkEmittedSubsegments(seg)
+
+	seg.Emitted = true
+	seg.emit()
+	return nil
+}
+
+// checkEnded checks that seg and its subsegments either have an end time or
+// are in progress.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) checkEnded() error {
+	if seg.EndTime == 0 && !seg.InProgress {
+		return fmt.Errorf("segment %q has neither an end time nor is in progress", seg.Name)
+	}
+
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		err := s.checkEnded()
+		s.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkEmittedSubsegments makes root the ParentSegment of the subsegments of
+// seg, as BeginSubsegment does. It is only called once checkEnded passed, so
+// that a segment failing to be emitted is left unchanged.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) linkEmittedSubsegments(root *Segment) {
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		s.ParentSegment = root
+		s.Sampled = true
+		s.linkEmittedSubsegments(root)
+		s.Unlock()
+		atomic.AddUint32(&root.totalSubSegments, 1)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmit(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	seg := &Segment{
+		TraceID:       "1-5759e988-bd862e3fe1be46a994272793",
+		ID:            "53995c3f42cd8ad8",
+		Name:          "forwarded",
+		StartTime:     1461096053.37518,
+		EndTime:       1461096053.4042,
+		Annotations:   map[string]interface{}{"tenant": "acme"},
+		Configuration: GetRecorder(ctx),
+	}
+	child := &Segment{
+		ID:        "70de5b6f19ff9a0a",
+		Name:      "downstream",
+		Namespace: "remote",
+		StartTime: 1461096053.38,
+		EndTime:   1461096053.40,
+	}
+	seg.AddSubsegment(child)
+	grandchild := &Segment{
+		ID:         "70de5b6f19ff9a0b",
+		Name:       "pending",
+		StartTime:  1461096053.39,
+		InProgress: true,
+	}
+	child.AddSubsegment(grandchild)
+
+	assert.NoError(t, Emit(seg))
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, seg.TraceID, emitted.TraceID)
+	assert.Equal(t, "forwarded", emitted.Name)
+	assert.Equal(t, 1461096053.4042, emitted.EndTime)
+	assert.Equal(t, "acme", emitted.Annotations["tenant"])
+	if !assert.Len(t, emitted.Subsegments, 1) {
+		return
+	}
+	var sub *Segment
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &sub))
+	assert.Equal(t, "downstream", sub.Name)
+	assert.Equal(t, "remote", sub.Namespace)
+	if !assert.Len(t, sub.Subsegments, 1) {
+		return
+	}
+	var pending *Segment
+	assert.NoError(t, json.Unmarshal(sub.Subsegments[0], &pending))
+	assert.Equal(t, "pending", pending.Name)
+	assert.True(t, pending.InProgress)
+
+	assert.Error(t, Emit(seg))
+}
+
+func TestEmitInvalidSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	valid := func() *Segment {
+		return &Segment{
+			TraceID:       "1-5759e988-bd862e3fe1be46a994272793",
+			ID:            "53995c3f42cd8ad8",
+			Name:          "forwarded",
+			StartTime:     1461096053.37518,
+			EndTime:       1461096053.4042,
+			Configuration: GetRecorder(ctx),
+		}
+	}
+
+	noTraceID := valid()
+	noTraceID.TraceID = ""
+	assert.EqualError(t, Emit(noTraceID), `segment "forwarded" has no trace ID`)
+
+	noEndTime := valid()
+	noEndTime.EndTime = 0
+	assert.EqualError(t, Emit(noEndTime), `segment "forwarded" has neither an end time nor is in progress`)
+
+	noChildID := valid()
+	noChildID.AddSubsegment(&Segment{Name: "child", StartTime: 1461096053.38, EndTime: 1461096053.40})
+	assert.EqualError(t, Emit(noChildID), `segment "child" has no ID`)
+
+	openChild := valid()
+	ended := &Segment{ID: "b", Name: "ended", StartTime: 1461096053.38, EndTime: 1461096053.40}
+	openChild.AddSubsegment(ended)
+	openChild.AddSubsegment(&Segment{ID: "c", Name: "open", StartTime: 1461096053.38})
+	assert.EqualError(t, Emit(openChild), `segment "open" has neither an end time nor is in progress`)
+	assert.Nil(t, openChild.ParentSegment, "a segment failing to be emitted is left unchanged")
+	assert.False(t, openChild.Sampled)
+	assert.Nil(t, ended.ParentSegment)
+
+	subsegment := valid()
+	child := valid()
+	subsegment.AddSubsegment(child)
+	assert.EqualError(t, Emit(child), `segment "forwarded" is a subsegment`)
+
+	assert.Error(t, Emit(nil))
+
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
@@ -8,10 +8,54 @@
 
 package xray
 
-import "net"
+import (
+	"context"
+	"net"
+)
 
 // Emitter provides an interface for implementing emitting trace entities.
 type Emitter interface {
 	Emit(seg *Segment)
 	RefreshEmitterWithAddress(raddr *net.UDPAddr)
 }
+
+// Drainer is implemented by emitters which can hold on to emitted
+// segments, for instance to batch them. Drain returns once every segment
+// emitted so far has been sent, or when ctx is done.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// tracingSuspender is implemented by emitters which can suspend tracing
+// while they are unable to send segments, such as DefaultEmitter with
+// WithCircuitBreaker. Segments begun while suspendsTracing returns true are
+// not sampled.
+type tracingSuspender interface {
+	suspendsTracing() bool
+}
+
+// emitterSuspendsTracing reports whether e suspends tracing.
+func emitterSuspendsTracing(e Emitter) bool {
+	s, ok := e.(tracingSuspender)
+	return ok && s.suspendsTracing()
+}
+
+// Flush drains the emitter configured in ctx, or the global emitter if ctx
+// carries no configuration. Emitters which do not implement Drainer send
+// segments synchronously and need no flushing.
+//
+// AWS Lambda freezes the execution environment as soon as the handler
+// returns and may never thaw it again, so segments still buffered by the
+// emitter at that point can be lost. Lambda handlers should call Flush
+// before returning from every invocation.
+func Flush(ctx context.Context) error {
+	emitter := globalCfg.Emitter()
+	if cfg := GetRecorder(ctx); cfg != nil && cfg.Emitter != nil {
+		emitter = cfg.Emitter
+	}
+
+	if d, ok := emitter.(Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return nil
+}
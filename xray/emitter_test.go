@@ -0,0 +1,59 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestDrainingEmitter struct {
+	TestEmitter
+	drained int
+	err     error
+}
+
+func (te *TestDrainingEmitter) Drain(ctx context.Context) error {
+	te.drained++
+	return te.err
+}
+
+func TestFlush(t *testing.T) {
+	e := &TestDrainingEmitter{}
+	ctx, err := ContextWithConfig(context.Background(), Config{Emitter: e})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, Flush(ctx))
+	assert.Equal(t, 1, e.drained)
+
+	e.err = errors.New("drain failed")
+	assert.Equal(t, e.err, Flush(ctx))
+}
+
+func TestFlushGlobalEmitter(t *testing.T) {
+	e := &TestDrainingEmitter{}
+	Configure(Config{Emitter: e})
+	defer ResetConfig()
+
+	assert.NoError(t, Flush(context.Background()))
+	assert.Equal(t, 1, e.drained)
+}
+
+func TestFlushWithoutDrainer(t *testing.T) {
+	ctx, err := ContextWithConfig(context.Background(), Config{Emitter: &TestEmitter{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, Flush(ctx))
+}
@@ -36,7 +36,7 @@ func (h *fasthttpHandler) Handler(sn SegmentNamer, handler fasthttp.RequestHandl
 		}
 
 		name := sn.Name(string(ctx.Request.Host()))
-		traceHeader := header.FromString(string(ctx.Request.Header.Peek(TraceIDHeaderKey)))
+		traceHeader := header.FromString(string(ctx.Request.Header.Peek(traceHeaderName(h.cfg))))
 
 		req, err := fasthttpToNetHTTPRequest(ctx)
 		if err != nil {
@@ -46,7 +46,13 @@ func (h *fasthttpHandler) Handler(sn SegmentNamer, handler fasthttp.RequestHandl
 		}
 
 		_, seg := NewSegmentFromHeader(auxCtx, name, req, traceHeader)
-		defer seg.Close(nil)
+		defer func() {
+			if p := recover(); p != nil {
+				seg.closeOnPanic(p)
+				panic(p)
+			}
+			seg.Close(nil)
+		}()
 
 		ctx.SetUserValue(fasthttpContextKey, seg)
 		httpCaptureRequest(seg, req)
@@ -88,7 +94,7 @@ func fasthttpToNetHTTPRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
 }
 
 func fasthttpTrace(seg *Segment, h fasthttp.RequestHandler, ctx *fasthttp.RequestCtx, traceHeader *header.Header) {
-	ctx.Request.Header.Set(TraceIDHeaderKey, generateTraceIDHeaderValue(seg, traceHeader))
+	ctx.Response.Header.Set(traceHeaderName(seg.ParentSegment.Configuration), generateTraceIDHeaderValue(seg, traceHeader))
 	h(ctx)
 
 	seg.Lock()
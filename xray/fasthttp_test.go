@@ -31,6 +31,50 @@ func TestFastHTTPHandler(t *testing.T) {
 	assert.Equal(t, "UA_test", seg.HTTP.Request.UserAgent)
 }
 
+func TestFastHTTPHandlerSamplingRequested(t *testing.T) {
+	ctx1, td := NewTestDaemon()
+	cfg := GetRecorder(ctx1)
+	defer td.Close()
+	strategy := &alternatingSamplingStrategy{}
+	cfg.SamplingStrategy = strategy
+
+	fh := NewFastHTTPInstrumentor(cfg)
+	handler := fh.Handler(NewFixedSegmentNamer("test"), func(ctx *fasthttp.RequestCtx) {})
+	rc := genericRequestCtx()
+	rc.Request.Header.Set(TraceIDHeaderKey, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=?")
+	handler(rc)
+
+	assert.Equal(t, 1, strategy.calls)
+	assert.Equal(t, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=1", string(rc.Response.Header.Peek(TraceIDHeaderKey)))
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1-57fbe041-2c7ad569f5d6ff149137be86", seg.TraceID)
+}
+
+func TestFastHTTPHandlerCustomTraceHeaderName(t *testing.T) {
+	ctx1, td := NewTestDaemon()
+	cfg := GetRecorder(ctx1)
+	defer td.Close()
+	cfg.TraceHeaderName = "Amzn-Trace-Id"
+
+	fh := NewFastHTTPInstrumentor(cfg)
+	handler := fh.Handler(NewFixedSegmentNamer("test"), func(ctx *fasthttp.RequestCtx) {})
+	rc := genericRequestCtx()
+	rc.Request.Header.Set("Amzn-Trace-Id", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=reqid;Sampled=1")
+	handler(rc)
+
+	assert.Equal(t, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=reqid;Sampled=1", string(rc.Response.Header.Peek("Amzn-Trace-Id")))
+	assert.Empty(t, rc.Response.Header.Peek(TraceIDHeaderKey))
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1-57fbe041-2c7ad569f5d6ff149137be86", seg.TraceID)
+	assert.Equal(t, "reqid", seg.ParentID)
+}
+
 // genericRequestCtx helper function to build fasthttp.RequestCtx
 func genericRequestCtx() *fasthttp.RequestCtx {
 	b := `{"body": "content"}`
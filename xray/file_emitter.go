@@ -0,0 +1,306 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// defaultFileMaxBackups is the number of rotated files a FileEmitter keeps,
+// see WithMaxBackups.
+const defaultFileMaxBackups = 5
+
+// defaultFileFlushInterval is the longest time segments wait in the buffer
+// of a FileEmitter before they are written to the file.
+const defaultFileFlushInterval = time.Second
+
+// FileEmitter writes segments to a file as newline-delimited JSON instead
+// of sending them to the daemon, for environments without network access to
+// X-Ray where traces are shipped by collecting log files. Every segment
+// document, and every subsegment streamed from its tree, is one line.
+//
+// Once writing a line would grow the file past its maximum size, the file
+// is rotated: path is renamed to path.1, path.1 to path.2 and so on, and a
+// new file is created at path. Only the 5 most recent rotated files are
+// kept, unless configured otherwise with WithMaxBackups. A line larger than
+// the maximum size is still written, to a file of its own.
+//
+// Lines are buffered and written to the file at least once a second, as
+// well as on Drain and Close. Call Close before the process exits so that
+// no segment is lost.
+type FileEmitter struct {
+	path          string
+	maxBytes      int64
+	maxBackups    int
+	flushInterval time.Duration
+
+	// mu guards the file and its buffer.
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// FileEmitterOption configures a FileEmitter created by NewFileEmitter.
+type FileEmitterOption interface {
+	apply(fe *FileEmitter)
+}
+
+type funcFileEmitterOption struct {
+	f func(fe *FileEmitter)
+}
+
+func (f funcFileEmitterOption) apply(fe *FileEmitter) {
+	f.f(fe)
+}
+
+// WithMaxBackups makes the emitter keep the n most recent rotated files.
+// An n of zero removes files as soon as they are rotated.
+func WithMaxBackups(n int) FileEmitterOption {
+	return funcFileEmitterOption{f: func(fe *FileEmitter) {
+		if n < 0 {
+			n = 0
+		}
+		fe.maxBackups = n
+	}}
+}
+
+// NewFileEmitter initializes and returns a pointer to an instance of
+// FileEmitter which appends segments to the file at path, created if
+// needed, and rotates it once it would exceed maxBytes. Call Close to write
+// the remaining segments and close the file.
+func NewFileEmitter(path string, maxBytes int64, opts ...FileEmitterOption) (*FileEmitter, error) {
+	if path == "" {
+		return nil, errors.New("file path must not be empty")
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("maximum file size %d must be positive", maxBytes)
+	}
+
+	fe := &FileEmitter{
+		path:          path,
+		maxBytes:      maxBytes,
+		maxBackups:    defaultFileMaxBackups,
+		flushInterval: defaultFileFlushInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(fe)
+	}
+	f, size, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fe.file = f
+	fe.size = size
+	fe.w = bufio.NewWriter(f)
+	fe.wg.Add(1)
+	go fe.flushPeriodically(fe.flushInterval)
+	return fe, nil
+}
+
+// RefreshEmitterWithAddress is a no-op as FileEmitter
+// does not send segments to the daemon.
+func (fe *FileEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// Emit writes segment or subsegment to the file if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (fe *FileEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	docs := packSegments(seg, nil)
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.closed {
+		logger.Debugf("Dropping segment %s emitted after the file emitter was closed", seg.Name)
+		return
+	}
+	for _, p := range docs {
+		if err := fe.writeLine(p); err != nil {
+			logger.Errorf("Error writing segment to %s: %v", fe.path, err)
+			return
+		}
+	}
+}
+
+// Drain writes every segment emitted so far to the file. It returns an
+// error if they could not be written, or when ctx is done.
+func (fe *FileEmitter) Drain(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.closed {
+		return nil
+	}
+	return fe.flush()
+}
+
+// Close stops the emitter, writes the segments emitted so far and closes
+// the file. Segments emitted after Close are dropped.
+func (fe *FileEmitter) Close() error {
+	fe.mu.Lock()
+	if fe.closed {
+		fe.mu.Unlock()
+		return nil
+	}
+	fe.closed = true
+	fe.mu.Unlock()
+
+	close(fe.done)
+	fe.wg.Wait()
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.closeFile()
+}
+
+func (fe *FileEmitter) flushPeriodically(interval time.Duration) {
+	defer fe.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := fe.Drain(context.Background()); err != nil {
+				logger.Errorf("Error writing segments to %s: %v", fe.path, err)
+			}
+		case <-fe.done:
+			return
+		}
+	}
+}
+
+// writeLine writes p followed by a newline, rotating the file first if the
+// line would grow it past maxBytes. If the rotation fails, the line is
+// written to the current file.
+// fe has a lock acquired by the caller.
+func (fe *FileEmitter) writeLine(p []byte) error {
+	n := int64(len(p)) + 1
+	if fe.size > 0 && fe.size+n > fe.maxBytes {
+		if err := fe.rotate(); err != nil {
+			logger.Errorf("Error rotating %s: %v", fe.path, err)
+		}
+	}
+	if _, err := fe.w.Write(p); err != nil {
+		fe.w.Reset(fe.file)
+		return err
+	}
+	if err := fe.w.WriteByte('\n'); err != nil {
+		fe.w.Reset(fe.file)
+		return err
+	}
+	fe.size += n
+	return nil
+}
+
+// flush writes the buffer to the file. As a bufio.Writer fails every later
+// write once one failed, the buffered lines are dropped on error so that the
+// following ones can be written.
+// fe has a lock acquired by the caller.
+func (fe *FileEmitter) flush() error {
+	if err := fe.w.Flush(); err != nil {
+		fe.w.Reset(fe.file)
+		return err
+	}
+	return nil
+}
+
+// rotate shifts path and the rotated files by one, removing the oldest one
+// past maxBackups, opens a new file at path and only then closes the
+// previous one. Until a new file is opened, lines keep being written to the
+// previous one, so that a failed rotation does not lose them.
+// fe has a lock acquired by the caller.
+func (fe *FileEmitter) rotate() error {
+	if err := fe.flush(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(fe.backupPath(fe.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := fe.maxBackups - 1; i >= 0; i-- {
+		if err := os.Rename(fe.backupPath(i), fe.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	f, size, err := openFile(fe.path)
+	if err != nil {
+		return err
+	}
+	prev := fe.file
+	fe.file = f
+	fe.size = size
+	fe.w.Reset(f)
+	if err := prev.Close(); err != nil {
+		logger.Errorf("Error closing rotated file %s: %v", fe.backupPath(1), err)
+	}
+	return nil
+}
+
+// backupPath returns the path of the ith most recent rotated file, or of
+// the current file for i 0.
+func (fe *FileEmitter) backupPath(i int) string {
+	if i == 0 {
+		return fe.path
+	}
+	return fmt.Sprintf("%s.%d", fe.path, i)
+}
+
+// openFile opens the file at path for appending and returns it with its
+// size.
+func openFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// closeFile writes the buffer to the file and closes it. The file is closed
+// even if the buffer could not be written. It is only called once, by Close.
+// fe has a lock acquired by the caller.
+func (fe *FileEmitter) closeFile() error {
+	err := fe.flush()
+	if cerr := fe.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readSegmentLines returns the names of the segments written to the file at
+// path, one per line.
+func readSegmentLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var seg Segment
+		if assert.NoError(t, json.Unmarshal(scanner.Bytes(), &seg)) {
+			names = append(names, seg.Name)
+		}
+	}
+	assert.NoError(t, scanner.Err())
+	return names
+}
+
+func TestNewFileEmitterInvalid(t *testing.T) {
+	_, err := NewFileEmitter("", 1024)
+	assert.Error(t, err)
+	_, err = NewFileEmitter(filepath.Join(t.TempDir(), "segments.log"), 0)
+	assert.Error(t, err)
+	_, err = NewFileEmitter(filepath.Join(t.TempDir(), "missing", "segments.log"), 1024)
+	assert.Error(t, err)
+}
+
+// withFlushInterval sets the interval at which a FileEmitter writes its
+// buffer, so that tests do not depend on the default one.
+func withFlushInterval(d time.Duration) FileEmitterOption {
+	return funcFileEmitterOption{f: func(fe *FileEmitter) {
+		fe.flushInterval = d
+	}}
+}
+
+func TestFileEmitter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.log")
+	fe, err := NewFileEmitter(path, 1<<20, withFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	emitTrace(fe, "first")
+	unsampled := &Segment{Name: "unsampled", TraceID: NewTraceID()}
+	unsampled.ParentSegment = unsampled
+	fe.Emit(unsampled)
+	emitTrace(fe, "second")
+	assert.Empty(t, readSegmentLines(t, path))
+
+	assert.NoError(t, fe.Drain(context.Background()))
+	assert.Equal(t, []string{"first", "second"}, readSegmentLines(t, path))
+
+	emitTrace(fe, "third")
+	assert.NoError(t, fe.Close())
+	assert.NoError(t, fe.Close())
+	emitTrace(fe, "dropped")
+	assert.Equal(t, []string{"first", "second", "third"}, readSegmentLines(t, path))
+}
+
+func TestFileEmitterRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.log")
+	const maxBytes = 512
+	fe, err := NewFileEmitter(path, maxBytes, WithMaxBackups(2))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 50; i++ {
+		emitTrace(fe, NewTraceID())
+	}
+	assert.NoError(t, fe.Close())
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if assert.NoError(t, err) {
+			assert.LessOrEqual(t, info.Size(), int64(maxBytes), p)
+		}
+		assert.NotEmpty(t, readSegmentLines(t, p))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileEmitterFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.log")
+	// The oldest rotated file cannot be removed, so every rotation fails.
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".1", "keep"), 0755))
+	fe, err := NewFileEmitter(path, 512, WithMaxBackups(1))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		emitTrace(fe, "kept")
+	}
+	assert.NoError(t, fe.Close())
+	assert.Len(t, readSegmentLines(t, path), 10)
+}
+
+func TestFileEmitterConcurrentRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.log")
+	fe, err := NewFileEmitter(path, 1024, WithMaxBackups(1000))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				emitTrace(fe, NewTraceID())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, fe.Close())
+
+	files, err := filepath.Glob(path + "*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Greater(t, len(files), 1)
+	var lines int
+	for _, f := range files {
+		lines += len(readSegmentLines(t, f))
+	}
+	assert.Equal(t, 200, lines)
+}
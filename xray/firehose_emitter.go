@@ -0,0 +1,297 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// Limits of a single Firehose PutRecordBatch call.
+const (
+	firehoseMaxBatchRecords = 500
+	firehoseMaxBatchBytes   = 4 * 1024 * 1024
+	firehoseMaxRecordBytes  = 1000 * 1024
+)
+
+// firehoseMaxRetries is the number of times records which Firehose failed to
+// put are sent again before they are dropped.
+const firehoseMaxRetries = 3
+
+// defaultFirehoseFlushInterval is the longest time segments wait in a
+// FirehoseEmitter before they are sent.
+const defaultFirehoseFlushInterval = time.Second
+
+// FirehoseClient is the part of the Firehose API used by FirehoseEmitter. It
+// is implemented by *firehose.Firehose.
+type FirehoseClient interface {
+	PutRecordBatchWithContext(ctx aws.Context, input *firehose.PutRecordBatchInput, opts ...request.Option) (*firehose.PutRecordBatchOutput, error)
+}
+
+// FirehoseEmitter sends segments to an Amazon Kinesis Data Firehose delivery
+// stream instead of the daemon, for instance to store traces in a data lake.
+// Every segment document, and every subsegment streamed from its tree, is
+// one newline terminated record. Records are sent in PutRecordBatch calls
+// holding at most 500 records and 4MB, once a batch is full or at least once
+// a second; records larger than the 1000KB limit of Firehose are dropped.
+// Records Firehose fails to put are sent again a few times before they are
+// dropped.
+//
+// A full batch is sent from Emit, which is called when a segment closes, so
+// wrap the emitter in a PooledEmitter to keep closing segments from waiting
+// on Firehose. The Firehose client must not be instrumented with AWS, as its
+// calls would be traced in turn.
+type FirehoseEmitter struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment.
+	dropped uint64
+
+	client     FirehoseClient
+	streamName string
+
+	// retryBackoff is the time waited before the first retry, doubled on
+	// every further retry.
+	retryBackoff time.Duration
+
+	// mu guards pending, pendingBytes and closed.
+	mu           sync.Mutex
+	pending      []*firehose.Record
+	pendingBytes int
+	closed       bool
+
+	// sendMu serializes sending batches, so that Drain returns only once
+	// batches sent concurrently have been sent as well.
+	sendMu sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewFirehoseEmitter initializes and returns a pointer to an instance of
+// FirehoseEmitter which puts segments into the delivery stream streamName
+// through client. Call Close to send the remaining segments and stop the
+// emitter.
+func NewFirehoseEmitter(client FirehoseClient, streamName string) (*FirehoseEmitter, error) {
+	if client == nil {
+		return nil, errors.New("firehose client must not be nil")
+	}
+	if streamName == "" {
+		return nil, errors.New("stream name must not be empty")
+	}
+
+	fe := &FirehoseEmitter{
+		client:       client,
+		streamName:   streamName,
+		retryBackoff: 100 * time.Millisecond,
+		done:         make(chan struct{}),
+	}
+	fe.wg.Add(1)
+	go fe.flushPeriodically(defaultFirehoseFlushInterval)
+	return fe, nil
+}
+
+// RefreshEmitterWithAddress is a no-op as FirehoseEmitter
+// does not send segments to the daemon.
+func (fe *FirehoseEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// Emit adds segment or subsegment to the batch if root segment is sampled,
+// and sends the batch once it is full.
+// seg has a write lock acquired by the caller.
+func (fe *FirehoseEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	full := false
+	for _, p := range packSegments(seg, nil) {
+		data := append(p, '\n')
+		if len(data) > firehoseMaxRecordBytes {
+			logger.Errorf("Dropping segment of %d bytes, larger than the Firehose record limit.", len(data))
+			atomic.AddUint64(&fe.dropped, 1)
+			continue
+		}
+
+		fe.mu.Lock()
+		if fe.closed {
+			fe.mu.Unlock()
+			atomic.AddUint64(&fe.dropped, 1)
+			continue
+		}
+		fe.pending = append(fe.pending, &firehose.Record{Data: data})
+		fe.pendingBytes += len(data)
+		full = full || len(fe.pending) >= firehoseMaxBatchRecords || fe.pendingBytes >= firehoseMaxBatchBytes
+		fe.mu.Unlock()
+	}
+
+	if full {
+		if err := fe.flush(context.Background()); err != nil {
+			logger.Errorf("Error sending segments to Firehose: %v", err)
+		}
+	}
+}
+
+// Drain sends every segment emitted so far to Firehose. It returns an error
+// if some of them could not be put, or when ctx is done.
+func (fe *FirehoseEmitter) Drain(ctx context.Context) error {
+	return fe.flush(ctx)
+}
+
+// Close stops the emitter and sends the segments emitted so far. Segments
+// emitted after Close are dropped.
+func (fe *FirehoseEmitter) Close() error {
+	fe.mu.Lock()
+	if fe.closed {
+		fe.mu.Unlock()
+		return nil
+	}
+	fe.closed = true
+	fe.mu.Unlock()
+
+	close(fe.done)
+	fe.wg.Wait()
+	return fe.flush(context.Background())
+}
+
+// DroppedCount returns the number of segments which were not put into the
+// delivery stream.
+func (fe *FirehoseEmitter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&fe.dropped)
+}
+
+func (fe *FirehoseEmitter) flushPeriodically(interval time.Duration) {
+	defer fe.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := fe.flush(context.Background()); err != nil {
+				logger.Errorf("Error sending segments to Firehose: %v", err)
+			}
+		case <-fe.done:
+			return
+		}
+	}
+}
+
+// flush sends the pending records in batches within the limits of Firehose.
+// It returns the last error of a batch which could not be put.
+func (fe *FirehoseEmitter) flush(ctx context.Context) error {
+	fe.sendMu.Lock()
+	defer fe.sendMu.Unlock()
+
+	var err error
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		batch := fe.nextBatch()
+		if len(batch) == 0 {
+			return err
+		}
+		if e := fe.send(ctx, batch); e != nil {
+			err = e
+		}
+	}
+}
+
+// nextBatch takes the longest run of pending records which fits one
+// PutRecordBatch call.
+func (fe *FirehoseEmitter) nextBatch() []*firehose.Record {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	n, size := 0, 0
+	for n < len(fe.pending) && n < firehoseMaxBatchRecords {
+		l := len(fe.pending[n].Data)
+		if n > 0 && size+l > firehoseMaxBatchBytes {
+			break
+		}
+		size += l
+		n++
+	}
+
+	batch := make([]*firehose.Record, n)
+	copy(batch, fe.pending)
+	for i := 0; i < n; i++ {
+		fe.pending[i] = nil
+	}
+	fe.pending = fe.pending[n:]
+	fe.pendingBytes -= size
+	return batch
+}
+
+// send puts batch into the delivery stream, sending the records which
+// failed again up to firehoseMaxRetries times. The records which could not be
+// put in the end are dropped.
+func (fe *FirehoseEmitter) send(ctx context.Context, batch []*firehose.Record) error {
+	backoff := fe.retryBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := fe.client.PutRecordBatchWithContext(ctx, &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(fe.streamName),
+			Records:            batch,
+		})
+		if err == nil {
+			batch = failedRecords(batch, out)
+			if len(batch) == 0 {
+				return nil
+			}
+			err = fmt.Errorf("firehose failed to put %d records", len(batch))
+		}
+
+		if attempt == firehoseMaxRetries || ctx.Err() != nil {
+			atomic.AddUint64(&fe.dropped, uint64(len(batch)))
+			return err
+		}
+
+		logger.Debugf("Retrying %d records after error from Firehose: %v", len(batch), err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddUint64(&fe.dropped, uint64(len(batch)))
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// failedRecords returns the records of batch which Firehose reports it
+// failed to put.
+func failedRecords(batch []*firehose.Record, out *firehose.PutRecordBatchOutput) []*firehose.Record {
+	if out == nil || aws.Int64Value(out.FailedPutCount) == 0 {
+		return nil
+	}
+
+	var failed []*firehose.Record
+	for i, r := range out.RequestResponses {
+		if i < len(batch) && r != nil && r.ErrorCode != nil {
+			failed = append(failed, batch[i])
+		}
+	}
+	return failed
+}
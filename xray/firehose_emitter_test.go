@@ -0,0 +1,204 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockFirehose records the batches it is sent. fail returns the indexes of
+// the records of a call which should fail.
+type mockFirehose struct {
+	mu      sync.Mutex
+	batches [][]*firehose.Record
+	fail    func(call int) map[int]bool
+	err     error
+}
+
+func (m *mockFirehose) PutRecordBatchWithContext(ctx aws.Context, input *firehose.PutRecordBatchInput, opts ...request.Option) (*firehose.PutRecordBatchOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	call := len(m.batches)
+	m.batches = append(m.batches, input.Records)
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var failed map[int]bool
+	if m.fail != nil {
+		failed = m.fail(call)
+	}
+	out := &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(int64(len(failed)))}
+	for i := range input.Records {
+		entry := &firehose.PutRecordBatchResponseEntry{RecordId: aws.String("id")}
+		if failed[i] {
+			entry = &firehose.PutRecordBatchResponseEntry{ErrorCode: aws.String("ServiceUnavailableException")}
+		}
+		out.RequestResponses = append(out.RequestResponses, entry)
+	}
+	return out, nil
+}
+
+func newTestFirehoseEmitter(t *testing.T, client FirehoseClient) *FirehoseEmitter {
+	fe, err := NewFirehoseEmitter(client, "traces")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	fe.retryBackoff = 0
+	return fe
+}
+
+func sampledSegment(name string) *Segment {
+	seg := &Segment{Name: name, Sampled: true}
+	seg.ParentSegment = seg
+	return seg
+}
+
+func TestNewFirehoseEmitterValidation(t *testing.T) {
+	_, err := NewFirehoseEmitter(nil, "traces")
+	assert.Error(t, err)
+	_, err = NewFirehoseEmitter(&mockFirehose{}, "")
+	assert.Error(t, err)
+}
+
+func TestFirehoseEmitterSendsOnClose(t *testing.T) {
+	client := &mockFirehose{}
+	fe := newTestFirehoseEmitter(t, client)
+
+	fe.Emit(sampledSegment("first"))
+	fe.Emit(sampledSegment("second"))
+	notSampled := sampledSegment("not sampled")
+	notSampled.Sampled = false
+	fe.Emit(notSampled)
+	assert.NoError(t, fe.Close())
+
+	fe.Emit(sampledSegment("after close"))
+	assert.Equal(t, uint64(1), fe.DroppedCount())
+
+	if !assert.Len(t, client.batches, 1) || !assert.Len(t, client.batches[0], 2) {
+		return
+	}
+	for i, name := range []string{"first", "second"} {
+		data := client.batches[0][i].Data
+		assert.True(t, bytes.HasSuffix(data, []byte("\n")))
+		var seg Segment
+		if assert.NoError(t, json.Unmarshal(data, &seg)) {
+			assert.Equal(t, name, seg.Name)
+		}
+	}
+}
+
+func TestFirehoseEmitterBatchLimits(t *testing.T) {
+	client := &mockFirehose{}
+	fe := newTestFirehoseEmitter(t, client)
+	defer fe.Close()
+
+	for i := 0; i < firehoseMaxBatchRecords+1; i++ {
+		fe.Emit(sampledSegment("test"))
+	}
+	assert.NoError(t, fe.Drain(context.Background()))
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	total := 0
+	for _, b := range client.batches {
+		assert.True(t, len(b) <= firehoseMaxBatchRecords)
+		total += len(b)
+	}
+	assert.Equal(t, firehoseMaxBatchRecords+1, total)
+}
+
+func TestFirehoseEmitterBatchBytes(t *testing.T) {
+	client := &mockFirehose{}
+	fe := newTestFirehoseEmitter(t, client)
+	defer fe.Close()
+
+	seg := sampledSegment(strings.Repeat("x", 200))
+	seg.Metadata = map[string]map[string]interface{}{"default": {"pad": strings.Repeat("y", 900*1024)}}
+	for i := 0; i < 6; i++ {
+		fe.Emit(seg)
+	}
+	assert.NoError(t, fe.Drain(context.Background()))
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	total := 0
+	for _, b := range client.batches {
+		size := 0
+		for _, r := range b {
+			size += len(r.Data)
+		}
+		assert.True(t, size <= firehoseMaxBatchBytes)
+		total += len(b)
+	}
+	assert.Equal(t, 6, total)
+	assert.True(t, len(client.batches) > 1)
+}
+
+func TestFirehoseEmitterDropsOversizedRecords(t *testing.T) {
+	client := &mockFirehose{}
+	fe := newTestFirehoseEmitter(t, client)
+
+	seg := sampledSegment("test")
+	seg.Metadata = map[string]map[string]interface{}{"default": {"pad": strings.Repeat("y", firehoseMaxRecordBytes)}}
+	fe.Emit(seg)
+	assert.NoError(t, fe.Close())
+
+	assert.Equal(t, uint64(1), fe.DroppedCount())
+	assert.Empty(t, client.batches)
+}
+
+func TestFirehoseEmitterRetriesFailedRecords(t *testing.T) {
+	client := &mockFirehose{
+		fail: func(call int) map[int]bool {
+			if call == 0 {
+				return map[int]bool{1: true}
+			}
+			return nil
+		},
+	}
+	fe := newTestFirehoseEmitter(t, client)
+
+	fe.Emit(sampledSegment("first"))
+	fe.Emit(sampledSegment("second"))
+	fe.Emit(sampledSegment("third"))
+	assert.NoError(t, fe.Close())
+
+	if !assert.Len(t, client.batches, 2) {
+		return
+	}
+	assert.Len(t, client.batches[0], 3)
+	if assert.Len(t, client.batches[1], 1) {
+		assert.Equal(t, client.batches[0][1], client.batches[1][0])
+	}
+	assert.Zero(t, fe.DroppedCount())
+}
+
+func TestFirehoseEmitterDropsAfterRetries(t *testing.T) {
+	client := &mockFirehose{err: errors.New("unavailable")}
+	fe := newTestFirehoseEmitter(t, client)
+
+	fe.Emit(sampledSegment("test"))
+	assert.Error(t, fe.Close())
+
+	assert.Len(t, client.batches, firehoseMaxRetries+1)
+	assert.Equal(t, uint64(1), fe.DroppedCount())
+}
@@ -0,0 +1,32 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goroutineID returns the ID of the calling goroutine, for debugging only.
+// Go deliberately exposes no such ID, so it is parsed from the header of the
+// goroutine's stack trace, "goroutine 42 [running]:". ok is false if the
+// header does not have that form, which the runtime does not guarantee.
+func goroutineID() (id uint64, ok bool) {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	} else {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(b), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoroutineID(t *testing.T) {
+	id, ok := goroutineID()
+	assert.True(t, ok)
+	assert.NotZero(t, id)
+
+	other := make(chan uint64)
+	go func() {
+		id, _ := goroutineID()
+		other <- id
+	}()
+	assert.NotEqual(t, id, <-other)
+}
+
+func TestCaptureGoroutineID(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	GetRecorder(ctx).CaptureGoroutineID = true
+	ctx, root := BeginSegment(ctx, "test")
+	_, subseg := BeginSubsegment(ctx, "subsegment")
+	subseg.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var tagged *Segment
+	if !assert.Len(t, seg.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &tagged)) {
+		return
+	}
+	id, _ := goroutineID()
+	assert.Equal(t, float64(id), tagged.Metadata["default"]["goroutine_id"])
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 
@@ -44,7 +45,13 @@ func UnaryClientInterceptor(clientInterceptorOptions ...GrpcOption) grpc.UnaryCl
 				return errors.New("failed to record gRPC transaction: segment cannot be found")
 			}
 
-			ctx = metadata.AppendToOutgoingContext(ctx, TraceIDHeaderKey, seg.DownstreamHeader().String())
+			recordDeadline(ctx, seg)
+			// The trace header is added to ctx, which keeps the deadline
+			// of the caller for gRPC to propagate along with it.
+			ctx = metadata.AppendToOutgoingContext(ctx, traceHeaderName(seg.ParentSegment.Configuration), seg.DownstreamHeader().String())
+			if md, ok := metadata.FromOutgoingContext(ctx); ok {
+				annotateMetadata(seg, md, option.metadataKeys)
+			}
 
 			seg.Lock()
 			seg.Namespace = "remote"
@@ -75,8 +82,8 @@ func UnaryServerInterceptor(serverInterceptorOptions ...GrpcOption) grpc.UnarySe
 		md, ok := metadata.FromIncomingContext(ctx)
 
 		var traceID string
-		if ok && len(md.Get(TraceIDHeaderKey)) == 1 {
-			traceID = md.Get(TraceIDHeaderKey)[0]
+		if values := md.Get(traceHeaderName(option.config)); ok && len(values) == 1 {
+			traceID = values[0]
 		}
 		traceHeader := header.FromString(traceID)
 
@@ -108,7 +115,13 @@ func UnaryServerInterceptor(serverInterceptorOptions ...GrpcOption) grpc.UnarySe
 			URL:    &requestURL,
 			Method: http.MethodPost,
 		}, traceHeader)
-		defer seg.Close(nil)
+		defer func() {
+			if p := recover(); p != nil {
+				seg.closeOnPanic(p)
+				panic(p)
+			}
+			seg.Close(nil)
+		}()
 
 		seg.Lock()
 		seg.GetHTTP().GetRequest().ClientIP, seg.GetHTTP().GetRequest().XForwardedFor = clientIPFromGrpcMetadata(md)
@@ -118,6 +131,8 @@ func UnaryServerInterceptor(serverInterceptorOptions ...GrpcOption) grpc.UnarySe
 			seg.GetHTTP().GetRequest().UserAgent = md.Get("user-agent")[0]
 		}
 		seg.Unlock()
+		annotateMetadata(seg, md, option.metadataKeys)
+		recordDeadline(ctx, seg)
 
 		resp, err = handler(ctx, req)
 		if err != nil {
@@ -161,6 +176,17 @@ func clientIPFromGrpcMetadata(md metadata.MD) (string, bool) {
 	return "", false
 }
 
+// recordDeadline records the time left before the deadline of ctx, in
+// milliseconds, as the "deadline_remaining_ms" metadata of the "grpc"
+// namespace of seg. Nothing is recorded if ctx has no deadline.
+func recordDeadline(ctx context.Context, seg *Segment) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	seg.AddMetadataToNamespace("grpc", "deadline_remaining_ms", time.Until(deadline).Milliseconds())
+}
+
 func recordContentLength(seg *Segment, reply interface{}) {
 	seg.Lock()
 	defer seg.Unlock()
@@ -179,11 +205,38 @@ func addResponseTraceHeader(ctx context.Context, seg *Segment, incomingTraceHead
 	}
 
 	headers := metadata.New(map[string]string{
-		TraceIDHeaderKey: respHeader.String(),
+		traceHeaderName(seg.ParentSegment.Configuration): respHeader.String(),
 	})
 	return grpc.SetHeader(ctx, headers)
 }
 
+// annotateMetadata records the values of the metadata keys listed in keys
+// as annotations of seg. Keys with several values are skipped, as
+// annotations hold a single string, number or boolean.
+func annotateMetadata(seg *Segment, md metadata.MD, keys []string) {
+	for _, key := range keys {
+		vals := md.Get(key)
+		switch len(vals) {
+		case 0:
+			continue
+		case 1:
+		default:
+			logger.Debugf("Not annotating gRPC metadata %s: it has %d values", key, len(vals))
+			continue
+		}
+		if err := seg.AddAnnotation(metadataAnnotationKey(key), vals[0]); err != nil {
+			logger.Debugf("Not annotating gRPC metadata %s: %v", key, err)
+		}
+	}
+}
+
+// metadataAnnotationKey turns the metadata key into an annotation key. X-Ray
+// only indexes annotation keys made of letters, digits and underscores, so
+// other characters are replaced with underscores.
+func metadataAnnotationKey(key string) string {
+	return SanitizeAnnotationKey(key)
+}
+
 func inferServiceName(fullMethodName string) string {
 	fullMethodName = fullMethodName[1:]
 	return fullMethodName[:strings.Index(fullMethodName, "/")]
@@ -196,6 +249,7 @@ type GrpcOption interface {
 type grpcOption struct {
 	config       *Config
 	segmentNamer SegmentNamer
+	metadataKeys []string
 }
 
 func newFuncGrpcOption(f func(option *grpcOption)) GrpcOption {
@@ -223,3 +277,32 @@ func WithSegmentNamer(sn SegmentNamer) GrpcOption {
 		option.segmentNamer = sn
 	})
 }
+
+// WithMetadataAnnotations makes the interceptors record the values of the
+// listed metadata keys as annotations of their segments, so that traces can
+// be filtered on them in the X-Ray console. The server interceptor reads the
+// incoming metadata of the call and the client interceptor its outgoing
+// metadata. Keys are matched case-insensitively and recorded with every
+// character other than letters, digits and underscores replaced with an
+// underscore: "x-tenant-id" is recorded as "x_tenant_id". Only keys with a
+// single value are recorded; binary keys, ending in "-bin", are ignored.
+//
+// Only the keys listed are captured. Keys carrying credentials, such as
+// authorization, are captured when listed, with a warning.
+func WithMetadataAnnotations(keys ...string) GrpcOption {
+	var valid []string
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if strings.HasSuffix(key, "-bin") {
+			logger.Warnf("not annotating binary gRPC metadata %s", key)
+			continue
+		}
+		if key == "authorization" || key == "cookie" {
+			logger.Warnf("capturing the %s gRPC metadata records credentials into segment annotations", key)
+		}
+		valid = append(valid, key)
+	}
+	return newFuncGrpcOption(func(option *grpcOption) {
+		option.metadataKeys = valid
+	})
+}
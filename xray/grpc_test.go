@@ -424,6 +424,116 @@ func TestUnaryServerAndClientInterceptor(t *testing.T) {
 	assert.Equal(t, "TestVersion", seg.Service.Version)
 }
 
+func TestUnaryServerInterceptorMetadataAnnotations(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	lis := newGrpcServer(
+		t,
+		grpc.UnaryInterceptor(
+			UnaryServerInterceptor(
+				WithRecorder(GetRecorder(ctx)),
+				WithSegmentNamer(NewFixedSegmentNamer("test")),
+				WithMetadataAnnotations("X-Tenant-Id", "x-multi", "x-data-bin"))),
+	)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis)
+	defer closeFunc()
+
+	callCtx := metadata.AppendToOutgoingContext(context.Background(),
+		"x-tenant-id", "acme",
+		"authorization", "Bearer secret",
+		"x-multi", "a", "x-multi", "b",
+		"x-data-bin", "binary",
+	)
+	_, err := client.Ping(callCtx, &pb.PingRequest{Value: "something"})
+	require.NoError(t, err)
+
+	seg, err := td.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"x_tenant_id": "acme"}, seg.Annotations)
+}
+
+func TestUnaryClientInterceptorMetadataAnnotations(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	lis := newGrpcServer(t)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis,
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(WithMetadataAnnotations("x-tenant-id"))))
+	defer closeFunc()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	callCtx := metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "acme", "authorization", "Bearer secret")
+	_, err := client.Ping(callCtx, &pb.PingRequest{Value: "something"})
+	root.Close(nil)
+	require.NoError(t, err)
+
+	seg, err := td.Recv()
+	require.NoError(t, err)
+	var subseg *Segment
+	require.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+	assert.Equal(t, map[string]interface{}{"x_tenant_id": "acme"}, subseg.Annotations)
+}
+
+func TestUnaryClientInterceptorDeadline(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	lis := newGrpcServer(t)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis,
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()))
+	defer closeFunc()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	_, err := client.Ping(callCtx, &pb.PingRequest{Value: "something"})
+	require.NoError(t, err)
+	_, err = client.Ping(ctx, &pb.PingRequest{Value: "something"})
+	require.NoError(t, err)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	require.NoError(t, err)
+	require.Len(t, seg.Subsegments, 2)
+	var withDeadline, withoutDeadline *Segment
+	require.NoError(t, json.Unmarshal(seg.Subsegments[0], &withDeadline))
+	require.NoError(t, json.Unmarshal(seg.Subsegments[1], &withoutDeadline))
+
+	remaining, ok := withDeadline.Metadata["grpc"]["deadline_remaining_ms"].(float64)
+	require.True(t, ok)
+	assert.Greater(t, remaining, float64(50*time.Second/time.Millisecond))
+	assert.LessOrEqual(t, remaining, float64(time.Minute/time.Millisecond))
+	assert.NotContains(t, withoutDeadline.Metadata["grpc"], "deadline_remaining_ms")
+}
+
+func TestUnaryServerInterceptorDeadline(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	lis := newGrpcServer(
+		t,
+		grpc.UnaryInterceptor(
+			UnaryServerInterceptor(
+				WithRecorder(GetRecorder(ctx)),
+				WithSegmentNamer(NewFixedSegmentNamer("test")))),
+	)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis)
+	defer closeFunc()
+
+	callCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := client.Ping(callCtx, &pb.PingRequest{Value: "something"})
+	require.NoError(t, err)
+
+	seg, err := td.Recv()
+	require.NoError(t, err)
+	remaining, ok := seg.Metadata["grpc"]["deadline_remaining_ms"].(float64)
+	require.True(t, ok)
+	assert.Greater(t, remaining, float64(50*time.Second/time.Millisecond))
+	assert.LessOrEqual(t, remaining, float64(time.Minute/time.Millisecond))
+}
+
 func TestInferServiceName(t *testing.T) {
 	assert.Equal(t, "com.example.Service", inferServiceName("/com.example.Service/method"))
 }
@@ -11,12 +11,16 @@ package xray
 import (
 	"bytes"
 	"context"
+	"io"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/pattern"
 )
 
@@ -73,19 +77,138 @@ func (dSN *DynamicSegmentNamer) Name(host string) string {
 	return dSN.FallbackName
 }
 
+// RequestSegmentNamer is implemented by segment namers which name segments
+// from the whole incoming request rather than only its host. Handler and
+// HandlerWithContext use NameRequest instead of Name if it is implemented.
+type RequestSegmentNamer interface {
+	SegmentNamer
+	NameRequest(r *http.Request) string
+}
+
+// defaultMaxNamerBodySize is the number of body bytes a BodySegmentNamer
+// reads if no MaxBodySize is set.
+const defaultMaxNamerBodySize = 64 * 1024
+
+// BodySegmentNamer names segments with a function which may read the request
+// body, such as to use the operation name of a GraphQL or gRPC-Web request
+// rather than the single path all operations are served on. The body is
+// buffered while the function reads it and handed back to the handler
+// unchanged.
+type BodySegmentNamer struct {
+	// FallbackName is used if NameFunc fails, returns an empty name or the
+	// body is larger than MaxBodySize.
+	FallbackName string
+
+	// MaxBodySize is the largest body in bytes that is buffered for
+	// NameFunc. It defaults to 64 KiB.
+	MaxBodySize int64
+
+	// NameFunc returns the segment name for the request.
+	NameFunc func(r *http.Request) (string, error)
+}
+
+// NewBodySegmentNamer creates a new body segment namer which names segments
+// with fn and falls back to the fallback name.
+func NewBodySegmentNamer(fallback string, fn func(r *http.Request) (string, error)) *BodySegmentNamer {
+	return &BodySegmentNamer{
+		FallbackName: fallback,
+		NameFunc:     fn,
+	}
+}
+
+// Name returns the fallback name, as the request body is not available.
+func (bSN *BodySegmentNamer) Name(host string) string {
+	return bSN.FallbackName
+}
+
+// NameRequest returns the segment name NameFunc chooses for r. NameFunc sees
+// a copy of r whose body holds at most MaxBodySize bytes, and r.Body is
+// replaced so that the handler still reads the whole body.
+func (bSN *BodySegmentNamer) NameRequest(r *http.Request) string {
+	if bSN.NameFunc == nil {
+		return bSN.FallbackName
+	}
+
+	peek := r
+	if r.Body != nil && r.Body != http.NoBody {
+		maxSize := bSN.MaxBodySize
+		if maxSize <= 0 {
+			maxSize = defaultMaxNamerBodySize
+		}
+
+		body := r.Body
+		buf, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+		r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+		if err != nil {
+			logger.Debugf("Unable to read request body for segment name: %v", err)
+			return bSN.FallbackName
+		}
+		if int64(len(buf)) > maxSize {
+			logger.Debugf("Request body exceeds %d bytes. Using fallback segment name", maxSize)
+			return bSN.FallbackName
+		}
+
+		peek = r.WithContext(r.Context())
+		peek.Body = io.NopCloser(bytes.NewReader(buf))
+	}
+
+	name, err := bSN.NameFunc(peek)
+	if err != nil {
+		logger.Debugf("Unable to name segment from request: %v", err)
+		return bSN.FallbackName
+	}
+	if name == "" {
+		return bSN.FallbackName
+	}
+	return name
+}
+
+// replayBody returns the buffered start of a request body followed by the
+// rest of the original body, which it closes.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// segmentName names the segment for r using sn.
+func segmentName(sn SegmentNamer, r *http.Request) string {
+	if rsn, ok := sn.(RequestSegmentNamer); ok {
+		return rsn.NameRequest(r)
+	}
+	return sn.Name(r.Host)
+}
+
 // HandlerWithContext wraps the provided http handler and context to parse
 // the incoming headers, add response headers if needed, and sets HTTP
 // specific trace fields. HandlerWithContext names the generated segments
 // using the provided SegmentNamer.
+//
+// Handlers nested in another handler tracing the request, such as the
+// handler of a sub-router wrapped with its own SegmentNamer, do not begin a
+// second segment: the request keeps the segment of the outermost handler
+// and the nested handler records a subsegment named using its SegmentNamer,
+// with the configuration of the outermost handler.
 func HandlerWithContext(ctx context.Context, sn SegmentNamer, h http.Handler) http.Handler {
 	cfg := GetRecorder(ctx)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := sn.Name(r.Host)
+		if traceNested(sn, h, w, r) {
+			return
+		}
+		name := segmentName(sn, r)
 
-		traceHeader := header.FromString(r.Header.Get(TraceIDHeaderKey))
+		traceHeader := header.FromString(r.Header.Get(traceHeaderName(cfg)))
 		ctx := context.WithValue(r.Context(), RecorderContextKey{}, cfg)
 		c, seg := NewSegmentFromHeader(ctx, name, r, traceHeader)
-		defer seg.Close(nil)
+		caller := inferredCaller(seg, r, traceHeader)
+		defer func() {
+			if p := recover(); p != nil {
+				seg.closeOnPanic(p)
+				emitInferredCaller(caller, seg)
+				panic(p)
+			}
+			seg.Close(nil)
+			emitInferredCaller(caller, seg)
+		}()
 		r = r.WithContext(c)
 
 		HttpTrace(seg, h, w, r, traceHeader)
@@ -96,32 +219,182 @@ func HandlerWithContext(ctx context.Context, sn SegmentNamer, h http.Handler) ht
 // using the request's context, parsing the incoming headers,
 // adding response headers if needed, and sets HTTP specific trace fields.
 // Handler names the generated segments using the provided SegmentNamer.
+// Handlers nested in another handler tracing the request record a
+// subsegment, as with HandlerWithContext.
 func Handler(sn SegmentNamer, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := sn.Name(r.Host)
+		if traceNested(sn, h, w, r) {
+			return
+		}
+		name := segmentName(sn, r)
 
-		traceHeader := header.FromString(r.Header.Get(TraceIDHeaderKey))
+		traceHeader := header.FromString(r.Header.Get(traceHeaderName(GetRecorder(r.Context()))))
 		ctx, seg := NewSegmentFromHeader(r.Context(), name, r, traceHeader)
-		defer seg.Close(nil)
+		caller := inferredCaller(seg, r, traceHeader)
+		defer func() {
+			if p := recover(); p != nil {
+				seg.closeOnPanic(p)
+				emitInferredCaller(caller, seg)
+				panic(p)
+			}
+			seg.Close(nil)
+			emitInferredCaller(caller, seg)
+		}()
 		r = r.WithContext(ctx)
 
 		HttpTrace(seg, h, w, r, traceHeader)
 	})
 }
 
+// ConfigureServer traces every request served by srv by wrapping srv.Handler,
+// or http.DefaultServeMux if it is nil, with Handler. Segments are named using
+// the provided SegmentNamer. Call ConfigureServer once, before srv starts
+// serving.
+//
+// Requests are traced with the configuration carried by their context,
+// falling back to the global configuration. To trace a server with its own
+// configuration, set srv.BaseContext to return a context created with
+// ContextWithConfig.
+//
+// Handlers served by srv which are also wrapped with Handler or
+// HandlerWithContext record a subsegment of the segment of the server.
+func ConfigureServer(srv *http.Server, sn SegmentNamer) {
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	srv.Handler = Handler(sn, h)
+}
+
+// PatternSegmentNamer is implemented by segment namers which name segments
+// after the pattern of the ServeMux route matching the request, such as
+// "/users/". Handlers returned by WrapMux use NamePattern instead of Name or
+// NameRequest if it is implemented. pattern is empty for requests matching
+// no route.
+type PatternSegmentNamer interface {
+	SegmentNamer
+	NamePattern(host, pattern string) string
+}
+
+// WrapMux traces every request served by mux, or http.DefaultServeMux if it
+// is nil, like Handler, and names the segments using the provided
+// SegmentNamer. Routing is left to mux, so routes registered on it after
+// WrapMux is called are traced as well. If sn implements PatternSegmentNamer
+// segments can be named after the route matching the request.
+//
+// A ServeMux registered as the handler of a route of mux is traced as part of
+// that route, and only the pattern of mux is passed to NamePattern. A nested
+// mux wrapped with WrapMux as well, with the SegmentNamer of its sub-tree,
+// records a subsegment named after its own route.
+func WrapMux(mux *http.ServeMux, sn SegmentNamer) http.Handler {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	return Handler(&muxSegmentNamer{mux: mux, namer: sn}, mux)
+}
+
+// muxSegmentNamer passes the pattern of the route of mux matching a request
+// to namer if it is a PatternSegmentNamer.
+type muxSegmentNamer struct {
+	mux   *http.ServeMux
+	namer SegmentNamer
+}
+
+func (m *muxSegmentNamer) Name(host string) string {
+	return m.namer.Name(host)
+}
+
+func (m *muxSegmentNamer) NameRequest(r *http.Request) string {
+	if psn, ok := m.namer.(PatternSegmentNamer); ok {
+		_, pattern := m.mux.Handler(r)
+		return psn.NamePattern(r.Host, pattern)
+	}
+	return segmentName(m.namer, r)
+}
+
+// traceNested serves r with h in a subsegment named using sn if r is already
+// traced by an enclosing handler, and reports whether it did.
+func traceNested(sn SegmentNamer, h http.Handler, w http.ResponseWriter, r *http.Request) bool {
+	if openSegment(r.Context()) == nil {
+		return false
+	}
+
+	ctx, subseg := BeginSubsegment(r.Context(), segmentName(sn, r))
+	defer func() {
+		if p := recover(); p != nil {
+			subseg.closeOnPanic(p)
+			panic(p)
+		}
+		subseg.Close(nil)
+	}()
+	h.ServeHTTP(w, r.WithContext(ctx))
+	return true
+}
+
 func HttpTrace(seg *Segment, h http.Handler, w http.ResponseWriter, r *http.Request, traceHeader *header.Header) {
 	httpCaptureRequest(seg, r)
+	r = captureCorrelationID(seg, r)
 	traceIDHeaderValue := generateTraceIDHeaderValue(seg, traceHeader)
-	w.Header().Set(TraceIDHeaderKey, traceIDHeaderValue)
+	w.Header().Set(traceHeaderName(seg.ParentSegment.Configuration), traceIDHeaderValue)
+
+	var body *timedBody
+	if seg.GetConfiguration().CaptureRequestBodyReadTime && r.Body != nil && r.Body != http.NoBody {
+		body = &timedBody{ReadCloser: r.Body}
+		r = r.WithContext(r.Context())
+		r.Body = body
+	}
 
 	capturer := &responseCapturer{w, 200, 0}
 	resp := capturer.wrappedResponseWriter()
 	h.ServeHTTP(resp, r)
 
+	if body != nil {
+		seg.AddMetadata("http.request.body_read_time", body.stop().Seconds())
+	}
+
 	seg.Lock()
 	seg.GetHTTP().GetResponse().ContentLength, _ = strconv.Atoi(capturer.Header().Get("Content-Length"))
+	captureHeaders(seg, "http.response.headers", capturer.Header(), seg.GetConfiguration().CaptureResponseHeaders)
+	if seg.GetConfiguration().FaultOnRequestDeadline && r.Context().Err() == context.DeadlineExceeded {
+		seg.addError(r.Context().Err())
+	}
+	statusSet := seg.responseStatusSet
 	seg.Unlock()
-	HttpCaptureResponse(seg, capturer.status)
+	if !statusSet {
+		HttpCaptureResponse(seg, capturer.status)
+	}
+}
+
+// timedBody counts the time spent in Read until the body has been read to
+// its end or stop is called.
+type timedBody struct {
+	io.ReadCloser
+
+	mu      sync.Mutex
+	elapsed time.Duration
+	done    bool
+}
+
+func (tb *timedBody) Read(p []byte) (int, error) {
+	start := clock.Now()
+	n, err := tb.ReadCloser.Read(p)
+	elapsed := clock.Now().Sub(start)
+
+	tb.mu.Lock()
+	if !tb.done {
+		tb.elapsed += elapsed
+		tb.done = err == io.EOF
+	}
+	tb.mu.Unlock()
+	return n, err
+}
+
+// stop ends the count and returns the time spent reading.
+func (tb *timedBody) stop() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.done = true
+	return tb.elapsed
 }
 
 func clientIP(r *http.Request) (string, bool) {
@@ -144,6 +417,8 @@ func btoi(b bool) int {
 }
 
 // generateTraceIDHeaderValue generates value for _x_amzn_trace_id header key
+// of the response. A sampling decision requested with Sampled=? is answered
+// with the decision made for seg.
 func generateTraceIDHeaderValue(seg *Segment, traceHeader *header.Header) string {
 	seg.Lock()
 	defer seg.Unlock()
@@ -201,4 +476,129 @@ func httpCaptureRequest(seg *Segment, r *http.Request) {
 	seg.GetHTTP().GetRequest().URL = scheme + r.Host + r.URL.Path
 	seg.GetHTTP().GetRequest().ClientIP, seg.GetHTTP().GetRequest().XForwardedFor = clientIP(r)
 	seg.GetHTTP().GetRequest().UserAgent = r.UserAgent()
+	captureHeaders(seg, "http.request.headers", r.Header, seg.GetConfiguration().CaptureRequestHeaders)
+}
+
+// CorrelationIDAnnotationKey is the annotation recording the value of the
+// Config.CorrelationIDHeader of a request.
+const CorrelationIDAnnotationKey = "correlation_id"
+
+// captureCorrelationID annotates seg with the correlation ID of r, which is
+// generated if r has none and Config.CorrelationIDGenerator is set. It
+// returns the request to pass to the handler, a copy of r with the
+// generated ID set on its header if one was generated.
+func captureCorrelationID(seg *Segment, r *http.Request) *http.Request {
+	cfg := seg.GetConfiguration()
+	if cfg.CorrelationIDHeader == "" {
+		return r
+	}
+
+	id := r.Header.Get(cfg.CorrelationIDHeader)
+	if id == "" {
+		if cfg.CorrelationIDGenerator == nil {
+			return r
+		}
+		id = cfg.CorrelationIDGenerator()
+		r = r.WithContext(r.Context())
+		r.Header = r.Header.Clone()
+		r.Header.Set(cfg.CorrelationIDHeader, id)
+	}
+	if err := seg.AddAnnotation(CorrelationIDAnnotationKey, id); err != nil {
+		logger.Debugf("Unable to annotate the correlation ID: %v", err)
+	}
+	return r
+}
+
+// InferredAnnotationKey is the annotation marking the placeholder segments
+// sent for the callers named by Config.InferredCallerHeader.
+const InferredAnnotationKey = "inferred"
+
+// inferredCaller returns a placeholder segment for the caller named by the
+// Config.InferredCallerHeader of r and makes it the parent of seg, or nil if
+// there is no such caller or seg already has a parent.
+func inferredCaller(seg *Segment, r *http.Request, traceHeader *header.Header) *Segment {
+	cfg := seg.GetConfiguration()
+	if cfg.InferredCallerHeader == "" || seg.Dummy || traceHeader.ParentID != "" {
+		return nil
+	}
+	name := r.Header.Get(cfg.InferredCallerHeader)
+	if name == "" {
+		return nil
+	}
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+	caller := &Segment{
+		Name:          name,
+		ID:            NewSegmentID(),
+		TraceID:       seg.TraceID,
+		StartTime:     seg.StartTime,
+		Annotations:   map[string]interface{}{InferredAnnotationKey: true},
+		Configuration: seg.Configuration,
+	}
+	seg.ParentID = caller.ID
+	return caller
+}
+
+// emitInferredCaller sends caller, the placeholder returned by
+// inferredCaller, once seg is closed, ending it with seg.
+func emitInferredCaller(caller, seg *Segment) {
+	if caller == nil {
+		return
+	}
+	seg.RLock()
+	caller.EndTime = seg.EndTime
+	seg.RUnlock()
+	if err := Emit(caller); err != nil {
+		logger.Errorf("Unable to emit the segment of inferred caller %q: %v", caller.Name, err)
+	}
+}
+
+// sensitiveHeaders lists headers carrying credentials, which are only
+// captured when configured explicitly.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// warnSensitiveHeaders logs a warning for every header in names that may
+// carry credentials.
+func warnSensitiveHeaders(names []string) {
+	for _, name := range names {
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				logger.Warnf("capturing the %s header records credentials into segment metadata", sensitive)
+			}
+		}
+	}
+}
+
+// captureHeaders stores the values of the headers listed in names into the
+// default metadata namespace of seg under key. The caller of captureHeaders
+// should have write lock on seg instance.
+func captureHeaders(seg *Segment, key string, h http.Header, names []string) {
+	if len(names) == 0 || seg.Dummy {
+		return
+	}
+
+	captured := make(map[string]interface{})
+	for k, v := range h {
+		for _, name := range names {
+			if strings.EqualFold(k, name) {
+				captured[k] = strings.Join(v, ", ")
+				break
+			}
+		}
+	}
+	if len(captured) == 0 {
+		return
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata["default"] == nil {
+		seg.Metadata["default"] = map[string]interface{}{}
+	}
+	seg.Metadata["default"][key] = captured
 }
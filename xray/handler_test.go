@@ -9,14 +9,19 @@
 package xray
 
 import (
+	"context"
+	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,6 +36,63 @@ func TestNewDynamicSegmentName(t *testing.T) {
 	assert.Equal(t, "a/b/c", n.RecognizedHosts)
 }
 
+func graphQLOperationName(r *http.Request) (string, error) {
+	var body struct {
+		OperationName string `json:"operationName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.OperationName, nil
+}
+
+func TestBodySegmentNamer(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxSize  int64
+		wantName string
+	}{
+		{"operation name", `{"operationName":"GetUser","query":"query GetUser { user { id } }"}`, 0, "GetUser"},
+		{"no operation name", `{"query":"{ user { id } }"}`, 0, "graphql"},
+		{"invalid body", `not json`, 0, "graphql"},
+		{"body too large", `{"operationName":"GetUser"}`, 8, "graphql"},
+		{"empty body", ``, 0, "graphql"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			var handlerBody string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				handlerBody = string(b)
+				assert.NoError(t, r.Body.Close())
+			})
+
+			sn := NewBodySegmentNamer("graphql", graphQLOperationName)
+			sn.MaxBodySize = test.maxSize
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/graphql", strings.NewReader(test.body))
+			HandlerWithContext(ctx, sn, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.wantName, seg.Name)
+			assert.Equal(t, test.body, handlerBody)
+		})
+	}
+}
+
+func TestBodySegmentNamerName(t *testing.T) {
+	sn := NewBodySegmentNamer("graphql", graphQLOperationName)
+	assert.Equal(t, "graphql", sn.Name("example.com"))
+}
+
 func TestHandlerWithContextForRootHandler(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -74,6 +136,40 @@ func TestHandlerWithContextForRootHandler(t *testing.T) {
 	assert.Equal(t, "TestVersion", seg.Service.Version)
 }
 
+func TestHandlerWithContextCustomTraceHeaderName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).TraceHeaderName = "Amzn-Trace-Id"
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set("Amzn-Trace-Id", "Root=1-57ff426a-80c11c39b0c928905eb0828d; Parent=reqid; Sampled=1")
+	req.Header.Set(TraceIDHeaderKey, "Root=1-57ff426a-00000000000000000000000; Parent=other; Sampled=1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	ts.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", seg.TraceID)
+	assert.Equal(t, "reqid", seg.ParentID)
+}
+
 func TestHandlerWithContextForNonRootHandler(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -112,6 +208,566 @@ func TestHandlerWithContextForNonRootHandler(t *testing.T) {
 	assert.Equal(t, "TestVersion", seg.Service.Version)
 }
 
+func TestHandlerWithALBTraceHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var self string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		self = GetSegment(r.Context()).IncomingHeader.Self
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set(TraceIDHeaderKey, "Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678;Sampled=1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, "Root=1-67891233-abcdef012345678912345678;Sampled=1", resp.Header.Get(TraceIDHeaderKey))
+
+	// make sure all connections are closed.
+	ts.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The load balancer sends no segment, so its Self ID is not the parent.
+	assert.Equal(t, "1-67891234-12456789abcdef012345678", self)
+	assert.Equal(t, "1-67891233-abcdef012345678912345678", seg.TraceID)
+	assert.Empty(t, seg.ParentID)
+}
+
+func TestConfigureServer(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, GetSegment(r.Context()))
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	ConfigureServer(ts.Config, NewFixedSegmentNamer("test"))
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(TraceIDHeaderKey))
+
+	// make sure all connections are closed.
+	ts.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+	assert.Equal(t, "TestVersion", seg.Service.Version)
+	assert.Equal(t, http.StatusAccepted, seg.HTTP.Response.Status)
+	assert.Equal(t, "/users", strings.TrimPrefix(seg.HTTP.Request.URL, ts.URL))
+}
+
+func TestConfigureServerDefaultServeMux(t *testing.T) {
+	srv := &http.Server{}
+	ConfigureServer(srv, NewFixedSegmentNamer("test"))
+	assert.NotNil(t, srv.Handler)
+}
+
+// routeSegmentNamer names segments after the matched ServeMux pattern.
+type routeSegmentNamer struct{}
+
+func (routeSegmentNamer) Name(host string) string { return "fallback" }
+
+func (routeSegmentNamer) NamePattern(host, pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	return "route " + pattern
+}
+
+func TestWrapMux(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, GetSegment(r.Context()))
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ts := httptest.NewUnstartedServer(WrapMux(mux, routeSegmentNamer{}))
+	ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	ts.Start()
+	defer ts.Close()
+
+	// Routes registered after wrapping are traced as well.
+	mux.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		path   string
+		name   string
+		status int
+	}{
+		{"/users/42", "route /users/", http.StatusAccepted},
+		{"/orders", "route /orders", http.StatusOK},
+		{"/missing", "unmatched", http.StatusNotFound},
+	} {
+		resp, err := http.Get(ts.URL + tc.path)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, tc.name, seg.Name)
+		assert.Equal(t, tc.status, seg.HTTP.Response.Status)
+	}
+}
+
+func TestWrapMuxPlainSegmentNamer(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewUnstartedServer(WrapMux(mux, NewFixedSegmentNamer("test")))
+	ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+}
+
+func TestNestedHandlers(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	admin := http.NewServeMux()
+	admin.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+		seg := GetSegment(r.Context())
+		if assert.NotNil(t, seg) {
+			assert.Equal(t, "admin", seg.Name)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", Handler(NewFixedSegmentNamer("admin"), admin))
+
+	ts := httptest.NewUnstartedServer(WrapMux(mux, routeSegmentNamer{}))
+	ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "route /admin/", seg.Name)
+	assert.Equal(t, http.StatusAccepted, seg.HTTP.Response.Status)
+	if assert.Len(t, seg.Subsegments, 1) {
+		var subseg *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+		assert.Equal(t, "admin", subseg.Name)
+	}
+
+	// No second root segment is emitted for the nested handler.
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestHandlerCapturesConfiguredHeaders(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	cfg := GetRecorder(ctx)
+	cfg.CaptureRequestHeaders = []string{"x-request-id", "Accept"}
+	cfg.CaptureResponseHeaders = []string{"X-Cache"}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("X-Other", "value")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, strings.NewReader(""))
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	// make sure all connections are closed.
+	ts.Close()
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"X-Request-Id": "abc",
+		"Accept":       "text/html, application/json",
+	}, seg.Metadata["default"]["http.request.headers"])
+	assert.Equal(t, map[string]interface{}{
+		"X-Cache": "HIT",
+	}, seg.Metadata["default"]["http.response.headers"])
+	assert.Empty(t, seg.Annotations)
+}
+
+func TestHandlerPanicClosesOpenSubsegments(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, _ := BeginSubsegment(r.Context(), "outer")
+		BeginSubsegment(ctx, "inner")
+		panic("handler panic")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.PanicsWithValue(t, "handler panic", func() {
+		HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, seg.Fault)
+	if assert.Len(t, seg.Cause.Exceptions, 1) {
+		assert.Equal(t, "handler panic", seg.Cause.Exceptions[0].Message)
+		assert.Equal(t, "panic", seg.Cause.Exceptions[0].Type)
+		assert.Equal(t, "TestHandlerPanicClosesOpenSubsegments.func1", seg.Cause.Exceptions[0].Stack[0].Label)
+	}
+
+	var outer *Segment
+	if !assert.Len(t, seg.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &outer)) {
+		return
+	}
+	assert.Equal(t, "outer", outer.Name)
+	assert.True(t, outer.Fault)
+	assert.False(t, outer.InProgress)
+
+	var inner *Segment
+	if !assert.Len(t, outer.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(outer.Subsegments[0], &inner)) {
+		return
+	}
+	assert.Equal(t, "inner", inner.Name)
+	assert.True(t, inner.Fault)
+	assert.False(t, inner.InProgress)
+}
+
+func TestHandlerCloseSegmentsOnCancel(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CloseSegmentsOnCancel = true
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, subseg := BeginSubsegment(r.Context(), "stream")
+		defer subseg.Close(nil)
+		close(started)
+		<-r.Context().Done()
+		<-release
+	})
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(reqCtx)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	<-started
+	cancel()
+
+	// The segment is sent on cancellation, before the handler returns.
+	seg, err := td.Recv()
+	close(release)
+	<-served
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, true, seg.Annotations[CanceledAnnotationKey])
+	assert.False(t, seg.Fault)
+	var subseg *Segment
+	if assert.Len(t, seg.Subsegments, 1) && assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, "stream", subseg.Name)
+		assert.False(t, subseg.InProgress)
+		assert.Equal(t, true, subseg.Annotations[CanceledAnnotationKey])
+	}
+
+	// Neither the handler returning nor the late close sends it again.
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestHandlerFaultOnRequestDeadline(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		exceed    bool
+		wantFault bool
+	}{
+		{"deadline exceeded", true, true, true},
+		{"completed before deadline", true, false, false},
+		{"disabled", false, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).FaultOnRequestDeadline = test.enabled
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.exceed {
+					<-r.Context().Done()
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			reqCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(reqCtx)
+			HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.wantFault, seg.Fault)
+			if test.wantFault && assert.NotNil(t, seg.Cause) && assert.Len(t, seg.Cause.Exceptions, 1) {
+				assert.Equal(t, context.DeadlineExceeded.Error(), seg.Cause.Exceptions[0].Message)
+			}
+		})
+	}
+}
+
+// slowBody returns one byte of data per Read, advancing clock by a second
+// on every call.
+type slowBody struct {
+	clock *utils.MockClock
+	data  string
+}
+
+func (sb *slowBody) Read(p []byte) (int, error) {
+	sb.clock.Increment(1, 0)
+	if sb.data == "" {
+		return 0, io.EOF
+	}
+	n := copy(p[:1], sb.data)
+	sb.data = sb.data[n:]
+	return n, nil
+}
+
+func TestHandlerCaptureRequestBodyReadTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		readAll  bool
+		wantTime interface{}
+	}{
+		{"read to end", true, true, float64(4)},
+		{"partially read", true, false, float64(1)},
+		{"disabled", false, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).CaptureRequestBodyReadTime = test.enabled
+			clock := useMockClock(t, 1000)
+
+			var body io.Reader
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body = r.Body
+				if test.readAll {
+					io.ReadAll(r.Body)
+				} else {
+					r.Body.Read(make([]byte, 1))
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/", io.NopCloser(&slowBody{clock: clock, data: "abc"}))
+			HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+			// Reads after the handler returned are not counted.
+			body.Read(make([]byte, 1))
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.wantTime, seg.Metadata["default"]["http.request.body_read_time"])
+		})
+	}
+}
+
+func TestHandlerKeepsManualResponseStatus(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The status reaches the client through a writer Handler cannot see.
+		assert.NoError(t, SetHTTPResponseStatus(r.Context(), http.StatusServiceUnavailable))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, seg.HTTP.Response.Status)
+	assert.True(t, seg.Fault)
+}
+
+func TestHandlerCorrelationID(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	cfg := GetRecorder(ctx)
+	cfg.CorrelationIDHeader = "X-Request-Id"
+	cfg.CorrelationIDGenerator = func() string { return "generated" }
+
+	var seen []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("X-Request-Id"))
+	})
+	h := HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "abc", seg.Annotations[CorrelationIDAnnotationKey])
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	seg, err = td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "generated", seg.Annotations[CorrelationIDAnnotationKey])
+	assert.Equal(t, []string{"abc", "generated"}, seen)
+	assert.Empty(t, req.Header.Get("X-Request-Id"))
+
+	cfg.CorrelationIDGenerator = nil
+	h = HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	seg, err = td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, seg.Annotations, CorrelationIDAnnotationKey)
+}
+
+func TestHandlerInferredCaller(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).InferredCallerHeader = "X-Caller"
+
+	handler := HandlerWithContext(ctx, NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("X-Caller", "gateway")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	caller, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+	assert.Equal(t, "gateway", caller.Name)
+	assert.Equal(t, true, caller.Annotations[InferredAnnotationKey])
+	assert.Equal(t, seg.TraceID, caller.TraceID)
+	assert.Equal(t, caller.ID, seg.ParentID)
+	assert.Empty(t, caller.ParentID)
+	assert.Equal(t, seg.StartTime, caller.StartTime)
+	assert.Equal(t, seg.EndTime, caller.EndTime)
+
+	// Requests with a parent already are not given a placeholder.
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("X-Caller", "gateway")
+	req.Header.Set(TraceIDHeaderKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err = td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
 func TestXRayHandlerPreservesOptionalInterfaces(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -158,6 +814,37 @@ func BenchmarkHandler(b *testing.B) {
 	}
 }
 
+func TestHandlerSamplingRequested(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	strategy := &alternatingSamplingStrategy{}
+	GetRecorder(ctx).SamplingStrategy = strategy
+
+	ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer ts.Close()
+
+	for _, want := range []string{"Sampled=1", "Sampled=0"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		req.Header.Set(TraceIDHeaderKey, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;Sampled=?")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+		assert.Equal(t, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;"+want, resp.Header.Get(TraceIDHeaderKey))
+	}
+	assert.Equal(t, 2, strategy.calls)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+}
+
 func TestGenerateTraceIDHeaderValue(t *testing.T) {
 	type args struct {
 		seg         *Segment
@@ -287,3 +974,24 @@ func TestHTTPCaptureResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestClosedSegmentNotCanceled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CloseSegmentsOnCancel = true
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, subseg := BeginSubsegment(ctx, "running")
+	seg.Close(nil)
+	// The context of seg is canceled after seg was closed.
+	seg.handleContextDone(context.Canceled)
+
+	subseg.RLock()
+	assert.True(t, subseg.InProgress)
+	assert.NotContains(t, subseg.Annotations, CanceledAnnotationKey)
+	subseg.RUnlock()
+	seg.RLock()
+	assert.NotContains(t, seg.Annotations, CanceledAnnotationKey)
+	seg.RUnlock()
+	subseg.Close(nil)
+}
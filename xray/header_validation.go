@@ -0,0 +1,90 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+)
+
+// ValidateTraceHeaderRoundTrip checks that the trace header s, for instance
+// one sent by another X-Ray SDK, is propagated unchanged by this SDK: it
+// parses s with header.FromString, serializes it again with String, parses
+// the result and checks that the trace ID, parent ID, sampling decision,
+// lineage and additional data of s are all preserved. Attribute order,
+// whitespace and the case of the known keys do not matter. The Self
+// attribute is not checked, as it is never propagated.
+//
+// It returns nil if s round trips, or an error listing every attribute
+// which does not, such as malformed entries, repeated keys or unrecognized
+// sampling decisions. It is meant for testing compatibility with other
+// SDKs.
+func ValidateTraceHeaderRoundTrip(s string) error {
+	serialized := header.FromString(s).String()
+	h := header.FromString(serialized)
+
+	var diffs []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i == -1 {
+			diffs = append(diffs, fmt.Sprintf("entry %q is not a key=value pair and is dropped", part))
+			continue
+		}
+		key := strings.TrimSpace(part[:i])
+		value := strings.TrimSpace(part[i+1:])
+
+		var name, got string
+		switch {
+		case isHeaderKey(header.RootPrefix, key):
+			name, got = "Root", h.TraceID
+		case isHeaderKey(header.ParentPrefix, key):
+			name, got = "Parent", h.ParentID
+		case isHeaderKey(header.SampledPrefix, key):
+			name, got = "Sampled", strings.TrimPrefix(string(h.SamplingDecision), header.SampledPrefix)
+		case isHeaderKey(header.LineagePrefix, key):
+			name, got = "Lineage", h.Lineage
+		case isHeaderKey(header.SelfPrefix, key):
+			continue
+		default:
+			var ok bool
+			name = key
+			if got, ok = h.AdditionalData[key]; !ok {
+				diffs = append(diffs, fmt.Sprintf("%s: %q is dropped", name, value))
+				continue
+			}
+		}
+
+		if seen[name] {
+			diffs = append(diffs, fmt.Sprintf("%s: key is repeated and only its last value is kept", name))
+			continue
+		}
+		seen[name] = true
+		if got != value {
+			diffs = append(diffs, fmt.Sprintf("%s: %q becomes %q", name, value, got))
+		}
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("trace header %q does not round trip through %q: %s", s, serialized, strings.Join(diffs, "; "))
+	}
+	return nil
+}
+
+// isHeaderKey reports whether key is the key of the given header prefix,
+// ignoring case as header.FromString does.
+func isHeaderKey(prefix, key string) bool {
+	return strings.EqualFold(prefix, key+"=")
+}
@@ -0,0 +1,46 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTraceHeaderRoundTrip(t *testing.T) {
+	valid := []string{
+		"",
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;Sampled=1",
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86; Parent=53995c3f42cd8ad8; Sampled=0",
+		"Sampled=?;Root=1-57fbe041-2c7ad569f5d6ff149137be86",
+		"root=1-57fbe041-2c7ad569f5d6ff149137be86;parent=53995c3f42cd8ad8;sampled=1",
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Lineage=a87bd80c:1|68fd508a:5;Foo=bar;Debug=1",
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Self=1-67891234-12456789abcdef012345678",
+	}
+	for _, s := range valid {
+		assert.NoError(t, ValidateTraceHeaderRoundTrip(s), s)
+	}
+
+	invalid := map[string][]string{
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=2":       {`Sampled: "2" becomes ""`},
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Orphan":          {`entry "Orphan" is not a key=value pair`},
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Root=1-5759e988": {`Root: "1-57fbe041-2c7ad569f5d6ff149137be86" becomes "1-5759e988"`, "Root: key is repeated"},
+		"Root=1-57fbe041-2c7ad569f5d6ff149137be86;Foo=a;Foo=b":     {`Foo: "a" becomes "b"`, "Foo: key is repeated"},
+	}
+	for s, diffs := range invalid {
+		err := ValidateTraceHeaderRoundTrip(s)
+		if !assert.Error(t, err, s) {
+			continue
+		}
+		for _, diff := range diffs {
+			assert.Contains(t, err.Error(), diff)
+		}
+	}
+}
@@ -47,7 +47,7 @@ func (xt *HTTPSubsegments) GetConn(hostPort string) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
 	if GetSegment(xt.opCtx).safeInProgress() {
-		xt.connCtx, _ = BeginSubsegment(xt.opCtx, "connect")
+		xt.connCtx, _ = beginInternalSubsegment(xt.opCtx, "connect")
 	}
 }
 
@@ -57,7 +57,7 @@ func (xt *HTTPSubsegments) DNSStart(info httptrace.DNSStartInfo) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
-		xt.dnsCtx, _ = BeginSubsegment(xt.connCtx, "dns")
+		xt.dnsCtx, _ = beginInternalSubsegment(xt.connCtx, "dns")
 	}
 }
 
@@ -85,7 +85,7 @@ func (xt *HTTPSubsegments) ConnectStart(network, addr string) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
-		xt.connectCtx, _ = BeginSubsegment(xt.connCtx, "dial")
+		xt.connectCtx, _ = beginInternalSubsegment(xt.connCtx, "dial")
 	}
 }
 
@@ -111,7 +111,7 @@ func (xt *HTTPSubsegments) TLSHandshakeStart() {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
-		xt.tlsCtx, _ = BeginSubsegment(xt.connCtx, "tls")
+		xt.tlsCtx, _ = beginInternalSubsegment(xt.connCtx, "tls")
 	}
 }
 
@@ -164,7 +164,7 @@ func (xt *HTTPSubsegments) GotConn(info *httptrace.GotConnInfo, err error) {
 		}
 
 		if err == nil {
-			xt.reqCtx, _ = BeginSubsegment(xt.opCtx, "request")
+			xt.reqCtx, _ = beginInternalSubsegment(xt.opCtx, "request")
 		}
 
 	}
@@ -178,7 +178,7 @@ func (xt *HTTPSubsegments) WroteRequest(info httptrace.WroteRequestInfo) {
 	defer xt.mu.Unlock()
 	if xt.reqCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
 		GetSegment(xt.reqCtx).Close(info.Err)
-		resCtx, _ := BeginSubsegment(xt.opCtx, "response")
+		resCtx, _ := beginInternalSubsegment(xt.opCtx, "response")
 		xt.responseCtx = resCtx
 	}
 
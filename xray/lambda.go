@@ -16,11 +16,16 @@ import (
 
 	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
 )
 
 // LambdaTraceHeaderKey is key to get trace header from context.
 const LambdaTraceHeaderKey string = "x-amzn-trace-id"
 
+// LambdaTraceHeaderEnvKey is the key to get the trace header of the current
+// invocation from environment variable.
+const LambdaTraceHeaderEnvKey string = "_X_AMZN_TRACE_ID"
+
 // LambdaTaskRootKey is the key to get Lambda Task Root from environment variable.
 const LambdaTaskRootKey string = "LAMBDA_TASK_ROOT"
 
@@ -30,18 +35,66 @@ const SDKInitializedFileFolder string = "/tmp/.aws-xray"
 // SDKInitializedFileName records the SDK initialized file name.
 const SDKInitializedFileName string = "initialized"
 
-func getTraceHeaderFromContext(ctx context.Context) *header.Header {
-	var traceHeader string
+// LambdaFunctionOrigin is the origin of segments recorded within a Lambda function.
+const LambdaFunctionOrigin string = "AWS::Lambda::Function"
 
-	if traceHeaderValue := ctx.Value(LambdaTraceHeaderKey); traceHeaderValue != nil {
-		traceHeader = traceHeaderValue.(string)
+func getTraceHeaderFromContext(ctx context.Context) *header.Header {
+	if traceHeader, ok := ctx.Value(LambdaTraceHeaderKey).(string); ok && traceHeader != "" {
 		return header.FromString(traceHeader)
 	}
 	return nil
 }
 
+func getTraceHeaderFromEnv() *header.Header {
+	if traceHeader := os.Getenv(LambdaTraceHeaderEnvKey); traceHeader != "" {
+		if h := header.FromString(traceHeader); h.TraceID != "" {
+			return h
+		}
+	}
+	return nil
+}
+
+// BeginLambdaSubsegment creates a subsegment for a given name and context
+// within an AWS Lambda function. The parent of the subsegment is looked up in
+// the following order:
+//
+//  1. the segment or subsegment in ctx
+//  2. the trace header stored in ctx under LambdaTraceHeaderKey, which
+//     aws-lambda-go sets for every invocation
+//  3. the trace header in the _X_AMZN_TRACE_ID environment variable, which
+//     the Lambda runtime sets for the current invocation
+//
+// If none is found, the context missing strategy is applied as with
+// BeginSubsegment. Unlike BeginSubsegment, which only falls back to the
+// environment variable when LAMBDA_TASK_ROOT is set, BeginLambdaSubsegment
+// always consults it.
+func BeginLambdaSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
+	if SdkDisabled() || GetSegment(ctx) != nil || getTraceHeaderFromContext(ctx) != nil {
+		return BeginSubsegment(ctx, name)
+	}
+
+	if h := getTraceHeaderFromEnv(); h != nil {
+		ctx, _ = BeginFacadeSegment(ctx, "facade", h)
+	}
+	return BeginSubsegment(ctx, name)
+}
+
+// getLambdaTraceHeader returns the trace header of the current invocation
+// from ctx. Code running without the handler's context, for instance during
+// init or in a goroutine, falls back to the _X_AMZN_TRACE_ID environment
+// variable when running in AWS Lambda.
+func getLambdaTraceHeader(ctx context.Context) *header.Header {
+	if h := getTraceHeaderFromContext(ctx); h != nil {
+		return h
+	}
+	if getLambdaTaskRoot() != "" {
+		return getTraceHeaderFromEnv()
+	}
+	return nil
+}
+
 func newFacadeSegment(ctx context.Context) (context.Context, *Segment) {
-	traceHeader := getTraceHeaderFromContext(ctx)
+	traceHeader := getLambdaTraceHeader(ctx)
 	return BeginFacadeSegment(ctx, "facade", traceHeader)
 }
 
@@ -51,6 +104,10 @@ func getLambdaTaskRoot() string {
 
 func initLambda() {
 	if getLambdaTaskRoot() != "" {
+		if plugins.InstancePluginMetadata != nil && plugins.InstancePluginMetadata.Origin == "" {
+			plugins.InstancePluginMetadata.Origin = LambdaFunctionOrigin
+		}
+
 		now := time.Now()
 		filePath, err := createFile(SDKInitializedFileFolder, SDKInitializedFileName)
 		if err != nil {
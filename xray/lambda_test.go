@@ -4,10 +4,12 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,7 +76,7 @@ func testHelper(ctx context.Context, t *testing.T, td *TestDaemon, sampled bool)
 
 	// Perform Request
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, strings.NewReader(""))
-	req.Header.Add(TraceIDHeaderKey, generateHeader(subseg).String())
+	req.Header.Add(TraceIDHeaderKey, BuildOutboundHeader(subseg).String())
 	resp, _ := http.DefaultClient.Do(req)
 
 	// Close the test server down
@@ -100,17 +102,119 @@ func testHelper(ctx context.Context, t *testing.T, td *TestDaemon, sampled bool)
 	}
 }
 
-func generateHeader(seg *Segment) header.Header {
-	var samplingDecision = header.Sampled
-	if !seg.Sampled {
-		samplingDecision = header.NotSampled
+func TestLambdaFacadeSegmentOrigin(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, ExampleTraceHeader)
+	_, subseg := BeginSubsegment(ctx, "test-lambda")
+	defer subseg.Close(nil)
+
+	assert.True(t, subseg.ParentSegment.Facade)
+	assert.Equal(t, LambdaFunctionOrigin, subseg.ParentSegment.Origin)
+}
+
+func TestInitLambdaSetsOrigin(t *testing.T) {
+	origin := plugins.InstancePluginMetadata.Origin
+	defer func() { plugins.InstancePluginMetadata.Origin = origin }()
+	plugins.InstancePluginMetadata.Origin = ""
+
+	os.Setenv(LambdaTaskRootKey, "/var/task")
+	defer os.Unsetenv(LambdaTaskRootKey)
+	initLambda()
+
+	assert.Equal(t, LambdaFunctionOrigin, plugins.InstancePluginMetadata.Origin)
+}
+
+func TestBeginLambdaSubsegmentFromContextKey(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTraceHeaderEnvKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, ExampleTraceHeader)
+	_, subseg := BeginLambdaSubsegment(ctx, "test-lambda")
+	if !assert.NotNil(t, subseg) {
+		return
 	}
+	subseg.Close(nil)
 
-	return header.Header{
-		TraceID:          seg.TraceID,
-		ParentID:         seg.ID,
-		SamplingDecision: samplingDecision,
+	assert.True(t, subseg.ParentSegment.Facade)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", subseg.TraceID)
+	assert.Equal(t, "1234abcd1234abcd", subseg.ParentID)
+}
 
-		AdditionalData: make(map[string]string),
+func TestBeginLambdaSubsegmentFromEnv(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTraceHeaderEnvKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+
+	_, subseg := BeginLambdaSubsegment(ctx, "test-lambda")
+	if !assert.NotNil(t, subseg) {
+		return
+	}
+	subseg.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
 	}
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", seg.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+	assert.Equal(t, "test-lambda", seg.Name)
+	assert.Equal(t, "subsegment", seg.Type)
+}
+
+func TestBeginLambdaSubsegmentPrefersSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTraceHeaderEnvKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, subseg := BeginLambdaSubsegment(ctx, "test-lambda")
+	if !assert.NotNil(t, subseg) {
+		return
+	}
+	assert.Equal(t, seg, subseg.ParentSegment)
+	subseg.Close(nil)
+	seg.Close(nil)
+}
+
+func TestBeginLambdaSubsegmentWithoutTraceHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTraceHeaderEnvKey, "")
+
+	_, subseg := BeginLambdaSubsegment(ctx, "test-lambda")
+	assert.Nil(t, subseg)
+}
+
+func TestBeginSubsegmentFallsBackToLambdaEnv(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTaskRootKey, "/var/task")
+	t.Setenv(LambdaTraceHeaderEnvKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+
+	_, subseg := BeginSubsegment(ctx, "init")
+	if !assert.NotNil(t, subseg) {
+		return
+	}
+	subseg.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", seg.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+	assert.Equal(t, "init", seg.Name)
+}
+
+func TestBeginSubsegmentIgnoresLambdaEnvOutsideLambda(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv(LambdaTaskRootKey, "")
+	t.Setenv(LambdaTraceHeaderEnvKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+
+	_, subseg := BeginSubsegment(ctx, "init")
+	assert.Nil(t, subseg)
 }
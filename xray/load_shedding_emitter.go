@@ -0,0 +1,176 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+)
+
+const (
+	// autoShedErrorRate is the fraction of recent writes failing from which
+	// automatic shedding increases.
+	autoShedErrorRate = 0.25
+
+	// autoShedStep is how much automatic shedding increases for every emit
+	// while at least autoShedErrorRate of the recent writes failed.
+	autoShedStep = 0.02
+
+	// autoShedRecovery is how much automatic shedding decreases for every
+	// other emit.
+	autoShedRecovery = 0.01
+
+	// autoShedMax is the highest fraction automatic shedding reaches, so
+	// that some traces still get through to show whether the daemon has
+	// recovered.
+	autoShedMax = 0.9
+)
+
+// writeErrorRater is implemented by emitters keeping track of how many of
+// their recent writes failed, such as DefaultEmitter.
+type writeErrorRater interface {
+	RecentWriteErrorRate() float64
+}
+
+// LoadSheddingEmitter drops a fraction of the traces emitted to an inner
+// emitter, as a last resort against overloading the daemon. Traces are shed
+// whole: whether a trace is shed depends only on its trace ID and the shed
+// rate, so the streamed subsegments of a trace are shed along with its
+// segment as long as the rate does not change in between.
+//
+// The rate is the fraction given to SetShedFraction or, if AutoShed is set
+// and higher, a fraction raised while the inner emitter fails to write.
+type LoadSheddingEmitter struct {
+	// shed is accessed atomically and kept first for 64-bit alignment.
+	shed uint64
+
+	// AutoShed makes the emitter shed more traces while many of the recent
+	// writes of the inner emitter fail, as DefaultEmitter reports with
+	// RecentWriteErrorRate: every emit raises the rate by 0.02, up to 0.9,
+	// while at least a quarter of the recent writes failed, and lowers it by
+	// 0.01 otherwise. It has no effect for inner emitters not reporting a
+	// RecentWriteErrorRate. It must be set before the emitter is used.
+	AutoShed bool
+
+	inner Emitter
+
+	mu           sync.Mutex
+	fraction     float64
+	autoFraction float64
+}
+
+// NewLoadSheddingEmitter initializes and returns a pointer to an instance of
+// LoadSheddingEmitter which emits to inner all but shedFraction, between 0
+// and 1, of the traces.
+func NewLoadSheddingEmitter(inner Emitter, shedFraction float64) (*LoadSheddingEmitter, error) {
+	if inner == nil {
+		return nil, errors.New("inner emitter must not be nil")
+	}
+	lse := &LoadSheddingEmitter{inner: inner}
+	if err := lse.SetShedFraction(shedFraction); err != nil {
+		return nil, err
+	}
+	return lse, nil
+}
+
+// SetShedFraction sets the fraction, between 0 and 1, of the traces to shed,
+// for instance from an operator toggle. Automatic shedding is kept if
+// higher.
+func (lse *LoadSheddingEmitter) SetShedFraction(fraction float64) error {
+	if math.IsNaN(fraction) || fraction < 0 || fraction > 1 {
+		return fmt.Errorf("shed fraction %v must be between 0 and 1", fraction)
+	}
+	lse.mu.Lock()
+	lse.fraction = fraction
+	lse.mu.Unlock()
+	return nil
+}
+
+// ShedRate returns the fraction of the traces currently shed.
+func (lse *LoadSheddingEmitter) ShedRate() float64 {
+	lse.mu.Lock()
+	defer lse.mu.Unlock()
+	return lse.rate()
+}
+
+// rate returns the fraction of the traces to shed.
+// lse has a lock acquired by the caller.
+func (lse *LoadSheddingEmitter) rate() float64 {
+	return math.Max(lse.fraction, lse.autoFraction)
+}
+
+// ShedCount returns the number of segments and streamed subsegments shed.
+func (lse *LoadSheddingEmitter) ShedCount() uint64 {
+	return atomic.LoadUint64(&lse.shed)
+}
+
+// Emit emits seg to the inner emitter unless its trace is shed.
+// seg has a write lock acquired by the caller.
+func (lse *LoadSheddingEmitter) Emit(seg *Segment) {
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	lse.mu.Lock()
+	rate := lse.rate()
+	lse.mu.Unlock()
+
+	if rate > 0 && sampling.TraceIDFraction("shed:"+seg.ParentSegment.TraceID) < rate {
+		atomic.AddUint64(&lse.shed, 1)
+		logger.Debugf("Shedding segment %q of trace %s", seg.Name, seg.ParentSegment.TraceID)
+		return
+	}
+
+	lse.inner.Emit(seg)
+	if r, ok := lse.inner.(writeErrorRater); ok && lse.AutoShed {
+		lse.adjustAutoShed(r.RecentWriteErrorRate())
+	}
+}
+
+// adjustAutoShed adjusts automatic shedding after an emit, with errorRate
+// the fraction of the recent writes of the inner emitter which failed.
+func (lse *LoadSheddingEmitter) adjustAutoShed(errorRate float64) {
+	lse.mu.Lock()
+	defer lse.mu.Unlock()
+
+	if errorRate < autoShedErrorRate {
+		lse.autoFraction = math.Max(lse.autoFraction-autoShedRecovery, 0)
+		return
+	}
+	if lse.autoFraction == 0 {
+		logger.Warnf("Emitter write errors started shedding traces")
+	}
+	lse.autoFraction = math.Min(lse.autoFraction+autoShedStep, autoShedMax)
+}
+
+// RefreshEmitterWithAddress refreshes the inner emitter with the input UDP address.
+func (lse *LoadSheddingEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	lse.inner.RefreshEmitterWithAddress(raddr)
+}
+
+// suspendsTracing reports whether the inner emitter suspends tracing.
+func (lse *LoadSheddingEmitter) suspendsTracing() bool {
+	return emitterSuspendsTracing(lse.inner)
+}
+
+// Drain drains the inner emitter if it implements Drainer.
+func (lse *LoadSheddingEmitter) Drain(ctx context.Context) error {
+	if d, ok := lse.inner.(Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return ctx.Err()
+}
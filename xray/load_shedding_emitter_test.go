@@ -0,0 +1,193 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingEmitter reports all of its recent writes as failed while failing
+// is set.
+type failingEmitter struct {
+	gatedEmitter
+	failing bool
+}
+
+func (fe *failingEmitter) Emit(seg *Segment) {
+	if fe.failing {
+		return
+	}
+	fe.gatedEmitter.Emit(seg)
+}
+
+func (fe *failingEmitter) RecentWriteErrorRate() float64 {
+	if fe.failing {
+		return 1
+	}
+	return 0
+}
+
+func emitTrace(e Emitter, traceID string) {
+	seg := &Segment{Name: traceID, TraceID: traceID, Sampled: true}
+	seg.ParentSegment = seg
+	e.Emit(seg)
+}
+
+func TestNewLoadSheddingEmitterInvalid(t *testing.T) {
+	_, err := NewLoadSheddingEmitter(nil, 0.5)
+	assert.Error(t, err)
+	_, err = NewLoadSheddingEmitter(&gatedEmitter{}, -0.1)
+	assert.Error(t, err)
+	_, err = NewLoadSheddingEmitter(&gatedEmitter{}, 1.1)
+	assert.Error(t, err)
+	_, err = NewLoadSheddingEmitter(&gatedEmitter{}, math.NaN())
+	assert.Error(t, err)
+}
+
+func TestLoadSheddingEmitter(t *testing.T) {
+	inner := &gatedEmitter{}
+	lse, err := NewLoadSheddingEmitter(inner, 0.5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 0.5, lse.ShedRate())
+
+	const traces = 1000
+	for i := 0; i < traces; i++ {
+		emitTrace(lse, NewTraceID())
+	}
+	emitted := len(inner.emitted())
+	assert.InDelta(t, traces/2, emitted, traces/10)
+	assert.Equal(t, uint64(traces-emitted), lse.ShedCount())
+
+	assert.NoError(t, lse.SetShedFraction(0))
+	assert.Equal(t, 0.0, lse.ShedRate())
+	emitTrace(lse, NewTraceID())
+	assert.Len(t, inner.emitted(), emitted+1)
+
+	assert.Error(t, lse.SetShedFraction(2))
+	assert.Equal(t, 0.0, lse.ShedRate())
+}
+
+func TestLoadSheddingEmitterShedsWholeTraces(t *testing.T) {
+	inner := &gatedEmitter{}
+	lse, err := NewLoadSheddingEmitter(inner, 0.5)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		traceID := NewTraceID()
+		root := &Segment{Name: traceID, TraceID: traceID, Sampled: true}
+		root.ParentSegment = root
+		sub := &Segment{Name: traceID, parent: root, ParentSegment: root}
+
+		lse.Emit(sub)
+		lse.Emit(root)
+		var count int
+		for _, name := range inner.emitted() {
+			if name == traceID {
+				count++
+			}
+		}
+		assert.Contains(t, []int{0, 2}, count, fmt.Sprintf("trace %s", traceID))
+	}
+}
+
+func TestLoadSheddingEmitterAutoShed(t *testing.T) {
+	inner := &failingEmitter{failing: true}
+	lse, err := NewLoadSheddingEmitter(inner, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	lse.AutoShed = true
+
+	// Traces are all emitted until the first errors raise the rate.
+	emitTrace(lse, NewTraceID())
+	assert.InDelta(t, autoShedStep, lse.ShedRate(), 1e-9)
+	for i := 0; i < 1000; i++ {
+		emitTrace(lse, NewTraceID())
+	}
+	assert.InDelta(t, autoShedMax, lse.ShedRate(), 1e-9)
+
+	// An operator fraction above the automatic one takes precedence.
+	assert.NoError(t, lse.SetShedFraction(1))
+	assert.Equal(t, 1.0, lse.ShedRate())
+	assert.NoError(t, lse.SetShedFraction(0))
+
+	inner.failing = false
+	for lse.ShedRate() > 0 {
+		emitTrace(lse, NewTraceID())
+	}
+	assert.Equal(t, 0.0, lse.ShedRate())
+	assert.NotEmpty(t, inner.emitted())
+}
+
+func TestLoadSheddingEmitterAutoShedWithoutDaemon(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lse, err := NewLoadSheddingEmitter(emitter, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	lse.AutoShed = true
+
+	// Nothing listens on the port any more, so the host refuses the packets
+	// and writes alternate between succeeding and failing.
+	conn.Close()
+	for i := 0; i < 100 && lse.ShedRate() < 0.5; i++ {
+		emitTrace(lse, NewTraceID())
+		time.Sleep(time.Millisecond)
+	}
+	assert.GreaterOrEqual(t, lse.ShedRate(), 0.5)
+	assert.Greater(t, emitter.RecentWriteErrorRate(), 0.0)
+}
+
+func TestLoadSheddingEmitterAutoShedConcurrent(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter, err := NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lse, err := NewLoadSheddingEmitter(emitter, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	lse.AutoShed = true
+	conn.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				emitTrace(lse, NewTraceID())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Greater(t, lse.ShedRate(), 0.0)
+}
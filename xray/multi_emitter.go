@@ -0,0 +1,161 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+)
+
+// MultiEmitter emits every segment to each of a list of emitters, for
+// instance to send segments to the daemon and to another collector while
+// migrating between the two.
+type MultiEmitter struct {
+	emitters []Emitter
+}
+
+// NewMultiEmitter initializes and returns a pointer to an instance of
+// MultiEmitter which emits to the given emitters in order. Nil emitters are
+// ignored.
+func NewMultiEmitter(emitters ...Emitter) *MultiEmitter {
+	me := &MultiEmitter{}
+	for _, e := range emitters {
+		if e != nil {
+			me.emitters = append(me.emitters, e)
+		}
+	}
+	return me
+}
+
+// Emit emits seg to each emitter. Emitters are called one after another, as
+// the SDK may reuse parts of the segment tree once Emit returns. Emitting
+// changes the segment tree: packing appends the serialized subsegments of
+// each segment to its Subsegments, and streaming removes subsegments from
+// the tree and marks them as sent on their own. The tree is therefore restored before each emitter after the
+// first, so that every emitter sees the same tree.
+// seg has a write lock acquired by the caller.
+func (me *MultiEmitter) Emit(seg *Segment) {
+	if len(me.emitters) < 2 {
+		for _, e := range me.emitters {
+			e.Emit(seg)
+		}
+		return
+	}
+
+	state := saveTreeState(seg, nil)
+	var total uint32
+	if seg.ParentSegment != nil {
+		total = atomic.LoadUint32(&seg.ParentSegment.totalSubSegments)
+	}
+	for i, e := range me.emitters {
+		if i > 0 {
+			restoreTreeState(seg, state)
+			if seg.ParentSegment != nil {
+				atomic.StoreUint32(&seg.ParentSegment.totalSubSegments, total)
+			}
+		}
+		e.Emit(seg)
+	}
+}
+
+// treeState holds the fields of a segment in a segment tree which emitting
+// the tree changes, as they were before it was emitted.
+type treeState struct {
+	seg            *Segment
+	rawSubsegments []*Segment
+	subsegments    []json.RawMessage
+	typ            string
+	traceID        string
+	parentID       string
+}
+
+// saveTreeState appends the state of seg and every subsegment below it to
+// states.
+// seg has a write lock acquired by the caller.
+func saveTreeState(seg *Segment, states []treeState) []treeState {
+	states = append(states, treeState{
+		seg:            seg,
+		rawSubsegments: append([]*Segment(nil), seg.rawSubsegments...),
+		subsegments:    append([]json.RawMessage(nil), seg.Subsegments...),
+		typ:            seg.Type,
+		traceID:        seg.TraceID,
+		parentID:       seg.ParentID,
+	})
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		states = saveTreeState(s, states)
+		s.Unlock()
+	}
+	return states
+}
+
+// restoreTreeState restores the segment tree below seg saved by
+// saveTreeState. Emitters may reorder the restored slices in place,
+// so each segment is given copies of them.
+// seg has a write lock acquired by the caller.
+func restoreTreeState(seg *Segment, states []treeState) {
+	for _, st := range states {
+		if st.seg != seg {
+			st.seg.Lock()
+		}
+		st.seg.rawSubsegments = append([]*Segment(nil), st.rawSubsegments...)
+		st.seg.Subsegments = append([]json.RawMessage(nil), st.subsegments...)
+		st.seg.Type = st.typ
+		st.seg.TraceID = st.traceID
+		st.seg.ParentID = st.parentID
+		if st.seg != seg {
+			st.seg.Unlock()
+		}
+	}
+}
+
+// RefreshEmitterWithAddress refreshes each emitter with the input UDP
+// address. Emitters sending somewhere other than the daemon, such as
+// ConsoleEmitter, treat this as a no-op.
+func (me *MultiEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	for _, e := range me.emitters {
+		e.RefreshEmitterWithAddress(raddr)
+	}
+}
+
+// suspendsTracing reports whether every emitter suspends tracing, as
+// segments are still sent as long as one of the emitters can send them.
+func (me *MultiEmitter) suspendsTracing() bool {
+	if len(me.emitters) == 0 {
+		return false
+	}
+	for _, e := range me.emitters {
+		if !emitterSuspendsTracing(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Drain drains each emitter which implements Drainer. It returns an
+// exception.MultiError holding the errors of the emitters which failed to
+// drain, or nil if all of them succeeded.
+func (me *MultiEmitter) Drain(ctx context.Context) error {
+	var errs exception.MultiError
+	for _, e := range me.emitters {
+		if d, ok := e.(Drainer); ok {
+			if err := d.Drain(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
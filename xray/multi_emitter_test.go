@@ -0,0 +1,188 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+	"github.com/stretchr/testify/assert"
+)
+
+// drainingEmitter records emitted segment names and refreshed addresses,
+// and returns drainErr from Drain.
+type drainingEmitter struct {
+	names    []string
+	addrs    []*net.UDPAddr
+	drainErr error
+	drained  bool
+}
+
+func (de *drainingEmitter) Emit(seg *Segment) {
+	de.names = append(de.names, seg.Name)
+}
+
+func (de *drainingEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	de.addrs = append(de.addrs, raddr)
+}
+
+func (de *drainingEmitter) Drain(ctx context.Context) error {
+	de.drained = true
+	return de.drainErr
+}
+
+// suspendingEmitter is an emitter which suspends tracing if suspend is set.
+type suspendingEmitter struct {
+	TestEmitter
+	suspend bool
+}
+
+func (se *suspendingEmitter) suspendsTracing() bool {
+	return se.suspend
+}
+
+func TestMultiEmitterEmitsToAll(t *testing.T) {
+	first := &drainingEmitter{}
+	second := &gatedEmitter{}
+	me := NewMultiEmitter(first, nil, second)
+
+	seg := &Segment{Name: "test"}
+	me.Emit(seg)
+
+	assert.Equal(t, []string{"test"}, first.names)
+	assert.Equal(t, []string{"test"}, second.names)
+}
+
+func TestMultiEmitterRefreshesAll(t *testing.T) {
+	first := &drainingEmitter{}
+	second := &drainingEmitter{}
+	me := NewMultiEmitter(first, second)
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+	me.RefreshEmitterWithAddress(addr)
+
+	assert.Equal(t, []*net.UDPAddr{addr}, first.addrs)
+	assert.Equal(t, []*net.UDPAddr{addr}, second.addrs)
+}
+
+func TestMultiEmitterDrainAggregatesErrors(t *testing.T) {
+	errFirst := errors.New("first")
+	errThird := errors.New("third")
+	first := &drainingEmitter{drainErr: errFirst}
+	second := &drainingEmitter{}
+	third := &drainingEmitter{drainErr: errThird}
+	me := NewMultiEmitter(first, &gatedEmitter{}, second, third)
+
+	err := me.Drain(context.Background())
+
+	assert.Equal(t, exception.MultiError{errFirst, errThird}, err)
+	assert.True(t, first.drained)
+	assert.True(t, second.drained)
+	assert.True(t, third.drained)
+}
+
+func TestMultiEmitterDrainSucceeds(t *testing.T) {
+	me := NewMultiEmitter(&drainingEmitter{}, &gatedEmitter{})
+
+	assert.NoError(t, me.Drain(context.Background()))
+
+	ctx, err := ContextWithConfig(context.Background(), Config{Emitter: me})
+	if assert.NoError(t, err) {
+		assert.NoError(t, Flush(ctx))
+	}
+}
+
+func TestMultiEmitterEmitsSameTreeToDefaultEmitters(t *testing.T) {
+	ctx, first := NewTestDaemon()
+	defer first.Close()
+	secondCtx, second := NewTestDaemon()
+	defer second.Close()
+
+	cfg := GetRecorder(ctx)
+	cfg.Emitter = NewMultiEmitter(cfg.Emitter, GetRecorder(secondCtx).Emitter)
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	for _, name := range []string{"first", "second"} {
+		_, seg := BeginSubsegment(ctx, name)
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	for _, td := range []*TestDaemon{first, second} {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "Segment", seg.Name)
+		if assert.Len(t, seg.Subsegments, 2) {
+			var s *Segment
+			assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &s))
+			assert.Equal(t, "first", s.Name)
+			assert.NoError(t, json.Unmarshal(seg.Subsegments[1], &s))
+			assert.Equal(t, "second", s.Name)
+		}
+		_, err = td.Recv()
+		assert.Error(t, err)
+	}
+}
+
+func TestMultiEmitterStreamsToDefaultEmitters(t *testing.T) {
+	ctx, first := NewTestDaemon()
+	defer first.Close()
+	secondCtx, second := NewTestDaemon()
+	defer second.Close()
+
+	cfg := GetRecorder(ctx)
+	cfg.Emitter = NewMultiEmitter(cfg.Emitter, GetRecorder(secondCtx).Emitter)
+	ss, err := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cfg.StreamingStrategy = ss
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	for _, name := range []string{"first", "second", "third"} {
+		_, seg := BeginSubsegment(ctx, name)
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	for _, td := range []*TestDaemon{first, second} {
+		var streamed, kept int
+		for {
+			seg, err := td.Recv()
+			if err != nil {
+				break
+			}
+			if seg.Name == "Segment" {
+				kept += len(seg.Subsegments)
+			} else {
+				streamed++
+			}
+		}
+		assert.Equal(t, 2, streamed)
+		assert.Equal(t, 1, kept)
+	}
+}
+
+func TestMultiEmitterSuspendsTracingOnlyIfAllDo(t *testing.T) {
+	first := &suspendingEmitter{suspend: true}
+	second := &suspendingEmitter{}
+	me := NewMultiEmitter(first, second)
+
+	assert.False(t, emitterSuspendsTracing(me))
+	second.suspend = true
+	assert.True(t, emitterSuspendsTracing(me))
+	assert.False(t, emitterSuspendsTracing(NewMultiEmitter(first, &TestEmitter{})))
+	assert.False(t, emitterSuspendsTracing(NewMultiEmitter()))
+}
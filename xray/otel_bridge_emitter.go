@@ -0,0 +1,290 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// OTelSpanKind is the kind of an OTelSpan. Its values are those of
+// SpanKind in go.opentelemetry.io/otel/trace, so it converts directly.
+type OTelSpanKind int
+
+// Kinds of the spans translated from segments.
+const (
+	OTelSpanKindInternal OTelSpanKind = 1
+	OTelSpanKindServer   OTelSpanKind = 2
+	OTelSpanKindClient   OTelSpanKind = 3
+)
+
+// OTelSpan is a segment or subsegment translated into the shape of an
+// OpenTelemetry span. Its IDs are the binary trace and span IDs OpenTelemetry
+// uses, and attribute values are string, bool, int64 or float64.
+type OTelSpan struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte // zero for a root span without a parent
+	Name         string
+	Kind         OTelSpanKind
+	StartTime    time.Time
+	EndTime      time.Time // zero for subsegments emitted in progress
+	Attributes   map[string]interface{}
+
+	// StatusError is true for segments recording an error or fault, and
+	// StatusMessage holds the message of their first exception.
+	StatusError   bool
+	StatusMessage string
+}
+
+// OTelSpanExporter receives the spans translated by OTelBridgeEmitter.
+// The SDK does not depend on OpenTelemetry, so the exporter is an adapter
+// which converts the spans, for instance into tracetest.SpanStub values
+// whose Snapshot is passed to the ExportSpans method of an OpenTelemetry
+// SpanExporter.
+type OTelSpanExporter interface {
+	ExportSpans(ctx context.Context, spans []OTelSpan) error
+}
+
+// OTelBridgeEmitter translates emitted segments into OpenTelemetry spans and
+// passes them to an OTelSpanExporter, so that X-Ray segments can be exported
+// along with OpenTelemetry spans while an application migrates between the
+// two. Every segment and subsegment of an emitted tree becomes one span, the
+// child of the span of its parent, and all of them are exported in one call.
+//
+// Segments are mapped to spans as follows:
+//
+//   - The trace ID, without its version and dashes, and the segment ID are
+//     decoded from hexadecimal into the IDs of the span.
+//   - Root segments are server spans, subsegments in the "remote" or "aws"
+//     namespace client spans, and other subsegments internal spans.
+//   - Annotations are attributes with the same key.
+//   - Metadata are attributes keyed "xray.metadata.<namespace>.<key>", with
+//     their values encoded as JSON strings.
+//   - HTTP data are the attributes "http.method", "http.url",
+//     "http.client_ip", "http.user_agent", "http.status_code" and
+//     "http.response_content_length".
+//   - Scalar values of the aws map are attributes keyed "aws.<key>".
+//   - SQL data are the attributes "db.system", "db.version", "db.user",
+//     "db.connection_string" (the URL) and "db.statement" (the sanitized
+//     query).
+//   - The namespace, origin, user and resource ARN of the segment are
+//     the attributes "xray.namespace", "xray.origin", "enduser.id" and
+//     "aws.resource_arn", and the flags "xray.error", "xray.fault" and
+//     "xray.throttle" are set to true if they are set on the segment.
+//
+// The exporter is called from Emit, which is called when a segment closes,
+// so wrap the emitter in a PooledEmitter to keep closing segments from
+// waiting on the export.
+type OTelBridgeEmitter struct {
+	exporter OTelSpanExporter
+}
+
+// NewOTelBridgeEmitter initializes and returns a pointer to an instance of
+// OTelBridgeEmitter which exports segments through exporter.
+func NewOTelBridgeEmitter(exporter OTelSpanExporter) (*OTelBridgeEmitter, error) {
+	if exporter == nil {
+		return nil, errors.New("span exporter must not be nil")
+	}
+	return &OTelBridgeEmitter{exporter: exporter}, nil
+}
+
+// RefreshEmitterWithAddress is a no-op as OTelBridgeEmitter
+// does not send segments to the daemon.
+func (oe *OTelBridgeEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// Emit exports the tree below seg as spans if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (oe *OTelBridgeEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	spans, err := appendOTelSpans(nil, seg, seg.ParentID)
+	if err != nil {
+		logger.Errorf("Error translating segment %s to spans: %v", seg.Name, err)
+		return
+	}
+	if err := oe.exporter.ExportSpans(context.Background(), spans); err != nil {
+		logger.Errorf("Error exporting segment %s: %v", seg.Name, err)
+	}
+}
+
+// appendOTelSpans appends the span of seg, whose parent has the ID
+// parentID, followed by the spans of its subsegments.
+// seg has a write lock acquired by the caller.
+func appendOTelSpans(spans []OTelSpan, seg *Segment, parentID string) ([]OTelSpan, error) {
+	span, err := otelSpan(seg, parentID)
+	if err != nil {
+		return nil, err
+	}
+	spans = append(spans, span)
+
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		spans, err = appendOTelSpans(spans, s, seg.ID)
+		s.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return spans, nil
+}
+
+// otelSpan translates seg, without its subsegments, into a span.
+// seg has a write lock acquired by the caller.
+func otelSpan(seg *Segment, parentID string) (OTelSpan, error) {
+	span := OTelSpan{
+		Name:       seg.Name,
+		Kind:       OTelSpanKindInternal,
+		StartTime:  floatToTime(seg.StartTime),
+		Attributes: map[string]interface{}{},
+	}
+	if seg.EndTime > 0 {
+		span.EndTime = floatToTime(seg.EndTime)
+	}
+
+	traceID := seg.TraceID
+	if traceID == "" && seg.ParentSegment != nil {
+		traceID = seg.ParentSegment.TraceID
+	}
+	parts := strings.Split(traceID, "-")
+	if len(parts) != 3 || !decodeHexID(span.TraceID[:], parts[1]+parts[2]) {
+		return OTelSpan{}, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	if !decodeHexID(span.SpanID[:], seg.ID) {
+		return OTelSpan{}, fmt.Errorf("invalid segment ID %q", seg.ID)
+	}
+	if parentID != "" && !decodeHexID(span.ParentSpanID[:], parentID) {
+		return OTelSpan{}, fmt.Errorf("invalid parent ID %q", parentID)
+	}
+
+	switch {
+	case seg.parent == nil && seg.Type != "subsegment":
+		span.Kind = OTelSpanKindServer
+	case seg.Namespace == "remote" || seg.Namespace == "aws":
+		span.Kind = OTelSpanKindClient
+	}
+
+	attrs := span.Attributes
+	for k, v := range seg.Annotations {
+		if v := otelAttributeValue(v); v != nil {
+			attrs[k] = v
+		}
+	}
+	for ns, m := range seg.Metadata {
+		for k, v := range m {
+			b, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			attrs["xray.metadata."+ns+"."+k] = string(b)
+		}
+	}
+
+	if seg.HTTP != nil {
+		if r := seg.HTTP.Request; r != nil {
+			setOTelString(attrs, "http.method", r.Method)
+			setOTelString(attrs, "http.url", r.URL)
+			setOTelString(attrs, "http.client_ip", r.ClientIP)
+			setOTelString(attrs, "http.user_agent", r.UserAgent)
+		}
+		if r := seg.HTTP.Response; r != nil {
+			if r.Status != 0 {
+				attrs["http.status_code"] = int64(r.Status)
+			}
+			if r.ContentLength != 0 {
+				attrs["http.response_content_length"] = int64(r.ContentLength)
+			}
+		}
+	}
+	for k, v := range seg.AWS {
+		if v := otelAttributeValue(v); v != nil {
+			attrs["aws."+k] = v
+		}
+	}
+	if seg.SQL != nil {
+		setOTelString(attrs, "db.system", seg.SQL.DatabaseType)
+		setOTelString(attrs, "db.version", seg.SQL.DatabaseVersion)
+		setOTelString(attrs, "db.user", seg.SQL.User)
+		setOTelString(attrs, "db.connection_string", seg.SQL.URL)
+		setOTelString(attrs, "db.statement", seg.SQL.SanitizedQuery)
+	}
+
+	setOTelString(attrs, "xray.namespace", seg.Namespace)
+	setOTelString(attrs, "xray.origin", seg.Origin)
+	setOTelString(attrs, "enduser.id", seg.User)
+	setOTelString(attrs, "aws.resource_arn", seg.ResourceARN)
+	if seg.Error {
+		attrs["xray.error"] = true
+	}
+	if seg.Fault {
+		attrs["xray.fault"] = true
+	}
+	if seg.Throttle {
+		attrs["xray.throttle"] = true
+	}
+
+	span.StatusError = seg.Error || seg.Fault
+	if span.StatusError && seg.Cause != nil && len(seg.Cause.Exceptions) > 0 {
+		span.StatusMessage = seg.Cause.Exceptions[0].Message
+	}
+	return span, nil
+}
+
+// decodeHexID decodes the hexadecimal id into dst, and reports whether it
+// has exactly the length of dst.
+func decodeHexID(dst []byte, id string) bool {
+	if hex.DecodedLen(len(id)) != len(dst) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(id))
+	return err == nil
+}
+
+// otelAttributeValue converts v into one of the attribute value types of
+// OTelSpan, or returns nil if v is not a scalar value.
+func otelAttributeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, int64, float64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return nil
+}
+
+func setOTelString(attrs map[string]interface{}, key, value string) {
+	if value != "" {
+		attrs[key] = value
+	}
+}
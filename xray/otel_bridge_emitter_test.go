@@ -0,0 +1,123 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSpanExporter struct {
+	mu    sync.Mutex
+	spans [][]OTelSpan
+}
+
+func (e *recordingSpanExporter) ExportSpans(ctx context.Context, spans []OTelSpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans)
+	return nil
+}
+
+func TestNewOTelBridgeEmitterNilExporter(t *testing.T) {
+	_, err := NewOTelBridgeEmitter(nil)
+	assert.Error(t, err)
+}
+
+func TestOTelBridgeEmitter(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	exporter := &recordingSpanExporter{}
+	oe, err := NewOTelBridgeEmitter(exporter)
+	if !assert.NoError(t, err) {
+		return
+	}
+	GetRecorder(ctx).Emitter = oe
+
+	ctx, root := BeginSegment(ctx, "root")
+	assert.NoError(t, root.AddAnnotation("tier", "premium"))
+	assert.NoError(t, root.AddAnnotation("shard", 3))
+	assert.NoError(t, root.AddMetadataToNamespace("app", "config", map[string]int{"a": 1}))
+	root.GetHTTP().GetRequest().Method = "GET"
+	root.GetHTTP().GetRequest().URL = "http://example.com/"
+	root.GetHTTP().GetResponse().Status = 500
+
+	_, sub := BeginSubsegment(ctx, "downstream")
+	sub.Lock()
+	sub.Namespace = "remote"
+	sub.Unlock()
+	sub.Close(errors.New("boom"))
+	root.Close(nil)
+
+	if !assert.Len(t, exporter.spans, 1) || !assert.Len(t, exporter.spans[0], 2) {
+		return
+	}
+	rs, ss := exporter.spans[0][0], exporter.spans[0][1]
+
+	assert.Equal(t, "root", rs.Name)
+	assert.Equal(t, OTelSpanKindServer, rs.Kind)
+	assert.Equal(t, root.TraceID[2:10]+root.TraceID[11:], hex.EncodeToString(rs.TraceID[:]))
+	assert.Equal(t, root.ID, hex.EncodeToString(rs.SpanID[:]))
+	assert.Equal(t, [8]byte{}, rs.ParentSpanID)
+	assert.False(t, rs.StartTime.IsZero())
+	assert.False(t, rs.EndTime.Before(rs.StartTime))
+	assert.Equal(t, "premium", rs.Attributes["tier"])
+	assert.Equal(t, int64(3), rs.Attributes["shard"])
+	assert.Equal(t, `{"a":1}`, rs.Attributes["xray.metadata.app.config"])
+	assert.Equal(t, "GET", rs.Attributes["http.method"])
+	assert.Equal(t, "http://example.com/", rs.Attributes["http.url"])
+	assert.Equal(t, int64(500), rs.Attributes["http.status_code"])
+	assert.False(t, rs.StatusError)
+
+	assert.Equal(t, "downstream", ss.Name)
+	assert.Equal(t, OTelSpanKindClient, ss.Kind)
+	assert.Equal(t, rs.TraceID, ss.TraceID)
+	assert.Equal(t, rs.SpanID, ss.ParentSpanID)
+	assert.Equal(t, "remote", ss.Attributes["xray.namespace"])
+	assert.Equal(t, true, ss.Attributes["xray.fault"])
+	assert.True(t, ss.StatusError)
+	assert.Equal(t, "boom", ss.StatusMessage)
+}
+
+func TestOTelBridgeEmitterSkipsUnsampled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	exporter := &recordingSpanExporter{}
+	oe, _ := NewOTelBridgeEmitter(exporter)
+	GetRecorder(ctx).Emitter = oe
+	GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+
+	_, root := BeginSegment(ctx, "root")
+	root.Close(nil)
+	assert.Empty(t, exporter.spans)
+}
+
+func TestOTelSpanInvalidTraceID(t *testing.T) {
+	seg := &Segment{Name: "test", TraceID: "not a trace ID", ID: "0123456789abcdef"}
+	_, err := otelSpan(seg, "")
+	assert.Error(t, err)
+}
+
+func TestOTelSpanSkipsUnmappedAnnotations(t *testing.T) {
+	seg := &Segment{Name: "test", TraceID: NewTraceID(), ID: "0123456789abcdef", Annotations: map[string]interface{}{
+		"kept":     "value",
+		"unmapped": uint64(1),
+	}}
+	span, err := otelSpan(seg, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "value", span.Attributes["kept"])
+	assert.NotContains(t, span.Attributes, "unmapped")
+}
@@ -0,0 +1,103 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// DebugTraceHeaderKey is the key of the trace header's additional data
+// marking a trace for investigation. See Segment.SetDebug.
+const DebugTraceHeaderKey = "Debug"
+
+// DebugAnnotationKey is the annotation key of segments marked for
+// investigation. See Segment.SetDebug.
+const DebugAnnotationKey = "debug"
+
+// maxOutboundHeaderSize is the largest serialized trace header sent to
+// downstream services. Additional data which does not fit is left out.
+const maxOutboundHeaderSize = 1024
+
+// BuildOutboundHeader returns the trace header for passing seg to downstream
+// calls. The header carries the trace ID of seg and seg's ID as the parent.
+// The downstream call is only sampled if both the root segment and seg are,
+// so subsegments begun with BeginSubsegmentWithoutSampling propagate
+// Sampled=0.
+//
+// Additional data is merged rather than replaced: the data inherited from the
+// header the root segment was started from comes first, and data from the
+// header seg itself was started from overrides it key by key. Additional data
+// is dropped, in key order, once the serialized header would exceed 1024 bytes.
+func BuildOutboundHeader(seg *Segment) header.Header {
+	h := header.Header{
+		AdditionalData: make(map[string]string),
+	}
+
+	// If SDK is disabled then return with an empty header
+	if SdkDisabled() {
+		return h
+	}
+
+	var sources []*header.Header
+	if in := seg.ParentSegment.IncomingHeader; in != nil {
+		sources = append(sources, in)
+	}
+	if in := seg.IncomingHeader; in != nil && seg != seg.ParentSegment {
+		sources = append(sources, in)
+	}
+
+	data := make(map[string]string)
+	for _, in := range sources {
+		if in.TraceID != "" {
+			h.TraceID = in.TraceID
+		}
+		if in.Lineage != "" {
+			h.Lineage = in.Lineage
+		}
+		for k, v := range in.AdditionalData {
+			data[k] = v
+		}
+	}
+
+	if atomic.LoadInt32(&seg.ParentSegment.debug) == 1 {
+		data[DebugTraceHeaderKey] = "1"
+	}
+
+	if h.TraceID == "" {
+		h.TraceID = seg.ParentSegment.TraceID
+	}
+	if seg.ParentSegment.Sampled && seg.Sampled {
+		h.SamplingDecision = header.Sampled
+	} else {
+		h.SamplingDecision = header.NotSampled
+	}
+	h.ParentID = seg.ID
+	atomic.StoreInt32(&seg.propagated, 1)
+
+	size := len(h.String())
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		n := len(k) + len(data[k]) + len("=;")
+		if size+n > maxOutboundHeaderSize {
+			logger.Debugf("Dropping trace header data %s to stay within %d bytes", k, maxOutboundHeaderSize)
+			continue
+		}
+		h.AdditionalData[k] = data[k]
+		size += n
+	}
+	return h
+}
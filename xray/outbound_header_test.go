@@ -0,0 +1,105 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildOutboundHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "Test")
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+
+	h := BuildOutboundHeader(subseg)
+	assert.Equal(t, seg.TraceID, h.TraceID)
+	assert.Equal(t, subseg.ID, h.ParentID)
+	assert.Equal(t, header.Sampled, h.SamplingDecision)
+	assert.Empty(t, h.AdditionalData)
+
+	subseg.Close(nil)
+	seg.Close(nil)
+}
+
+func TestBuildOutboundHeaderSubsegmentWithoutSampling(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "Test")
+	_, subseg := BeginSubsegmentWithoutSampling(ctx, "Subsegment")
+
+	assert.Equal(t, header.NotSampled, BuildOutboundHeader(subseg).SamplingDecision)
+	assert.Equal(t, header.Sampled, BuildOutboundHeader(seg).SamplingDecision)
+
+	subseg.Close(nil)
+	seg.Close(nil)
+}
+
+func TestBuildOutboundHeaderNotSampled(t *testing.T) {
+	seg := &Segment{ID: "53995c3f42cd8ad8", TraceID: "1-57fbe041-2c7ad569f5d6ff149137be86"}
+	seg.ParentSegment = seg
+
+	h := BuildOutboundHeader(seg)
+	assert.Equal(t, header.NotSampled, h.SamplingDecision)
+	assert.Equal(t, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;Sampled=0", h.String())
+}
+
+func TestBuildOutboundHeaderMergesAdditionalData(t *testing.T) {
+	root := &Segment{ID: "root", Sampled: true}
+	root.ParentSegment = root
+	root.IncomingHeader = header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=upstream;Lineage=a87bd80c:1;Foo=bar;Baz=qux")
+	subseg := &Segment{ID: "subseg", ParentSegment: root, Sampled: true}
+	subseg.IncomingHeader = header.FromString("Foo=override;Own=data")
+
+	h := BuildOutboundHeader(subseg)
+	assert.Equal(t, "1-57fbe041-2c7ad569f5d6ff149137be86", h.TraceID)
+	assert.Equal(t, "subseg", h.ParentID)
+	assert.Equal(t, "a87bd80c:1", h.Lineage)
+	assert.Equal(t, map[string]string{"Foo": "override", "Baz": "qux", "Own": "data"}, h.AdditionalData)
+
+	// The inherited header is left untouched.
+	assert.Equal(t, "bar", root.IncomingHeader.AdditionalData["Foo"])
+	assert.Equal(t, "upstream", root.IncomingHeader.ParentID)
+}
+
+func TestBuildOutboundHeaderCapsSize(t *testing.T) {
+	root := &Segment{ID: "53995c3f42cd8ad8", Sampled: true}
+	root.ParentSegment = root
+	root.IncomingHeader = header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;A=small;B=" + strings.Repeat("x", maxOutboundHeaderSize) + ";C=small")
+
+	h := BuildOutboundHeader(root)
+	assert.Equal(t, map[string]string{"A": "small", "C": "small"}, h.AdditionalData)
+	assert.True(t, len(h.String()) <= maxOutboundHeaderSize)
+}
+
+func TestBuildOutboundHeaderDebug(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "Test")
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+	assert.NotContains(t, BuildOutboundHeader(subseg).AdditionalData, DebugTraceHeaderKey)
+
+	subseg.SetDebug(true)
+	assert.Equal(t, "1", BuildOutboundHeader(subseg).AdditionalData[DebugTraceHeaderKey])
+	assert.Equal(t, true, seg.Annotations[DebugAnnotationKey])
+
+	seg.SetDebug(false)
+	assert.NotContains(t, BuildOutboundHeader(subseg).AdditionalData, DebugTraceHeaderKey)
+	assert.NotContains(t, seg.Annotations, DebugAnnotationKey)
+
+	subseg.Close(nil)
+	seg.Close(nil)
+}
@@ -0,0 +1,209 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// PooledEmitter hands emitted segments to a fixed number of workers which
+// pass them on to an inner emitter, so that closing a segment does not wait
+// for the segment to be sent. Segments wait in a bounded queue; once the queue
+// is full they are dropped, or Emit blocks if BlockWhenFull is set.
+type PooledEmitter struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment.
+	dropped uint64
+
+	// BlockWhenFull makes Emit wait for room in the queue instead of
+	// dropping the segment. It must be set before the emitter is used.
+	BlockWhenFull bool
+
+	inner Emitter
+	queue chan *Segment
+
+	// closeMu guards closed and the queue against being closed while Emit
+	// is sending on it.
+	closeMu sync.RWMutex
+	closed  bool
+
+	// mu guards pending and idle, which Drain uses to wait for the queue.
+	mu      sync.Mutex
+	pending int
+	idle    []chan struct{}
+}
+
+// NewPooledEmitter initializes and returns a pointer to an instance of
+// PooledEmitter which emits segments to inner from the given number of
+// workers, holding at most queue segments that wait for a worker.
+func NewPooledEmitter(inner Emitter, workers, queue int) (*PooledEmitter, error) {
+	if inner == nil {
+		return nil, errors.New("inner emitter must not be nil")
+	}
+	if workers < 1 {
+		return nil, errors.New("workers must be a positive integer")
+	}
+	if queue < 0 {
+		return nil, errors.New("queue must be a non-negative integer")
+	}
+
+	pe := &PooledEmitter{
+		inner: inner,
+		queue: make(chan *Segment, queue),
+	}
+	for i := 0; i < workers; i++ {
+		go pe.work()
+	}
+	return pe, nil
+}
+
+// RefreshEmitterWithAddress refreshes the inner emitter with the input UDP address.
+func (pe *PooledEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	pe.inner.RefreshEmitterWithAddress(raddr)
+}
+
+// suspendsTracing reports whether the inner emitter suspends tracing.
+func (pe *PooledEmitter) suspendsTracing() bool {
+	return emitterSuspendsTracing(pe.inner)
+}
+
+// Emit queues segment or subsegment if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (pe *PooledEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	for _, p := range packSegments(seg, nil) {
+		snapshot := &Segment{}
+		if err := json.Unmarshal(p, snapshot); err != nil {
+			logger.Errorf("JSON error while queueing (Sub)Segment: %v", err)
+			continue
+		}
+		snapshot.ParentSegment = snapshot
+		snapshot.Sampled = true
+		pe.enqueue(snapshot)
+	}
+}
+
+func (pe *PooledEmitter) enqueue(seg *Segment) {
+	pe.closeMu.RLock()
+	defer pe.closeMu.RUnlock()
+
+	if pe.closed {
+		atomic.AddUint64(&pe.dropped, 1)
+		return
+	}
+
+	pe.mu.Lock()
+	pe.pending++
+	pe.mu.Unlock()
+
+	if pe.BlockWhenFull {
+		pe.queue <- seg
+		return
+	}
+
+	select {
+	case pe.queue <- seg:
+	default:
+		atomic.AddUint64(&pe.dropped, 1)
+		pe.done()
+		logger.RateLimitedErrorf("Dropping segment as the emitter queue of %d segments is full", cap(pe.queue))
+	}
+}
+
+func (pe *PooledEmitter) work() {
+	for seg := range pe.queue {
+		pe.emit(seg)
+		pe.done()
+	}
+}
+
+func (pe *PooledEmitter) emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	seg.Lock()
+	defer seg.Unlock()
+	pe.inner.Emit(seg)
+}
+
+// done marks one queued segment as handled and wakes up Drain once no
+// segment is left.
+func (pe *PooledEmitter) done() {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	pe.pending--
+	if pe.pending == 0 {
+		for _, ch := range pe.idle {
+			close(ch)
+		}
+		pe.idle = nil
+	}
+}
+
+// Drain waits until every queued segment has been handed to the inner
+// emitter and then drains the inner emitter if it implements Drainer.
+func (pe *PooledEmitter) Drain(ctx context.Context) error {
+	pe.mu.Lock()
+	if pe.pending > 0 {
+		ch := make(chan struct{})
+		pe.idle = append(pe.idle, ch)
+		pe.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		pe.mu.Unlock()
+	}
+
+	if d, ok := pe.inner.(Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return ctx.Err()
+}
+
+// Close stops the workers once the queued segments have been emitted.
+// Segments emitted after Close are dropped.
+func (pe *PooledEmitter) Close() {
+	pe.closeMu.Lock()
+	defer pe.closeMu.Unlock()
+
+	if !pe.closed {
+		pe.closed = true
+		close(pe.queue)
+	}
+}
+
+// DroppedCount returns the number of segments which were not queued, either
+// because the queue was full or because the emitter was closed.
+func (pe *PooledEmitter) DroppedCount() uint64 {
+	return atomic.LoadUint64(&pe.dropped)
+}
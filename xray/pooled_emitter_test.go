@@ -0,0 +1,189 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gatedEmitter records the names of emitted segments. If release is set,
+// Emit announces the segment on started and waits for release.
+type gatedEmitter struct {
+	started chan string
+	release chan struct{}
+
+	mu    sync.Mutex
+	names []string
+}
+
+func (ge *gatedEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+func (ge *gatedEmitter) Emit(seg *Segment) {
+	if ge.release != nil {
+		ge.started <- seg.Name
+		<-ge.release
+	}
+	ge.mu.Lock()
+	ge.names = append(ge.names, seg.Name)
+	ge.mu.Unlock()
+}
+
+func (ge *gatedEmitter) emitted() []string {
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+	return append([]string(nil), ge.names...)
+}
+
+func newGatedEmitter() *gatedEmitter {
+	return &gatedEmitter{
+		started: make(chan string),
+		release: make(chan struct{}),
+	}
+}
+
+func testEmitSegment(e Emitter, name string) {
+	seg := &Segment{Name: name, Sampled: true}
+	seg.ParentSegment = seg
+	e.Emit(seg)
+}
+
+func TestNewPooledEmitterInvalid(t *testing.T) {
+	_, err := NewPooledEmitter(nil, 1, 1)
+	assert.Error(t, err)
+	_, err = NewPooledEmitter(&gatedEmitter{}, 0, 1)
+	assert.Error(t, err)
+	_, err = NewPooledEmitter(&gatedEmitter{}, 1, -1)
+	assert.Error(t, err)
+}
+
+func TestPooledEmitter(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	pe, err := NewPooledEmitter(GetRecorder(ctx).Emitter, 2, 8)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pe.Close()
+	GetRecorder(ctx).Emitter = pe
+
+	ctx, root := BeginSegment(ctx, "Test")
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+	subseg.Close(nil)
+	root.Close(nil)
+	assert.NoError(t, Flush(ctx))
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Test", seg.Name)
+	assert.Equal(t, root.ID, seg.ID)
+	if assert.Equal(t, 1, len(seg.Subsegments)) {
+		s := &Segment{}
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], s))
+		assert.Equal(t, "Subsegment", s.Name)
+	}
+	assert.Equal(t, uint64(0), pe.DroppedCount())
+}
+
+func TestPooledEmitterDropsWhenFull(t *testing.T) {
+	inner := newGatedEmitter()
+	pe, err := NewPooledEmitter(inner, 1, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pe.Close()
+
+	testEmitSegment(pe, "first")
+	assert.Equal(t, "first", <-inner.started)
+	testEmitSegment(pe, "second")
+	testEmitSegment(pe, "third")
+	assert.Equal(t, uint64(1), pe.DroppedCount())
+
+	close(inner.release)
+	assert.Equal(t, "second", <-inner.started)
+	assert.NoError(t, pe.Drain(context.Background()))
+	assert.Equal(t, []string{"first", "second"}, inner.emitted())
+}
+
+func TestPooledEmitterBlocksWhenFull(t *testing.T) {
+	inner := newGatedEmitter()
+	pe, err := NewPooledEmitter(inner, 1, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pe.Close()
+	pe.BlockWhenFull = true
+
+	testEmitSegment(pe, "first")
+	assert.Equal(t, "first", <-inner.started)
+	testEmitSegment(pe, "second")
+
+	emitted := make(chan struct{})
+	go func() {
+		testEmitSegment(pe, "third")
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+		t.Fatal("Emit returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(inner.release)
+	for i := 0; i < 2; i++ {
+		<-inner.started
+	}
+	<-emitted
+	assert.NoError(t, pe.Drain(context.Background()))
+	assert.Equal(t, []string{"first", "second", "third"}, inner.emitted())
+	assert.Equal(t, uint64(0), pe.DroppedCount())
+}
+
+func TestPooledEmitterDrainTimeout(t *testing.T) {
+	inner := newGatedEmitter()
+	pe, err := NewPooledEmitter(inner, 1, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pe.Close()
+	defer close(inner.release)
+
+	testEmitSegment(pe, "first")
+	<-inner.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pe.Drain(ctx))
+}
+
+func TestPooledEmitterClose(t *testing.T) {
+	inner := &gatedEmitter{}
+	pe, err := NewPooledEmitter(inner, 1, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testEmitSegment(pe, "first")
+	assert.NoError(t, pe.Drain(context.Background()))
+	pe.Close()
+	pe.Close()
+	testEmitSegment(pe, "second")
+
+	assert.Equal(t, []string{"first"}, inner.emitted())
+	assert.Equal(t, uint64(1), pe.DroppedCount())
+}
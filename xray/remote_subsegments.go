@@ -0,0 +1,114 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+)
+
+// AttachRemoteSubsegments adds the subsegments serialized in raw, as returned
+// by a downstream service, to the children of seg, so that they are emitted
+// as part of the segment tree of seg. raw holds either one subsegment
+// document or a JSON array of them, each with the subsegments it embeds.
+//
+// Every attached subsegment gets the trace ID of seg, and the ID of seg, or
+// of the remote subsegment embedding it, as its parent ID. raw is rejected
+// as a whole if it is malformed, if a subsegment has no name, no start or end
+// time, is still in progress, has an ID which is not 16 hexadecimal digits or
+// is used twice, or belongs to another trace.
+func (seg *Segment) AttachRemoteSubsegments(raw []byte) error {
+	if SdkDisabled() {
+		return nil
+	}
+
+	var docs []json.RawMessage
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		if err := json.Unmarshal(raw, &docs); err != nil {
+			return fmt.Errorf("malformed remote subsegments: %v", err)
+		}
+	} else {
+		docs = []json.RawMessage{raw}
+	}
+	if len(docs) == 0 {
+		return errors.New("no remote subsegments to attach")
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.Dummy {
+		return nil
+	}
+	if !seg.InProgress {
+		return fmt.Errorf("unable to attach remote subsegments to closed segment %q", seg.Name)
+	}
+
+	root := seg.ParentSegment
+	ids := map[string]bool{}
+	subsegments := make([]*Segment, 0, len(docs))
+	for _, doc := range docs {
+		s, err := parseRemoteSubsegment(doc, seg, root, ids)
+		if err != nil {
+			return err
+		}
+		subsegments = append(subsegments, s)
+	}
+
+	seg.rawSubsegments = append(seg.rawSubsegments, subsegments...)
+	atomic.AddUint32(&root.totalSubSegments, uint32(len(ids)))
+	return nil
+}
+
+// parseRemoteSubsegment parses doc into a closed subsegment of parent, along
+// with the subsegments it embeds. ids holds the IDs parsed so far.
+func parseRemoteSubsegment(doc json.RawMessage, parent *Segment, root *Segment, ids map[string]bool) (*Segment, error) {
+	s := &Segment{}
+	if err := json.Unmarshal(doc, s); err != nil {
+		return nil, fmt.Errorf("malformed remote subsegment: %v", err)
+	}
+
+	switch {
+	case s.Name == "":
+		return nil, fmt.Errorf("remote subsegment with ID %q has no name", s.ID)
+	case !header.IsValidSegmentID(s.ID):
+		return nil, fmt.Errorf("remote subsegment %q has invalid ID %q", s.Name, s.ID)
+	case ids[s.ID]:
+		return nil, fmt.Errorf("remote subsegment %q has duplicate ID %q", s.Name, s.ID)
+	case s.StartTime == 0 || s.EndTime == 0 || s.InProgress:
+		return nil, fmt.Errorf("remote subsegment %q is not complete", s.Name)
+	case s.TraceID != "" && s.TraceID != root.TraceID:
+		return nil, fmt.Errorf("remote subsegment %q belongs to trace %q", s.Name, s.TraceID)
+	}
+	ids[s.ID] = true
+
+	s.parent = parent
+	s.ParentSegment = root
+	s.Sampled = root.Sampled
+	s.TraceID = root.TraceID
+	s.ParentID = parent.ID
+	s.Type = ""
+
+	embedded := s.Subsegments
+	s.Subsegments = nil
+	for _, doc := range embedded {
+		child, err := parseRemoteSubsegment(doc, s, root, ids)
+		if err != nil {
+			return nil, err
+		}
+		s.rawSubsegments = append(s.rawSubsegments, child)
+	}
+	return s, nil
+}
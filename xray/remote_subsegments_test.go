@@ -0,0 +1,109 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachRemoteSubsegments(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, sub := BeginSubsegment(ctx, "rpc")
+	err := sub.AttachRemoteSubsegments([]byte(`[
+		{"id": "1111111111111111", "name": "lookup", "start_time": 1, "end_time": 2,
+		 "trace_id": "` + root.TraceID + `", "parent_id": "ffffffffffffffff", "type": "subsegment",
+		 "subsegments": [{"id": "2222222222222222", "name": "query", "start_time": 1.5, "end_time": 1.8}]},
+		{"id": "3333333333333333", "name": "render", "start_time": 2, "end_time": 3}
+	]`))
+	assert.NoError(t, err)
+	sub.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		return
+	}
+	var rpc Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &rpc)) || !assert.Len(t, rpc.Subsegments, 2) {
+		return
+	}
+
+	var lookup, render, query Segment
+	assert.NoError(t, json.Unmarshal(rpc.Subsegments[0], &lookup))
+	assert.NoError(t, json.Unmarshal(rpc.Subsegments[1], &render))
+	assert.Equal(t, "lookup", lookup.Name)
+	assert.Equal(t, root.TraceID, lookup.TraceID)
+	assert.Equal(t, rpc.ID, lookup.ParentID)
+	assert.Empty(t, lookup.Type)
+	assert.Equal(t, "render", render.Name)
+	assert.Equal(t, rpc.ID, render.ParentID)
+
+	if assert.Len(t, lookup.Subsegments, 1) && assert.NoError(t, json.Unmarshal(lookup.Subsegments[0], &query)) {
+		assert.Equal(t, "query", query.Name)
+		assert.Equal(t, "1111111111111111", query.ParentID)
+		assert.Equal(t, root.TraceID, query.TraceID)
+	}
+}
+
+func TestAttachRemoteSubsegmentsSingleDocument(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "root")
+	assert.NoError(t, root.AttachRemoteSubsegments([]byte(`{"id": "1111111111111111", "name": "remote", "start_time": 1, "end_time": 2}`)))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if assert.NoError(t, err) && assert.Len(t, seg.Subsegments, 1) {
+		var remote Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &remote))
+		assert.Equal(t, root.ID, remote.ParentID)
+	}
+}
+
+func TestAttachRemoteSubsegmentsRejectsMalformedInput(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "root")
+	defer root.Close(nil)
+
+	for _, raw := range []string{
+		``,
+		`[]`,
+		`not json`,
+		`[{"id": "1111111111111111", "name": "remote", "start_time": 1, "end_time": 2},]`,
+		`{"id": "111111111111111", "name": "short id", "start_time": 1, "end_time": 2}`,
+		`{"id": "111111111111111g", "name": "not hex", "start_time": 1, "end_time": 2}`,
+		`{"id": "1111111111111111", "start_time": 1, "end_time": 2}`,
+		`{"id": "1111111111111111", "name": "no end", "start_time": 1}`,
+		`{"id": "1111111111111111", "name": "in progress", "start_time": 1, "end_time": 2, "in_progress": true}`,
+		`{"id": "1111111111111111", "name": "other trace", "start_time": 1, "end_time": 2, "trace_id": "1-57ff426a-80c11c39b0c928905eb0828d"}`,
+		`[{"id": "1111111111111111", "name": "a", "start_time": 1, "end_time": 2}, {"id": "1111111111111111", "name": "b", "start_time": 1, "end_time": 2}]`,
+		`{"id": "1111111111111111", "name": "bad child", "start_time": 1, "end_time": 2, "subsegments": [{"id": "x", "name": "child", "start_time": 1, "end_time": 2}]}`,
+	} {
+		assert.Error(t, root.AttachRemoteSubsegments([]byte(raw)), raw)
+	}
+	assert.Empty(t, root.rawSubsegments)
+}
+
+func TestAttachRemoteSubsegmentsToClosedSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "root")
+	root.Close(nil)
+	assert.Error(t, root.AttachRemoteSubsegments([]byte(`{"id": "1111111111111111", "name": "remote", "start_time": 1, "end_time": 2}`)))
+}
@@ -0,0 +1,52 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+)
+
+// ScheduledTracer traces periodic work, such as the runs of a ticker or a
+// cron job, by recording every run as its own trace.
+type ScheduledTracer struct {
+	// Context, if set, is the parent of the context passed to the traced
+	// function, such as a context created with ContextWithConfig to use a
+	// configuration other than the global one.
+	Context context.Context
+
+	name string
+}
+
+// NewScheduledTracer initializes a ScheduledTracer which names the segment of
+// every run with the provided name.
+func NewScheduledTracer(name string) *ScheduledTracer {
+	return &ScheduledTracer{name: name}
+}
+
+// Tick runs fn within a new root segment and closes it with the error fn
+// returns, which Tick also returns. The sampling strategy decides for every
+// run whether it is traced. A panic in fn is recorded as a fault on the
+// segment before it continues to unwind.
+func (st *ScheduledTracer) Tick(fn func(context.Context) error) (err error) {
+	parent := st.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, seg := BeginSegment(parent, st.name)
+	defer func() {
+		if p := recover(); p != nil {
+			seg.closeOnPanic(p)
+			panic(p)
+		}
+		seg.Close(err)
+	}()
+
+	return fn(ctx)
+}
@@ -0,0 +1,116 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+// alternatingSamplingStrategy samples every other request, starting with the
+// first one.
+type alternatingSamplingStrategy struct {
+	calls int
+}
+
+func (s *alternatingSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	s.calls++
+	return &sampling.Decision{Sample: s.calls%2 == 1}
+}
+
+func TestScheduledTracerTick(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	st := NewScheduledTracer("cleanup")
+	st.Context = ctx
+
+	var traceIDs []string
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, st.Tick(func(ctx context.Context) error {
+			_, subseg := BeginSubsegment(ctx, "delete")
+			subseg.Close(nil)
+			return nil
+		}))
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "cleanup", seg.Name)
+		assert.Len(t, seg.Subsegments, 1)
+		traceIDs = append(traceIDs, seg.TraceID)
+	}
+	assert.NotEqual(t, traceIDs[0], traceIDs[1])
+}
+
+func TestScheduledTracerTickError(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	st := NewScheduledTracer("cleanup")
+	st.Context = ctx
+
+	tickErr := errors.New("cleanup failed")
+	assert.Equal(t, tickErr, st.Tick(func(ctx context.Context) error {
+		return tickErr
+	}))
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, seg.Fault)
+	assert.Equal(t, "cleanup failed", seg.Cause.Exceptions[0].Message)
+}
+
+func TestScheduledTracerTickSampling(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ss := &alternatingSamplingStrategy{}
+	GetRecorder(ctx).SamplingStrategy = ss
+
+	st := NewScheduledTracer("cleanup")
+	st.Context = ctx
+
+	var sampled []bool
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, st.Tick(func(ctx context.Context) error {
+			sampled = append(sampled, GetSegment(ctx).Sampled)
+			return nil
+		}))
+	}
+	assert.Equal(t, 3, ss.calls)
+	assert.Equal(t, []bool{true, false, true}, sampled)
+}
+
+func TestScheduledTracerTickPanic(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	st := NewScheduledTracer("cleanup")
+	st.Context = ctx
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = st.Tick(func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, seg.Fault)
+	assert.Equal(t, "panic", seg.Cause.Exceptions[0].Type)
+}
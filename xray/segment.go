@@ -11,18 +11,26 @@ package xray
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
 	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/utils"
 )
 
 // NewTraceID generates a string format of random trace ID.
@@ -32,7 +40,7 @@ func NewTraceID() string {
 	if err != nil {
 		panic(err)
 	}
-	return fmt.Sprintf("1-%08x-%02x", time.Now().Unix(), r)
+	return fmt.Sprintf("1-%08x-%02x", clock.Now().Unix(), r)
 }
 
 // NewSegmentID generates a string format of segment ID.
@@ -45,6 +53,20 @@ func NewSegmentID() string {
 	return fmt.Sprintf("%02x", r)
 }
 
+// newSegmentID generates the ID of seg with the SegmentIDGenerator of its
+// configuration, falling back to NewSegmentID.
+func (seg *Segment) newSegmentID() string {
+	if seg.ParentSegment == nil || seg.ParentSegment.Configuration == nil || seg.ParentSegment.Configuration.SegmentIDGenerator == nil {
+		return NewSegmentID()
+	}
+	id := seg.ParentSegment.Configuration.SegmentIDGenerator()
+	if !header.IsValidSegmentID(id) {
+		logger.RateLimitedErrorf("Ignoring invalid segment ID %q from SegmentIDGenerator: segment IDs must be 16 lowercase hexadecimal digits", id)
+		return NewSegmentID()
+	}
+	return id
+}
+
 func noOpTraceID() string {
 	return "1-00000000-000000000000000000000000"
 }
@@ -57,23 +79,125 @@ func noOpSegmentID() string {
 // NOTE: This is an internal API only to be used in Lambda context within the SDK. Consider using BeginSegment instead.
 func BeginFacadeSegment(ctx context.Context, name string, h *header.Header) (context.Context, *Segment) {
 	seg := basicSegment(name, h)
+	seg.Origin = LambdaFunctionOrigin
+
+	cfg := GetRecorder(ctx)
+	seg.assignConfiguration(cfg)
 
 	if h == nil {
 		// generates segment and trace id based on sampling decision and AWS_XRAY_NOOP_ID env variable
 		idGeneration(seg)
 	}
 
-	cfg := GetRecorder(ctx)
-	seg.assignConfiguration(cfg)
-
 	return context.WithValue(ctx, ContextKey, seg), seg
 }
 
+// NestedSegmentBehavior is what BeginSegment does when its context already
+// holds an open segment or subsegment, usually by mistake, which would
+// otherwise split the work into two traces.
+type NestedSegmentBehavior int
+
+const (
+	// NestedSegmentDefault leaves the behavior to the global configuration,
+	// which defaults to NestedSegmentAsSubsegment.
+	NestedSegmentDefault NestedSegmentBehavior = iota
+
+	// NestedSegmentAsSubsegment begins a subsegment of the segment or
+	// subsegment of the context instead of a segment.
+	NestedSegmentAsSubsegment
+
+	// NestedSegmentAsSegment begins a segment regardless, the root of a
+	// trace of its own, as BeginSegment used to.
+	NestedSegmentAsSegment
+
+	// NestedSegmentError reports the call to the ContextMissingStrategy,
+	// which logs it by default, and begins nothing: BeginSegment returns
+	// ctx and an unsampled segment, which is never sent.
+	NestedSegmentError
+)
+
+// OpenSubsegmentPolicy is what closing a segment does while some of its
+// subsegments are still open, for instance subsegments of work started in
+// the background which outlives the request.
+type OpenSubsegmentPolicy int
+
+const (
+	// OpenSubsegmentsInProgress sends the segment right away, with its open
+	// subsegments marked in progress. Closing them afterwards sends
+	// nothing, so what they record from then on is lost.
+	OpenSubsegmentsInProgress OpenSubsegmentPolicy = iota
+
+	// OpenSubsegmentsReport sends the segment like
+	// OpenSubsegmentsInProgress, and logs an error naming the open
+	// subsegments, for finding the code which leaves them open.
+	OpenSubsegmentsReport
+
+	// OpenSubsegmentsDetach sends the segment right away, without its open
+	// subsegments. Each of them is sent on its own once closed, as a
+	// subsegment document with the trace ID and parent ID linking it to
+	// the segment.
+	OpenSubsegmentsDetach
+)
+
 // BeginSegment creates a Segment for a given name and context.
+// The returned context is derived from ctx, so values, deadlines and
+// cancellation of ctx remain visible to code running within the segment.
+// Nothing is sent to the daemon when a segment begins: the segment is
+// emitted once, after it has been closed, and only completed subsegments
+// are streamed before that.
+//
+// If ctx already holds an open segment or subsegment, what is begun
+// depends on the Config.NestedSegmentBehavior of its segment: by default a
+// subsegment of it.
 func BeginSegment(ctx context.Context, name string) (context.Context, *Segment) {
+	if parent := openSegment(ctx); parent != nil {
+		cfg := parent.ParentSegment.GetConfiguration()
+		switch cfg.NestedSegmentBehavior {
+		case NestedSegmentDefault, NestedSegmentAsSubsegment:
+			return BeginSubsegment(ctx, name)
+		case NestedSegmentError:
+			cfg.ContextMissingStrategy.ContextMissing(fmt.Sprintf("failed to begin segment named '%v': the context already holds segment '%v'.", name, parent.Name))
+			return ctx, dummySegment(name)
+		}
+	}
 	return BeginSegmentWithSampling(ctx, name, nil, nil)
 }
 
+// dummySegment returns an unsampled segment named name which is not part
+// of any segment tree, so that callers handed it can record into it and
+// close it without effect.
+func dummySegment(name string) *Segment {
+	seg := &Segment{
+		Dummy:      true,
+		Name:       name,
+		ID:         noOpSegmentID(),
+		TraceID:    noOpTraceID(),
+		StartTime:  epochNow(),
+		InProgress: true,
+	}
+	seg.ParentSegment = seg
+	return seg
+}
+
+// openSegment returns the segment or subsegment of ctx if it has not been
+// closed yet.
+func openSegment(ctx context.Context) *Segment {
+	if SdkDisabled() {
+		return nil
+	}
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return nil
+	}
+
+	seg.RLock()
+	defer seg.RUnlock()
+	if seg.ParentSegment == nil || seg.EndTime != 0 || seg.Emitted {
+		return nil
+	}
+	return seg
+}
+
 func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request, traceHeader *header.Header) (context.Context, *Segment) {
 	// If SDK is disabled then return with an empty segment
 	if SdkDisabled() {
@@ -93,18 +217,42 @@ func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request,
 	seg.Lock()
 	defer seg.Unlock()
 
-	seg.addPlugin(plugins.InstancePluginMetadata)
-	seg.addSDKAndServiceInformation()
-	if seg.ParentSegment.GetConfiguration().ServiceVersion != "" {
-		seg.GetService().Version = seg.ParentSegment.GetConfiguration().ServiceVersion
+	resourceARN := seg.ParentSegment.GetConfiguration().ResourceARN
+
+	// The trace ID of the header is kept before sampling, so that
+	// strategies can base their decision on it. A new one is only generated
+	// when a strategy is consulted, or by idGeneration for sampled segments.
+	if traceHeader != nil && traceHeader.TraceID != "" {
+		seg.TraceID = traceHeader.TraceID
 	}
 
-	if r == nil || traceHeader == nil {
-		// No header or request information provided so we can only evaluate sampling based on the serviceName
-		sd := seg.ParentSegment.GetConfiguration().SamplingStrategy.ShouldTrace(&sampling.Request{ServiceName: name})
-		seg.Sampled = sd.Sample
-		logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
-		seg.AddRuleName(sd)
+	var forced bool
+	if override := seg.ParentSegment.GetConfiguration().SamplingOverride; override != nil && r != nil {
+		var decision bool
+		if decision, forced = override(r); forced {
+			seg.Sampled = decision
+		}
+	}
+
+	if forced {
+		logger.Debugf("SamplingOverride decided: Sampled=%t", seg.Sampled)
+	} else if r == nil || traceHeader == nil {
+		if seg.ParentSegment.GetConfiguration().SampleAll {
+			seg.Sampled = true
+			logger.Debug("SampleAll decided: Sampled=true")
+		} else if isLargeRequest(seg.ParentSegment.GetConfiguration(), r) {
+			seg.Sampled = true
+			logger.Debug("Request size decided: Sampled=true")
+		} else {
+			// No header or request information provided so we can only evaluate sampling based on the serviceName
+			seg.ensureTraceID()
+			sd := seg.ParentSegment.GetConfiguration().SamplingStrategy.ShouldTrace(&sampling.Request{ServiceName: seg.Name, ResourceARN: resourceARN, TraceID: seg.TraceID, Attributes: samplingAttributes(ctx)})
+			seg.Sampled = sd.Sample
+			logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
+			seg.AddRuleName(sd)
+			seg.addSamplingRate(sd)
+			seg.sampleMinimumRate()
+		}
 	} else {
 		// Sampling strategy for http calls
 		seg.Sampled = traceHeader.SamplingDecision == header.Sampled
@@ -116,26 +264,66 @@ func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request,
 			logger.Debug("Incoming header decided: Sampled=false")
 		}
 
-		if traceHeader.SamplingDecision != header.Sampled && traceHeader.SamplingDecision != header.NotSampled {
+		debug := traceHeader.AdditionalData[DebugTraceHeaderKey] == "1"
+		if debug {
+			seg.markDebug(true)
+		}
+
+		if debug && seg.ParentSegment.GetConfiguration().SampleDebugTraces {
+			seg.Sampled = true
+			logger.Debug("Debug trace header decided: Sampled=true")
+		} else if sampleAll(seg.ParentSegment.GetConfiguration(), traceHeader) {
+			seg.Sampled = true
+			logger.Debug("SampleAll decided: Sampled=true")
+		} else if traceHeader.SamplingDecision != header.Sampled && traceHeader.SamplingDecision != header.NotSampled && isLargeRequest(seg.ParentSegment.GetConfiguration(), r) {
+			seg.Sampled = true
+			logger.Debug("Request size decided: Sampled=true")
+		} else if traceHeader.SamplingDecision != header.Sampled && traceHeader.SamplingDecision != header.NotSampled {
+			seg.ensureTraceID()
 			samplingRequest := &sampling.Request{
 				Host:        r.Host,
 				URL:         r.URL.Path,
 				Method:      r.Method,
 				ServiceName: seg.Name,
 				ServiceType: plugins.InstancePluginMetadata.Origin,
+				ResourceARN: resourceARN,
+				TraceID:     seg.TraceID,
+				Attributes:  samplingAttributes(ctx),
 			}
 			sd := seg.ParentSegment.GetConfiguration().SamplingStrategy.ShouldTrace(samplingRequest)
 			seg.Sampled = sd.Sample
 			logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
 			seg.AddRuleName(sd)
+			seg.addSamplingRate(sd)
+			seg.sampleMinimumRate()
 		}
 	}
 
+	if seg.Sampled && emitterSuspendsTracing(seg.ParentSegment.GetConfiguration().Emitter) {
+		seg.Sampled = false
+		logger.Debug("Emitter circuit breaker decided: Sampled=false")
+	}
+
 	// check whether segment is dummy or not based on sampling decision
 	if !seg.ParentSegment.Sampled {
 		seg.Dummy = true
 	}
 
+	// Unsampled segments are never emitted, so only sampled ones are given
+	// the data describing the service.
+	if !seg.Dummy {
+		seg.addPlugin(plugins.InstancePluginMetadata)
+		if !seg.ParentSegment.GetConfiguration().DisableSDKMetadata {
+			seg.addSDKAndServiceInformation()
+		}
+		if seg.ParentSegment.GetConfiguration().ServiceVersion != "" {
+			seg.GetService().Version = seg.ParentSegment.GetConfiguration().ServiceVersion
+		}
+		if resourceARN != "" {
+			seg.GetAWS()["resource_arn"] = resourceARN
+		}
+	}
+
 	// Dummy segments don't get sent and don't need a goroutine to cancel them.
 	if !seg.Dummy {
 		// Create a new context for to cancel segment.
@@ -149,7 +337,7 @@ func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request,
 		seg.cancelCtx = cancelCtx
 		go func() {
 			<-ctx1.Done()
-			seg.handleContextDone()
+			seg.handleContextDone(ctx.Err())
 		}()
 	}
 
@@ -159,18 +347,29 @@ func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request,
 	return context.WithValue(ctx, ContextKey, seg), seg
 }
 
+// ensureTraceID generates the trace ID of seg unless it has one.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) ensureTraceID() {
+	if seg.TraceID == "" {
+		seg.TraceID = NewTraceID()
+	}
+}
+
+// idGeneration assigns the IDs of seg. Unsampled segments get no-op IDs
+// unless AWS_XRAY_NOOP_ID is false; others keep the trace ID decided before
+// sampling, if any.
 func idGeneration(seg *Segment) {
 	noOpID := os.Getenv("AWS_XRAY_NOOP_ID")
 	if noOpID != "" && strings.ToLower(noOpID) == "false" {
-		seg.TraceID = NewTraceID()
-		seg.ID = NewSegmentID()
+		seg.ensureTraceID()
+		seg.ID = seg.newSegmentID()
 	} else {
 		if !seg.Sampled {
 			seg.TraceID = noOpTraceID()
 			seg.ID = noOpSegmentID()
 		} else {
-			seg.TraceID = NewTraceID()
-			seg.ID = NewSegmentID()
+			seg.ensureTraceID()
+			seg.ID = seg.newSegmentID()
 		}
 	}
 }
@@ -187,7 +386,7 @@ func basicSegment(name string, h *header.Header) *Segment {
 	defer seg.Unlock()
 
 	seg.Name = name
-	seg.StartTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	seg.StartTime = epochNow()
 	seg.InProgress = true
 	seg.Dummy = false
 
@@ -198,11 +397,45 @@ func basicSegment(name string, h *header.Header) *Segment {
 		seg.ID = h.ParentID
 		seg.TraceID = h.TraceID
 		seg.Sampled = h.SamplingDecision == header.Sampled
+		seg.IncomingHeader = h
 	}
 
 	return seg
 }
 
+// sampleAll reports whether Config.SampleAll samples a request with the
+// trace header h, which is only sampled against an upstream Sampled=0 if
+// Config.OverrideUpstreamSampling is set as well.
+func sampleAll(cfg *Config, h *header.Header) bool {
+	if !cfg.SampleAll {
+		return false
+	}
+	return h.SamplingDecision != header.NotSampled || cfg.OverrideUpstreamSampling
+}
+
+// isLargeRequest reports whether Config.SampleLargeRequestsOver samples r.
+func isLargeRequest(cfg *Config, r *http.Request) bool {
+	return r != nil && cfg.SampleLargeRequestsOver > 0 && r.ContentLength > cfg.SampleLargeRequestsOver
+}
+
+// minimumSampleRand decides the samples of Config.MinimumSampleRate.
+var minimumSampleRand utils.Rand = &utils.DefaultRand{}
+
+// sampleMinimumRate samples seg, if the sampling strategy did not, with the
+// probability of Config.MinimumSampleRate.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) sampleMinimumRate() {
+	rate := seg.ParentSegment.GetConfiguration().MinimumSampleRate
+	if seg.Sampled || rate <= 0 {
+		return
+	}
+	if minimumSampleRand.Float64() < rate {
+		seg.Sampled = true
+		seg.addSamplingRate(&sampling.Decision{Sample: true, Rate: &rate})
+		logger.Debug("MinimumSampleRate decided: Sampled=true")
+	}
+}
+
 // assignConfiguration assigns value to seg.Configuration
 func (seg *Segment) assignConfiguration(cfg *Config) {
 	seg.Lock()
@@ -213,6 +446,42 @@ func (seg *Segment) assignConfiguration(cfg *Config) {
 		seg.GetConfiguration().StreamingStrategy = globalCfg.streamingStrategy
 		seg.GetConfiguration().Emitter = globalCfg.emitter
 		seg.GetConfiguration().ServiceVersion = globalCfg.serviceVersion
+		seg.GetConfiguration().CaptureRequestHeaders = globalCfg.captureRequestHeaders
+		seg.GetConfiguration().CaptureResponseHeaders = globalCfg.captureResponseHeaders
+		seg.GetConfiguration().ResourceARN = globalCfg.resourceARN
+		seg.GetConfiguration().TraceHeaderName = globalCfg.traceHeaderName
+		seg.GetConfiguration().FaultOnRequestDeadline = globalCfg.faultOnRequestDeadline
+		seg.GetConfiguration().DisableStackTraces = globalCfg.disableStackTraces
+		seg.GetConfiguration().CaptureSQLPrepareTimings = globalCfg.captureSQLPrepareTimings
+		seg.GetConfiguration().CountSQLRows = globalCfg.countSQLRows
+		seg.GetConfiguration().CaptureSQLPoolStats = globalCfg.captureSQLPoolStats
+		seg.GetConfiguration().ValidateSegments = globalCfg.validateSegments
+		seg.GetConfiguration().DisableSDKMetadata = globalCfg.disableSDKMetadata
+		seg.GetConfiguration().SampleAll = globalCfg.sampleAll
+		seg.GetConfiguration().OverrideUpstreamSampling = globalCfg.overrideUpstreamSampling
+		seg.GetConfiguration().CaptureCallerLocation = globalCfg.captureCallerLocation
+		seg.GetConfiguration().CoalesceSubsegments = globalCfg.coalesceSubsegments
+		seg.GetConfiguration().AggregationPolicy = globalCfg.aggregationPolicy
+		seg.GetConfiguration().SampleDebugTraces = globalCfg.sampleDebugTraces
+		seg.GetConfiguration().CaptureRequestBodyReadTime = globalCfg.captureRequestBodyReadTime
+		seg.GetConfiguration().MaxSubsegmentsPerSegment = globalCfg.maxSubsegmentsPerSegment
+		seg.GetConfiguration().TruncateOversizeSegments = globalCfg.truncateOversizeSegments
+		seg.GetConfiguration().EmitSubsegmentsIndependently = globalCfg.independentSubsegments
+		seg.GetConfiguration().SegmentIDGenerator = globalCfg.segmentIDGenerator
+		seg.GetConfiguration().AnnotationKeySanitizer = globalCfg.annotationKeySanitizer
+		seg.GetConfiguration().AnnotationCardinalityGuard = globalCfg.annotationCardinalityGuard
+		seg.GetConfiguration().SamplingOverride = globalCfg.samplingOverride
+		seg.GetConfiguration().SampleLargeRequestsOver = globalCfg.sampleLargeRequestsOver
+		seg.GetConfiguration().MinimumSampleRate = globalCfg.minimumSampleRate
+		seg.GetConfiguration().OnEmit = globalCfg.onEmit
+		seg.GetConfiguration().NestedSegmentBehavior = globalCfg.nestedSegmentBehavior
+		seg.GetConfiguration().OpenSubsegmentPolicy = globalCfg.openSubsegmentPolicy
+		seg.GetConfiguration().CaptureGoroutineID = globalCfg.captureGoroutineID
+		seg.GetConfiguration().PromoteOrphanSubsegments = globalCfg.promoteOrphanSubsegments
+		seg.GetConfiguration().CorrelationIDHeader = globalCfg.correlationIDHeader
+		seg.GetConfiguration().CorrelationIDGenerator = globalCfg.correlationIDGenerator
+		seg.GetConfiguration().CloseSegmentsOnCancel = globalCfg.closeSegmentsOnCancel
+		seg.GetConfiguration().InferredCallerHeader = globalCfg.inferredCallerHeader
 	} else {
 		if cfg.ContextMissingStrategy != nil {
 			seg.GetConfiguration().ContextMissingStrategy = cfg.ContextMissingStrategy
@@ -249,19 +518,186 @@ func (seg *Segment) assignConfiguration(cfg *Config) {
 		} else {
 			seg.GetConfiguration().ServiceVersion = globalCfg.serviceVersion
 		}
+
+		if cfg.CaptureRequestHeaders != nil {
+			seg.GetConfiguration().CaptureRequestHeaders = cfg.CaptureRequestHeaders
+		} else {
+			seg.GetConfiguration().CaptureRequestHeaders = globalCfg.captureRequestHeaders
+		}
+
+		if cfg.CaptureResponseHeaders != nil {
+			seg.GetConfiguration().CaptureResponseHeaders = cfg.CaptureResponseHeaders
+		} else {
+			seg.GetConfiguration().CaptureResponseHeaders = globalCfg.captureResponseHeaders
+		}
+
+		if cfg.ResourceARN != "" {
+			seg.GetConfiguration().ResourceARN = cfg.ResourceARN
+		} else {
+			seg.GetConfiguration().ResourceARN = globalCfg.resourceARN
+		}
+
+		if cfg.TraceHeaderName != "" {
+			seg.GetConfiguration().TraceHeaderName = cfg.TraceHeaderName
+		} else {
+			seg.GetConfiguration().TraceHeaderName = globalCfg.traceHeaderName
+		}
+
+		seg.GetConfiguration().FaultOnRequestDeadline = cfg.FaultOnRequestDeadline || globalCfg.faultOnRequestDeadline
+		seg.GetConfiguration().DisableStackTraces = cfg.DisableStackTraces || globalCfg.disableStackTraces
+		seg.GetConfiguration().CaptureSQLPrepareTimings = cfg.CaptureSQLPrepareTimings || globalCfg.captureSQLPrepareTimings
+		seg.GetConfiguration().CountSQLRows = cfg.CountSQLRows || globalCfg.countSQLRows
+		seg.GetConfiguration().CaptureSQLPoolStats = cfg.CaptureSQLPoolStats || globalCfg.captureSQLPoolStats
+		seg.GetConfiguration().ValidateSegments = cfg.ValidateSegments || globalCfg.validateSegments
+		seg.GetConfiguration().DisableSDKMetadata = cfg.DisableSDKMetadata || globalCfg.disableSDKMetadata
+		seg.GetConfiguration().SampleAll = cfg.SampleAll || globalCfg.sampleAll
+		seg.GetConfiguration().OverrideUpstreamSampling = cfg.OverrideUpstreamSampling || globalCfg.overrideUpstreamSampling
+		seg.GetConfiguration().CaptureCallerLocation = cfg.CaptureCallerLocation || globalCfg.captureCallerLocation
+
+		if cfg.CoalesceSubsegments > 0 {
+			seg.GetConfiguration().CoalesceSubsegments = cfg.CoalesceSubsegments
+		} else {
+			seg.GetConfiguration().CoalesceSubsegments = globalCfg.coalesceSubsegments
+		}
+
+		if cfg.AggregationPolicy != nil {
+			seg.GetConfiguration().AggregationPolicy = cfg.AggregationPolicy
+		} else {
+			seg.GetConfiguration().AggregationPolicy = globalCfg.aggregationPolicy
+		}
+
+		seg.GetConfiguration().SampleDebugTraces = cfg.SampleDebugTraces || globalCfg.sampleDebugTraces
+		seg.GetConfiguration().CaptureRequestBodyReadTime = cfg.CaptureRequestBodyReadTime || globalCfg.captureRequestBodyReadTime
+
+		if cfg.MaxSubsegmentsPerSegment > 0 {
+			seg.GetConfiguration().MaxSubsegmentsPerSegment = cfg.MaxSubsegmentsPerSegment
+		} else {
+			seg.GetConfiguration().MaxSubsegmentsPerSegment = globalCfg.maxSubsegmentsPerSegment
+		}
+
+		seg.GetConfiguration().TruncateOversizeSegments = cfg.TruncateOversizeSegments || globalCfg.truncateOversizeSegments
+		seg.GetConfiguration().EmitSubsegmentsIndependently = cfg.EmitSubsegmentsIndependently || globalCfg.independentSubsegments
+
+		if cfg.SegmentIDGenerator != nil {
+			seg.GetConfiguration().SegmentIDGenerator = cfg.SegmentIDGenerator
+		} else {
+			seg.GetConfiguration().SegmentIDGenerator = globalCfg.segmentIDGenerator
+		}
+
+		if cfg.AnnotationKeySanitizer != nil {
+			seg.GetConfiguration().AnnotationKeySanitizer = cfg.AnnotationKeySanitizer
+		} else {
+			seg.GetConfiguration().AnnotationKeySanitizer = globalCfg.annotationKeySanitizer
+		}
+
+		if cfg.AnnotationCardinalityGuard != nil {
+			seg.GetConfiguration().AnnotationCardinalityGuard = cfg.AnnotationCardinalityGuard
+		} else {
+			seg.GetConfiguration().AnnotationCardinalityGuard = globalCfg.annotationCardinalityGuard
+		}
+
+		if cfg.SamplingOverride != nil {
+			seg.GetConfiguration().SamplingOverride = cfg.SamplingOverride
+		} else {
+			seg.GetConfiguration().SamplingOverride = globalCfg.samplingOverride
+		}
+
+		if cfg.SampleLargeRequestsOver > 0 {
+			seg.GetConfiguration().SampleLargeRequestsOver = cfg.SampleLargeRequestsOver
+		} else {
+			seg.GetConfiguration().SampleLargeRequestsOver = globalCfg.sampleLargeRequestsOver
+		}
+
+		if cfg.MinimumSampleRate > 0 {
+			seg.GetConfiguration().MinimumSampleRate = cfg.MinimumSampleRate
+		} else {
+			seg.GetConfiguration().MinimumSampleRate = globalCfg.minimumSampleRate
+		}
+
+		if cfg.OnEmit != nil {
+			seg.GetConfiguration().OnEmit = cfg.OnEmit
+		} else {
+			seg.GetConfiguration().OnEmit = globalCfg.onEmit
+		}
+
+		if cfg.NestedSegmentBehavior != NestedSegmentDefault {
+			seg.GetConfiguration().NestedSegmentBehavior = cfg.NestedSegmentBehavior
+		} else {
+			seg.GetConfiguration().NestedSegmentBehavior = globalCfg.nestedSegmentBehavior
+		}
+
+		if cfg.OpenSubsegmentPolicy != OpenSubsegmentsInProgress {
+			seg.GetConfiguration().OpenSubsegmentPolicy = cfg.OpenSubsegmentPolicy
+		} else {
+			seg.GetConfiguration().OpenSubsegmentPolicy = globalCfg.openSubsegmentPolicy
+		}
+
+		seg.GetConfiguration().CaptureGoroutineID = cfg.CaptureGoroutineID || globalCfg.captureGoroutineID
+		seg.GetConfiguration().PromoteOrphanSubsegments = cfg.PromoteOrphanSubsegments || globalCfg.promoteOrphanSubsegments
+
+		if cfg.CorrelationIDHeader != "" {
+			seg.GetConfiguration().CorrelationIDHeader = cfg.CorrelationIDHeader
+		} else {
+			seg.GetConfiguration().CorrelationIDHeader = globalCfg.correlationIDHeader
+		}
+
+		if cfg.CorrelationIDGenerator != nil {
+			seg.GetConfiguration().CorrelationIDGenerator = cfg.CorrelationIDGenerator
+		} else {
+			seg.GetConfiguration().CorrelationIDGenerator = globalCfg.correlationIDGenerator
+		}
+
+		seg.GetConfiguration().CloseSegmentsOnCancel = cfg.CloseSegmentsOnCancel || globalCfg.closeSegmentsOnCancel
+
+		if cfg.InferredCallerHeader != "" {
+			seg.GetConfiguration().InferredCallerHeader = cfg.InferredCallerHeader
+		} else {
+			seg.GetConfiguration().InferredCallerHeader = globalCfg.inferredCallerHeader
+		}
 	}
 	seg.Unlock()
 }
 
 func BeginSubsegmentWithoutSampling(ctx context.Context, name string) (context.Context, *Segment) {
 	newCtx, subseg := BeginSubsegment(ctx, name)
+	if subseg == nil {
+		return newCtx, nil
+	}
+
+	// subseg is already visible to its parent, so it may be read concurrently.
+	subseg.Lock()
 	subseg.Dummy = true
 	subseg.Sampled = false
+	subseg.Unlock()
 	return newCtx, subseg
 }
 
 // BeginSubsegment creates a subsegment for a given name and context.
+//
+// The subsegment is local: it has no namespace and no http block, so X-Ray
+// shows it in the timeline of its segment without inferring a node of the
+// service map from it, which suits timing local work such as a CPU-bound
+// function. Subsegments for calls to other services are remote: the
+// clients of the SDK, such as Client, AWS and SQLContext, set their
+// namespace to "remote" or "aws" and record the request, so that X-Ray
+// adds the called service to the map. Set the Namespace and HTTP request
+// of a subsegment to record such a call by hand.
 func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
+	ctx, seg := newSubsegment(ctx, name, false, false)
+	recordCallerLocation(seg)
+	return ctx, seg
+}
+
+// beginInternalSubsegment begins a subsegment the SDK creates for itself,
+// such as the phases of an HTTP round trip or an AWS request.
+func beginInternalSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
+	return newSubsegment(ctx, name, true, false)
+}
+
+// newSubsegment creates a subsegment for a given name and context. internal
+// is true for subsegments the SDK creates for itself, and pooled is true if
+// the subsegment is taken from segmentPool.
+func newSubsegment(ctx context.Context, name string, internal, pooled bool) (context.Context, *Segment) {
 	// If SDK is disabled then return with an empty segment
 	if SdkDisabled() {
 		seg := &Segment{}
@@ -274,12 +710,16 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 
 	var parent *Segment
 	// first time to create facade segment
-	if getTraceHeaderFromContext(ctx) != nil && GetSegment(ctx) == nil {
+	if getLambdaTraceHeader(ctx) != nil && GetSegment(ctx) == nil {
 		_, parent = newFacadeSegment(ctx)
 	} else {
 		parent = GetSegment(ctx)
 		if parent == nil {
 			cfg := GetRecorder(ctx)
+			if !internal && (globalCfg.promoteOrphanSubsegments || cfg != nil && cfg.PromoteOrphanSubsegments) {
+				logger.Debugf("Beginning segment named %s for a subsegment without segment", name)
+				return BeginSegment(ctx, name)
+			}
 			failedMessage := fmt.Sprintf("failed to begin subsegment named '%v': segment cannot be found.", name)
 			if cfg != nil && cfg.ContextMissingStrategy != nil {
 				cfg.ContextMissingStrategy.ContextMissing(failedMessage)
@@ -290,7 +730,108 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 		}
 	}
 
-	seg := &Segment{parent: parent}
+	seg := parent.newChild(name, internal, pooled)
+	for key, value := range defaultAnnotations(ctx) {
+		seg.AddAnnotation(key, value)
+	}
+	return context.WithValue(ctx, ContextKey, seg), seg
+}
+
+// BeginSubsegment creates a subsegment for a given name as a child of seg.
+// Unlike the context based BeginSubsegment it does not need a context, which
+// allows explicitly parenting subsegments to a segment which is already held.
+//
+// Libraries which do not pass a context around can trace entirely through
+// segment values: the context given to BeginSegment only supplies the
+// configuration, and the returned context may be dropped. Subsegments are
+// then begun with this method, annotated with methods such as AddAnnotation,
+// AddMetadata and AddError, and propagated with DownstreamHeader. Closing the
+// root segment after its subsegments emits the segment tree, and subsegments
+// of a closed root are emitted as they close.
+func (seg *Segment) BeginSubsegment(name string) *Segment {
+	// If SDK is disabled then return with an empty segment
+	if SdkDisabled() {
+		return &Segment{}
+	}
+
+	if seg == nil {
+		logger.Debugf("No parent segment to begin subsegment named %s. No-op", name)
+		return nil
+	}
+
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	subseg := seg.newChild(name, false, false)
+	recordCallerLocation(subseg)
+	return subseg
+}
+
+// BeginSubsegmentWithoutSampling creates a subsegment of seg which is not
+// sampled, like the context based BeginSubsegmentWithoutSampling.
+func (seg *Segment) BeginSubsegmentWithoutSampling(name string) *Segment {
+	subseg := seg.BeginSubsegment(name)
+	if subseg == nil || SdkDisabled() {
+		return subseg
+	}
+
+	// subseg is already visible to its parent, so it may be read concurrently.
+	subseg.Lock()
+	subseg.Dummy = true
+	subseg.Sampled = false
+	subseg.Unlock()
+	return subseg
+}
+
+// recordCallerLocation adds the file and line of the first caller outside
+// the SDK as the "source" metadata of seg if CaptureCallerLocation is set.
+func recordCallerLocation(seg *Segment) {
+	if seg == nil || seg.ParentSegment == nil || !seg.ParentSegment.GetConfiguration().CaptureCallerLocation {
+		return
+	}
+
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !isSDKFrame(frame) {
+			seg.AddMetadata("source", fmt.Sprintf("%s:%d", frame.File, frame.Line))
+			return
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// isSDKFrame reports whether frame belongs to the SDK itself rather than to
+// the code using it. The SDK's own tests count as code using it.
+func isSDKFrame(frame runtime.Frame) bool {
+	return strings.HasPrefix(frame.Function, "github.com/aws/aws-xray-sdk-go/") &&
+		!strings.HasSuffix(frame.File, "_test.go")
+}
+
+// newChild creates a subsegment for a given name and adds it to the children
+// of parent. internal is true for subsegments the SDK creates for itself,
+// and pooled is true if the subsegment is taken from segmentPool.
+func (parent *Segment) newChild(name string, internal, pooled bool) *Segment {
+	if root := parent.ParentSegment; root != nil && !root.Sampled {
+		return parent.unsampledChild(name)
+	}
+	if root := parent.ParentSegment; root != nil && !internal && !root.reserveSubsegment() {
+		return parent.droppedChild(name)
+	}
+
+	var seg *Segment
+	if pooled {
+		seg = getPooledSegment()
+	} else {
+		seg = &Segment{}
+	}
+	seg.internal = internal
+	seg.parent = parent
 	logger.Debugf("Beginning subsegment named %s", name)
 
 	seg.Lock()
@@ -301,12 +842,12 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 	// generates subsegment id based on sampling decision and AWS_XRAY_NOOP_ID env variable
 	noOpID := os.Getenv("AWS_XRAY_NOOP_ID")
 	if noOpID != "" && strings.ToLower(noOpID) == "false" {
-		seg.ID = NewSegmentID()
+		seg.ID = seg.newSegmentID()
 	} else {
 		if !seg.ParentSegment.Sampled {
 			seg.ID = noOpSegmentID()
 		} else {
-			seg.ID = NewSegmentID()
+			seg.ID = seg.newSegmentID()
 		}
 	}
 
@@ -323,13 +864,82 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 	parent.Unlock()
 
 	seg.Name = name
-	seg.StartTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	seg.StartTime = epochNow()
 	seg.InProgress = true
 	seg.Sampled = seg.ParentSegment.Sampled
 	seg.TraceID = seg.ParentSegment.TraceID
 	seg.ParentID = seg.ParentSegment.ID
 
-	return context.WithValue(ctx, ContextKey, seg), seg
+	if seg.ParentSegment.GetConfiguration().CaptureGoroutineID {
+		if id, ok := goroutineID(); ok {
+			if seg.Metadata == nil {
+				seg.Metadata = map[string]map[string]interface{}{}
+			}
+			if seg.Metadata["default"] == nil {
+				seg.Metadata["default"] = map[string]interface{}{}
+			}
+			seg.Metadata["default"]["goroutine_id"] = id
+		}
+	}
+
+	return seg
+}
+
+// reserveSubsegment counts a subsegment begun in the tree of root and
+// reports whether it fits within Config.MaxSubsegmentsPerSegment.
+func (root *Segment) reserveSubsegment() bool {
+	max := root.GetConfiguration().MaxSubsegmentsPerSegment
+	return max <= 0 || atomic.AddUint32(&root.beganSubsegments, 1) <= uint32(max)
+}
+
+// droppedChild returns a subsegment of parent which is not part of the
+// segment tree, for subsegments beyond Config.MaxSubsegmentsPerSegment.
+// Downstream calls made through it carry the ID of parent.
+func (parent *Segment) droppedChild(name string) *Segment {
+	root := parent.ParentSegment
+	atomic.AddUint32(&root.droppedSubsegments, 1)
+	logger.Debugf("Dropping subsegment named %s beyond the maximum number of subsegments", name)
+
+	parent.RLock()
+	id := parent.ID
+	parent.RUnlock()
+
+	return &Segment{
+		parent:        parent,
+		ParentSegment: root,
+		Dummy:         true,
+		Sampled:       root.Sampled,
+		Name:          name,
+		ID:            id,
+		TraceID:       root.TraceID,
+		StartTime:     epochNow(),
+		InProgress:    true,
+	}
+}
+
+// unsampledChild returns a subsegment of parent, whose segment tree is not
+// sampled. Such subsegments are never emitted, so they are not added to the
+// segment tree and cost as little as possible to begin, record into and
+// close. Downstream calls made through them still carry the unsampled
+// decision and the trace ID.
+func (parent *Segment) unsampledChild(name string) *Segment {
+	root := parent.ParentSegment
+	id := noOpSegmentID()
+	if noOpID := os.Getenv("AWS_XRAY_NOOP_ID"); noOpID != "" && strings.ToLower(noOpID) == "false" {
+		id = root.newSegmentID()
+	}
+
+	return &Segment{
+		parent:        parent,
+		ParentSegment: root,
+		Dummy:         true,
+		Name:          name,
+		ID:            id,
+		TraceID:       root.TraceID,
+		ParentID:      root.ID,
+		StartTime:     epochNow(),
+		InProgress:    true,
+	}
 }
 
 // NewSegmentFromHeader creates a segment for downstream call and add information to the segment that gets from HTTP header.
@@ -351,8 +961,9 @@ func NewSegmentFromHeader(ctx context.Context, name string, r *http.Request, h *
 
 // Check if SDK is disabled
 func SdkDisabled() bool {
-	disableKey := os.Getenv("AWS_XRAY_SDK_DISABLED")
-	return strings.ToLower(disableKey) == "true"
+	// EqualFold does not allocate like ToLower, and this runs on every
+	// operation on a segment.
+	return strings.EqualFold(os.Getenv("AWS_XRAY_SDK_DISABLED"), "true")
 }
 
 // Close a segment.
@@ -368,18 +979,115 @@ func (seg *Segment) Close(err error) {
 	}
 
 	seg.Lock()
-	if seg.parent != nil {
-		logger.Debugf("Closing subsegment named %s", seg.Name)
-	} else {
-		logger.Debugf("Closing segment named %s", seg.Name)
+	seg.close(epochNow(), err)
+}
+
+// CloseAt closes seg like Close, with endTime as its end time instead of
+// the current time, for operations whose completion is only learnt later,
+// such as in an asynchronous callback. It returns an error, without closing
+// seg, if endTime is before the start time of seg.
+func (seg *Segment) CloseAt(endTime time.Time, err error) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	if seg == nil {
+		return errors.New("unable to close nil segment")
+	}
+
+	end := float64(endTime.UnixNano()) / float64(time.Second)
+	seg.Lock()
+	if end < seg.StartTime {
+		seg.Unlock()
+		return fmt.Errorf("end time %v of segment %q is before its start time %v", endTime, seg.Name, floatToTime(seg.StartTime))
+	}
+	seg.close(end, err)
+	return nil
+}
+
+// CloseAll closes every segment of segs like Close, with the same end time,
+// as for sibling subsegments completing together in an asynchronous join.
+// Closing them one by one would skew their end times by the time each Close
+// takes. Segments which are nil or already closed are skipped. Subsegments
+// are closed before their ancestors in segs, so that they are sent along
+// with them.
+func CloseAll(segs []*Segment, err error) {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return
+	}
+
+	end := epochNow()
+	open := make([]*Segment, 0, len(segs))
+	depths := make(map[*Segment]int, len(segs))
+	for _, seg := range segs {
+		if seg == nil {
+			continue
+		}
+		if _, ok := depths[seg]; ok {
+			continue
+		}
+		depths[seg] = seg.depth()
+		open = append(open, seg)
+	}
+	sort.SliceStable(open, func(i, j int) bool {
+		return depths[open[i]] > depths[open[j]]
+	})
+
+	for _, seg := range open {
+		seg.Lock()
+		if seg.EndTime != 0 {
+			seg.Unlock()
+			logger.Debugf("Segment named %s is already closed", seg.Name)
+			continue
+		}
+		seg.close(end, err)
+	}
+}
+
+// depth returns the number of ancestors of seg.
+func (seg *Segment) depth() int {
+	var depth int
+	for s := seg; ; depth++ {
+		s.RLock()
+		p := s.parent
+		s.RUnlock()
+		if p == nil {
+			return depth
+		}
+		s = p
+	}
+}
+
+// close ends seg at end and sends it.
+// seg has a write lock acquired by the caller, which close releases.
+func (seg *Segment) close(end float64, err error) {
+	// Unsampled segments are closed without logging, which would allocate
+	// for every request that is not traced.
+	if !seg.Dummy {
+		if seg.parent != nil {
+			logger.Debugf("Closing subsegment named %s", seg.Name)
+		} else {
+			logger.Debugf("Closing segment named %s", seg.Name)
+		}
 	}
-	seg.EndTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	seg.EndTime = end
 	seg.InProgress = false
 
 	if err != nil {
 		seg.addError(err)
 	}
 
+	if seg.parent == nil && seg.openSegments > 0 && !seg.Dummy {
+		switch seg.GetConfiguration().OpenSubsegmentPolicy {
+		case OpenSubsegmentsReport:
+			logger.Errorf("Segment %q closed with open subsegments %s", seg.Name, strings.Join(seg.openSubsegmentNames(), ", "))
+		case OpenSubsegmentsDetach:
+			seg.detachOpenSubsegments()
+		}
+	}
+
 	cancelSegCtx := seg.cancelCtx
 
 	seg.Unlock()
@@ -399,19 +1107,95 @@ func (seg *Segment) Close(err error) {
 	seg.send()
 }
 
-// CloseAndStream closes a subsegment and sends it.
-func (seg *Segment) CloseAndStream(err error) {
-	// If SDK is disabled then return
-	if SdkDisabled() {
-		return
+// openSubsegmentNames returns the names of the subsegments of seg which are
+// not complete yet.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) openSubsegmentNames() []string {
+	var names []string
+	for _, child := range seg.rawSubsegments {
+		child.RLock()
+		if child.EndTime == 0 || child.openSegments > 0 {
+			names = append(names, strconv.Quote(child.Name))
+		}
+		child.RUnlock()
 	}
-	
-	if seg.parent != nil {
-		logger.Debugf("Ending subsegment named: %s", seg.Name)
-		seg.Lock()
-		seg.EndTime = float64(time.Now().UnixNano()) / float64(time.Second)
-		seg.InProgress = false
-		seg.Emitted = true
+	return names
+}
+
+// detachOpenSubsegments removes the subsegments of seg which are not
+// complete yet, and makes each of them sent on its own once complete.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) detachOpenSubsegments() {
+	kept := seg.rawSubsegments[:0]
+	for _, child := range seg.rawSubsegments {
+		child.Lock()
+		if child.EndTime == 0 || child.openSegments > 0 {
+			// Typed as a subsegment, the child is emitted on its own
+			// once it is complete.
+			child.beforeEmitSubsegment(seg)
+			seg.openSegments--
+			atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(0))
+			logger.Debugf("Detaching open subsegment named %s from segment named %s", child.Name, seg.Name)
+		} else {
+			kept = append(kept, child)
+		}
+		child.Unlock()
+	}
+	for i := len(kept); i < len(seg.rawSubsegments); i++ {
+		seg.rawSubsegments[i] = nil
+	}
+	seg.rawSubsegments = kept
+}
+
+// CloseWithContext closes seg like Close, and returns an error wrapping
+// ctx.Err() if ctx is done before seg has been sent. Closing a root segment
+// also drains its emitter if the emitter implements Drainer, so that batched
+// segments are sent before CloseWithContext returns.
+//
+// It is meant for services which must send their segments within a
+// shutdown deadline: when ctx is done CloseWithContext returns right away and
+// the segment keeps being sent in the background.
+func (seg *Segment) CloseWithContext(ctx context.Context, err error) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		seg.Close(err)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("segment not sent before context was done: %w", ctx.Err())
+	}
+
+	if seg == nil || seg.parent != nil || seg.Dummy || SdkDisabled() {
+		return nil
+	}
+	emitter := globalCfg.Emitter()
+	if cfg := seg.Configuration; cfg != nil && cfg.Emitter != nil {
+		emitter = cfg.Emitter
+	}
+	if d, ok := emitter.(Drainer); ok {
+		if err := d.Drain(ctx); err != nil {
+			return fmt.Errorf("segment not sent before context was done: %w", err)
+		}
+	}
+	return nil
+}
+
+// CloseAndStream closes a subsegment and sends it.
+func (seg *Segment) CloseAndStream(err error) {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return
+	}
+	
+	if seg.parent != nil {
+		logger.Debugf("Ending subsegment named: %s", seg.Name)
+		seg.Lock()
+		seg.EndTime = epochNow()
+		seg.InProgress = false
+		seg.Emitted = true
 		seg.Unlock()
 		if seg.parent.RemoveSubsegment(seg) {
 			logger.Debugf("Removing subsegment named: %s", seg.Name)
@@ -434,6 +1218,62 @@ func (seg *Segment) CloseAndStream(err error) {
 	seg.emit()
 }
 
+// closeOnPanic closes seg with an error for the recovered panic p. Any
+// subsegments which are still in progress are closed with a fault first,
+// which shows where the panic happened once seg is emitted.
+func (seg *Segment) closeOnPanic(p interface{}) {
+	if seg == nil {
+		return
+	}
+	err := seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+	seg.closeOpenSubsegments(markFault)
+	seg.Close(err)
+}
+
+// CanceledAnnotationKey is the annotation marking the (sub)segments closed
+// because the context of their request was canceled, with
+// Config.CloseSegmentsOnCancel.
+const CanceledAnnotationKey = "canceled"
+
+// markFault marks s, which is locked, as a fault.
+func markFault(s *Segment) {
+	s.Fault = true
+}
+
+// markCanceled marks s, which is locked, with CanceledAnnotationKey.
+func markCanceled(s *Segment) {
+	if s.Annotations == nil {
+		s.Annotations = map[string]interface{}{}
+	}
+	s.Annotations[CanceledAnnotationKey] = true
+}
+
+// closeOpenSubsegments marks every subsegment below seg which is still in
+// progress with mark, called with the subsegment locked, and closes it,
+// deepest first, so that seg is not kept from being emitted by subsegments
+// which will never be closed.
+func (seg *Segment) closeOpenSubsegments(mark func(child *Segment)) {
+	seg.RLock()
+	children := make([]*Segment, len(seg.rawSubsegments))
+	copy(children, seg.rawSubsegments)
+	seg.RUnlock()
+
+	for _, child := range children {
+		child.closeOpenSubsegments(mark)
+
+		child.Lock()
+		inProgress := child.InProgress
+		if inProgress {
+			mark(child)
+		}
+		child.Unlock()
+
+		if inProgress {
+			child.Close(nil)
+		}
+	}
+}
+
 // RemoveSubsegment removes a subsegment child from a segment or subsegment.
 func (seg *Segment) RemoveSubsegment(remove *Segment) bool {
 	seg.Lock()
@@ -465,10 +1305,46 @@ func (seg *Segment) isOrphan() bool {
 }
 
 func (seg *Segment) emit() {
-	seg.ParentSegment.GetConfiguration().Emitter.Emit(seg)
+	cfg := seg.ParentSegment.GetConfiguration()
+	if cfg.ValidateSegments && seg.ParentSegment.Sampled {
+		if err := ValidateSegment(seg); err != nil {
+			logger.RateLimitedErrorf("Not emitting invalid segment %q: %v", seg.Name, err)
+			atomic.AddUint64(&rejectedSegments, 1)
+			return
+		}
+	}
+	if dropped := atomic.LoadUint32(&seg.droppedSubsegments); dropped > 0 && seg.ParentSegment == seg {
+		if seg.Metadata == nil {
+			seg.Metadata = map[string]map[string]interface{}{}
+		}
+		if seg.Metadata["default"] == nil {
+			seg.Metadata["default"] = map[string]interface{}{}
+		}
+		seg.Metadata["default"]["dropped_subsegments"] = dropped
+	}
+	if cfg.AggregationPolicy != nil {
+		seg.aggregateSubsegments(cfg.AggregationPolicy)
+	}
+	if cfg.CoalesceSubsegments >= 2 {
+		seg.coalesceSubsegments(cfg.CoalesceSubsegments)
+	}
+	cfg.Emitter.Emit(seg)
+	if seg.ParentSegment == seg {
+		seg.releasePooledSubsegments()
+	}
 }
 
-func (seg *Segment) handleContextDone() {
+// handleContextDone is called once the context of seg is done, either
+// because the context it was begun with is done or because seg was closed,
+// with err the error of the context it was begun with at that point. As
+// that context may also be canceled after seg was closed, err can be
+// non-nil for a closed seg.
+func (seg *Segment) handleContextDone(err error) {
+	if errors.Is(err, context.Canceled) && seg.safeCloseSegmentsOnCancel() {
+		seg.closeCanceled()
+		return
+	}
+
 	seg.Lock()
 	seg.ContextDone = true
 	if !seg.InProgress && !seg.Emitted {
@@ -479,6 +1355,36 @@ func (seg *Segment) handleContextDone() {
 	}
 }
 
+func (seg *Segment) safeCloseSegmentsOnCancel() bool {
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.GetConfiguration().CloseSegmentsOnCancel
+}
+
+// closeCanceled closes the subsegments of seg still in progress and seg,
+// marking them with CanceledAnnotationKey, unless seg is closed already.
+func (seg *Segment) closeCanceled() {
+	seg.RLock()
+	closed := seg.EndTime != 0 || seg.Emitted
+	seg.RUnlock()
+	if closed {
+		// seg was closed before its context was canceled, so the
+		// subsegments still running were not canceled with it.
+		return
+	}
+
+	seg.closeOpenSubsegments(markCanceled)
+
+	seg.Lock()
+	if seg.EndTime != 0 {
+		seg.Unlock()
+		return
+	}
+	markCanceled(seg)
+	seg.ContextDone = true
+	seg.close(epochNow(), nil)
+}
+
 // send tries to emit the current (Sub)Segment. If the (Sub)Segment is ready to send,
 // it emits out. If it is ready but has non-nil parent, it traverses to parent and checks whether parent is
 // ready to send and sends entire subtree from the parent. The locking and traversal of the tree
@@ -492,12 +1398,34 @@ func (seg *Segment) send() {
 			break
 		}
 
+		child := s
+		emitted := s.Emitted
 		tmp := s.parent
 		s.Unlock()
 
 		s = tmp
 		s.Lock()
 		s.openSegments--
+		if emitted {
+			// The subsegment was emitted on its own, so it no longer
+			// belongs to the tree emitted with s.
+			s.removeEmittedSubsegment(child)
+		}
+	}
+}
+
+// removeEmittedSubsegment removes child, which was emitted on its own, from
+// the subsegments of seg.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) removeEmittedSubsegment(child *Segment) {
+	for i, v := range seg.rawSubsegments {
+		if v == child {
+			copy(seg.rawSubsegments[i:], seg.rawSubsegments[i+1:])
+			seg.rawSubsegments[len(seg.rawSubsegments)-1] = nil
+			seg.rawSubsegments = seg.rawSubsegments[:len(seg.rawSubsegments)-1]
+			atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(0))
+			return
+		}
 	}
 }
 
@@ -506,13 +1434,23 @@ func (seg *Segment) send() {
 func (seg *Segment) flush() bool {
 	if (seg.openSegments == 0 && seg.EndTime > 0) || seg.ContextDone {
 		if seg.isOrphan() {
-			seg.Emitted = true
-			seg.emit()
+			// Subsegments closed once their segment was sent, such as
+			// those closed on cancellation, must not send it again.
+			if !seg.Emitted {
+				seg.Emitted = true
+				seg.emit()
+			}
 		} else if seg.parent != nil && seg.parent.Facade {
 			seg.Emitted = true
 			seg.beforeEmitSubsegment(seg.parent)
 			logger.Debugf("emit lambda subsegment named: %v", seg.Name)
 			seg.emit()
+		} else if !seg.Emitted && seg.parent == seg.ParentSegment && seg.ParentSegment.GetConfiguration().EmitSubsegmentsIndependently {
+			seg.Emitted = true
+			seg.beforeEmitSubsegment(seg.parent)
+			logger.Debugf("emit independent subsegment named: %v", seg.Name)
+			seg.emit()
+			return false
 		} else {
 			return false
 		}
@@ -535,6 +1473,174 @@ func (seg *Segment) getName() string {
 	return n
 }
 
+// getNamespace returns namespace of the segment. This method is thread safe.
+func (seg *Segment) getNamespace() string {
+	seg.RLock()
+	n := seg.Namespace
+	seg.RUnlock()
+	return n
+}
+
+// GetStartTime returns the time the segment was begun. This method is thread safe.
+func (seg *Segment) GetStartTime() time.Time {
+	seg.RLock()
+	defer seg.RUnlock()
+	return floatToTime(seg.StartTime)
+}
+
+// GetEndTime returns the time the segment was closed, or the zero time if it
+// is still in progress. This method is thread safe.
+func (seg *Segment) GetEndTime() time.Time {
+	seg.RLock()
+	defer seg.RUnlock()
+	if seg.EndTime == 0 {
+		return time.Time{}
+	}
+	return floatToTime(seg.EndTime)
+}
+
+// GetDuration returns the time elapsed between the start and end of the
+// segment. For a segment that is still in progress it returns the time
+// elapsed so far. This method is thread safe.
+func (seg *Segment) GetDuration() time.Duration {
+	seg.RLock()
+	defer seg.RUnlock()
+	if seg.EndTime == 0 {
+		return clock.Now().Sub(floatToTime(seg.StartTime))
+	}
+	return floatToTime(seg.EndTime).Sub(floatToTime(seg.StartTime))
+}
+
+// SetEnqueueTime records that the work traced by seg was queued at enqueued
+// before it began, for instance by a worker pool. The time is added to the
+// default metadata of seg as "enqueue_time", in seconds since the epoch,
+// along with the wait as "wait_time", in seconds. So that the X-Ray console
+// shows the wait as a phase, the start of seg is moved back to enqueued and a
+// subsegment named "queued" covers the time from enqueued until seg began:
+// the timeline then draws the wait as the first part of seg. The "queued"
+// subsegment counts against Config.MaxSubsegmentsPerSegment like any other
+// and is left out once the maximum is reached. It returns an error if
+// enqueued is later than the time seg began, or once seg has been closed.
+func (seg *Segment) SetEnqueueTime(enqueued time.Time) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	// If segment is dummy we return
+	if seg.Dummy {
+		return nil
+	}
+
+	if seg.EndTime > 0 {
+		return fmt.Errorf("failed to set the enqueue time of segment %q: segment is closed", seg.Name)
+	}
+	// GetStartTime rounds to microseconds, so allow for that much.
+	enqueuedAt := float64(enqueued.UnixNano()) / float64(time.Second)
+	if enqueuedAt > seg.StartTime+1e-6 {
+		return fmt.Errorf("failed to set the enqueue time of segment %q: enqueue time %v is after the start %v", seg.Name, enqueued, floatToTime(seg.StartTime))
+	}
+	if enqueuedAt > seg.StartTime {
+		enqueuedAt = seg.StartTime
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata["default"] == nil {
+		seg.Metadata["default"] = map[string]interface{}{}
+	}
+	seg.Metadata["default"]["enqueue_time"] = enqueuedAt
+	seg.Metadata["default"]["wait_time"] = seg.StartTime - enqueuedAt
+
+	if !seg.ParentSegment.reserveSubsegment() {
+		atomic.AddUint32(&seg.ParentSegment.droppedSubsegments, 1)
+		logger.Debugf("Dropping subsegment named queued beyond the maximum number of subsegments")
+		seg.StartTime = enqueuedAt
+		return nil
+	}
+	queued := &Segment{
+		parent:        seg,
+		ParentSegment: seg.ParentSegment,
+		Name:          "queued",
+		TraceID:       seg.ParentSegment.TraceID,
+		ParentID:      seg.ID,
+		StartTime:     enqueuedAt,
+		EndTime:       seg.StartTime,
+		Sampled:       seg.ParentSegment.Sampled,
+	}
+	queued.ID = queued.newSegmentID()
+	atomic.AddUint32(&seg.ParentSegment.totalSubSegments, 1)
+	seg.rawSubsegments = append(seg.rawSubsegments, queued)
+	seg.StartTime = enqueuedAt
+	return nil
+}
+
+// clock provides the current time for trace IDs and segment timings.
+// Tests replace it to control start and end times.
+var clock utils.Clock = &utils.DefaultClock{}
+
+// epochNow returns the current time of clock in seconds since the epoch.
+func epochNow() float64 {
+	return float64(clock.Now().UnixNano()) / float64(time.Second)
+}
+
+// floatToTime converts the seconds since the epoch recorded in StartTime and
+// EndTime to a time.Time, rounded to the microsecond as float64 seconds cannot
+// represent current times more precisely.
+func floatToTime(t float64) time.Time {
+	sec, frac := math.Modf(t)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e6))*int64(time.Microsecond))
+}
+
+// ConsoleURL returns a link to the trace of the segment in the X-Ray console
+// of the given region. The console search window is set to the hour from the
+// time encoded in the trace ID, so the trace is found without widening the
+// default window. It returns an empty string if region is empty or the
+// segment has no valid trace ID, e.g. because it is not sampled.
+// This method is thread safe.
+func (seg *Segment) ConsoleURL(region string) string {
+	seg.RLock()
+	traceID := seg.TraceID
+	seg.RUnlock()
+
+	if region == "" || traceID == "" || traceID == noOpTraceID() {
+		return ""
+	}
+
+	// Trace IDs have the form 1-{8 hex digit epoch seconds}-{24 hex digit random}.
+	parts := strings.Split(traceID, "-")
+	if len(parts) != 3 || parts[0] != "1" || len(parts[1]) != 8 {
+		return ""
+	}
+	epoch, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil {
+		return ""
+	}
+
+	start := time.Unix(epoch, 0).UTC()
+	end := start.Add(time.Hour)
+	const layout = "2006-01-02T15:04:05"
+
+	return fmt.Sprintf("https://%s/xray/home?region=%s#/traces/%s?timeRange=%s~%s",
+		consoleHost(region), region, traceID, start.Format(layout), end.Format(layout))
+}
+
+// consoleHost returns the host of the AWS console for the partition of region.
+func consoleHost(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "console.amazonaws.cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "console.amazonaws-us-gov.com"
+	default:
+		return region + ".console.aws.amazon.com"
+	}
+}
+
 func (seg *Segment) root() *Segment {
 	if seg.parent == nil {
 		return seg
@@ -565,8 +1671,13 @@ func (seg *Segment) addPlugin(metadata *plugins.PluginMetadata) {
 	}
 }
 
+// addSDKAndServiceInformation records the SDK version as aws.xray.sdk_version
+// and the Go runtime as service.runtime and service.runtime_version.
 func (seg *Segment) addSDKAndServiceInformation() {
-	seg.GetAWS()["xray"] = SDK{Version: SDKVersion, Type: SDKType}
+	// Keep the sampling rule and rate recorded by the sampling decision.
+	sdk, _ := seg.GetAWS()["xray"].(SDK)
+	sdk.Version, sdk.Type = SDKVersion, SDKType
+	seg.GetAWS()["xray"] = sdk
 
 	seg.GetService().Runtime = runtime.Compiler
 	seg.GetService().RuntimeVersion = runtime.Version()
@@ -594,13 +1705,31 @@ func (seg *Segment) AddAnnotation(key string, value interface{}) error {
 	if seg.Dummy {
 		return nil
 	}
+	return seg.addAnnotation(key, value)
+}
 
-	switch value.(type) {
-	case bool, int, uint, float32, float64, string:
-	default:
+// addAnnotation adds an annotation to seg like AddAnnotation, for callers
+// which already hold the lock of seg.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) addAnnotation(key string, value interface{}) error {
+	if !isAnnotationValue(value) {
 		return fmt.Errorf("failed to add annotation key: %q value: %q to subsegment %q. value must be of type string, number or boolean", key, value, seg.Name)
 	}
 
+	key = seg.annotationKey(key)
+	if seg.ParentSegment != nil && seg.ParentSegment.Configuration != nil {
+		if guard := seg.ParentSegment.Configuration.AnnotationCardinalityGuard; guard != nil && guard.exceeds(key, value) && guard.MoveToMetadata {
+			if seg.Metadata == nil {
+				seg.Metadata = map[string]map[string]interface{}{}
+			}
+			if seg.Metadata["default"] == nil {
+				seg.Metadata["default"] = map[string]interface{}{}
+			}
+			seg.Metadata["default"][key] = value
+			return nil
+		}
+	}
+
 	if seg.Annotations == nil {
 		seg.Annotations = map[string]interface{}{}
 	}
@@ -608,6 +1737,253 @@ func (seg *Segment) AddAnnotation(key string, value interface{}) error {
 	return nil
 }
 
+// annotationKey returns key as it is added to the annotations of seg, with
+// the characters X-Ray does not index replaced.
+func (seg *Segment) annotationKey(key string) string {
+	sanitize := SanitizeAnnotationKey
+	if seg.ParentSegment != nil && seg.ParentSegment.Configuration != nil && seg.ParentSegment.Configuration.AnnotationKeySanitizer != nil {
+		sanitize = seg.ParentSegment.Configuration.AnnotationKeySanitizer
+	}
+	sanitized := sanitize(key)
+	if sanitized != key {
+		sanitizedAnnotationKeyOnce.Do(func() {
+			logger.Debugf("Annotation key %q contains characters X-Ray does not index, adding it as %q. Further keys are replaced without logging.", key, sanitized)
+		})
+	}
+	return sanitized
+}
+
+// isAnnotationValue reports whether value is of a type X-Ray accepts as the
+// value of an annotation.
+func isAnnotationValue(value interface{}) bool {
+	switch value.(type) {
+	case bool, int, uint, float32, float64, string:
+		return true
+	}
+	return false
+}
+
+// sanitizedAnnotationKeyOnce logs the first annotation key replaced by
+// AddAnnotation.
+var sanitizedAnnotationKeyOnce sync.Once
+
+// SanitizeAnnotationKey returns key with every character other than ASCII
+// letters, digits and underscores replaced with an underscore. X-Ray drops
+// annotations whose keys contain other characters, so AddAnnotation passes
+// its keys through this function unless Config.AnnotationKeySanitizer is set.
+func SanitizeAnnotationKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// SetDebug marks the trace seg belongs to for investigation. The root
+// segment gets the annotation "debug": true, which traces can be filtered on
+// in the X-Ray console, and calls to downstream services carry Debug=1 in the
+// additional data of their trace header. Downstream services setting
+// Config.SampleDebugTraces sample such calls. The sampling decision of seg's
+// own trace was made when its root segment began and is not changed.
+// SetDebug(false) removes the mark, but not Debug=1 inherited from upstream.
+func (seg *Segment) SetDebug(debug bool) {
+	// If SDK is disabled then return
+	if SdkDisabled() || seg == nil {
+		return
+	}
+
+	seg.RLock()
+	root := seg.ParentSegment
+	seg.RUnlock()
+
+	root.Lock()
+	defer root.Unlock()
+	root.markDebug(debug)
+}
+
+// markDebug records whether seg, a root segment, is marked for investigation.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) markDebug(debug bool) {
+	if debug {
+		atomic.StoreInt32(&seg.debug, 1)
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations[DebugAnnotationKey] = true
+		return
+	}
+	atomic.StoreInt32(&seg.debug, 0)
+	delete(seg.Annotations, DebugAnnotationKey)
+}
+
+// SetRemoteServiceName makes the subsegment seg a call to the downstream
+// service called name, for instance when a generic client calls a shared
+// service on behalf of several logical ones. X-Ray names the service map node
+// of a remote subsegment after the subsegment's name, which the trace
+// timeline shows as well; the trace format has no separate field for the
+// node. SetRemoteServiceName therefore renames seg, records its previous name
+// as the "label" metadata so it remains visible in the subsegment's details,
+// and sets the remote namespace unless seg already has a namespace.
+func (seg *Segment) SetRemoteServiceName(name string) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.parent == nil {
+		return fmt.Errorf("unable to set remote service name of segment %q: not a subsegment", seg.Name)
+	}
+	if seg.Dummy || seg.Name == name {
+		seg.Name = name
+		return nil
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata["default"] == nil {
+		seg.Metadata["default"] = map[string]interface{}{}
+	}
+	if _, ok := seg.Metadata["default"]["label"]; !ok {
+		seg.Metadata["default"]["label"] = seg.Name
+	}
+	seg.Name = name
+	if seg.Namespace == "" {
+		seg.Namespace = "remote"
+	}
+	return nil
+}
+
+// SetName renames seg, a segment or subsegment, for names only known once
+// the operation started, such as the endpoint a client resolved. Names are
+// cut to 200 characters. The name can only be changed until seg has been
+// emitted or streamed, on its own or as part of its segment.
+func (seg *Segment) SetName(name string) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	if name == "" {
+		return errors.New("unable to rename segment: name is empty")
+	}
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	// The root is locked on its own first, as roots are locked before
+	// their subsegments.
+	if root := seg.ParentSegment; root != nil && root != seg {
+		root.RLock()
+		emitted := root.Emitted
+		root.RUnlock()
+		if emitted {
+			return fmt.Errorf("unable to rename subsegment %q: segment has already been emitted", seg.Name)
+		}
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.Facade {
+		return errors.New("unable to rename facade segment")
+	}
+	if seg.Emitted {
+		return fmt.Errorf("unable to rename segment %q: segment has already been emitted", seg.Name)
+	}
+	seg.Name = name
+	return nil
+}
+
+// OperationAnnotationKey is the annotation holding the operation of a
+// segment renamed with SetServiceName.
+const OperationAnnotationKey = "operation"
+
+// SetServiceName makes name, a stable and low-cardinality identity of the
+// service, the name of the segment seg, while the name it was begun with, such
+// as the route of the request, is kept as the operation. X-Ray groups the
+// nodes of the service map and the traces of a service by segment name, the
+// only field of the trace format identifying a service, so SetServiceName
+// renames seg and records its previous name as the "operation" annotation.
+// The console then shows one node for the service, and the traces of an
+// operation can be found with the filter expression
+// annotation.operation = "...". Sampling rules match the name seg was begun
+// with.
+func (seg *Segment) SetServiceName(name string) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.parent != nil {
+		return fmt.Errorf("unable to set service name of subsegment %q: not a segment", seg.Name)
+	}
+	if seg.Dummy || seg.Name == name {
+		seg.Name = name
+		return nil
+	}
+
+	if seg.Annotations == nil {
+		seg.Annotations = map[string]interface{}{}
+	}
+	if _, ok := seg.Annotations[OperationAnnotationKey]; !ok {
+		seg.Annotations[OperationAnnotationKey] = seg.Name
+	}
+	seg.Name = name
+	return nil
+}
+
+// SetResourceARN records the ARN of the AWS resource that handled the request
+// in the aws metadata of the segment. Sampling decisions are made when a
+// segment begins, so set Config.ResourceARN for sampling rules to match it.
+func (seg *Segment) SetResourceARN(arn string) {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	seg.GetAWS()["resource_arn"] = arn
+}
+
+// SetMaxSubsegmentCount overrides the MaxSubsegmentCount of
+// DefaultStreamingStrategy for the segment tree seg belongs to, for instance
+// for a batch job whose trace legitimately has many subsegments and would
+// otherwise be streamed in many small pieces. Other streaming strategies
+// ignore it. See DefaultStreamingStrategy for the limits of the daemon.
+func (seg *Segment) SetMaxSubsegmentCount(count int) error {
+	if count <= 0 {
+		return errors.New("count must be a positive integer")
+	}
+	// If SDK is disabled then return
+	if SdkDisabled() || seg == nil {
+		return nil
+	}
+
+	seg.RLock()
+	root := seg.ParentSegment
+	seg.RUnlock()
+	atomic.StoreUint32(&root.maxSubsegmentCount, uint32(count))
+	return nil
+}
+
 // AddMetadata allows adding metadata to the segment.
 func (seg *Segment) AddMetadata(key string, value interface{}) error {
 	// If SDK is disabled then return
@@ -658,6 +2034,92 @@ func (seg *Segment) AddMetadataToNamespace(namespace string, key string, value i
 	return nil
 }
 
+// AppendMetadata appends value to the metadata of the segment stored under
+// key in namespace. If the stored value is not a slice yet, it is turned into
+// a slice holding the previous value, if any, followed by value. Unlike
+// AddMetadataToNamespace, an existing value is never overwritten.
+func (seg *Segment) AppendMetadata(namespace string, key string, value interface{}) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	// If segment is dummy we return
+	if seg.Dummy {
+		return nil
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata[namespace] == nil {
+		seg.Metadata[namespace] = map[string]interface{}{}
+	}
+	seg.Metadata[namespace][key] = appendValue(seg.Metadata[namespace][key], value)
+	return nil
+}
+
+// appendValue returns the elements of existing followed by value. A missing
+// value is an empty slice and any other value a slice of itself.
+func appendValue(existing interface{}, value interface{}) []interface{} {
+	switch v := existing.(type) {
+	case nil:
+		return []interface{}{value}
+	case []interface{}:
+		return append(v, value)
+	}
+
+	rv := reflect.ValueOf(existing)
+	if rv.Kind() != reflect.Slice {
+		return []interface{}{existing, value}
+	}
+	values := make([]interface{}, 0, rv.Len()+1)
+	for i := 0; i < rv.Len(); i++ {
+		values = append(values, rv.Index(i).Interface())
+	}
+	return append(values, value)
+}
+
+// PhasesMetadataKey is the key of the default metadata namespace under which
+// RecordPhase records phase durations.
+const PhasesMetadataKey = "phases"
+
+// RecordPhase adds d to the duration of the phase name of the segment, in
+// milliseconds, recorded in the default metadata namespace under
+// PhasesMetadataKey. It times steps within a segment, such as parsing and
+// validating a request, more cheaply than a subsegment for each. Durations
+// recorded for the same phase add up.
+func (seg *Segment) RecordPhase(name string, d time.Duration) {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	// If segment is dummy we return
+	if seg.Dummy {
+		return
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata["default"] == nil {
+		seg.Metadata["default"] = map[string]interface{}{}
+	}
+	phases, ok := seg.Metadata["default"][PhasesMetadataKey].(map[string]float64)
+	if !ok {
+		phases = map[string]float64{}
+		seg.Metadata["default"][PhasesMetadataKey] = phases
+	}
+	phases[name] += float64(d) / float64(time.Millisecond)
+}
+
 // AddError allows adding an error to the segment.
 func (seg *Segment) AddError(err error) error {
 	// If SDK is disabled then return
@@ -673,8 +2135,79 @@ func (seg *Segment) AddError(err error) error {
 	return nil
 }
 
+// AddErrorNonFatal records err in the cause of the segment like AddError,
+// but leaves the error, fault and throttle flags as they are, for handled or
+// expected errors worth the detail which should not count towards the error
+// rates of the service. A nil err is ignored.
+func (seg *Segment) AddErrorNonFatal(err error) {
+	// If SDK is disabled then return
+	if SdkDisabled() || err == nil {
+		return
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	// addError is called directly so that the stack recorded starts at the
+	// same frame as for AddError.
+	fault := seg.Fault
+	seg.addError(err)
+	seg.Fault = fault
+}
+
+// AddException allows adding an already formatted exception to the segment.
+// The given stack is recorded as is, instead of the stack of the caller.
+func (seg *Segment) AddException(errType, message string, stack []uintptr) error {
+	return seg.addException(errType, message, stack, false)
+}
+
+// AddRemoteException allows adding an already formatted exception to the segment,
+// marking it as having been caused by a remote (downstream) service.
+func (seg *Segment) AddRemoteException(errType, message string, stack []uintptr) error {
+	return seg.addException(errType, message, stack, true)
+}
+
+func (seg *Segment) addException(errType, message string, stack []uintptr, remote bool) error {
+	// If SDK is disabled then return
+	if SdkDisabled() {
+		return nil
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	seg.Fault = true
+	seg.GetCause().WorkingDirectory, _ = os.Getwd()
+	seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, exception.NewException(errType, message, stack, remote))
+
+	return nil
+}
+
 func (seg *Segment) addError(err error) {
 	seg.Fault = true
 	seg.GetCause().WorkingDirectory, _ = os.Getwd()
-	seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.ExceptionFromError(err))
+	cfg := seg.ParentSegment.GetConfiguration()
+	var e exception.Exception
+	if cfg.DisableStackTraces {
+		e = exception.ExceptionWithoutStack(err)
+	} else {
+		e = cfg.ExceptionFormattingStrategy.ExceptionFromError(err)
+	}
+	seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, e)
+
+	// The fields of structured errors are not part of the exception
+	// schema, so they are recorded as metadata keyed by the exception ID.
+	if fields := exception.ErrorFields(err); fields != nil {
+		key := e.ID
+		if key == "" {
+			key = strconv.Itoa(len(seg.GetCause().Exceptions) - 1)
+		}
+		if seg.Metadata == nil {
+			seg.Metadata = map[string]map[string]interface{}{}
+		}
+		if seg.Metadata["error_fields"] == nil {
+			seg.Metadata["error_fields"] = map[string]interface{}{}
+		}
+		seg.Metadata["error_fields"][key] = fields
+	}
 }
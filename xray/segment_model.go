@@ -24,16 +24,49 @@ type Segment struct {
 	parent           *Segment
 	openSegments     int
 	totalSubSegments uint32
-	Sampled          bool           `json:"-"`
-	RequestWasTraced bool           `json:"-"` // Used by xray.RequestWasTraced
-	ContextDone      bool           `json:"-"`
-	Emitted          bool           `json:"-"`
-	IncomingHeader   *header.Header `json:"-"`
-	ParentSegment    *Segment       `json:"-"` // The root of the Segment tree, the parent Segment (not Subsegment).
+	// maxSubsegmentCount overrides the MaxSubsegmentCount of
+	// DefaultStreamingStrategy for this segment tree if non-zero. It is
+	// accessed atomically.
+	maxSubsegmentCount uint32
+	// beganSubsegments and droppedSubsegments count the subsegments begun
+	// in this segment tree and those dropped by
+	// Config.MaxSubsegmentsPerSegment. They are accessed atomically.
+	beganSubsegments   uint32
+	droppedSubsegments uint32
+	Sampled            bool           `json:"-"`
+	RequestWasTraced   bool           `json:"-"` // Used by xray.RequestWasTraced
+	ContextDone        bool           `json:"-"`
+	Emitted            bool           `json:"-"`
+	IncomingHeader     *header.Header `json:"-"`
+	ParentSegment      *Segment       `json:"-"` // The root of the Segment tree, the parent Segment (not Subsegment).
 
 	// cancels the context bound to this Segment, after Segment is closed
 	cancelCtx context.CancelFunc
 
+	// internal is true for subsegments the SDK creates for itself, such as
+	// the phases of an HTTP round trip or an AWS request
+	internal bool
+
+	// pooled is true for subsegments taken from segmentPool, which are
+	// returned to it once the segment tree they belong to has been emitted
+	pooled bool
+
+	// responseStatusSet is true once SetHTTPResponseStatus has been called,
+	// so that Handler does not override the status with the one it captured
+	responseStatusSet bool
+
+	// debug is 1 if the trace was marked for investigation with SetDebug
+	// or by an incoming trace header. It is accessed atomically.
+	debug int32
+
+	// propagated is 1 once the ID of the segment has been sent downstream
+	// as the parent ID of a trace header. It is accessed atomically.
+	propagated int32
+
+	// awsPages maps the pagination token returned by an AWS call made under
+	// this segment to the page number of the request that will send it
+	awsPages map[string]int
+
 	// Required
 	TraceID   string  `json:"trace_id,omitempty"`
 	ID        string  `json:"id"`
@@ -131,27 +164,10 @@ type SQLData struct {
 }
 
 // DownstreamHeader returns a header for passing to downstream calls.
+// See BuildOutboundHeader.
 func (s *Segment) DownstreamHeader() *header.Header {
-	r := &header.Header{}
-
-	// If SDK is disabled then return with an empty header
-	if SdkDisabled() {
-		return r
-	}
-
-	if parent := s.ParentSegment.IncomingHeader; parent != nil {
-		*r = *parent // copy parent incoming header
-	}
-	if r.TraceID == "" {
-		r.TraceID = s.ParentSegment.TraceID
-	}
-	if s.ParentSegment.Sampled {
-		r.SamplingDecision = header.Sampled
-	} else {
-		r.SamplingDecision = header.NotSampled
-	}
-	r.ParentID = s.ID
-	return r
+	h := BuildOutboundHeader(s)
+	return &h
 }
 
 // GetCause returns value of Cause.
@@ -221,8 +237,18 @@ func (s *Segment) GetConfiguration() *Config {
 // AddRuleName adds rule name, if present from sampling decision to xray context.
 func (s *Segment) AddRuleName(sd *sampling.Decision) {
 	if sd.Rule != nil {
-		sdk := s.GetAWS()["xray"].(SDK)
+		sdk, _ := s.GetAWS()["xray"].(SDK)
 		sdk.RuleName = *sd.Rule
 		s.GetAWS()["xray"] = sdk
 	}
 }
+
+// addSamplingRate adds the rate of a sampling decision which sampled the
+// segment, if present, to xray context.
+func (s *Segment) addSamplingRate(sd *sampling.Decision) {
+	if sd.Sample && sd.Rate != nil {
+		sdk, _ := s.GetAWS()["xray"].(SDK)
+		sdk.SamplingRate = *sd.Rate
+		s.GetAWS()["xray"] = sdk
+	}
+}
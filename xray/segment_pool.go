@@ -0,0 +1,120 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"sync"
+)
+
+// segmentPool holds subsegments the SDK creates for the phases of an AWS
+// request, such as "marshal" and "unmarshal", for reuse across requests.
+var segmentPool = sync.Pool{
+	New: func() interface{} {
+		return &Segment{}
+	},
+}
+
+// getPooledSegment returns a zeroed subsegment from segmentPool.
+func getPooledSegment() *Segment {
+	seg := segmentPool.Get().(*Segment)
+	seg.pooled = true
+	return seg
+}
+
+// beginPooledSubsegment begins an internal subsegment which is returned to
+// segmentPool once the segment tree it belongs to has been emitted. Only use
+// it for subsegments which nothing refers to once they are closed: the
+// subsegments of httptrace, for instance, may still be recorded into by
+// late callbacks of the round trip.
+func beginPooledSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
+	return newSubsegment(ctx, name, true, true)
+}
+
+// releasePooledSubsegments returns the pooled subsegments below seg to
+// segmentPool once seg has been emitted. Nothing is released while a
+// subsegment below seg is still open, and a pooled subsegment is only
+// released together with all subsegments below it, since a subsegment which
+// is not pooled may still refer to its parent.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) releasePooledSubsegments() {
+	if seg.openSegments != 0 || len(seg.rawSubsegments) == 0 {
+		return
+	}
+
+	kept := seg.rawSubsegments[:0]
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		release := s.releasable()
+		if !release {
+			s.releasePooledSubsegments()
+		}
+		s.Unlock()
+
+		if !release {
+			kept = append(kept, s)
+			continue
+		}
+		s.release()
+	}
+	for i := len(kept); i < len(seg.rawSubsegments); i++ {
+		seg.rawSubsegments[i] = nil
+	}
+	seg.rawSubsegments = kept
+}
+
+// releasable reports whether seg and all subsegments below it are pooled
+// and closed.
+// seg has a write lock acquired by the caller.
+func (seg *Segment) releasable() bool {
+	if !seg.pooled || seg.InProgress || seg.openSegments != 0 {
+		return false
+	}
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		ok := s.releasable()
+		s.Unlock()
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// release resets seg and the subsegments below it and returns them to
+// segmentPool. seg must be releasable and no longer part of a segment tree.
+func (seg *Segment) release() {
+	for _, s := range seg.rawSubsegments {
+		s.release()
+	}
+	seg.reset()
+	segmentPool.Put(seg)
+}
+
+// reset clears every field of seg so that nothing recorded for one request
+// can leak into another once seg is reused. The backing arrays of the
+// subsegment slices are kept to avoid reallocating them.
+func (seg *Segment) reset() {
+	for i := range seg.rawSubsegments {
+		seg.rawSubsegments[i] = nil
+	}
+	for i := range seg.Subsegments {
+		seg.Subsegments[i] = nil
+	}
+	rawSubsegments := seg.rawSubsegments[:0]
+	subsegments := seg.Subsegments[:0]
+
+	*seg = Segment{}
+	if cap(rawSubsegments) > 0 {
+		seg.rawSubsegments = rawSubsegments
+	}
+	if cap(subsegments) > 0 {
+		seg.Subsegments = subsegments
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPooledSegmentReset(t *testing.T) {
+	seg := getPooledSegment()
+	assert.True(t, seg.pooled)
+
+	parent := &Segment{}
+	seg.parent = parent
+	seg.ParentSegment = parent
+	seg.openSegments = 1
+	seg.internal = true
+	seg.Sampled = true
+	seg.IncomingHeader = &header.Header{TraceID: "1-57fbe041-2c7ad569f5d6ff149137be86"}
+	seg.TraceID = "1-57fbe041-2c7ad569f5d6ff149137be86"
+	seg.ID = "f46ae0b1c2a4b3c4"
+	seg.Name = "Pooled"
+	seg.StartTime = 1
+	seg.EndTime = 2
+	seg.Fault = true
+	seg.GetCause().WorkingDirectory = "/"
+	seg.GetHTTP().GetRequest().URL = "http://example.com"
+	seg.GetAWS()["operation"] = "ListFunctions"
+	seg.GetSQL().SanitizedQuery = "SELECT 1"
+	seg.GetService().Version = "1.0"
+	seg.Annotations = map[string]interface{}{"key": "value"}
+	seg.Metadata = map[string]map[string]interface{}{"default": {"key": "value"}}
+	seg.Subsegments = append(seg.Subsegments, json.RawMessage(`{}`))
+	seg.rawSubsegments = append(seg.rawSubsegments, &Segment{})
+	seg.GetConfiguration().Emitter = &TestEmitter{}
+
+	subsegments, rawSubsegments := seg.Subsegments, seg.rawSubsegments
+	seg.reset()
+
+	assert.Equal(t, &Segment{rawSubsegments: seg.rawSubsegments, Subsegments: seg.Subsegments}, seg)
+	assert.Empty(t, seg.rawSubsegments)
+	assert.Empty(t, seg.Subsegments)
+	// Backing arrays must not keep the previous children alive.
+	assert.Nil(t, subsegments[0])
+	assert.Nil(t, rawSubsegments[0])
+}
+
+func TestPooledSubsegmentsReleasedAfterEmit(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	opCtx, opSeg := BeginSubsegment(ctx, "Operation")
+	_, pooled := beginPooledSubsegment(opCtx, "Pooled")
+	assert.NoError(t, pooled.AddAnnotation("key", "value"))
+	pooled.Close(nil)
+	opSeg.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var op *Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &op))
+	var p *Segment
+	assert.NoError(t, json.Unmarshal(op.Subsegments[0], &p))
+	assert.Equal(t, "Pooled", p.Name)
+	assert.Equal(t, "value", p.Annotations["key"])
+
+	// The user facing subsegment is kept while the pooled one is released.
+	assert.Len(t, root.rawSubsegments, 1)
+	assert.Empty(t, opSeg.rawSubsegments)
+	assert.False(t, pooled.pooled)
+	assert.Empty(t, pooled.Name)
+	assert.Nil(t, pooled.Annotations)
+}
+
+func TestPooledSubsegmentsNotReleasedWhileOpen(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	_, pooled := beginPooledSubsegment(ctx, "Pooled")
+
+	root.Lock()
+	root.releasePooledSubsegments()
+	root.Unlock()
+
+	assert.Len(t, root.rawSubsegments, 1)
+	assert.Equal(t, "Pooled", pooled.Name)
+	pooled.Close(nil)
+	root.Close(nil)
+}
+
+func TestPooledSubsegmentWithUserSubsegmentNotReleased(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Segment")
+	pooledCtx, pooled := beginPooledSubsegment(ctx, "Pooled")
+	_, user := BeginSubsegment(pooledCtx, "User")
+	user.Close(nil)
+	pooled.Close(nil)
+	root.Close(nil)
+
+	_, err := td.Recv()
+	assert.NoError(t, err)
+
+	// user still refers to pooled as its parent, so neither is released.
+	assert.Len(t, root.rawSubsegments, 1)
+	assert.True(t, pooled.pooled)
+	assert.Equal(t, "Pooled", pooled.Name)
+	assert.Same(t, pooled, user.parent)
+}
+
+func TestPooledSubsegmentsDoNotLeakIntoLaterTraces(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	for i := 0; i < 10; i++ {
+		ctx, root := BeginSegment(ctx, "Segment")
+		_, pooled := beginPooledSubsegment(ctx, "Pooled")
+		if i == 0 {
+			assert.NoError(t, pooled.AddAnnotation("key", "value"))
+			assert.NoError(t, pooled.AddMetadata("key", "value"))
+			pooled.GetAWS()["operation"] = "ListFunctions"
+			pooled.GetHTTP().GetRequest().URL = "http://example.com"
+			pooled.Close(errors.New("error"))
+		} else {
+			pooled.Close(nil)
+		}
+		root.Close(nil)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		var p *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &p))
+		assert.Equal(t, root.ID, p.ParentID)
+		if i == 0 {
+			continue
+		}
+		assert.Nil(t, p.Annotations)
+		assert.Nil(t, p.Metadata)
+		assert.Nil(t, p.AWS)
+		assert.Nil(t, p.HTTP)
+		assert.Nil(t, p.Cause)
+		assert.False(t, p.Fault)
+	}
+}
+
+// Benchmarks
+func BenchmarkSegmentPool(b *testing.B) {
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          &TestEmitter{},
+		SamplingStrategy: &TestSamplingStrategy{},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name   string
+		pooled bool
+	}{
+		{"Unpooled", false},
+		{"Pooled", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ctx, root := BeginSegment(ctx, "Segment")
+				for j := 0; j < 6; j++ {
+					_, seg := newSubsegment(ctx, "Subsegment", true, bm.pooled)
+					seg.Close(nil)
+				}
+				root.Close(nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+)
+
+// SegmentSnapshot is a read-only copy of the fields of a (Sub)Segment which
+// are emitted, taken by Segment.Snapshot.
+type SegmentSnapshot struct {
+	TraceID     string
+	ID          string
+	ParentID    string
+	Name        string
+	Type        string
+	Namespace   string
+	Origin      string
+	ResourceARN string
+	User        string
+
+	StartTime float64
+	EndTime   float64
+
+	InProgress bool
+	Sampled    bool
+	Fault      bool
+	Error      bool
+	Throttle   bool
+
+	Cause   *CauseData
+	HTTP    *HTTPData
+	SQL     *SQLData
+	Service *ServiceData
+	AWS     map[string]interface{}
+
+	Annotations map[string]interface{}
+	Metadata    map[string]map[string]interface{}
+
+	// Subsegments holds snapshots of the subsegments which have not been
+	// streamed or emitted yet.
+	Subsegments []SegmentSnapshot
+}
+
+// Snapshot returns a copy of the current state of seg, which can be read
+// while seg is still being changed, for instance from another goroutine.
+// The maps and structs of the snapshot are copies, and so are the snapshots
+// of its subsegment tree, each taken under the lock of its subsegment. The
+// values stored in annotations, metadata and the aws map are not copied, so
+// they must not be modified. Changes to the snapshot have no effect on seg.
+func (seg *Segment) Snapshot() SegmentSnapshot {
+	if seg == nil {
+		return SegmentSnapshot{}
+	}
+
+	seg.RLock()
+	defer seg.RUnlock()
+
+	snap := SegmentSnapshot{
+		TraceID:     seg.TraceID,
+		ID:          seg.ID,
+		ParentID:    seg.ParentID,
+		Name:        seg.Name,
+		Type:        seg.Type,
+		Namespace:   seg.Namespace,
+		Origin:      seg.Origin,
+		ResourceARN: seg.ResourceARN,
+		User:        seg.User,
+		StartTime:   seg.StartTime,
+		EndTime:     seg.EndTime,
+		InProgress:  seg.InProgress,
+		Fault:       seg.Fault,
+		Error:       seg.Error,
+		Throttle:    seg.Throttle,
+		AWS:         copyMap(seg.AWS),
+		Annotations: copyMap(seg.Annotations),
+	}
+	if seg.ParentSegment != nil {
+		// Sampled is only set on the root of the tree and never changes
+		// once the root has begun.
+		snap.Sampled = seg.ParentSegment.Sampled
+	}
+
+	if seg.Cause != nil {
+		c := *seg.Cause
+		c.Paths = append([]string(nil), c.Paths...)
+		c.Exceptions = append([]exception.Exception(nil), c.Exceptions...)
+		snap.Cause = &c
+	}
+	if seg.HTTP != nil {
+		h := HTTPData{}
+		if seg.HTTP.Request != nil {
+			r := *seg.HTTP.Request
+			h.Request = &r
+		}
+		if seg.HTTP.Response != nil {
+			r := *seg.HTTP.Response
+			h.Response = &r
+		}
+		snap.HTTP = &h
+	}
+	if seg.SQL != nil {
+		s := *seg.SQL
+		snap.SQL = &s
+	}
+	if seg.Service != nil {
+		s := *seg.Service
+		snap.Service = &s
+	}
+
+	if seg.Metadata != nil {
+		snap.Metadata = make(map[string]map[string]interface{}, len(seg.Metadata))
+		for ns, m := range seg.Metadata {
+			snap.Metadata[ns] = copyMap(m)
+		}
+	}
+
+	// Locking children while holding the lock of their parent follows the
+	// order used when a segment tree is emitted.
+	for _, s := range seg.rawSubsegments {
+		snap.Subsegments = append(snap.Subsegments, s.Snapshot())
+	}
+	return snap
+}
+
+// TreeSnapshotOptions controls the fields of segments which
+// Segment.TreeSnapshot omits, to compare trees with golden files.
+type TreeSnapshotOptions struct {
+	// OmitIDs clears the trace IDs, IDs and parent IDs of the segments, and
+	// the IDs of the exceptions recorded in their causes.
+	OmitIDs bool
+
+	// OmitTimes clears the start and end times of the segments.
+	OmitTimes bool
+}
+
+// TreeSnapshot returns the whole tree of the closed segment seg, as it was
+// emitted, for regression tests comparing it with an expected tree. Unlike
+// Snapshot, the tree includes the subsegments which were emitted along with
+// seg, and values of annotations, metadata and the aws map are those decoded
+// from the emitted JSON document, so that numbers are float64. Subsegments
+// streamed separately from the tree are not included. Subsegments are
+// ordered by name, and those with the same name in the order they were
+// emitted, so that the tree does not depend on the order in which
+// concurrent subsegments began. TreeSnapshot returns an error if seg is
+// still in progress.
+func (seg *Segment) TreeSnapshot(opts TreeSnapshotOptions) (SegmentSnapshot, error) {
+	if seg == nil {
+		return SegmentSnapshot{}, errors.New("unable to snapshot nil segment")
+	}
+
+	seg.RLock()
+	defer seg.RUnlock()
+
+	if seg.InProgress {
+		return SegmentSnapshot{}, fmt.Errorf("segment %q is still in progress", seg.Name)
+	}
+	b, err := json.Marshal(seg)
+	if err != nil {
+		return SegmentSnapshot{}, err
+	}
+	sampled := seg.ParentSegment != nil && seg.ParentSegment.Sampled
+	return treeSnapshot(b, seg.rawSubsegments, sampled, opts)
+}
+
+// treeSnapshot snapshots the segment document b along with its subsegments.
+// The subsegments embedded in b are only filled in when the segment tree is
+// emitted to the daemon, so the subsegments of raw, the segment b was
+// marshalled from, are snapshotted instead if b embeds none.
+// The segment b was marshalled from has a read lock acquired by the caller.
+func treeSnapshot(b []byte, raw []*Segment, sampled bool, opts TreeSnapshotOptions) (SegmentSnapshot, error) {
+	doc := &Segment{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return SegmentSnapshot{}, err
+	}
+	snap := doc.Snapshot()
+	snap.Sampled = sampled
+
+	if len(doc.Subsegments) > 0 {
+		for _, sub := range doc.Subsegments {
+			s, err := treeSnapshot(sub, nil, sampled, opts)
+			if err != nil {
+				return SegmentSnapshot{}, err
+			}
+			snap.Subsegments = append(snap.Subsegments, s)
+		}
+	} else {
+		for _, sub := range raw {
+			sub.RLock()
+			b, err := json.Marshal(sub)
+			var s SegmentSnapshot
+			if err == nil {
+				s, err = treeSnapshot(b, sub.rawSubsegments, sampled, opts)
+			}
+			sub.RUnlock()
+			if err != nil {
+				return SegmentSnapshot{}, err
+			}
+			snap.Subsegments = append(snap.Subsegments, s)
+		}
+	}
+	sort.SliceStable(snap.Subsegments, func(i, j int) bool {
+		return snap.Subsegments[i].Name < snap.Subsegments[j].Name
+	})
+
+	if opts.OmitIDs {
+		snap.TraceID, snap.ID, snap.ParentID = "", "", ""
+		if snap.Cause != nil {
+			for i := range snap.Cause.Exceptions {
+				snap.Cause.Exceptions[i].ID = ""
+			}
+		}
+	}
+	if opts.OmitTimes {
+		snap.StartTime, snap.EndTime = 0, 0
+	}
+	return snap, nil
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,171 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentSnapshot(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	assert.NoError(t, root.AddAnnotation("key", "value"))
+	assert.NoError(t, root.AddMetadataToNamespace("ns", "key", 1))
+	root.GetHTTP().GetRequest().Method = "GET"
+	_, sub := BeginSubsegment(ctx, "sub")
+	assert.NoError(t, sub.AddAnnotation("sub", true))
+
+	snap := root.Snapshot()
+	assert.Equal(t, root.TraceID, snap.TraceID)
+	assert.Equal(t, root.ID, snap.ID)
+	assert.Equal(t, "root", snap.Name)
+	assert.True(t, snap.InProgress)
+	assert.True(t, snap.Sampled)
+	assert.Equal(t, "value", snap.Annotations["key"])
+	assert.Equal(t, 1, snap.Metadata["ns"]["key"])
+	assert.Equal(t, "GET", snap.HTTP.Request.Method)
+	if assert.Len(t, snap.Subsegments, 1) {
+		assert.Equal(t, "sub", snap.Subsegments[0].Name)
+		assert.Equal(t, root.ID, snap.Subsegments[0].ParentID)
+		assert.Equal(t, true, snap.Subsegments[0].Annotations["sub"])
+	}
+
+	// Changes to the segment do not show in the snapshot, nor the other way round.
+	assert.NoError(t, root.AddAnnotation("key", "changed"))
+	assert.NoError(t, root.AddMetadataToNamespace("ns", "other", 2))
+	root.GetHTTP().GetRequest().Method = "POST"
+	assert.NoError(t, sub.AddAnnotation("sub", false))
+	assert.Equal(t, "value", snap.Annotations["key"])
+	assert.NotContains(t, snap.Metadata["ns"], "other")
+	assert.Equal(t, "GET", snap.HTTP.Request.Method)
+	assert.Equal(t, true, snap.Subsegments[0].Annotations["sub"])
+
+	snap.Annotations["key"] = "snapshot"
+	snap.Metadata["ns"]["key"] = 3
+	assert.Equal(t, "changed", root.Annotations["key"])
+	assert.Equal(t, 1, root.Metadata["ns"]["key"])
+
+	sub.Close(nil)
+	root.Close(nil)
+	_, err := td.Recv()
+	assert.NoError(t, err)
+}
+
+func TestSegmentSnapshotNil(t *testing.T) {
+	var seg *Segment
+	assert.Equal(t, SegmentSnapshot{}, seg.Snapshot())
+}
+
+func TestSegmentSnapshotDataRace(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, sub := BeginSubsegment(ctx, fmt.Sprintf("sub-%d", i))
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, sub.AddAnnotation(fmt.Sprintf("key-%d", j), j))
+				assert.NoError(t, root.AddMetadata(fmt.Sprintf("key-%d-%d", i, j), j))
+			}
+			sub.Close(nil)
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				snap := root.Snapshot()
+				for _, s := range snap.Subsegments {
+					_ = len(s.Annotations)
+				}
+				_ = len(snap.Metadata["default"])
+			}
+		}()
+	}
+	wg.Wait()
+	root.Close(nil)
+	_, err := td.Recv()
+	assert.NoError(t, err)
+}
+
+func TestSegmentTreeSnapshot(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	assert.NoError(t, root.AddAnnotation("tier", "premium"))
+	var wg sync.WaitGroup
+	for _, name := range []string{"b", "a", "c"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ctx, sub := BeginSubsegment(ctx, name)
+			assert.NoError(t, sub.AddMetadata("count", 1))
+			_, leaf := BeginSubsegment(ctx, name+"-leaf")
+			leaf.Close(nil)
+			sub.Close(nil)
+		}(name)
+	}
+	wg.Wait()
+	root.Close(nil)
+	_, err := td.Recv()
+	assert.NoError(t, err)
+
+	tree, err := root.TreeSnapshot(TreeSnapshotOptions{OmitIDs: true, OmitTimes: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected := func(name string) SegmentSnapshot {
+		return SegmentSnapshot{
+			Name:     name,
+			Sampled:  true,
+			Metadata: map[string]map[string]interface{}{"default": {"count": float64(1)}},
+			Subsegments: []SegmentSnapshot{
+				{Name: name + "-leaf", Sampled: true},
+			},
+		}
+	}
+	assert.Equal(t, "root", tree.Name)
+	assert.Empty(t, tree.TraceID)
+	assert.Empty(t, tree.ID)
+	assert.Zero(t, tree.StartTime)
+	assert.Zero(t, tree.EndTime)
+	assert.Equal(t, "premium", tree.Annotations["tier"])
+	assert.Equal(t, []SegmentSnapshot{expected("a"), expected("b"), expected("c")}, tree.Subsegments)
+
+	withIDs, err := root.TreeSnapshot(TreeSnapshotOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, root.TraceID, withIDs.TraceID)
+		assert.Equal(t, root.ID, withIDs.ID)
+		assert.Equal(t, root.StartTime, withIDs.StartTime)
+		if assert.Len(t, withIDs.Subsegments, 3) {
+			assert.Equal(t, root.ID, withIDs.Subsegments[0].ParentID)
+		}
+	}
+}
+
+func TestSegmentTreeSnapshotInProgress(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "root")
+	_, err := root.TreeSnapshot(TreeSnapshotOptions{})
+	assert.Error(t, err)
+	root.Close(nil)
+}
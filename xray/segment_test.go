@@ -10,18 +10,50 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/utils"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestSegmentEmittedOnceAtClose(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, sub := BeginSubsegment(ctx, "sub")
+	sub.Close(nil)
+	_, err := td.Recv()
+	assert.Error(t, err, "nothing is emitted before the segment closes")
+
+	root.Close(nil)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	assert.False(t, seg.InProgress)
+	assert.Len(t, seg.Subsegments, 1)
+	_, err = td.Recv()
+	assert.Error(t, err, "the segment is emitted once")
+}
+
 func TestSegmentDataRace(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -94,6 +126,41 @@ func TestSubsegmentDataRaceWithContextCancel(t *testing.T) {
 	seg.Close(nil)
 }
 
+func TestConcurrentSubsegmentsSameParent(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "TestSegment")
+	ctx, parent := BeginSubsegment(ctx, "TestParent")
+
+	var wg sync.WaitGroup
+	n := 100
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, seg := BeginSubsegment(ctx, "TestSubsegment")
+			assert.NoError(t, seg.AddAnnotation("key", "value"))
+			seg.Close(nil)
+		}()
+	}
+	wg.Wait()
+	parent.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, seg.Subsegments, 1)
+
+	parent.RLock()
+	defer parent.RUnlock()
+	assert.Len(t, parent.rawSubsegments, n)
+	assert.Len(t, parent.Subsegments, n)
+	assert.Equal(t, 0, parent.openSegments)
+	assert.Equal(t, uint32(n+1), atomic.LoadUint32(&root.totalSubSegments))
+}
+
 func TestSegmentDownstreamHeader(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -285,6 +352,38 @@ func TestIDGeneration_segSubSeg(t *testing.T) {
 	os.Unsetenv("AWS_XRAY_NOOP_ID")
 }
 
+func TestUnsampledSegmentTree(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+
+	ctx, seg := BeginSegment(ctx, "unsampled")
+	assert.True(t, seg.Dummy)
+	assert.Nil(t, seg.AWS)
+
+	_, subSeg := BeginSubsegment(ctx, "sub")
+	assert.True(t, subSeg.Dummy)
+	assert.False(t, subSeg.Sampled)
+	assert.Empty(t, seg.rawSubsegments)
+	assert.Equal(t, 0, seg.openSegments)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		subSeg.AddAnnotation("key", "value")
+		subSeg.AddMetadata("key", "value")
+		subSeg.Close(nil)
+	})
+	assert.Zero(t, allocs)
+
+	// The unsampled decision is still propagated downstream.
+	h := subSeg.DownstreamHeader()
+	assert.Equal(t, header.NotSampled, h.SamplingDecision)
+	assert.Equal(t, seg.TraceID, h.TraceID)
+
+	seg.Close(nil)
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
+
 // Benchmarks
 func BenchmarkBeginSegment(b *testing.B) {
 	ctx, td := NewTestDaemon()
@@ -307,6 +406,38 @@ func BenchmarkBeginSubsegment(b *testing.B) {
 	seg.Close(nil)
 }
 
+// fixedSamplingStrategy samples either every request or none.
+type fixedSamplingStrategy bool
+
+func (s fixedSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	return &sampling.Decision{Sample: bool(s)}
+}
+
+func benchmarkSegmentTree(b *testing.B, sampled bool) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(sampled)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, seg := BeginSegment(ctx, "TestBenchSeg")
+		_, subSeg := BeginSubsegment(ctx, "TestBenchSubSeg")
+		subSeg.AddAnnotation("key", "value")
+		subSeg.AddMetadata("key", "value")
+		subSeg.Close(nil)
+		seg.Close(nil)
+	}
+}
+
+func BenchmarkSegmentTree_sampled(b *testing.B) {
+	benchmarkSegmentTree(b, true)
+}
+
+func BenchmarkSegmentTree_unsampled(b *testing.B) {
+	benchmarkSegmentTree(b, false)
+}
+
 func BenchmarkAddError(b *testing.B) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -343,3 +474,1439 @@ func TestBeginSegmentNameFromEnv(t *testing.T) {
 	os.Unsetenv("AWS_XRAY_TRACING_NAME")
 	n.Close(nil)
 }
+
+func TestAddErrorNonFatal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.AddErrorNonFatal(errors.New("cache miss"))
+	seg.AddErrorNonFatal(nil)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, emitted.Fault)
+	assert.False(t, emitted.Error)
+	assert.False(t, emitted.Throttle)
+	if assert.NotNil(t, emitted.Cause) && assert.Len(t, emitted.Cause.Exceptions, 1) {
+		assert.Equal(t, "cache miss", emitted.Cause.Exceptions[0].Message)
+	}
+}
+
+func TestAddException(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	stack := make([]uintptr, 8)
+	stack = stack[:runtime.Callers(1, stack)]
+	assert.NoError(t, seg.AddException("UpstreamError", "upstream failed", stack))
+	assert.NoError(t, seg.AddRemoteException("ThrottlingException", "rate exceeded", nil))
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, emitted.Fault)
+	exceptions := emitted.Cause.Exceptions
+	if !assert.Len(t, exceptions, 2) {
+		return
+	}
+	assert.Equal(t, "UpstreamError", exceptions[0].Type)
+	assert.Equal(t, "upstream failed", exceptions[0].Message)
+	assert.False(t, exceptions[0].Remote)
+	assert.Equal(t, "TestAddException", exceptions[0].Stack[0].Label)
+	assert.Equal(t, "ThrottlingException", exceptions[1].Type)
+	assert.True(t, exceptions[1].Remote)
+	assert.Empty(t, exceptions[1].Stack)
+}
+
+type resourceARNSamplingStrategy struct {
+	request *sampling.Request
+}
+
+func (s *resourceARNSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	s.request = request
+	return &sampling.Decision{Sample: true}
+}
+
+func TestResourceARN(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	arn := "arn:aws:lambda:us-east-1:123456789012:function:orders"
+	ss := &resourceARNSamplingStrategy{}
+	cfg := GetRecorder(ctx)
+	cfg.SamplingStrategy = ss
+	cfg.ResourceARN = arn
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.Equal(t, arn, ss.request.ResourceARN)
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, arn, emitted.AWS["resource_arn"])
+}
+
+func TestSetResourceARN(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	arn := "arn:aws:apigateway:us-east-1::/restapis/abc/stages/prod"
+	_, seg := BeginSegment(ctx, "test")
+	seg.SetResourceARN(arn)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, arn, emitted.AWS["resource_arn"])
+}
+
+func TestSetName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, subseg := BeginSubsegment(ctx, "endpoint")
+	assert.Error(t, subseg.SetName(""))
+	assert.NoError(t, subseg.SetName("api.example.com"))
+	subseg.Close(nil)
+	assert.NoError(t, root.SetName("renamed"))
+	_, streamed := BeginSubsegment(ctx, "streamed")
+	streamed.CloseAndStream(nil)
+	assert.Error(t, streamed.SetName("too late"))
+	root.Close(nil)
+
+	sent, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "streamed", sent.Name)
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "renamed", emitted.Name)
+	var child *Segment
+	if assert.Len(t, emitted.Subsegments, 1) && assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &child)) {
+		assert.Equal(t, "api.example.com", child.Name)
+	}
+	assert.Error(t, root.SetName("too late"))
+	assert.Error(t, subseg.SetName("too late"))
+}
+
+func TestSetServiceName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "GET /orders/{id}")
+	_, subseg := BeginSubsegment(ctx, "subsegment")
+	assert.Error(t, subseg.SetServiceName("orders"))
+	subseg.Close(nil)
+	assert.NoError(t, root.SetServiceName("orders-api"))
+	assert.NoError(t, root.SetServiceName("orders"))
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "orders", emitted.Name)
+	assert.Equal(t, "GET /orders/{id}", emitted.Annotations[OperationAnnotationKey])
+}
+
+func TestSetRemoteServiceName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	assert.Error(t, root.SetRemoteServiceName("orders"))
+
+	_, subseg := BeginSubsegment(ctx, "POST api.internal")
+	assert.NoError(t, subseg.SetRemoteServiceName("billing"))
+	assert.NoError(t, subseg.SetRemoteServiceName("invoices"))
+	subseg.Close(nil)
+
+	_, awsSubseg := BeginSubsegment(ctx, "dynamodb")
+	awsSubseg.Namespace = "aws"
+	assert.NoError(t, awsSubseg.SetRemoteServiceName("users-table"))
+	awsSubseg.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, emitted.Subsegments, 2) {
+		return
+	}
+	var remote, remoteAWS *Segment
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &remote))
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[1], &remoteAWS))
+	assert.Equal(t, "invoices", remote.Name)
+	assert.Equal(t, "remote", remote.Namespace)
+	assert.Equal(t, "POST api.internal", remote.Metadata["default"]["label"])
+	assert.Equal(t, "users-table", remoteAWS.Name)
+	assert.Equal(t, "aws", remoteAWS.Namespace)
+}
+
+func TestSetDebug(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, subseg := BeginSubsegment(ctx, "sub")
+	subseg.SetDebug(true)
+	subseg.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, true, emitted.Annotations[DebugAnnotationKey])
+}
+
+func TestSampleDebugTraces(t *testing.T) {
+	tests := []struct {
+		name              string
+		header            string
+		sampleDebugTraces bool
+		wantSampled       bool
+		wantDebug         bool
+	}{
+		{"debug sampled", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0;Debug=1", true, true, true},
+		{"debug without config", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0;Debug=1", false, false, true},
+		{"not debug", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", true, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).SampleDebugTraces = test.sampleDebugTraces
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			_, seg := BeginSegmentWithSampling(ctx, "test", r, header.FromString(test.header))
+			assert.Equal(t, test.wantSampled, seg.Sampled)
+			assert.Equal(t, test.wantDebug, seg.Annotations[DebugAnnotationKey] == true)
+			seg.Close(nil)
+		})
+	}
+}
+
+// countingSamplingStrategy samples every request and counts its calls.
+type countingSamplingStrategy struct {
+	calls int
+}
+
+func (s *countingSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	s.calls++
+	return &sampling.Decision{Sample: true}
+}
+
+func TestSamplingOverride(t *testing.T) {
+	probe := func(r *http.Request) (bool, bool) {
+		switch r.URL.Path {
+		case "/probe":
+			return false, true
+		case "/always":
+			return true, true
+		}
+		return false, false
+	}
+	tests := []struct {
+		name              string
+		path              string
+		header            string
+		wantSampled       bool
+		wantStrategyCalls int
+	}{
+		{"forced off", "/probe", "Root=1-57fbe041-2c7ad569f5d6ff149137be86", false, 0},
+		{"forced off over upstream", "/probe", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=1", false, 0},
+		{"forced off over debug", "/probe", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=1;Debug=1", false, 0},
+		{"forced on over upstream", "/always", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", true, 0},
+		{"not forced", "/orders", "Root=1-57fbe041-2c7ad569f5d6ff149137be86", true, 1},
+		{"not forced upstream", "/orders", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			ss := &countingSamplingStrategy{}
+			GetRecorder(ctx).SamplingStrategy = ss
+			GetRecorder(ctx).SampleDebugTraces = true
+			GetRecorder(ctx).SamplingOverride = probe
+
+			r := httptest.NewRequest(http.MethodGet, "http://example.com"+test.path, nil)
+			_, seg := BeginSegmentWithSampling(ctx, "test", r, header.FromString(test.header))
+			assert.Equal(t, test.wantSampled, seg.Sampled)
+			assert.Equal(t, test.wantStrategyCalls, ss.calls)
+			seg.Close(nil)
+		})
+	}
+}
+
+func TestSampleLargeRequestsOver(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		header        string
+		wantSampled   bool
+	}{
+		{"large", 2048, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", true},
+		{"at threshold", 1024, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", false},
+		{"small", 10, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", false},
+		{"unknown length", -1, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", false},
+		{"large not sampled upstream", 2048, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+			GetRecorder(ctx).SampleLargeRequestsOver = 1024
+
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/upload", nil)
+			r.ContentLength = test.contentLength
+			_, seg := BeginSegmentWithSampling(ctx, "test", r, header.FromString(test.header))
+			assert.Equal(t, test.wantSampled, seg.Sampled)
+			seg.Close(nil)
+		})
+	}
+}
+
+func TestMinimumSampleRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		random      float64
+		header      string
+		wantSampled bool
+	}{
+		{"within rate", 0.05, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", true},
+		{"above rate", 0.5, "Root=1-57fbe041-2c7ad569f5d6ff149137be86", false},
+		{"not sampled upstream", 0.05, "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", false},
+		{"no header", 0.05, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+			GetRecorder(ctx).MinimumSampleRate = 0.1
+			old := minimumSampleRand
+			minimumSampleRand = &utils.MockRand{F64: test.random}
+			defer func() { minimumSampleRand = old }()
+
+			var seg *Segment
+			if test.header == "" {
+				_, seg = BeginSegment(ctx, "test")
+			} else {
+				r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+				_, seg = BeginSegmentWithSampling(ctx, "test", r, header.FromString(test.header))
+			}
+			assert.Equal(t, test.wantSampled, seg.Sampled)
+			seg.Close(nil)
+		})
+	}
+}
+
+type ruleNameSamplingStrategy struct{}
+
+func (s *ruleNameSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	rule := "orders"
+	return &sampling.Decision{Sample: true, Rule: &rule}
+}
+
+type rateSamplingStrategy struct {
+	rate float64
+}
+
+func (s *rateSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	return &sampling.Decision{Sample: true, Rate: &s.rate}
+}
+
+func TestSamplingRateRecorded(t *testing.T) {
+	tests := []struct {
+		name      string
+		sampleAll bool
+		want      interface{}
+	}{
+		{"sampled at rate", false, 0.05},
+		{"forced", true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			GetRecorder(ctx).SamplingStrategy = &rateSamplingStrategy{rate: 0.05}
+			GetRecorder(ctx).SampleAll = test.sampleAll
+
+			_, seg := BeginSegment(ctx, "test")
+			seg.Close(nil)
+
+			emitted, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			xray, _ := emitted.AWS["xray"].(map[string]interface{})
+			assert.Equal(t, SDKVersion, xray["sdk_version"])
+			assert.Equal(t, test.want, xray["sampling_rate"])
+		})
+	}
+}
+
+func TestMinimumSampleRateRecorded(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).SamplingStrategy = fixedSamplingStrategy(false)
+	GetRecorder(ctx).MinimumSampleRate = 0.1
+	old := minimumSampleRand
+	minimumSampleRand = &utils.MockRand{F64: 0.05}
+	defer func() { minimumSampleRand = old }()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0.1, emitted.AWS["xray"].(map[string]interface{})["sampling_rate"])
+	}
+}
+
+func TestSDKMetadata(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"sdk_version": SDKVersion, "sdk": SDKType}, emitted.AWS["xray"])
+	assert.Equal(t, runtime.Compiler, emitted.Service.Runtime)
+	assert.Equal(t, runtime.Version(), emitted.Service.RuntimeVersion)
+}
+
+func TestDisableSDKMetadata(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	cfg := GetRecorder(ctx)
+	cfg.DisableSDKMetadata = true
+	cfg.SamplingStrategy = &ruleNameSamplingStrategy{}
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"sampling_rule_name": "orders"}, emitted.AWS["xray"])
+	if emitted.Service != nil {
+		assert.Empty(t, emitted.Service.Runtime)
+		assert.Empty(t, emitted.Service.RuntimeVersion)
+	}
+}
+
+func TestSampleAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		override    bool
+		wantSampled bool
+	}{
+		{"no header", "", false, true},
+		{"sampling requested", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=?", false, true},
+		{"upstream sampled", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=1", false, true},
+		{"upstream not sampled", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", false, false},
+		{"upstream not sampled overridden", "Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+			cfg := GetRecorder(ctx)
+			cfg.SamplingStrategy = sampling.NewDeterministicStrategy(false)
+			cfg.SampleAll = true
+			cfg.OverrideUpstreamSampling = test.override
+
+			var seg *Segment
+			if test.header == "" {
+				_, seg = BeginSegment(ctx, "test")
+			} else {
+				r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+				_, seg = BeginSegmentWithSampling(ctx, "test", r, header.FromString(test.header))
+			}
+			assert.Equal(t, test.wantSampled, seg.Sampled)
+			seg.Close(nil)
+		})
+	}
+}
+
+func TestSampleAllContextWithTraceHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	cfg := GetRecorder(ctx)
+	cfg.SamplingStrategy = sampling.NewDeterministicStrategy(false)
+	cfg.SampleAll = true
+
+	h := header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8")
+	assert.True(t, GetSegment(ContextWithTraceHeader(ctx, h)).Sampled)
+
+	h = header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;Parent=53995c3f42cd8ad8;Sampled=0")
+	assert.False(t, GetSegment(ContextWithTraceHeader(ctx, h)).Sampled)
+}
+
+func TestOverrideUpstreamSamplingWithoutSampleAll(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).OverrideUpstreamSampling = true
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	_, seg := BeginSegmentWithSampling(ctx, "test", r, header.FromString("Root=1-57fbe041-2c7ad569f5d6ff149137be86;Sampled=0"))
+	assert.False(t, seg.Sampled)
+	seg.Close(nil)
+}
+
+type requestIDKey struct{}
+
+func TestBeginSegmentPreservesContextValues(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx = context.WithValue(ctx, requestIDKey{}, "req-1234")
+	ctx, cancel := context.WithCancel(ctx)
+
+	segCtx, seg := BeginSegment(ctx, "test")
+	assert.Equal(t, "req-1234", segCtx.Value(requestIDKey{}))
+	subCtx, subseg := BeginSubsegment(segCtx, "subsegment")
+	assert.Equal(t, "req-1234", subCtx.Value(requestIDKey{}))
+
+	cancel()
+	assert.Equal(t, context.Canceled, subCtx.Err())
+	subseg.Close(nil)
+	seg.Close(nil)
+}
+
+func TestSegmentBeginSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "Segment")
+	child := root.BeginSubsegment("Child")
+	grandchild := child.BeginSubsegment("Grandchild")
+
+	assert.Equal(t, root, child.ParentSegment)
+	assert.Equal(t, root, grandchild.ParentSegment)
+	assert.Equal(t, root.TraceID, grandchild.TraceID)
+	assert.Equal(t, uint32(2), atomic.LoadUint32(&root.totalSubSegments))
+
+	grandchild.Close(nil)
+	child.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var c *Segment
+	if !assert.Len(t, seg.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &c)) {
+		return
+	}
+	assert.Equal(t, "Child", c.Name)
+	var gc *Segment
+	if !assert.Len(t, c.Subsegments, 1) || !assert.NoError(t, json.Unmarshal(c.Subsegments[0], &gc)) {
+		return
+	}
+	assert.Equal(t, "Grandchild", gc.Name)
+}
+
+// TestContextFreeLifecycle traces a segment tree without using a context
+// beyond the one supplying the configuration to BeginSegment.
+func TestContextFreeLifecycle(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "Library")
+	query := root.BeginSubsegment("query")
+	assert.NoError(t, query.AddAnnotation("table", "users"))
+	assert.NoError(t, query.AddMetadata("rows", 3))
+	assert.Equal(t, query.ID, query.DownstreamHeader().ParentID)
+	assert.NoError(t, query.AddError(errors.New("timeout")))
+	query.Close(nil)
+
+	cache := root.BeginSubsegmentWithoutSampling("cache")
+	assert.Equal(t, header.NotSampled, cache.DownstreamHeader().SamplingDecision)
+	cache.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Library", seg.Name)
+	if !assert.Len(t, seg.Subsegments, 2) {
+		return
+	}
+	var q *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &q)) {
+		return
+	}
+	assert.Equal(t, "query", q.Name)
+	assert.Equal(t, "users", q.Annotations["table"])
+	assert.Equal(t, float64(3), q.Metadata["default"]["rows"])
+	assert.True(t, q.Fault)
+}
+
+func TestSegmentBeginSubsegmentWithoutSampling(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, root := BeginSegment(ctx, "Segment")
+	child := root.BeginSubsegmentWithoutSampling("Child")
+	assert.False(t, child.Sampled)
+	assert.True(t, child.Dummy)
+	child.Close(nil)
+	root.Close(nil)
+
+	var seg *Segment
+	assert.Nil(t, seg.BeginSubsegmentWithoutSampling("Child"))
+}
+
+func TestNilSegmentBeginSubsegment(t *testing.T) {
+	var seg *Segment
+	assert.Nil(t, seg.BeginSubsegment("Child"))
+}
+
+func TestSegmentTimingOpen(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	before := time.Now()
+	_, seg := BeginSegment(ctx, "Open")
+	defer seg.Close(nil)
+	time.Sleep(10 * time.Millisecond)
+
+	assert.WithinDuration(t, before, seg.GetStartTime(), 5*time.Millisecond)
+	assert.True(t, seg.GetEndTime().IsZero())
+	assert.True(t, seg.GetDuration() >= 10*time.Millisecond)
+}
+
+func TestSegmentTimingClosed(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "Closed")
+	time.Sleep(10 * time.Millisecond)
+	seg.Close(nil)
+
+	d := seg.GetDuration()
+	assert.True(t, d >= 10*time.Millisecond)
+	assert.False(t, seg.GetEndTime().IsZero())
+	assert.WithinDuration(t, seg.GetStartTime().Add(d), seg.GetEndTime(), time.Microsecond)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, d, seg.GetDuration())
+}
+
+// useMockClock replaces the clock used for segment timings
+// until the end of the test.
+func useMockClock(t *testing.T, sec int64) *utils.MockClock {
+	mc := &utils.MockClock{NowTime: sec}
+	old := clock
+	clock = mc
+	t.Cleanup(func() { clock = old })
+	return mc
+}
+
+func TestSegmentTimingMockClock(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	mc := useMockClock(t, 1500000000)
+
+	ctx, seg := BeginSegment(ctx, "Segment")
+	mc.Increment(0, int64(250*time.Millisecond))
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+	mc.Increment(1, 0)
+	assert.Equal(t, time.Second, subseg.GetDuration())
+	subseg.Close(nil)
+	mc.Increment(0, int64(250*time.Millisecond))
+	seg.Close(nil)
+
+	assert.Equal(t, time.Unix(1500000000, 0), seg.GetStartTime())
+	assert.Equal(t, 1500*time.Millisecond, seg.GetDuration())
+	assert.Equal(t, "59682f00", seg.TraceID[2:10])
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1500000000.0, emitted.StartTime)
+	assert.Equal(t, 1500000001.5, emitted.EndTime)
+
+	s := &Segment{}
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], s))
+	assert.InDelta(t, 1500000000.25, s.StartTime, 1e-6)
+	assert.InDelta(t, 1500000001.25, s.EndTime, 1e-6)
+}
+
+func TestSetEnqueueTime(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	mc := useMockClock(t, 1500000000)
+
+	ctx, seg := BeginSegment(ctx, "Segment")
+	mc.Increment(1, 0)
+	_, subseg := BeginSubsegment(ctx, "Task")
+	assert.Error(t, subseg.SetEnqueueTime(time.Unix(1500000002, 0)), "enqueued after the start")
+	assert.NoError(t, subseg.SetEnqueueTime(time.Unix(1500000000, int64(250*time.Millisecond))))
+	mc.Increment(1, 0)
+	subseg.Close(nil)
+	assert.Error(t, subseg.SetEnqueueTime(time.Unix(1500000000, 0)), "closed")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	task := &Segment{}
+	if !assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], task)) {
+		return
+	}
+	assert.Equal(t, "Task", task.Name)
+	assert.InDelta(t, 1500000000.25, task.StartTime, 1e-6)
+	assert.InDelta(t, 1500000002, task.EndTime, 1e-6)
+	assert.InDelta(t, 1500000000.25, task.Metadata["default"]["enqueue_time"], 1e-6)
+	assert.InDelta(t, 0.75, task.Metadata["default"]["wait_time"], 1e-6)
+
+	if !assert.Len(t, task.Subsegments, 1) {
+		return
+	}
+	queued := &Segment{}
+	assert.NoError(t, json.Unmarshal(task.Subsegments[0], queued))
+	assert.Equal(t, "queued", queued.Name)
+	assert.Len(t, queued.ID, 16)
+	assert.InDelta(t, 1500000000.25, queued.StartTime, 1e-6)
+	assert.InDelta(t, 1500000001, queued.EndTime, 1e-6)
+}
+
+func TestSetEnqueueTimeAtStart(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "Segment")
+	assert.NoError(t, seg.SetEnqueueTime(seg.GetStartTime()))
+	assert.GreaterOrEqual(t, seg.Metadata["default"]["wait_time"], 0.0)
+	seg.Close(nil)
+}
+
+func TestSegmentConsoleURL(t *testing.T) {
+	seg := &Segment{TraceID: "1-5759e988-bd862e3fe1be46a994272793"}
+
+	assert.Equal(t, "https://us-west-2.console.aws.amazon.com/xray/home?region=us-west-2#/traces/1-5759e988-bd862e3fe1be46a994272793?timeRange=2016-06-09T22:11:20~2016-06-09T23:11:20",
+		seg.ConsoleURL("us-west-2"))
+	assert.Equal(t, "https://console.amazonaws.cn/xray/home?region=cn-north-1#/traces/1-5759e988-bd862e3fe1be46a994272793?timeRange=2016-06-09T22:11:20~2016-06-09T23:11:20",
+		seg.ConsoleURL("cn-north-1"))
+	assert.Empty(t, seg.ConsoleURL(""))
+}
+
+func TestSegmentConsoleURLInvalidTraceID(t *testing.T) {
+	for _, traceID := range []string{"", noOpTraceID(), "1-xyz-bd862e3fe1be46a994272793", "invalid"} {
+		seg := &Segment{TraceID: traceID}
+		assert.Empty(t, seg.ConsoleURL("us-west-2"), traceID)
+	}
+}
+
+func TestSubsegmentConsoleURL(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "Segment")
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+	assert.Equal(t, seg.ConsoleURL("us-east-1"), subseg.ConsoleURL("us-east-1"))
+	assert.Contains(t, subseg.ConsoleURL("us-east-1"), seg.TraceID)
+	subseg.Close(nil)
+	seg.Close(nil)
+}
+
+func TestSegmentIDGenerator(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var n int
+	GetRecorder(ctx).SegmentIDGenerator = func() string {
+		n++
+		return fmt.Sprintf("%016x", n)
+	}
+
+	ctx, seg := BeginSegment(ctx, "Segment")
+	_, subseg := BeginSubsegment(ctx, "Subsegment")
+	subseg.Close(nil)
+	seg.Close(nil)
+
+	assert.Equal(t, "0000000000000001", seg.ID)
+	assert.Equal(t, "0000000000000002", subseg.ID)
+	assert.Equal(t, "0000000000000001", subseg.ParentID)
+}
+
+func TestSegmentIDGeneratorInvalidID(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	for _, id := range []string{"", "123", "0000000000000ABC", "000000000000000g", "00000000000000001"} {
+		GetRecorder(ctx).SegmentIDGenerator = func() string { return id }
+
+		_, seg := BeginSegment(ctx, "Segment")
+		assert.NotEqual(t, id, seg.ID)
+		assert.True(t, header.IsValidSegmentID(seg.ID), seg.ID)
+		seg.Close(nil)
+	}
+}
+
+func TestAppendMetadataConcurrently(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "Test")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			seg.AppendMetadata("items", "processed", i)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, seg.Metadata["items"]["processed"], 50)
+	seg.Close(nil)
+}
+
+func TestRecordPhase(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "Test")
+	seg.RecordPhase("parse", 3*time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seg.RecordPhase("commit", 200*time.Microsecond)
+		}()
+	}
+	wg.Wait()
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	phases, ok := emitted.Metadata["default"][PhasesMetadataKey].(map[string]interface{})
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Len(t, phases, 2)
+	assert.InDelta(t, 3.0, phases["parse"], 1e-9)
+	assert.InDelta(t, 10.0, phases["commit"], 1e-9)
+}
+
+func TestCaptureCallerLocation(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).CaptureCallerLocation = true
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, _, line, _ := runtime.Caller(0)
+	_, direct := BeginSubsegment(ctx, "direct")
+	direct.Close(nil)
+	method := seg.BeginSubsegment("method")
+	method.Close(nil)
+	var captured *Segment
+	Capture(ctx, "captured", func(ctx context.Context) error {
+		captured = GetSegment(ctx)
+		return nil
+	})
+	seg.Close(nil)
+
+	assert.Equal(t, fmt.Sprintf("segment_test.go:%d", line+1), filepath.Base(direct.Metadata["default"]["source"].(string)))
+	assert.Equal(t, fmt.Sprintf("segment_test.go:%d", line+3), filepath.Base(method.Metadata["default"]["source"].(string)))
+	assert.Equal(t, fmt.Sprintf("segment_test.go:%d", line+6), filepath.Base(captured.Metadata["default"]["source"].(string)))
+}
+
+func TestCaptureCallerLocationDisabledByDefault(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, subseg := BeginSubsegment(ctx, "direct")
+	subseg.Close(nil)
+	seg.Close(nil)
+
+	assert.NotContains(t, subseg.Metadata["default"], "source")
+}
+
+func TestMaxSubsegmentsPerSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).MaxSubsegmentsPerSegment = 2
+
+	ctx, root := BeginSegment(ctx, "test")
+	childCtx, child := BeginSubsegment(ctx, "child")
+	_, grandchild := BeginSubsegment(childCtx, "grandchild")
+	grandchild.Close(nil)
+
+	droppedCtx, dropped := BeginSubsegment(childCtx, "dropped")
+	assert.True(t, dropped.Dummy)
+	assert.Equal(t, child.ID, dropped.DownstreamHeader().ParentID)
+	assert.Equal(t, header.Sampled, dropped.DownstreamHeader().SamplingDecision)
+	_, nested := BeginSubsegment(droppedCtx, "nested")
+	assert.True(t, nested.Dummy)
+	nested.Close(nil)
+	dropped.Close(nil)
+	child.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, float64(2), emitted.Metadata["default"]["dropped_subsegments"])
+	if !assert.Len(t, emitted.Subsegments, 1) {
+		return
+	}
+	var emittedChild *Segment
+	if assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &emittedChild)) {
+		assert.Equal(t, "child", emittedChild.Name)
+		assert.Len(t, emittedChild.Subsegments, 1)
+	}
+}
+
+func TestMaxSubsegmentsPerSegmentSkipsInternal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).MaxSubsegmentsPerSegment = 1
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, internal := beginInternalSubsegment(ctx, "connect")
+	assert.False(t, internal.Dummy)
+	internal.Close(nil)
+	_, child := BeginSubsegment(ctx, "child")
+	assert.False(t, child.Dummy, "internal subsegments do not count against the maximum")
+	child.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, 2)
+	assert.NotContains(t, emitted.Metadata["default"], "dropped_subsegments")
+}
+
+func TestMaxSubsegmentsPerSegmentCountsQueued(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).MaxSubsegmentsPerSegment = 1
+	mc := useMockClock(t, 1500000000)
+
+	ctx, root := BeginSegment(ctx, "test")
+	mc.Increment(1, 0)
+	_, child := BeginSubsegment(ctx, "child")
+	assert.NoError(t, child.SetEnqueueTime(time.Unix(1500000000, 0)))
+	child.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, float64(1), emitted.Metadata["default"]["dropped_subsegments"])
+	var emittedChild *Segment
+	if assert.Len(t, emitted.Subsegments, 1) && assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &emittedChild)) {
+		assert.Empty(t, emittedChild.Subsegments)
+		assert.InDelta(t, 1500000000, emittedChild.StartTime, 1e-6)
+	}
+}
+
+func TestMaxSubsegmentsPerSegmentUnlimitedByDefault(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	for i := 0; i < 10; i++ {
+		_, seg := BeginSubsegment(ctx, "child")
+		seg.Close(nil)
+	}
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, 10)
+	assert.NotContains(t, emitted.Metadata["default"], "dropped_subsegments")
+}
+
+func TestCloseWithContextDrainsEmitter(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	de := &drainingEmitter{}
+	GetRecorder(ctx).Emitter = de
+
+	_, seg := BeginSegment(ctx, "test")
+	assert.NoError(t, seg.CloseWithContext(context.Background(), nil))
+	assert.Equal(t, []string{"test"}, de.names)
+	assert.True(t, de.drained)
+}
+
+func TestCloseWithContextDeadline(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ge := newGatedEmitter()
+	GetRecorder(ctx).Emitter = ge
+
+	_, seg := BeginSegment(ctx, "test")
+	closeCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	errCh := make(chan error)
+	go func() { errCh <- seg.CloseWithContext(closeCtx, nil) }()
+	assert.Equal(t, "test", <-ge.started)
+
+	err := <-errCh
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// Closing goes on in the background once the deadline passed.
+	close(ge.release)
+	assert.Eventually(t, func() bool { return len(ge.emitted()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+// traceIDStrategy samples every request and records their trace IDs.
+type traceIDStrategy struct {
+	traceIDs []string
+}
+
+func (s *traceIDStrategy) ShouldTrace(rq *sampling.Request) *sampling.Decision {
+	s.traceIDs = append(s.traceIDs, rq.TraceID)
+	return &sampling.Decision{Sample: true}
+}
+
+func TestSamplingRequestCarriesTraceID(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ss := &traceIDStrategy{}
+	GetRecorder(ctx).SamplingStrategy = ss
+
+	_, seg := BeginSegment(ctx, "new trace")
+	seg.Close(nil)
+
+	h := header.FromString("Root=1-57ff426a-80c11c39b0c928905eb0828d")
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	_, seg2 := NewSegmentFromHeader(ctx, "incoming trace", r, h)
+	seg2.Close(nil)
+
+	assert.Equal(t, []string{seg.TraceID, "1-57ff426a-80c11c39b0c928905eb0828d"}, ss.traceIDs)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", seg2.TraceID)
+}
+
+func TestAddAnnotationSanitizesKey(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	assert.NoError(t, seg.AddAnnotation("http.status", 200))
+	assert.NoError(t, seg.AddAnnotation("user-id", "u1"))
+	assert.NoError(t, seg.AddAnnotation("order total", 12.5))
+	assert.NoError(t, seg.AddAnnotation("valid_Key1", true))
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"http_status": float64(200),
+		"user_id":     "u1",
+		"order_total": 12.5,
+		"valid_Key1":  true,
+	}, emitted.Annotations)
+}
+
+func TestAddAnnotationCustomKeySanitizer(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).AnnotationKeySanitizer = func(key string) string {
+		return strings.ToLower(SanitizeAnnotationKey(key))
+	}
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, sub := BeginSubsegment(ctx, "sub")
+	assert.NoError(t, sub.AddAnnotation("User-ID", 1))
+	sub.Close(nil)
+	seg.Close(nil)
+
+	assert.Equal(t, map[string]interface{}{"user_id": 1}, sub.Annotations)
+}
+
+func TestCloseAt(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	useMockClock(t, 1000)
+
+	_, seg := BeginSegment(ctx, "test")
+	assert.NoError(t, seg.CloseAt(time.Unix(1002, int64(500*time.Millisecond)), errors.New("late")))
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, float64(1000), emitted.StartTime)
+	assert.Equal(t, 1002.5, emitted.EndTime)
+	assert.False(t, emitted.InProgress)
+	assert.True(t, emitted.Fault)
+}
+
+func TestCloseAtBeforeStart(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	useMockClock(t, 1000)
+
+	_, seg := BeginSegment(ctx, "test")
+	assert.Error(t, seg.CloseAt(time.Unix(999, 0), nil))
+	assert.True(t, seg.InProgress)
+	assert.NoError(t, seg.CloseAt(time.Unix(1000, 0), nil))
+	assert.Equal(t, float64(1000), seg.EndTime)
+}
+
+func TestCloseAll(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, closed := BeginSubsegment(ctx, "closed")
+	closed.Close(nil)
+	closedEnd := closed.EndTime
+	joinCtx, join := BeginSubsegment(ctx, "join")
+	var branches []*Segment
+	for i := 0; i < 3; i++ {
+		_, branch := BeginSubsegment(joinCtx, fmt.Sprintf("branch-%d", i))
+		branches = append(branches, branch)
+		time.Sleep(time.Millisecond)
+	}
+
+	CloseAll(append([]*Segment{join, nil, closed}, branches...), errors.New("join failed"))
+	assert.Equal(t, closedEnd, closed.EndTime)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, emitted.Subsegments, 2) {
+		return
+	}
+	var joined Segment
+	assert.NoError(t, json.Unmarshal(emitted.Subsegments[1], &joined))
+	assert.Equal(t, "join", joined.Name)
+	assert.True(t, joined.Fault)
+	if !assert.Len(t, joined.Subsegments, 3) {
+		return
+	}
+	for _, raw := range joined.Subsegments {
+		var branch Segment
+		assert.NoError(t, json.Unmarshal(raw, &branch))
+		assert.Equal(t, joined.EndTime, branch.EndTime, branch.Name)
+		assert.True(t, branch.Fault)
+	}
+}
+
+func TestEmitSubsegmentsIndependently(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).EmitSubsegmentsIndependently = true
+
+	ctx, root := BeginSegment(ctx, "root")
+	subCtx, first := BeginSubsegment(ctx, "first")
+	_, nested := BeginSubsegment(subCtx, "nested")
+	_, second := BeginSubsegment(ctx, "second")
+	nested.Close(nil)
+	first.Close(nil)
+	root.Close(nil)
+	second.Close(nil)
+
+	for _, name := range []string{"first", "second"} {
+		sub, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, name, sub.Name)
+		assert.Equal(t, "subsegment", sub.Type)
+		assert.Equal(t, root.TraceID, sub.TraceID)
+		assert.Equal(t, root.ID, sub.ParentID)
+		if name == "first" {
+			assert.Len(t, sub.Subsegments, 1)
+		}
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	assert.Empty(t, seg.Subsegments)
+}
+
+func TestNestedBeginSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	rootCtx, root := BeginSegment(ctx, "root")
+	_, nested := BeginSegment(rootCtx, "nested")
+	assert.Equal(t, root, nested.parent)
+	assert.Equal(t, root.TraceID, nested.root().TraceID)
+	nested.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	if !assert.Len(t, seg.Subsegments, 1) {
+		return
+	}
+	var sub *Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &sub))
+	assert.Equal(t, "nested", sub.Name)
+
+	// A closed segment does not nest.
+	_, next := BeginSegment(rootCtx, "next")
+	assert.Nil(t, next.parent)
+	assert.NotEqual(t, root.TraceID, next.TraceID)
+	next.Close(nil)
+	_, err = td.Recv()
+	assert.NoError(t, err)
+}
+
+func TestNestedBeginSegmentAsSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).NestedSegmentBehavior = NestedSegmentAsSegment
+
+	rootCtx, root := BeginSegment(ctx, "root")
+	_, nested := BeginSegment(rootCtx, "nested")
+	assert.Nil(t, nested.parent)
+	assert.NotEqual(t, root.TraceID, nested.TraceID)
+	nested.Close(nil)
+	root.Close(nil)
+
+	for _, name := range []string{"nested", "root"} {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, name, seg.Name)
+		assert.Empty(t, seg.Subsegments)
+	}
+}
+
+func TestNestedBeginSegmentError(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).NestedSegmentBehavior = NestedSegmentError
+	GetRecorder(ctx).ContextMissingStrategy = ctxmissing.NewDefaultRuntimeErrorStrategy()
+
+	rootCtx, root := BeginSegment(ctx, "root")
+	subCtx, sub := BeginSubsegment(rootCtx, "sub")
+	assert.Panics(t, func() {
+		BeginSegment(subCtx, "nested")
+	})
+	sub.Close(nil)
+	root.Close(nil)
+
+	GetRecorder(ctx).ContextMissingStrategy = ctxmissing.NewDefaultIgnoreErrorStrategy()
+	rootCtx, root = BeginSegment(ctx, "other")
+	nestedCtx, nested := BeginSegment(rootCtx, "nested")
+	if !assert.NotNil(t, nested) {
+		return
+	}
+	assert.True(t, nested.Dummy)
+	assert.Equal(t, rootCtx, nestedCtx)
+	assert.NoError(t, nested.AddAnnotation("key", "value"))
+	assert.NoError(t, nested.AddMetadata("key", "value"))
+	nested.Close(nil)
+	root.Close(nil)
+
+	for _, want := range []struct {
+		name        string
+		subsegments int
+	}{{"root", 1}, {"other", 0}} {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, want.name, seg.Name)
+		assert.Len(t, seg.Subsegments, want.subsegments)
+	}
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
+
+func TestNestedBeginSegmentOverridesGlobal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	globalCfg.nestedSegmentBehavior = NestedSegmentAsSegment
+	defer func() { globalCfg.nestedSegmentBehavior = NestedSegmentDefault }()
+
+	rootCtx, root := BeginSegment(ctx, "root")
+	_, nested := BeginSegment(rootCtx, "nested")
+	assert.Nil(t, nested.parent)
+	nested.Close(nil)
+	root.Close(nil)
+
+	GetRecorder(ctx).NestedSegmentBehavior = NestedSegmentAsSubsegment
+	rootCtx, root = BeginSegment(ctx, "root")
+	_, nested = BeginSegment(rootCtx, "nested")
+	assert.Equal(t, root, nested.parent)
+	nested.Close(nil)
+	root.Close(nil)
+}
+
+func TestCloseWithOpenSubsegmentInProgress(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, background := BeginSubsegment(ctx, "background")
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	if assert.Len(t, seg.Subsegments, 1) {
+		var subseg *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+		assert.True(t, subseg.InProgress)
+	}
+
+	// Closing the subsegment sends neither it nor the segment again.
+	background.Close(nil)
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestCloseWithOpenSubsegmentDetach(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).OpenSubsegmentPolicy = OpenSubsegmentsDetach
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, done := BeginSubsegment(ctx, "done")
+	done.Close(nil)
+	bgCtx, background := BeginSubsegment(ctx, "background")
+	_, nested := BeginSubsegment(bgCtx, "nested")
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "root", seg.Name)
+	if assert.Len(t, seg.Subsegments, 1) {
+		var subseg *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+		assert.Equal(t, "done", subseg.Name)
+	}
+
+	nested.Close(nil)
+	background.Close(nil)
+	detached, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "background", detached.Name)
+	assert.Equal(t, "subsegment", detached.Type)
+	assert.Equal(t, seg.TraceID, detached.TraceID)
+	assert.Equal(t, seg.ID, detached.ParentID)
+	assert.Len(t, detached.Subsegments, 1)
+
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestBeginSubsegmentIsLocal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, local := BeginSubsegment(ctx, "compute")
+	local.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, seg.Subsegments, 1) {
+		return
+	}
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &raw))
+	assert.Equal(t, "compute", raw["name"])
+	assert.NotContains(t, raw, "namespace")
+	assert.NotContains(t, raw, "http")
+	assert.NotContains(t, raw, "aws")
+	assert.NotContains(t, raw, "sql")
+}
+
+func TestBeginSubsegmentPromoteOrphan(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).ContextMissingStrategy = ctxmissing.NewDefaultIgnoreErrorStrategy()
+
+	_, sub := BeginSubsegment(ctx, "orphan")
+	assert.Nil(t, sub)
+
+	GetRecorder(ctx).PromoteOrphanSubsegments = true
+	GetRecorder(ctx).ContextMissingStrategy = ctxmissing.NewDefaultRuntimeErrorStrategy()
+	subCtx, sub := BeginSubsegment(ctx, "library")
+	if !assert.NotNil(t, sub) {
+		return
+	}
+	assert.Equal(t, sub, GetSegment(subCtx))
+	_, child := BeginSubsegment(subCtx, "query")
+	child.Close(nil)
+	sub.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "library", seg.Name)
+	assert.Empty(t, seg.ParentID)
+	assert.Equal(t, "", seg.Type)
+	assert.Len(t, seg.Subsegments, 1)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// rejectedSegments counts the segments not emitted because they failed
+// validation. It is accessed atomically.
+var rejectedSegments uint64
+
+// RejectedSegmentCount returns the number of segments which were not emitted
+// because they are missing required fields. Segments are only validated if
+// Config.ValidateSegments is set.
+func RejectedSegmentCount() uint64 {
+	return atomic.LoadUint64(&rejectedSegments)
+}
+
+// ValidateSegment checks that seg and the subsegments it embeds carry the
+// fields the daemon requires: a trace ID, an ID, a name and a start time.
+// It returns an error naming the first (sub)segment missing one of them.
+// seg has a write lock acquired by the caller.
+func ValidateSegment(seg *Segment) error {
+	if seg.TraceID == "" {
+		return fmt.Errorf("segment %q has no trace ID", seg.Name)
+	}
+	return validateEntity(seg)
+}
+
+// validateEntity checks the fields required of every (sub)segment.
+// seg has a write lock acquired by the caller.
+func validateEntity(seg *Segment) error {
+	switch {
+	case seg.Name == "":
+		return fmt.Errorf("segment with ID %q has no name", seg.ID)
+	case seg.ID == "":
+		return fmt.Errorf("segment %q has no ID", seg.Name)
+	case seg.StartTime == 0:
+		return fmt.Errorf("segment %q has no start time", seg.Name)
+	}
+
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		err := validateEntity(s)
+		s.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
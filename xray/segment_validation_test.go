@@ -0,0 +1,72 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSegment(t *testing.T) {
+	valid := func() *Segment {
+		return &Segment{TraceID: "1-57fbe041-2c7ad569f5d6ff149137be86", ID: "53995c3f42cd8ad8", Name: "Test", StartTime: 1500000000}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(seg *Segment)
+		wantErr string
+	}{
+		{"valid", func(seg *Segment) {}, ""},
+		{"no trace ID", func(seg *Segment) { seg.TraceID = "" }, `segment "Test" has no trace ID`},
+		{"no ID", func(seg *Segment) { seg.ID = "" }, `segment "Test" has no ID`},
+		{"no name", func(seg *Segment) { seg.Name = "" }, `segment with ID "53995c3f42cd8ad8" has no name`},
+		{"no start time", func(seg *Segment) { seg.StartTime = 0 }, `segment "Test" has no start time`},
+		{"invalid subsegment", func(seg *Segment) {
+			seg.rawSubsegments = []*Segment{{ID: "1e5a13f4a8cee0f1", Name: "Subsegment"}}
+		}, `segment "Subsegment" has no start time`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seg := valid()
+			test.modify(seg)
+			err := ValidateSegment(seg)
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equal(t, test.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateSegmentsRejectsInvalidSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).ValidateSegments = true
+
+	rejected := RejectedSegmentCount()
+
+	ctx1, root := BeginSegment(ctx, "Invalid")
+	_, subseg := BeginSubsegment(ctx1, "")
+	subseg.Close(nil)
+	root.Close(nil)
+
+	_, root = BeginSegment(ctx, "Valid")
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Valid", seg.Name)
+	assert.Equal(t, rejected+1, RejectedSegmentCount())
+}
@@ -0,0 +1,26 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package xray
+
+import (
+	"errors"
+	"net"
+)
+
+// errSendBufferSizeUnsupported is returned where the send buffer size of a
+// socket cannot be read back.
+var errSendBufferSizeUnsupported = errors.New("reading the send buffer size is not supported on this platform")
+
+// sendBufferSize returns the effective send buffer size of conn.
+func sendBufferSize(conn *net.UDPConn) (int, error) {
+	return 0, errSendBufferSizeUnsupported
+}
@@ -39,43 +39,56 @@ type namedValueChecker interface {
 
 var (
 	muInitializedDrivers sync.Mutex
-	initializedDrivers   map[string]struct{}
+	initializedDrivers   map[string]*driverDriver
 	attrHook             func(attr *dbAttribute) // for testing
 )
 
-func initXRayDriver(driver, dsn string) error {
+func initXRayDriver(driver, dsn string) (*driverDriver, error) {
 	muInitializedDrivers.Lock()
 	defer muInitializedDrivers.Unlock()
 
 	if initializedDrivers == nil {
-		initializedDrivers = map[string]struct{}{}
+		initializedDrivers = map[string]*driverDriver{}
 	}
-	if _, ok := initializedDrivers[driver]; ok {
-		return nil
+	if d, ok := initializedDrivers[driver]; ok {
+		return d, nil
 	}
 
 	db, err := sql.Open(driver, dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	sql.Register(driver+":xray", &driverDriver{
+	d := &driverDriver{
 		Driver:   db.Driver(),
 		baseName: driver,
-	})
-	initializedDrivers[driver] = struct{}{}
+	}
+	sql.Register(driver+":xray", d)
+	initializedDrivers[driver] = d
 	db.Close()
-	return nil
+	return d, nil
 }
 
 // SQLContext opens a normalized and traced wrapper around an *sql.DB connection.
 // It uses `sql.Open` internally and shares the same function signature.
 // To ensure passwords are filtered, it is HIGHLY RECOMMENDED that your DSN
 // follows the format: `<schema>://<user>:<password>@<host>:<port>/<database>`
+//
+// The connection pool of the returned *sql.DB is recorded into its
+// subsegments if enabled by Config.CaptureSQLPoolStats.
 func SQLContext(driver, dsn string) (*sql.DB, error) {
-	if err := initXRayDriver(driver, dsn); err != nil {
+	d, err := initXRayDriver(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Same as sql.Open, which opens a connector of the driver, but keeping
+	// the connector to let it read the pool statistics of the DB.
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
 		return nil, err
 	}
-	return sql.Open(driver+":xray", dsn)
+	db := sql.OpenDB(c)
+	c.(*driverConnector).setDB(db)
+	return db, nil
 }
 
 type driverDriver struct {
@@ -123,6 +136,7 @@ func (conn *driverConn) Prepare(query string) (driver.Stmt, error) {
 func (conn *driverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
 	var stmt driver.Stmt
 	var err error
+	start := clock.Now()
 	if connCtx, ok := conn.Conn.(driver.ConnPrepareContext); ok {
 		stmt, err = connCtx.PrepareContext(ctx, query)
 	} else {
@@ -140,10 +154,11 @@ func (conn *driverConn) PrepareContext(ctx context.Context, query string) (drive
 		return nil, err
 	}
 	return &driverStmt{
-		Stmt:  stmt,
-		attr:  conn.attr,
-		query: query,
-		conn:  conn,
+		Stmt:        stmt,
+		attr:        conn.attr,
+		query:       query,
+		conn:        conn,
+		prepareTime: clock.Now().Sub(start),
 	}, nil
 }
 
@@ -194,6 +209,9 @@ func (conn *driverConn) ExecContext(ctx context.Context, query string, args []dr
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				recordRowsAffected(ctx, result)
+			}
 			return err
 		})
 	} else {
@@ -218,6 +236,9 @@ func (conn *driverConn) ExecContext(ctx context.Context, query string, args []dr
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				recordRowsAffected(ctx, result)
+			}
 			return err
 		})
 	}
@@ -239,6 +260,9 @@ func (conn *driverConn) QueryContext(ctx context.Context, query string, args []d
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				rows = countRows(ctx, rows)
+			}
 			return err
 		})
 	} else {
@@ -262,6 +286,9 @@ func (conn *driverConn) QueryContext(ctx context.Context, query string, args []d
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				rows = countRows(ctx, rows)
+			}
 			return err
 		})
 	}
@@ -299,8 +326,12 @@ type dbAttribute struct {
 	databaseVersion  string
 	driverVersion    string
 	user             string
+	dsnUser          string
 	dbname           string
 	host             string
+
+	// db is the DB whose pool statistics are recorded, nil if unknown.
+	db *sql.DB
 }
 
 func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, conn driver.Conn, dsn string, filtered bool) (*dbAttribute, error) {
@@ -334,6 +365,7 @@ func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, con
 			}
 
 			u.User = url.User(uname)
+			attr.dsnUser = uname
 		}
 
 		// Strip password and X-Amz-Security-Token (present in RDS IAM authentication) from query parameters
@@ -364,6 +396,7 @@ func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, con
 		} else {
 			attr.connectionString = stripPasswords(dsn)
 		}
+		attr.dsnUser = userFromDSN(dsn)
 	}
 
 	// Detect database type and use that to populate attributes
@@ -386,6 +419,12 @@ func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, con
 		attr.dbname = "Unknown"
 	}
 
+	// The user reported by the database is the most accurate, but the user
+	// of the DSN still tells which credentials the connection used.
+	if attr.user == "Unknown" && attr.dsnUser != "" {
+		attr.user = attr.dsnUser
+	}
+
 	// There's no standard to get SQL driver version information
 	// So we invent an interface by which drivers can provide us this data
 	type versionedDriver interface {
@@ -534,6 +573,25 @@ func (attr *dbAttribute) populate(ctx context.Context, query string) {
 	seg.GetSQL().User = attr.user
 	seg.GetSQL().SanitizedQuery = query
 	seg.Unlock()
+
+	attr.recordPoolStats(seg)
+}
+
+// recordPoolStats records the connections of the pool of the DB into the
+// "pool" metadata of the "sql" namespace of seg, if enabled by
+// Config.CaptureSQLPoolStats.
+func (attr *dbAttribute) recordPoolStats(seg *Segment) {
+	if attr.db == nil || !seg.ParentSegment.GetConfiguration().CaptureSQLPoolStats {
+		return
+	}
+
+	stats := attr.db.Stats()
+	seg.AddMetadataToNamespace("sql", "pool", map[string]interface{}{
+		"open":       stats.OpenConnections,
+		"in_use":     stats.InUse,
+		"idle":       stats.Idle,
+		"wait_count": stats.WaitCount,
+	})
 }
 
 type driverTx struct {
@@ -553,6 +611,9 @@ type driverStmt struct {
 	conn  *driverConn
 	attr  *dbAttribute
 	query string
+
+	// prepareTime is how long preparing the statement took.
+	prepareTime time.Duration
 }
 
 func (stmt *driverStmt) Close() error {
@@ -574,7 +635,12 @@ func (stmt *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValu
 		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
+			start := clock.Now()
 			result, err = execerContext.ExecContext(ctx, args)
+			stmt.recordTimings(ctx, clock.Now().Sub(start))
+			if err == nil {
+				recordRowsAffected(ctx, result)
+			}
 			return err
 		})
 	} else {
@@ -590,7 +656,12 @@ func (stmt *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValu
 		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
+			start := clock.Now()
 			result, err = stmt.Stmt.Exec(dargs)
+			stmt.recordTimings(ctx, clock.Now().Sub(start))
+			if err == nil {
+				recordRowsAffected(ctx, result)
+			}
 			return err
 		})
 	}
@@ -611,7 +682,12 @@ func (stmt *driverStmt) QueryContext(ctx context.Context, args []driver.NamedVal
 		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
+			start := clock.Now()
 			result, err = queryCtx.QueryContext(ctx, args)
+			stmt.recordTimings(ctx, clock.Now().Sub(start))
+			if err == nil {
+				result = countRows(ctx, result)
+			}
 			return err
 		})
 	} else {
@@ -627,7 +703,12 @@ func (stmt *driverStmt) QueryContext(ctx context.Context, args []driver.NamedVal
 		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
+			start := clock.Now()
 			result, err = stmt.Stmt.Query(dargs)
+			stmt.recordTimings(ctx, clock.Now().Sub(start))
+			if err == nil {
+				result = countRows(ctx, result)
+			}
 			return err
 		})
 	}
@@ -659,6 +740,19 @@ func (stmt *driverStmt) populate(ctx context.Context) {
 	seg.Unlock()
 }
 
+// recordTimings records the time spent preparing stmt and the time spent
+// executing it into the metadata of the segment in ctx, if enabled by
+// Config.CaptureSQLPrepareTimings.
+func (stmt *driverStmt) recordTimings(ctx context.Context, execTime time.Duration) {
+	seg := GetSegment(ctx)
+	if seg == nil || !seg.ParentSegment.GetConfiguration().CaptureSQLPrepareTimings {
+		return
+	}
+
+	seg.AddMetadataToNamespace("sql", "prepare", stmt.prepareTime.Seconds())
+	seg.AddMetadataToNamespace("sql", "execute", execTime.Seconds())
+}
+
 // CheckNamedValue for implementing NamedValueChecker
 func (stmt *driverStmt) CheckNamedValue(nv *driver.NamedValue) (err error) {
 	if nvc, ok := stmt.Stmt.(namedValueChecker); ok {
@@ -687,6 +781,43 @@ func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
 	return ret, err
 }
 
+// userFromDSN returns the user of a DSN which is not a URL, such as the
+// key/value form "host=localhost user=app password=secret" of Postgres and
+// ODBC or the "user:password@tcp(localhost:3306)/db" form of MySQL. It
+// returns an empty string if the DSN names no user.
+func userFromDSN(dsn string) string {
+	// In the MySQL form the credentials come before the "@" preceding the
+	// address, and the password may itself contain an "@".
+	prefix := dsn
+	if i := strings.Index(prefix, "("); i >= 0 {
+		prefix = prefix[:i]
+	}
+	if at := strings.LastIndex(prefix, "@"); at > 0 && !strings.Contains(prefix[:at], "=") {
+		return strings.SplitN(prefix[:at], ":", 2)[0]
+	}
+
+	delimiter := " "
+	if strings.Count(dsn, ";") > strings.Count(dsn, " ") {
+		delimiter = ";"
+	}
+	for _, tok := range strings.Split(dsn, delimiter) {
+		kv := strings.SplitN(tok, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		// A driver prefix such as "odbc:" is part of the first key.
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if i := strings.LastIndex(key, ":"); i >= 0 {
+			key = key[i+1:]
+		}
+		switch key {
+		case "user", "user id", "uid", "username":
+			return strings.Trim(strings.TrimSpace(kv[1]), `'"{}`)
+		}
+	}
+	return ""
+}
+
 func stripPasswords(dsn string) string {
 	var (
 		tok        bytes.Buffer
@@ -12,6 +12,7 @@ package xray
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"sync"
 )
@@ -48,6 +49,16 @@ type driverConnector struct {
 
 	mu   sync.RWMutex
 	attr *dbAttribute
+	db   *sql.DB
+}
+
+// setDB sets the DB opened with the connector, whose pool statistics are
+// recorded into the subsegments of its connections. It must be called
+// before the first connection is made.
+func (c *driverConnector) setDB(db *sql.DB) {
+	c.mu.Lock()
+	c.db = db
+	c.mu.Unlock()
 }
 
 func (c *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
@@ -96,6 +107,7 @@ func (c *driverConnector) getAttr(ctx context.Context) (*dbAttribute, error) {
 	if err != nil {
 		return nil, err
 	}
+	attr.db = c.db
 	c.attr = attr
 	return attr, nil
 }
@@ -0,0 +1,118 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"reflect"
+)
+
+// recordRowsAffected records the number of rows affected by result as the
+// "rows_affected" metadata of the "sql" namespace of the segment in ctx.
+// Drivers which do not know it return an error from RowsAffected, in which
+// case nothing is recorded.
+func recordRowsAffected(ctx context.Context, result driver.Result) {
+	if result == nil {
+		return
+	}
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil {
+		seg.AddMetadataToNamespace("sql", "rows_affected", n)
+	}
+}
+
+// countRows wraps rows to count the rows read through it, if enabled by
+// Config.CountSQLRows.
+func countRows(ctx context.Context, rows driver.Rows) driver.Rows {
+	seg := GetSegment(ctx)
+	if rows == nil || seg == nil || !seg.ParentSegment.GetConfiguration().CountSQLRows {
+		return rows
+	}
+	return &countingRows{Rows: rows, seg: seg}
+}
+
+// countingRows counts the rows read from Rows, across all result sets, and
+// records the count into seg when closed. database/sql closes rows once
+// they are read to the end.
+//
+// It implements the optional interfaces of driver.Rows on behalf of Rows,
+// falling back to what database/sql does for drivers not implementing them.
+type countingRows struct {
+	driver.Rows
+	seg   *Segment
+	count int64
+}
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.count++
+	}
+	return err
+}
+
+func (r *countingRows) Close() error {
+	err := r.Rows.Close()
+	r.seg.AddMetadataToNamespace("sql", "rows_returned", r.count)
+	return err
+}
+
+func (r *countingRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *countingRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *countingRows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *countingRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *countingRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *countingRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *countingRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,62 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+)
+
+// StateTraceHeaderKey is the key of a JSON state payload, such as the input
+// of an AWS Step Functions state, under which InjectTraceHeaderJSON places
+// the trace header. It matches the AWSTraceHeader attribute with which Amazon
+// SQS carries trace headers. The value is the trace header in its string
+// form, for example:
+//
+//	{"AWSTraceHeader": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"}
+//
+// States which pass their input on to the next state, for example with
+// "ResultPath", keep the key so that every state joins the same trace.
+const StateTraceHeaderKey = "AWSTraceHeader"
+
+// InjectTraceHeaderJSON stores the trace header for calls made from the
+// segment or subsegment in ctx under StateTraceHeaderKey of payload, so that
+// the state receiving payload as its input can continue the trace with
+// ExtractTraceHeaderJSON.
+func InjectTraceHeaderJSON(ctx context.Context, payload map[string]interface{}) error {
+	if payload == nil {
+		return errors.New("unable to inject trace header into nil payload")
+	}
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return ErrRetrieveSegment
+	}
+
+	payload[StateTraceHeaderKey] = seg.DownstreamHeader().String()
+	return nil
+}
+
+// ExtractTraceHeaderJSON returns the trace header stored under
+// StateTraceHeaderKey of payload by InjectTraceHeaderJSON. It returns nil if
+// payload carries no trace header with a trace ID. Pass the header to
+// ContextWithTraceHeader to continue the trace.
+func ExtractTraceHeaderJSON(payload map[string]interface{}) *header.Header {
+	s, ok := payload[StateTraceHeaderKey].(string)
+	if !ok {
+		return nil
+	}
+
+	h := header.FromString(s)
+	if h.TraceID == "" {
+		return nil
+	}
+	return h
+}
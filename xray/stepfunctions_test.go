@@ -0,0 +1,71 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectExtractTraceHeaderJSON(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Producer")
+	subCtx, subseg := BeginSubsegment(ctx, "StartExecution")
+
+	payload := map[string]interface{}{"orderId": "1234"}
+	assert.NoError(t, InjectTraceHeaderJSON(subCtx, payload))
+	subseg.Close(nil)
+	root.Close(nil)
+
+	// The payload is passed on to the next state as JSON.
+	b, err := json.Marshal(payload)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var input map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(b, &input)) {
+		return
+	}
+
+	h := ExtractTraceHeaderJSON(input)
+	if !assert.NotNil(t, h) {
+		return
+	}
+	assert.Equal(t, root.TraceID, h.TraceID)
+	assert.Equal(t, subseg.ID, h.ParentID)
+	assert.Equal(t, header.Sampled, h.SamplingDecision)
+
+	_, consumer := BeginSubsegment(ContextWithTraceHeader(ctx, h), "Consumer")
+	assert.Equal(t, root.TraceID, consumer.ParentSegment.TraceID)
+	assert.Equal(t, subseg.ID, consumer.ParentSegment.ID)
+	consumer.Close(nil)
+}
+
+func TestInjectTraceHeaderJSONErrors(t *testing.T) {
+	assert.Equal(t, ErrRetrieveSegment, InjectTraceHeaderJSON(context.Background(), map[string]interface{}{}))
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+	defer root.Close(nil)
+	assert.Error(t, InjectTraceHeaderJSON(ctx, nil))
+}
+
+func TestExtractTraceHeaderJSONMissing(t *testing.T) {
+	assert.Nil(t, ExtractTraceHeaderJSON(nil))
+	assert.Nil(t, ExtractTraceHeaderJSON(map[string]interface{}{}))
+	assert.Nil(t, ExtractTraceHeaderJSON(map[string]interface{}{StateTraceHeaderKey: 42}))
+	assert.Nil(t, ExtractTraceHeaderJSON(map[string]interface{}{StateTraceHeaderKey: "Sampled=1"}))
+}
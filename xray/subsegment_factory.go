@@ -0,0 +1,65 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+)
+
+// ServiceAnnotationKey is the annotation in which subsegments begun by a
+// SubsegmentFactory record the name of the service they call.
+const ServiceAnnotationKey = "service"
+
+// SubsegmentFactory begins subsegments sharing a namespace and service
+// name, so that a library wrapping a client of some backend tags all of its
+// subsegments consistently. For instance a Redis client library would keep
+// one factory and begin a subsegment per command:
+//
+//	var redisSegments = xray.NewSubsegmentFactory("remote", "redis")
+//
+//	func (c *Client) Get(ctx context.Context, key string) (value string, err error) {
+//		ctx, seg := redisSegments.Begin(ctx, "GET")
+//		defer func() { seg.Close(err) }()
+//		...
+//	}
+//
+// Subsegments are begun with BeginSubsegment, under the segment or
+// subsegment of the context, and may be given further data like any other
+// subsegment. A SubsegmentFactory is safe for concurrent use.
+type SubsegmentFactory struct {
+	namespace   string
+	serviceName string
+}
+
+// NewSubsegmentFactory returns a SubsegmentFactory whose subsegments are in
+// namespace, such as "remote", and record serviceName under
+// ServiceAnnotationKey. Either is left unset on the subsegments if empty.
+func NewSubsegmentFactory(namespace, serviceName string) *SubsegmentFactory {
+	return &SubsegmentFactory{namespace: namespace, serviceName: serviceName}
+}
+
+// Begin creates a subsegment named name under the segment or subsegment of
+// ctx, with the namespace and service name of the factory.
+func (f *SubsegmentFactory) Begin(ctx context.Context, name string) (context.Context, *Segment) {
+	ctx, seg := BeginSubsegment(ctx, name)
+	if seg == nil {
+		return ctx, nil
+	}
+
+	seg.Lock()
+	if !seg.Dummy {
+		seg.Namespace = f.namespace
+	}
+	seg.Unlock()
+
+	if f.serviceName != "" {
+		_ = seg.AddAnnotation(ServiceAnnotationKey, f.serviceName)
+	}
+	return ctx, seg
+}
@@ -0,0 +1,75 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubsegmentFactory(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	factory := NewSubsegmentFactory("remote", "redis")
+
+	ctx, root := BeginSegment(ctx, "test")
+	ctx, outer := BeginSubsegment(ctx, "cache lookup")
+	_, sub := factory.Begin(ctx, "GET")
+	sub.Close(nil)
+	outer.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		return
+	}
+	var lookup, get *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &lookup)) || !assert.Len(t, lookup.Subsegments, 1) {
+		return
+	}
+	assert.Empty(t, lookup.Namespace)
+	if assert.NoError(t, json.Unmarshal(lookup.Subsegments[0], &get)) {
+		assert.Equal(t, "GET", get.Name)
+		assert.Equal(t, "remote", get.Namespace)
+		assert.Equal(t, "redis", get.Annotations[ServiceAnnotationKey])
+	}
+}
+
+func TestSubsegmentFactoryWithoutServiceName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, sub := NewSubsegmentFactory("remote", "").Begin(ctx, "call")
+	sub.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		return
+	}
+	var call *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &call)) {
+		assert.Equal(t, "remote", call.Namespace)
+		assert.NotContains(t, call.Annotations, ServiceAnnotationKey)
+	}
+}
+
+func TestSubsegmentFactoryWithoutSegment(t *testing.T) {
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: &TestContextMissingStrategy{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, sub := NewSubsegmentFactory("remote", "redis").Begin(ctx, "GET")
+	assert.Nil(t, sub)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// TimeFormatter serializes the start and end times of segments for backends
+// other than the X-Ray daemon, which expects seconds since the epoch.
+type TimeFormatter interface {
+	// FormatTime returns the JSON value for a time given in seconds since
+	// the epoch.
+	FormatTime(epochSeconds float64) ([]byte, error)
+}
+
+// EpochTimeFormatter formats times as floating point seconds since the
+// epoch, the format of the X-Ray daemon.
+type EpochTimeFormatter struct{}
+
+// FormatTime returns epochSeconds as a JSON number.
+func (EpochTimeFormatter) FormatTime(epochSeconds float64) ([]byte, error) {
+	return json.Marshal(epochSeconds)
+}
+
+// ISO8601TimeFormatter formats times as ISO-8601 strings in UTC with
+// microsecond precision, such as "2017-07-14T02:40:00.123456Z".
+type ISO8601TimeFormatter struct{}
+
+// FormatTime returns epochSeconds as a JSON string.
+func (ISO8601TimeFormatter) FormatTime(epochSeconds float64) ([]byte, error) {
+	sec, frac := math.Modf(epochSeconds)
+	t := time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC()
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
+
+// formattedSegment is the document of a segment whose start and end times
+// are formatted by a TimeFormatter. Its fields take precedence over the
+// fields of the embedded Segment with the same JSON names.
+type formattedSegment struct {
+	*Segment
+	StartTime json.RawMessage `json:"start_time"`
+	EndTime   json.RawMessage `json:"end_time,omitempty"`
+}
+
+// segmentDocument returns the value to marshal as the document of s, with
+// its start and end times formatted by tf, or s itself if tf is nil. The
+// subsegments embedded in s are serialized already, and formatted when
+// they were.
+// s has a lock acquired by the caller.
+func segmentDocument(s *Segment, tf TimeFormatter) (interface{}, error) {
+	if tf == nil {
+		return s, nil
+	}
+	start, err := tf.FormatTime(s.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	doc := &formattedSegment{Segment: s, StartTime: start}
+	if s.EndTime != 0 {
+		if doc.EndTime, err = tf.FormatTime(s.EndTime); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// FormatSegmentTimes rewrites the start and end times of a serialized
+// segment or subsegment, including those of its embedded subsegments, using
+// tf. Custom emitters can use it to send segments in formats other than the
+// one of the X-Ray daemon.
+func FormatSegmentTimes(b []byte, tf TimeFormatter) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"start_time", "end_time"} {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+		var t float64
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		formatted, err := tf.FormatTime(t)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = formatted
+	}
+
+	if raw, ok := fields["subsegments"]; ok {
+		var subsegments []json.RawMessage
+		if err := json.Unmarshal(raw, &subsegments); err != nil {
+			return nil, err
+		}
+		for i, s := range subsegments {
+			formatted, err := FormatSegmentTimes(s, tf)
+			if err != nil {
+				return nil, err
+			}
+			subsegments[i] = formatted
+		}
+		formatted, err := json.Marshal(subsegments)
+		if err != nil {
+			return nil, err
+		}
+		fields["subsegments"] = formatted
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochTimeFormatter(t *testing.T) {
+	b, err := EpochTimeFormatter{}.FormatTime(1500000000.25)
+	assert.NoError(t, err)
+	assert.Equal(t, "1500000000.25", string(b))
+}
+
+func TestISO8601TimeFormatter(t *testing.T) {
+	b, err := ISO8601TimeFormatter{}.FormatTime(1500000000.123456)
+	assert.NoError(t, err)
+	assert.Equal(t, `"2017-07-14T02:40:00.123456Z"`, string(b))
+
+	b, err = ISO8601TimeFormatter{}.FormatTime(1500000000)
+	assert.NoError(t, err)
+	assert.Equal(t, `"2017-07-14T02:40:00Z"`, string(b))
+}
+
+func TestFormatSegmentTimes(t *testing.T) {
+	in := `{"name":"Test","start_time":1500000000,"end_time":1500000001.5,"subsegments":[{"name":"Sub","start_time":1500000000.5,"in_progress":true}]}`
+
+	b, err := FormatSegmentTimes([]byte(in), ISO8601TimeFormatter{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var out struct {
+		Name        string `json:"name"`
+		StartTime   string `json:"start_time"`
+		EndTime     string `json:"end_time"`
+		Subsegments []struct {
+			Name       string `json:"name"`
+			StartTime  string `json:"start_time"`
+			EndTime    string `json:"end_time"`
+			InProgress bool   `json:"in_progress"`
+		} `json:"subsegments"`
+	}
+	if !assert.NoError(t, json.Unmarshal(b, &out)) {
+		return
+	}
+	assert.Equal(t, "Test", out.Name)
+	assert.Equal(t, "2017-07-14T02:40:00Z", out.StartTime)
+	assert.Equal(t, "2017-07-14T02:40:01.5Z", out.EndTime)
+	if assert.Len(t, out.Subsegments, 1) {
+		assert.Equal(t, "Sub", out.Subsegments[0].Name)
+		assert.Equal(t, "2017-07-14T02:40:00.5Z", out.Subsegments[0].StartTime)
+		assert.Empty(t, out.Subsegments[0].EndTime)
+		assert.True(t, out.Subsegments[0].InProgress)
+	}
+}
+
+func TestFormatSegmentTimesEpochRoundTrip(t *testing.T) {
+	seg := &Segment{Name: "Test", ID: "53995c3f42cd8ad8", StartTime: 1500000000.123456, EndTime: 1500000001}
+	b, err := json.Marshal(seg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	formatted, err := FormatSegmentTimes(b, EpochTimeFormatter{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	out := &Segment{}
+	assert.NoError(t, json.Unmarshal(formatted, out))
+	assert.Equal(t, seg.StartTime, out.StartTime)
+	assert.Equal(t, seg.EndTime, out.EndTime)
+}
@@ -16,9 +16,11 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/stretchr/testify/assert"
 )
 
 func NewTestDaemon() (context.Context, *TestDaemon) {
@@ -76,6 +78,9 @@ func (td *TestDaemon) Close() {
 	})
 }
 
+// run reads datagrams from the daemon connection and queues every segment
+// they contain. A datagram holds a header line followed by one or more
+// segment documents separated by newlines.
 func (td *TestDaemon) run(c chan *result) {
 	buffer := make([]byte, 64*1024)
 	for {
@@ -89,27 +94,40 @@ func (td *TestDaemon) run(c chan *result) {
 			continue
 		}
 
-		idx := bytes.IndexByte(buffer, '\n')
-		buffered := buffer[idx+1 : n]
+		for _, line := range bytes.Split(buffer[:n], []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 || isDaemonHeader(line) {
+				continue
+			}
 
-		seg := &Segment{}
-		err = json.Unmarshal(buffered, &seg)
-		if err != nil {
+			seg := &Segment{}
+			err = json.Unmarshal(line, &seg)
+			if err != nil {
+				select {
+				case c <- &result{nil, err}:
+				case <-td.ctx.Done():
+					return
+				}
+				continue
+			}
+
+			seg.Sampled = true
 			select {
-			case c <- &result{nil, err}:
+			case c <- &result{seg, nil}:
 			case <-td.ctx.Done():
 				return
 			}
-			continue
 		}
+	}
+}
 
-		seg.Sampled = true
-		select {
-		case c <- &result{seg, nil}:
-		case <-td.ctx.Done():
-			return
-		}
+// isDaemonHeader reports whether line is the header preceding segment documents.
+func isDaemonHeader(line []byte) bool {
+	var h struct {
+		Format  string `json:"format"`
+		Version *int   `json:"version"`
 	}
+	return json.Unmarshal(line, &h) == nil && h.Format != "" && h.Version != nil
 }
 
 func (td *TestDaemon) Recv() (*Segment, error) {
@@ -123,6 +141,27 @@ func (td *TestDaemon) Recv() (*Segment, error) {
 	}
 }
 
+// Reset discards the segments received so far, so that table driven tests
+// sharing a daemon start every case clean. Segments are sent over UDP and
+// may still be on their way, so Reset returns once no segment has arrived
+// for a short while.
+func (td *TestDaemon) Reset() {
+	for {
+		select {
+		case <-td.ch:
+		case <-time.After(10 * time.Millisecond):
+			return
+		case <-td.ctx.Done():
+			return
+		}
+	}
+}
+
+// Len returns the number of segments received and not read with Recv yet.
+func (td *TestDaemon) Len() int {
+	return len(td.ch)
+}
+
 type XRayHeaders struct {
 	RootTraceID string
 	ParentID    string
@@ -138,3 +177,49 @@ func ParseHeadersForTest(h http.Header) XRayHeaders {
 		Sampled:     traceHeader.SamplingDecision == header.Sampled,
 	}
 }
+
+func TestTestDaemonMultipleSegmentsInOneDatagram(t *testing.T) {
+	_, td := NewTestDaemon()
+	defer td.Close()
+
+	conn, err := net.Dial("udp", td.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	packet := Header + `{"name":"first","id":"1"}` + "\n" + `{"name":"second","id":"2"}` + "\n"
+	if _, err := conn.Write([]byte(packet)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, name, seg.Name)
+	}
+}
+
+func TestTestDaemonReset(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSegment(ctx, fmt.Sprintf("leftover-%d", i))
+		seg.Close(nil)
+	}
+	assert.Eventually(t, func() bool { return td.Len() == 3 }, time.Second, time.Millisecond)
+
+	td.Reset()
+	assert.Equal(t, 0, td.Len())
+
+	_, seg := BeginSegment(ctx, "next")
+	seg.Close(nil)
+	got, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "next", got.Name)
+	}
+	assert.Equal(t, 0, td.Len())
+}